@@ -0,0 +1,108 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLog writes one line per request in the Common or Combined Log
+// Format understood by GoAccess, awstats, and friends
+type AccessLog struct {
+	mu       sync.Mutex
+	Out      io.Writer
+	Combined bool
+}
+
+// NewAccessLog returns an access log for format, one of common or
+// combined, or nil if format is none
+func NewAccessLog(out io.Writer, format string) (*AccessLog, error) {
+	switch format {
+	case "none", "":
+		return nil, nil
+	case "common":
+		return &AccessLog{Out: out}, nil
+	case "combined":
+		return &AccessLog{Out: out, Combined: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown access log format, %s", format)
+	}
+}
+
+// Wrap logs each request served by h
+func (a *AccessLog) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, req)
+
+		line := a.Format(req, recorder.Status(), recorder.bytes, started)
+		if variant := recorder.Header().Get(VariantHeader); variant != "" {
+			line = strings.TrimSuffix(line, "\n") + " variant=" + variant + "\n"
+		}
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		io.WriteString(a.Out, line)
+	})
+}
+
+// Format renders a single log line for the request
+func (a *AccessLog) Format(req *http.Request, status int, bytes int64, started time.Time) string {
+	user := "-"
+	if u, _, ok := req.BasicAuth(); ok && u != "" {
+		user = u
+	}
+
+	size := "-"
+	if bytes > 0 {
+		size = fmt.Sprintf("%d", bytes)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		clientIP(req),
+		user,
+		started.Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method,
+		req.RequestURI,
+		req.Proto,
+		status,
+		size,
+	)
+	if a.Combined {
+		line += fmt.Sprintf(" \"%s\" \"%s\"", quoteField(req.Referer()), quoteField(req.UserAgent()))
+	}
+	return line + "\n"
+}
+
+func quoteField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.Replace(value, `"`, `\"`, -1)
+}
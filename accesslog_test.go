@@ -0,0 +1,54 @@
+package s3site
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	started := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+
+	req, _ := http.NewRequest("GET", "/apache_pb.gif", nil)
+	req.RequestURI = "/apache_pb.gif"
+	req.RemoteAddr = "127.0.0.1:52000"
+	req.SetBasicAuth("frank", "secret")
+	req.Header.Set("Referer", "http://www.example.com/start.html")
+	req.Header.Set("User-Agent", "Mozilla/4.08")
+
+	common := &AccessLog{}
+	expected := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.1" 200 2326` + "\n"
+	if line := common.Format(req, 200, 2326, started); line != expected {
+		t.Errorf("expected %s, got %s", expected, line)
+	}
+
+	combined := &AccessLog{Combined: true}
+	expected = `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.1" 304 - "http://www.example.com/start.html" "Mozilla/4.08"` + "\n"
+	if line := combined.Format(req, 304, 0, started); line != expected {
+		t.Errorf("expected %s, got %s", expected, line)
+	}
+}
+
+func TestAccessLogWrap(t *testing.T) {
+	buf := &bytes.Buffer{}
+	accessLog, err := NewAccessLog(buf, "common")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := accessLog.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !bytes.Contains(buf.Bytes(), []byte(`" 200 5`)) {
+		t.Errorf("unexpected access log %s", buf.String())
+	}
+
+	if _, err := NewAccessLog(buf, "fancy"); err == nil {
+		t.Error("expected unknown format to be rejected")
+	}
+}
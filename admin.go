@@ -0,0 +1,167 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+type purgeRequest struct {
+	Path   string `json:"path"`
+	Prefix string `json:"prefix"`
+}
+
+type purgeResponse struct {
+	Purged       int    `json:"purged"`
+	Invalidation string `json:"invalidation,omitempty"`
+}
+
+// PurgeHandler evicts entries from the cache; either a single path via
+// PURGE /path or any number of entries via POST /-/purge {"prefix": "/docs/"}.
+// When cdn is non-nil, the same paths are invalidated in cloudfront.  Paths
+// are keyed as they are when served, under the prefix live when purged.
+func PurgeHandler(live *LiveOptions, keys *KeyPolicy, cache *Cache, cdn *CloudFront) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		opts := live.Load()
+		if !opts.IsAdmin(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var purged int
+		var paths []string
+		switch req.Method {
+		case "PURGE":
+			paths = append(paths, req.URL.Path)
+			if cache != nil {
+				purged = cache.Delete(opts.Key(keys.Path(req.URL.Path)))
+			}
+
+		case "POST":
+			in := purgeRequest{}
+			if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if in.Path == "" && in.Prefix == "" {
+				http.Error(w, "one of path or prefix is required", http.StatusBadRequest)
+				return
+			}
+
+			if in.Path != "" {
+				paths = append(paths, in.Path)
+			}
+			if in.Prefix != "" {
+				paths = append(paths, strings.TrimSuffix(in.Prefix, "*")+"*")
+			}
+
+			if cache != nil {
+				if in.Path != "" {
+					purged += cache.Delete(opts.Key(keys.Path(in.Path)))
+				}
+				if in.Prefix != "" {
+					purged += cache.DeletePrefix(opts.keyPrefix(keys.Path(strings.TrimSuffix(in.Prefix, "*"))))
+				}
+			}
+
+		default:
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		logger.Info("purged cache", Fields{"request_id": requestID(req), "paths": paths, "purged": purged})
+
+		out := purgeResponse{Purged: purged}
+		if cdn != nil {
+			id, err := cdn.Invalidate(paths)
+			if err != nil {
+				logger.Error("unable to invalidate cloudfront", Fields{"request_id": requestID(req), "error": err})
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			out.Invalidation = id
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// CacheStatsHandler reports cache hit ratio, evictions, fill latency, and
+// bytes saved as json
+func CacheStatsHandler(live *LiveOptions, cache *Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		opts := live.Load()
+		if !opts.IsAdmin(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		snapshot := CacheSnapshot{}
+		if cache != nil {
+			snapshot = cache.Snapshot()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snapshot)
+	}
+}
+
+// PprofHandler exposes net/http/pprof under /debug/pprof/ to admins only
+func PprofHandler(live *LiveOptions) http.HandlerFunc {
+	profiles := http.NewServeMux()
+	profiles.HandleFunc("/debug/pprof/", pprof.Index)
+	profiles.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	profiles.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	profiles.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	profiles.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		opts := live.Load()
+		if !opts.IsAdmin(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		profiles.ServeHTTP(w, req)
+	}
+}
+
+// VarsHandler exposes expvar at /debug/vars to admins only; the published
+// cmdline may contain credentials
+func VarsHandler(live *LiveOptions) http.HandlerFunc {
+	vars := expvar.Handler()
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		opts := live.Load()
+		if !opts.IsAdmin(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		vars.ServeHTTP(w, req)
+	}
+}
@@ -0,0 +1,108 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPurgeHandler(t *testing.T) {
+	opts := &Options{Prefix: "site", IndexFile: "index.html", AdminToken: "secret"}
+	cache := NewCache(100)
+	cache.Set(&Entry{Key: "site/docs/index.html", Body: []byte("1")})
+	cache.Set(&Entry{Key: "site/docs/a.html", Body: []byte("1")})
+	cache.Set(&Entry{Key: "site/about.html", Body: []byte("1")})
+	handler := PurgeHandler(NewLiveOptions(opts, nil), &KeyPolicy{}, cache, nil)
+
+	req, _ := http.NewRequest("PURGE", "/about.html", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthenticated purge to be rejected, got %d", w.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if body := strings.TrimSpace(w.Body.String()); body != `{"purged":1}` {
+		t.Errorf("unexpected response %s", body)
+	}
+
+	req, _ = http.NewRequest("POST", "/-/purge", strings.NewReader(`{"prefix":"/docs/"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if body := strings.TrimSpace(w.Body.String()); body != `{"purged":2}` {
+		t.Errorf("unexpected response %s", body)
+	}
+}
+
+func TestPprofHandler(t *testing.T) {
+	handler := PprofHandler(NewLiveOptions(&Options{AdminToken: "secret"}, nil))
+
+	req, _ := http.NewRequest("GET", "/debug/pprof/", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", w.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("expected pprof index, got %d", w.Code)
+	}
+}
+
+func TestPurgeHandlerLiveSlot(t *testing.T) {
+	opts := &Options{BluePrefix: "blue", GreenPrefix: "green", Slot: SlotBlue, IndexFile: "index.html", AdminToken: "secret"}
+	live := NewLiveOptions(opts, nil)
+	cache := NewCache(100)
+	cache.Set(&Entry{Key: "blue/about.html", Body: []byte("1")})
+	cache.Set(&Entry{Key: "green/about.html", Body: []byte("1")})
+	handler := PurgeHandler(live, &KeyPolicy{Lowercase: true}, cache, nil)
+
+	live.Update(func(o *Options) { o.Slot = SlotGreen })
+
+	req, _ := http.NewRequest("PURGE", "/docs/../About.html", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if body := strings.TrimSpace(w.Body.String()); body != `{"purged":1}` {
+		t.Errorf("unexpected response %s", body)
+	}
+	if _, ok := cache.Get("green/about.html"); ok {
+		t.Error("expected the path to be purged from the live slot as it's keyed when served")
+	}
+	if _, ok := cache.Get("blue/about.html"); !ok {
+		t.Error("expected the idle slot to be left alone")
+	}
+}
+
+func TestAdminHandlersReloadToken(t *testing.T) {
+	live := NewLiveOptions(&Options{AdminToken: "old"}, func() (*Options, error) {
+		return &Options{AdminToken: "new"}, nil
+	})
+	handlers := map[string]http.HandlerFunc{
+		"/-/cache":      CacheStatsHandler(live, NewCache(10)),
+		"/debug/pprof/": PprofHandler(live),
+		"/debug/vars":   VarsHandler(live),
+	}
+	if _, err := live.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, handler := range handlers {
+		for token, expected := range map[string]int{"old": http.StatusUnauthorized, "new": http.StatusOK} {
+			req, _ := http.NewRequest("GET", path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if w.Code != expected {
+				t.Errorf("%s with the %s token: expected %d, got %d", path, token, expected, w.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"hash/fnv"
+	"mime"
+	"path"
+	"sync"
+)
+
+// Admission decides which s3 responses are worth holding in the cache
+type Admission struct {
+	// MaxObject is the largest object, in bytes, that will be cached; 0
+	// means no limit beyond the size of the cache
+	MaxObject int64
+	// ContentTypes, if set, restricts caching to matching content types
+	// e.g. text/*, application/javascript
+	ContentTypes []string
+}
+
+// Allows reports whether an object of the given size and content type
+// may be cached; size is -1 when unknown
+func (a *Admission) Allows(size int64, contentType string) bool {
+	if a == nil {
+		return true
+	}
+	if a.MaxObject > 0 && size > a.MaxObject {
+		return false
+	}
+	if len(a.ContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range a.ContentTypes {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	sketchDepth    = 4
+	sketchMaxCount = 15
+)
+
+// Sketch is a count-min sketch estimating how often each key is
+// requested.  Counts are periodically halved so that the estimate tracks
+// recent popularity; this is the frequency filter behind TinyLFU.
+type Sketch struct {
+	mu      sync.Mutex
+	width   uint64
+	rows    [sketchDepth][]uint8
+	samples int
+	reset   int
+}
+
+// NewSketch returns a sketch sized for roughly width distinct hot keys
+func NewSketch(width int) *Sketch {
+	size := uint64(1)
+	for size < uint64(width) {
+		size = size << 1
+	}
+
+	s := &Sketch{
+		width: size,
+		reset: 10 * int(size),
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, size)
+	}
+	return s
+}
+
+func (s *Sketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	lo, hi := sum&0xffffffff, sum>>32
+
+	var indexes [sketchDepth]uint64
+	for i := range indexes {
+		indexes[i] = (lo + uint64(i)*hi) & (s.width - 1)
+	}
+	return indexes
+}
+
+// Increment records a request for key
+func (s *Sketch) Increment(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, index := range s.indexes(key) {
+		if s.rows[i][index] < sketchMaxCount {
+			s.rows[i][index]++
+		}
+	}
+
+	s.samples++
+	if s.samples >= s.reset {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] = s.rows[i][j] >> 1
+			}
+		}
+		s.samples = s.samples / 2
+	}
+}
+
+// Estimate returns the approximate number of recent requests for key
+func (s *Sketch) Estimate(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	estimate := sketchMaxCount
+	for i, index := range s.indexes(key) {
+		if count := int(s.rows[i][index]); count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
@@ -0,0 +1,68 @@
+package s3site
+
+import "testing"
+
+func TestAdmissionAllows(t *testing.T) {
+	admission := &Admission{MaxObject: 100, ContentTypes: []string{"text/*", "application/javascript"}}
+
+	testCases := []struct {
+		Size        int64
+		ContentType string
+		Expected    bool
+	}{
+		{10, "text/html; charset=utf-8", true},
+		{10, "application/javascript", true},
+		{10, "video/mp4", false},
+		{1000, "text/html", false},
+		{-1, "text/css", true},
+	}
+	for _, tc := range testCases {
+		if got := admission.Allows(tc.Size, tc.ContentType); got != tc.Expected {
+			t.Errorf("%d %s: expected %v, got %v", tc.Size, tc.ContentType, tc.Expected, got)
+		}
+	}
+
+	if !(*Admission)(nil).Allows(1<<30, "") {
+		t.Error("expected nil admission to allow everything")
+	}
+}
+
+func TestSketch(t *testing.T) {
+	sketch := NewSketch(1024)
+	for i := 0; i < 5; i++ {
+		sketch.Increment("hot")
+	}
+	sketch.Increment("cold")
+
+	if hot, cold := sketch.Estimate("hot"), sketch.Estimate("cold"); hot < 5 || cold > hot {
+		t.Errorf("unexpected estimates hot=%d cold=%d", hot, cold)
+	}
+
+	// enough traffic to trigger aging halves the counts
+	for i := 0; i < 10*1024; i++ {
+		sketch.Increment("other")
+	}
+	if hot := sketch.Estimate("hot"); hot > 3 {
+		t.Errorf("expected hot key to have aged, got %d", hot)
+	}
+}
+
+func TestCacheTinyLFURejectsOneOffs(t *testing.T) {
+	cache := NewCache(10)
+	cache.Frequency = NewSketch(1024)
+
+	for i := 0; i < 3; i++ {
+		cache.Get("hot")
+	}
+	cache.Set(&Entry{Key: "hot", Body: []byte("1234567890")})
+
+	cache.Get("once")
+	cache.Set(&Entry{Key: "once", Body: []byte("1234567890")})
+
+	if _, ok := cache.Get("hot"); !ok {
+		t.Error("expected the hot entry to survive a one-off request")
+	}
+	if snapshot := cache.Snapshot(); snapshot.Rejected != 1 {
+		t.Errorf("expected 1 rejection, got %d", snapshot.Rejected)
+	}
+}
@@ -0,0 +1,189 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// minimum traffic in a window before a rate is considered meaningful
+const (
+	minAlertRequests = 20
+	minAlertS3Calls  = 10
+)
+
+// Alert is the generic json payload posted to the webhook
+type Alert struct {
+	Name      string    `json:"alert"`
+	Status    string    `json:"status"` // firing or resolved
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Window    string    `json:"window"`
+	Host      string    `json:"host"`
+	Bucket    string    `json:"bucket"`
+	Time      time.Time `json:"time"`
+}
+
+// Summary describes the alert in a sentence
+func (a Alert) Summary() string {
+	if a.Status == "resolved" {
+		return fmt.Sprintf("[s3site %s] resolved: %s is back to %.3g (threshold %.3g over %s)", a.Host, a.Name, a.Value, a.Threshold, a.Window)
+	}
+	return fmt.Sprintf("[s3site %s] %s is %.3g, above %.3g over %s, serving bucket %s", a.Host, a.Name, a.Value, a.Threshold, a.Window, a.Bucket)
+}
+
+type alertCounters struct {
+	totals       RequestTotals
+	s3Calls      uint64
+	s3Failures   uint64
+	authFailures int64
+}
+
+// Alerter posts to a webhook when the 5xx rate, s3 failure rate, or
+// number of auth failures over a window crosses its threshold, and again
+// once it recovers.  A threshold of 0 disables that alert.
+type Alerter struct {
+	Webhook string
+	Slack   bool // post {"text": ...} for slack incoming webhooks
+	Client  *http.Client
+	Metrics *Metrics
+	Bucket  string
+
+	ErrorRate     float64
+	S3FailureRate float64
+	AuthFailures  int64
+	Window        time.Duration
+
+	last   alertCounters
+	firing map[string]bool
+}
+
+func (a *Alerter) counters() alertCounters {
+	calls, failures := a.Metrics.S3Failures()
+	return alertCounters{
+		totals:       a.Metrics.Totals(),
+		s3Calls:      calls,
+		s3Failures:   failures,
+		authFailures: a.Metrics.AuthFailures(),
+	}
+}
+
+// Run evaluates the thresholds once per window
+func (a *Alerter) Run() {
+	a.last = a.counters()
+	for range time.Tick(a.Window) {
+		a.Evaluate()
+	}
+}
+
+// Evaluate compares the window since the previous call to the thresholds
+// and notifies on any change in state
+func (a *Alerter) Evaluate() []Alert {
+	if a.firing == nil {
+		a.firing = map[string]bool{}
+	}
+
+	current := a.counters()
+	delta := current.totals.Sub(a.last.totals)
+	s3Calls := current.s3Calls - a.last.s3Calls
+	s3Failures := current.s3Failures - a.last.s3Failures
+	authFailures := current.authFailures - a.last.authFailures
+	a.last = current
+
+	var alerts []Alert
+	check := func(name string, value, threshold float64, enough bool) {
+		if threshold <= 0 || !enough && !a.firing[name] {
+			return
+		}
+		firing := enough && value > threshold
+		if firing == a.firing[name] {
+			return
+		}
+		a.firing[name] = firing
+
+		status := "resolved"
+		if firing {
+			status = "firing"
+		}
+		alerts = append(alerts, a.alert(name, status, value, threshold))
+	}
+
+	var errorRate, s3FailureRate float64
+	if delta.Requests > 0 {
+		errorRate = float64(delta.Errors) / float64(delta.Requests)
+	}
+	if s3Calls > 0 {
+		s3FailureRate = float64(s3Failures) / float64(s3Calls)
+	}
+	check("5xx rate", errorRate, a.ErrorRate, delta.Requests >= minAlertRequests)
+	check("s3 failure rate", s3FailureRate, a.S3FailureRate, s3Calls >= minAlertS3Calls)
+	check("auth failures", float64(authFailures), float64(a.AuthFailures), true)
+
+	for _, alert := range alerts {
+		if err := a.notify(alert); err != nil {
+			logger.Warn("unable to send alert", Fields{"alert": alert.Name, "error": err})
+		}
+	}
+	return alerts
+}
+
+func (a *Alerter) alert(name, status string, value, threshold float64) Alert {
+	host, _ := os.Hostname()
+	return Alert{
+		Name:      name,
+		Status:    status,
+		Value:     value,
+		Threshold: threshold,
+		Window:    a.Window.String(),
+		Host:      host,
+		Bucket:    a.Bucket,
+		Time:      time.Now().UTC(),
+	}
+}
+
+func (a *Alerter) notify(alert Alert) error {
+	var payload interface{} = alert
+	if a.Slack {
+		payload = map[string]string{"text": alert.Summary()}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.Client.Post(a.Webhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package s3site
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlerter(t *testing.T) {
+	var received []Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		alert := Alert{}
+		data, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(data, &alert)
+		received = append(received, alert)
+	}))
+	defer server.Close()
+
+	metrics := NewMetrics()
+	alerter := &Alerter{
+		Webhook:      server.URL,
+		Client:       http.DefaultClient,
+		Metrics:      metrics,
+		ErrorRate:    0.1,
+		AuthFailures: 5,
+		Window:       time.Minute,
+	}
+	alerter.last = alerter.counters()
+
+	for i := 0; i < 20; i++ {
+		status := 200
+		if i%4 == 0 {
+			status = 503
+		}
+		metrics.ObserveRequest(status, 0, time.Millisecond)
+	}
+	for i := 0; i < 6; i++ {
+		metrics.ObserveAuthFailure()
+	}
+	if alerts := alerter.Evaluate(); len(alerts) != 2 {
+		t.Fatalf("expected 5xx and auth alerts, got %v", alerts)
+	}
+	if len(received) != 2 || received[0].Name != "5xx rate" || received[0].Status != "firing" || received[0].Value != 0.25 {
+		t.Errorf("unexpected webhook payloads, %+v", received)
+	}
+
+	// still failing; no repeat notification
+	for i := 0; i < 20; i++ {
+		metrics.ObserveRequest(500, 0, time.Millisecond)
+	}
+	metrics.ObserveAuthFailure()
+	if alerts := alerter.Evaluate(); len(alerts) != 1 || alerts[0].Name != "auth failures" || alerts[0].Status != "resolved" {
+		t.Errorf("expected only the auth alert to resolve, got %v", alerts)
+	}
+
+	// a quiet window resolves the 5xx alert
+	if alerts := alerter.Evaluate(); len(alerts) != 1 || alerts[0].Name != "5xx rate" || alerts[0].Status != "resolved" {
+		t.Errorf("expected the 5xx alert to resolve, got %v", alerts)
+	}
+}
+
+func TestAlerterSlack(t *testing.T) {
+	var payload map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(data, &payload)
+	}))
+	defer server.Close()
+
+	alerter := &Alerter{Webhook: server.URL, Slack: true, Client: http.DefaultClient, Bucket: "example", Window: time.Minute}
+	if err := alerter.notify(alerter.alert("s3 failure rate", "firing", 0.5, 0.1)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(payload["text"], "s3 failure rate is 0.5, above 0.1 over 1m0s, serving bucket example") {
+		t.Errorf("unexpected slack text, %s", payload["text"])
+	}
+}
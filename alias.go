@@ -0,0 +1,191 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+// Alias sends requests for a short path elsewhere
+type Alias struct {
+	// To is a path, whose key is served in place of the alias, or the url
+	// redirected to
+	To string
+	// Status is 200 to serve To in place of the alias, or the status of
+	// the redirect to it
+	Status int
+}
+
+// redirectStatus reports whether status is one of the redirects aliases and
+// rewrites may answer with
+func redirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// ParseAliases parses aliases of the form "from to [status]", one per line
+// e.g. "/install /downloads/install.sh" or "/gh https://github.com/me 301".
+// Aliases to paths are served in place unless given a status; aliases to
+// urls are redirected, with a 302 unless given a status.  Blank lines and
+// lines beginning # are skipped.
+func ParseAliases(data []byte) (map[string]Alias, error) {
+	aliases := map[string]Alias{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 || !strings.HasPrefix(fields[0], "/") {
+			return nil, fmt.Errorf("line %d: invalid alias, %s", line, scanner.Text())
+		}
+
+		alias := Alias{To: fields[1], Status: http.StatusOK}
+		external := !strings.HasPrefix(alias.To, "/")
+		if external {
+			u, err := url.Parse(alias.To)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("line %d: invalid alias target, %s", line, alias.To)
+			}
+			alias.Status = http.StatusFound
+		}
+		if len(fields) == 3 {
+			status, err := strconv.Atoi(fields[2])
+			switch {
+			case err != nil:
+				return nil, fmt.Errorf("line %d: invalid status, %s", line, fields[2])
+			case status == http.StatusOK && !external:
+			case redirectStatus(status):
+			default:
+				return nil, fmt.Errorf("line %d: unsupported status, %d; use 200 for paths, or 301, 302, 303, 307, or 308", line, status)
+			}
+			alias.Status = status
+		}
+		aliases[fields[0]] = alias
+	}
+	return aliases, scanner.Err()
+}
+
+// Aliases holds the aliases configured with the site, and those read from
+// an object in the bucket, which take precedence and may be edited without
+// a deploy
+type Aliases struct {
+	Static map[string]Alias
+	// Key, relative to the site's prefix, holds aliases in the bucket; none
+	// are read when empty
+	Key string
+
+	mu     sync.RWMutex
+	object map[string]Alias
+}
+
+// NewAliases returns the aliases of values, each one or more lines of
+// aliases, and those in the object at key.  It returns nil when there
+// are neither.
+func NewAliases(values []string, key string) (*Aliases, error) {
+	if len(values) == 0 && key == "" {
+		return nil, nil
+	}
+	static, err := ParseAliases([]byte(strings.Join(values, "\n")))
+	if err != nil {
+		return nil, err
+	}
+	return &Aliases{Static: static, Key: key}, nil
+}
+
+// Lookup returns the alias of urlPath
+func (a *Aliases) Lookup(urlPath string) (Alias, bool) {
+	if a == nil {
+		return Alias{}, false
+	}
+	a.mu.RLock()
+	alias, ok := a.object[urlPath]
+	a.mu.RUnlock()
+	if !ok {
+		alias, ok = a.Static[urlPath]
+	}
+	return alias, ok
+}
+
+// Refresh reads the aliases in the object at key; a missing object holds
+// none
+func (a *Aliases) Refresh(bucket *s3.Bucket, key string) error {
+	aliases := map[string]Alias{}
+	data, err := bucket.Get(key)
+	if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+		err = nil
+	} else if err == nil {
+		aliases, err = ParseAliases(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.object = aliases
+	a.mu.Unlock()
+	return nil
+}
+
+// Run reads the aliases object of the site live serves every interval, and
+// whenever its options change
+func (a *Aliases) Run(live *LiveOptions, bucket *s3.Bucket, creds *Credentials, interval time.Duration) {
+	live.RunEvery(interval, func(opts *Options) {
+		key := opts.keyPrefix("/") + a.Key
+		if err := a.Refresh(creds.Sign(bucket), key); err != nil {
+			logger.Warn("unable to read aliases", Fields{"bucket": bucket.Name, "key": key, "error": err})
+		}
+	})
+}
+
+// Route sends a request for an alias on to its target, returning the path
+// to serve in its place, or true when it redirected
+func (a *Aliases) Route(w http.ResponseWriter, req *http.Request, urlPath string) (string, bool) {
+	alias, ok := a.Lookup(urlPath)
+	if !ok {
+		return urlPath, false
+	}
+	if alias.Status == http.StatusOK {
+		return alias.To, false
+	}
+
+	target := alias.To
+	if req.URL.RawQuery != "" && !strings.Contains(target, "?") {
+		target += "?" + req.URL.RawQuery
+	}
+	http.Redirect(w, req, target, alias.Status)
+	return urlPath, true
+}
@@ -0,0 +1,99 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+func TestParseAliases(t *testing.T) {
+	aliases, err := ParseAliases([]byte(`
+# comments and blank lines are skipped
+/install /downloads/install.sh
+/old     /new 301
+/gh      https://github.com/savaki/s3site
+/docs    https://docs.example.com 308
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Alias{
+		"/install": {To: "/downloads/install.sh", Status: 200},
+		"/old":     {To: "/new", Status: 301},
+		"/gh":      {To: "https://github.com/savaki/s3site", Status: 302},
+		"/docs":    {To: "https://docs.example.com", Status: 308},
+	}
+	for from, alias := range expected {
+		if aliases[from] != alias {
+			t.Errorf("%s: expected %+v; got %+v", from, alias, aliases[from])
+		}
+	}
+
+	for _, line := range []string{
+		"/a",
+		"a /b",
+		"/a /b 200 extra",
+		"/a github.com",
+		"/a https://example.com 200",
+		"/a /b 404",
+		"/a /b x",
+	} {
+		if _, err := ParseAliases([]byte(line)); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	mem := &memS3{objects: map[string][]byte{
+		"site/_aliases": []byte("/install /downloads/v2/install.sh\n/blog https://blog.example.com\n"),
+	}}
+	server := httptest.NewServer(mem)
+	defer server.Close()
+	bucket := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{Name: "us-east-1", S3Endpoint: server.URL}).Bucket("bucket")
+
+	aliases, err := NewAliases([]string{"/install /downloads/install.sh", "/old /new 301"}, "_aliases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias, _ := aliases.Lookup("/install"); alias.To != "/downloads/install.sh" {
+		t.Errorf("expected the configured alias before the object is read; got %+v", alias)
+	}
+	if err := aliases.Refresh(bucket, "site/_aliases"); err != nil {
+		t.Fatal(err)
+	}
+	if alias, _ := aliases.Lookup("/install"); alias.To != "/downloads/v2/install.sh" {
+		t.Errorf("expected the object's alias to win; got %+v", alias)
+	}
+
+	w := httptest.NewRecorder()
+	if urlPath, redirected := aliases.Route(w, httptest.NewRequest("GET", "/install", nil), "/install"); redirected || urlPath != "/downloads/v2/install.sh" {
+		t.Errorf("expected /install served in place; got %s", urlPath)
+	}
+
+	w = httptest.NewRecorder()
+	if _, redirected := aliases.Route(w, httptest.NewRequest("GET", "/old?a=1", nil), "/old"); !redirected || w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/new?a=1" {
+		t.Errorf("expected a 301 to /new?a=1; got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	w = httptest.NewRecorder()
+	if _, redirected := aliases.Route(w, httptest.NewRequest("GET", "/blog", nil), "/blog"); !redirected || w.Code != http.StatusFound || w.Header().Get("Location") != "https://blog.example.com" {
+		t.Errorf("expected a 302 to the blog; got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	delete(mem.objects, "site/_aliases")
+	if err := aliases.Refresh(bucket, "site/_aliases"); err != nil {
+		t.Fatalf("expected a missing object to hold no aliases; got %v", err)
+	}
+	if _, ok := aliases.Lookup("/blog"); ok {
+		t.Error("expected the object's aliases dropped")
+	}
+
+	var none *Aliases
+	if urlPath, redirected := none.Route(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil), "/a"); redirected || urlPath != "/a" {
+		t.Error("expected nothing routed without aliases")
+	}
+}
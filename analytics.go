@@ -0,0 +1,230 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnalyticsPath serves the analytics report to admins
+const AnalyticsPath = "/-/analytics"
+
+// maxAnalyticsKeys bounds the distinct paths or referrers tracked per
+// bucket; the remainder are counted under otherKey
+const (
+	maxAnalyticsKeys = 10000
+	otherKey         = "(other)"
+)
+
+type analyticsBucket struct {
+	start     time.Time
+	requests  int64
+	paths     map[string]int64
+	referrers map[string]int64
+	statuses  map[string]int64
+	bandwidth map[string]int64
+}
+
+func newAnalyticsBucket(start time.Time) *analyticsBucket {
+	return &analyticsBucket{
+		start:     start,
+		paths:     map[string]int64{},
+		referrers: map[string]int64{},
+		statuses:  map[string]int64{},
+		bandwidth: map[string]int64{},
+	}
+}
+
+func incr(counts map[string]int64, key string, n int64) {
+	if _, ok := counts[key]; !ok && len(counts) >= maxAnalyticsKeys {
+		key = otherKey
+	}
+	counts[key] += n
+}
+
+// Analytics keeps rolling, server side traffic aggregates in hourly
+// buckets.  Only the referring host is kept and nothing identifies
+// individual visitors.
+type Analytics struct {
+	mu      sync.Mutex
+	buckets []*analyticsBucket // oldest first
+	hours   int
+	now     func() time.Time
+}
+
+// NewAnalytics retains the given number of hours of aggregates
+func NewAnalytics(hours int) *Analytics {
+	return &Analytics{hours: hours, now: time.Now}
+}
+
+// Record adds a completed request to the current bucket
+func (a *Analytics) Record(req *http.Request, status int, bytes int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	hour := a.now().Truncate(time.Hour)
+	if n := len(a.buckets); n == 0 || a.buckets[n-1].start.Before(hour) {
+		a.buckets = append(a.buckets, newAnalyticsBucket(hour))
+	}
+	cutoff := hour.Add(-time.Duration(a.hours) * time.Hour)
+	for len(a.buckets) > 0 && !a.buckets[0].start.After(cutoff) {
+		a.buckets = a.buckets[1:]
+	}
+
+	b := a.buckets[len(a.buckets)-1]
+	b.requests++
+	incr(b.paths, req.URL.Path, 1)
+	incr(b.statuses, strconv.Itoa(status), 1)
+	incr(b.bandwidth, pathPrefix(req.URL.Path), bytes)
+	if referrer := referringHost(req); referrer != "" {
+		incr(b.referrers, referrer, 1)
+	}
+}
+
+// pathPrefix returns the top level directory of urlPath e.g. /docs/
+func pathPrefix(urlPath string) string {
+	segments := strings.SplitN(strings.TrimPrefix(urlPath, "/"), "/", 2)
+	if len(segments) < 2 {
+		return "/"
+	}
+	return "/" + segments[0] + "/"
+}
+
+// referringHost returns the host of an external referrer, if any
+func referringHost(req *http.Request) string {
+	u, err := url.Parse(req.Referer())
+	if err != nil || u.Host == "" || u.Host == req.Host {
+		return ""
+	}
+	return u.Host
+}
+
+// AnalyticsCount is a single ranked entry in a report
+type AnalyticsCount struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// AnalyticsReport summarizes the retained window
+type AnalyticsReport struct {
+	Since     time.Time        `json:"since"`
+	Requests  int64            `json:"requests"`
+	Paths     []AnalyticsCount `json:"paths"`
+	Referrers []AnalyticsCount `json:"referrers"`
+	Statuses  []AnalyticsCount `json:"statuses"`
+	Bandwidth []AnalyticsCount `json:"bandwidth"`
+}
+
+// Report returns the top n entries of each aggregate over the window
+func (a *Analytics) Report(n int) AnalyticsReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	paths, referrers, statuses, bandwidth := map[string]int64{}, map[string]int64{}, map[string]int64{}, map[string]int64{}
+	report := AnalyticsReport{}
+	for i, b := range a.buckets {
+		if i == 0 {
+			report.Since = b.start
+		}
+		report.Requests += b.requests
+		for _, pair := range []struct{ dst, src map[string]int64 }{
+			{paths, b.paths}, {referrers, b.referrers}, {statuses, b.statuses}, {bandwidth, b.bandwidth},
+		} {
+			for key, count := range pair.src {
+				pair.dst[key] += count
+			}
+		}
+	}
+
+	report.Paths = top(paths, n)
+	report.Referrers = top(referrers, n)
+	report.Statuses = top(statuses, n)
+	report.Bandwidth = top(bandwidth, n)
+	return report
+}
+
+func top(counts map[string]int64, n int) []AnalyticsCount {
+	ranked := make([]AnalyticsCount, 0, len(counts))
+	for key, count := range counts {
+		ranked = append(ranked, AnalyticsCount{Key: key, Count: count})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Key < ranked[j].Key
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+var analyticsTemplate = template.Must(template.New("analytics").Parse(`<!DOCTYPE html>
+<html><head><title>s3site analytics</title></head>
+<body>
+<h1>{{.Requests}} requests since {{.Since.Format "2006-01-02 15:04 MST"}}</h1>
+{{define "table"}}<table>{{range .}}<tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>{{end}}</table>{{end}}
+<h2>Top paths</h2>{{template "table" .Paths}}
+<h2>Referrers</h2>{{template "table" .Referrers}}
+<h2>Status codes</h2>{{template "table" .Statuses}}
+<h2>Bytes by prefix</h2>{{template "table" .Bandwidth}}
+</body></html>
+`))
+
+// Wrap records each request served by h and serves the report at
+// AnalyticsPath to admins, as html when ?format=html
+func (a *Analytics) Wrap(live *LiveOptions, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == AnalyticsPath {
+			if !live.Load().IsAdmin(req) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			report := a.Report(20)
+			if req.URL.Query().Get("format") == "html" {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				analyticsTemplate.Execute(w, report)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(report)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, req)
+		if !strings.HasPrefix(req.URL.Path, "/-/") {
+			a.Record(req, recorder.Status(), recorder.bytes)
+		}
+	})
+}
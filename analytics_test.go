@@ -0,0 +1,83 @@
+package s3site
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyticsReport(t *testing.T) {
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	a := NewAnalytics(2)
+	a.now = func() time.Time { return now }
+
+	req := httptest.NewRequest("GET", "/docs/a.html", nil)
+	req.Header.Set("Referer", "https://news.example.com/item?id=1")
+	a.Record(req, 200, 100)
+	a.Record(httptest.NewRequest("GET", "/docs/a.html", nil), 200, 100)
+	a.Record(httptest.NewRequest("GET", "/missing", nil), 404, 10)
+
+	report := a.Report(1)
+	if report.Requests != 3 || !report.Since.Equal(now.Truncate(time.Hour)) {
+		t.Errorf("unexpected report totals, %+v", report)
+	}
+	if len(report.Paths) != 1 || report.Paths[0] != (AnalyticsCount{"/docs/a.html", 2}) {
+		t.Errorf("unexpected top paths, %v", report.Paths)
+	}
+	if report.Referrers[0] != (AnalyticsCount{"news.example.com", 1}) {
+		t.Errorf("expected only the referring host, got %v", report.Referrers)
+	}
+	if report.Bandwidth[0] != (AnalyticsCount{"/docs/", 200}) {
+		t.Errorf("unexpected bandwidth, %v", report.Bandwidth)
+	}
+
+	// buckets older than the window roll off
+	now = now.Add(2 * time.Hour)
+	a.Record(httptest.NewRequest("GET", "/", nil), 200, 1)
+	now = now.Add(time.Hour)
+	a.Record(httptest.NewRequest("GET", "/", nil), 200, 1)
+	if report := a.Report(10); report.Requests != 2 {
+		t.Errorf("expected old buckets to roll off, got %d requests", report.Requests)
+	}
+}
+
+func TestAnalyticsWrap(t *testing.T) {
+	opts := &Options{AdminToken: "secret"}
+	a := NewAnalytics(24)
+	h := a.Wrap(NewLiveOptions(opts, nil), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/index.html", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/-/cache", nil))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", AnalyticsPath, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", AnalyticsPath, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	report := AnalyticsReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != 1 || report.Bandwidth[0] != (AnalyticsCount{"/", 5}) {
+		t.Errorf("expected only the site request to be recorded, got %+v", report)
+	}
+
+	req = httptest.NewRequest("GET", AnalyticsPath+"?format=html", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "<td>/index.html</td><td>1</td>") {
+		t.Errorf("unexpected html report, %s", w.Body.String())
+	}
+}
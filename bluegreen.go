@@ -0,0 +1,138 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// SwitchPath reports, and on POST switches, the live blue/green slot
+	SwitchPath = "/-/switch"
+
+	SlotBlue  = "blue"
+	SlotGreen = "green"
+)
+
+// BlueGreen returns true when the options configure blue and green prefixes
+func (o *Options) BlueGreen() bool {
+	return o.BluePrefix != "" || o.GreenPrefix != ""
+}
+
+// livePrefix returns the prefix serving traffic; the live slot's prefix
+// when blue/green prefixes are configured, otherwise the prefix
+func (o *Options) livePrefix() string {
+	switch {
+	case !o.BlueGreen():
+		return o.Prefix
+	case o.Slot == SlotGreen:
+		return o.GreenPrefix
+	default:
+		return o.BluePrefix
+	}
+}
+
+type switchRequest struct {
+	Slot string `json:"slot"`
+}
+
+type switchResponse struct {
+	Live     string `json:"live"`
+	Previous string `json:"previous,omitempty"`
+	Prefix   string `json:"prefix"`
+	Blue     string `json:"blue"`
+	Green    string `json:"green"`
+}
+
+// SwitchHandler reports the live slot on GET /-/switch and switches it on
+// POST /-/switch {"slot": "green"}; an empty body switches to the other slot.
+// Requests in flight finish on the slot they started on.
+func SwitchHandler(live *LiveOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		opts := live.Load()
+		if !opts.IsAdmin(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !opts.BlueGreen() {
+			http.Error(w, "blue-prefix and green-prefix are not configured", http.StatusNotFound)
+			return
+		}
+
+		current := live.Load()
+		out := switchResponse{Live: current.Slot}
+		switch req.Method {
+		case "GET":
+		case "POST":
+			in := switchRequest{}
+			if req.ContentLength != 0 {
+				if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			slot, err := switchSlot(live, in.Slot)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			out = switchResponse{Live: slot, Previous: current.Slot}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		current = live.Load()
+		out.Prefix, out.Blue, out.Green = current.livePrefix(), current.BluePrefix, current.GreenPrefix
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// switchSlot makes slot live, or the other slot when slot is empty,
+// returning the slot now live
+func switchSlot(live *LiveOptions, slot string) (string, error) {
+	switch slot {
+	case "", SlotBlue, SlotGreen:
+	default:
+		return "", fmt.Errorf("unknown slot, %s; use blue or green", slot)
+	}
+
+	var previous string
+	live.Update(func(o *Options) {
+		previous = o.Slot
+		if slot == "" {
+			slot = SlotGreen
+			if o.Slot == SlotGreen {
+				slot = SlotBlue
+			}
+		}
+		o.Slot = slot
+	})
+	if slot != previous {
+		logger.Info("switched live slot", Fields{"live": slot, "previous": previous, "prefix": live.Load().livePrefix()})
+	}
+	return slot, nil
+}
@@ -0,0 +1,83 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSwitchHandler(t *testing.T) {
+	opts := &Options{AdminToken: "secret", BluePrefix: "blue/", GreenPrefix: "green/", Slot: SlotBlue, IndexFile: "index.html"}
+	live := NewLiveOptions(opts, func() (*Options, error) { return opts, nil })
+	handler := SwitchHandler(live)
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, SwitchPath, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if key := live.Load().Key("/"); key != "blue/index.html" {
+		t.Errorf("expected blue to be live, got %s", key)
+	}
+
+	w := serve("POST", "")
+	if body := strings.TrimSpace(w.Body.String()); body != `{"live":"green","previous":"blue","prefix":"green/","blue":"blue/","green":"green/"}` {
+		t.Errorf("unexpected response %s", body)
+	}
+	if key := live.Load().Key("/"); key != "green/index.html" {
+		t.Errorf("expected green to be live, got %s", key)
+	}
+	if opts.Slot != SlotBlue {
+		t.Error("expected the switch to leave the loaded options unchanged")
+	}
+
+	serve("POST", `{"slot":"blue"}`)
+	if slot := live.Load().Slot; slot != SlotBlue {
+		t.Errorf("expected to roll back to blue, got %s", slot)
+	}
+	if w := serve("POST", `{"slot":"purple"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown slot, got %d", w.Code)
+	}
+	if body := strings.TrimSpace(serve("GET", "").Body.String()); body != `{"live":"blue","prefix":"blue/","blue":"blue/","green":"green/"}` {
+		t.Errorf("unexpected response %s", body)
+	}
+
+	if _, err := live.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	serve("POST", "")
+	if _, err := live.Reload(); err != nil || live.Load().Slot != SlotGreen {
+		t.Errorf("expected reload to keep the switched slot, got %s", live.Load().Slot)
+	}
+
+	req, _ := http.NewRequest("POST", SwitchPath, nil)
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", w.Code)
+	}
+}
+
+func TestValidateBlueGreen(t *testing.T) {
+	testCases := map[string]func(*Options){
+		"blue-prefix": func(o *Options) { o.Prefix, o.BluePrefix = "", "blue/" },
+		"prefix":      func(o *Options) { o.Prefix, o.BluePrefix, o.GreenPrefix = "site/", "blue/", "green/" },
+		"releases": func(o *Options) {
+			o.Prefix, o.BluePrefix, o.GreenPrefix, o.Releases, o.ReleasePoll = "", "blue/", "green/", true, time.Second
+		},
+		"live-slot": func(o *Options) { o.Slot = "purple" },
+	}
+	for field, fn := range testCases {
+		opts := validOptions()
+		fn(opts)
+		problems, _ := opts.Validate().(ConfigError)
+		if len(problems) != 1 || problems[0].Field != field {
+			t.Errorf("%s: unexpected problems, %v", field, problems)
+		}
+	}
+}
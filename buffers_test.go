@@ -0,0 +1,71 @@
+package s3site
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// onlyReader hides any WriterTo implementation so copies exercise the buffer
+type onlyReader struct {
+	io.Reader
+}
+
+// onlyWriter hides any ReaderFrom implementation for the same reason
+type onlyWriter struct {
+	io.Writer
+}
+
+var payload = bytes.Repeat([]byte("s3site"), 50000)
+
+func TestReadBody(t *testing.T) {
+	for _, length := range []int64{int64(len(payload)), -1} {
+		data, err := readBody(onlyReader{bytes.NewReader(payload)}, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, payload) {
+			t.Errorf("length %d: body mismatch", length)
+		}
+	}
+
+	if _, err := readBody(bytes.NewReader(payload[:10]), 20); err == nil {
+		t.Error("expected short body to fail")
+	}
+}
+
+func BenchmarkReadAll(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ioutil.ReadAll(onlyReader{bytes.NewReader(payload)})
+	}
+}
+
+func BenchmarkReadBodyKnownLength(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		readBody(onlyReader{bytes.NewReader(payload)}, int64(len(payload)))
+	}
+}
+
+func BenchmarkReadBodyUnknownLength(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		readBody(onlyReader{bytes.NewReader(payload)}, -1)
+	}
+}
+
+func BenchmarkCopy(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		io.Copy(onlyWriter{ioutil.Discard}, onlyReader{bytes.NewReader(payload)})
+	}
+}
+
+func BenchmarkCopyPooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		copyPooled(onlyWriter{ioutil.Discard}, onlyReader{bytes.NewReader(payload)})
+	}
+}
@@ -0,0 +1,378 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"container/list"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CacheHit   = "HIT"
+	CacheMiss  = "MISS"
+	CacheStale = "STALE"
+)
+
+// CacheStats counts cache activity
+type CacheStats struct {
+	Hits       int64
+	Misses     int64
+	Stale      int64
+	Evictions  int64
+	Fills      int64
+	FillNanos  int64
+	BytesSaved int64
+
+	Revalidations int64
+	NotModified   int64
+	Rejected      int64
+}
+
+// Record counts a lookup with the given outcome
+func (s *CacheStats) Record(status string, entry *Entry) {
+	switch status {
+	case CacheHit:
+		atomic.AddInt64(&s.Hits, 1)
+		atomic.AddInt64(&s.BytesSaved, int64(len(entry.Body)))
+	case CacheStale:
+		atomic.AddInt64(&s.Stale, 1)
+		atomic.AddInt64(&s.BytesSaved, int64(len(entry.Body)))
+	case CacheMiss:
+		atomic.AddInt64(&s.Misses, 1)
+	}
+}
+
+// RecordFill counts an object fetched from s3 into the cache
+func (s *CacheStats) RecordFill(elapsed time.Duration) {
+	atomic.AddInt64(&s.Fills, 1)
+	atomic.AddInt64(&s.FillNanos, int64(elapsed))
+}
+
+// RecordRevalidation counts a conditional fetch to s3
+func (s *CacheStats) RecordRevalidation(notModified bool) {
+	atomic.AddInt64(&s.Revalidations, 1)
+	if notModified {
+		atomic.AddInt64(&s.NotModified, 1)
+	}
+}
+
+// CacheSnapshot is a point in time view of the cache suitable for reporting
+type CacheSnapshot struct {
+	Entries     int     `json:"entries"`
+	Bytes       int64   `json:"bytes"`
+	MaxBytes    int64   `json:"max_bytes"`
+	Hits        int64   `json:"hits"`
+	Misses      int64   `json:"misses"`
+	Stale       int64   `json:"stale"`
+	HitRatio    float64 `json:"hit_ratio"`
+	Evictions   int64   `json:"evictions"`
+	Fills       int64   `json:"fills"`
+	FillLatency float64 `json:"fill_latency_ms"`
+	BytesSaved  int64   `json:"bytes_saved"`
+
+	Revalidations int64 `json:"revalidations"`
+	NotModified   int64 `json:"not_modified"`
+	Rejected      int64 `json:"rejected"`
+}
+
+// add sums s and o, weighting the ratios by their lookups and fills
+func (s CacheSnapshot) add(o CacheSnapshot) CacheSnapshot {
+	fillMillis := s.FillLatency*float64(s.Fills) + o.FillLatency*float64(o.Fills)
+
+	s.Entries += o.Entries
+	s.Bytes += o.Bytes
+	s.MaxBytes += o.MaxBytes
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Stale += o.Stale
+	s.Evictions += o.Evictions
+	s.Fills += o.Fills
+	s.BytesSaved += o.BytesSaved
+	s.Revalidations += o.Revalidations
+	s.NotModified += o.NotModified
+	s.Rejected += o.Rejected
+
+	s.HitRatio, s.FillLatency = 0, 0
+	if lookups := s.Hits + s.Stale + s.Misses; lookups > 0 {
+		s.HitRatio = float64(s.Hits+s.Stale) / float64(lookups)
+	}
+	if s.Fills > 0 {
+		s.FillLatency = fillMillis / float64(s.Fills)
+	}
+	return s
+}
+
+// Entry is a single object held in the cache
+type Entry struct {
+	Key          string
+	Variant      string
+	Body         []byte
+	ContentType  string
+	ETag         string
+	LastModified string
+	// CacheControl is the object's Cache-Control metadata, which takes the
+	// place of max-age e.g. for fingerprinted assets
+	CacheControl string
+	Fetched      time.Time
+	// Stream is set in place of Body for objects too large, or otherwise
+	// not admitted, to be held in the cache
+	Stream io.ReadCloser
+	// Restored entries were loaded from a previous run's disk cache and
+	// have yet to be revalidated
+	Restored bool
+}
+
+// CacheKey identifies the entry within the cache
+func (e *Entry) CacheKey() string {
+	return CacheKey(e.Key, e.Variant)
+}
+
+// CacheKey combines an s3 key with the normalized query string variant
+func CacheKey(key, variant string) string {
+	if variant == "" {
+		return key
+	}
+	return key + "?" + variant
+}
+
+// Age returns how long ago the entry was last confirmed against s3
+func (e *Entry) Age() time.Duration {
+	return time.Since(e.Fetched)
+}
+
+// Cache is a size bounded, least recently used cache of s3 objects held
+// in memory and, if Disk is set, backed by a larger on-disk tier
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[string]*list.Element
+	pending  map[string]bool
+	Stats    CacheStats
+	Disk     *DiskStore
+	// Frequency, if set, enables TinyLFU admission; a new entry may only
+	// displace an entry that has been requested less often
+	Frequency *Sketch
+}
+
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+		pending:  map[string]bool{},
+	}
+}
+
+func (c *Cache) Get(key string) (*Entry, bool) {
+	if c.Frequency != nil {
+		c.Frequency.Increment(key)
+	}
+
+	c.mu.Lock()
+	element, ok := c.items[key]
+	if ok {
+		c.ll.MoveToFront(element)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		return element.Value.(*Entry), true
+	}
+	if c.Disk == nil {
+		return nil, false
+	}
+
+	entry, ok := c.Disk.Get(key)
+	if ok {
+		c.set(entry)
+	}
+	return entry, ok
+}
+
+func (c *Cache) Set(entry *Entry) {
+	c.set(entry)
+	if c.Disk != nil {
+		c.Disk.Put(entry)
+	}
+}
+
+// set stores entry in memory only
+func (c *Cache) set(entry *Entry) {
+	size := int64(len(entry.Body))
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[entry.CacheKey()]; ok {
+		c.remove(element)
+	} else if c.Frequency != nil && c.bytes+size > c.maxBytes && c.ll.Len() > 0 {
+		victim := c.ll.Back().Value.(*Entry)
+		if c.Frequency.Estimate(entry.CacheKey()) <= c.Frequency.Estimate(victim.CacheKey()) {
+			atomic.AddInt64(&c.Stats.Rejected, 1)
+			return
+		}
+	}
+	c.items[entry.CacheKey()] = c.ll.PushFront(entry)
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.ll.Back())
+		atomic.AddInt64(&c.Stats.Evictions, 1)
+	}
+}
+
+// Touch marks the entry as freshly confirmed against s3 and returns the
+// updated entry or nil if key is no longer cached
+func (c *Cache) Touch(key string) *Entry {
+	now := time.Now()
+	if c.Disk != nil {
+		c.Disk.Touch(key, now)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	entry := *element.Value.(*Entry)
+	entry.Fetched = now
+	entry.Restored = false
+	element.Value = &entry
+	return &entry
+}
+
+// Delete removes key, along with all of its variants, from the cache and
+// returns the number of entries removed
+func (c *Cache) Delete(key string) int {
+	return c.removeMatching(func(cacheKey string) bool {
+		return cacheKey == key || strings.HasPrefix(cacheKey, key+"?")
+	})
+}
+
+// Remove removes the single entry identified by cacheKey
+func (c *Cache) Remove(cacheKey string) {
+	c.removeMatching(func(candidate string) bool {
+		return candidate == cacheKey
+	})
+}
+
+// DeletePrefix removes every key beginning with prefix and returns the
+// number of entries removed
+func (c *Cache) DeletePrefix(prefix string) int {
+	return c.removeMatching(func(cacheKey string) bool {
+		return strings.HasPrefix(cacheKey, prefix)
+	})
+}
+
+// removeMatching removes matching entries from every tier and returns the
+// number of distinct entries removed
+func (c *Cache) removeMatching(match func(string) bool) int {
+	removed := map[string]bool{}
+
+	c.mu.Lock()
+	for cacheKey, element := range c.items {
+		if match(cacheKey) {
+			c.remove(element)
+			removed[cacheKey] = true
+		}
+	}
+	c.mu.Unlock()
+
+	if c.Disk != nil {
+		matches := []string{}
+		for _, cacheKey := range c.Disk.Keys() {
+			if match(cacheKey) {
+				matches = append(matches, cacheKey)
+			}
+		}
+		for _, cacheKey := range c.Disk.Remove(matches...) {
+			removed[cacheKey] = true
+		}
+	}
+	return len(removed)
+}
+
+func (c *Cache) remove(element *list.Element) {
+	entry := c.ll.Remove(element).(*Entry)
+	delete(c.items, entry.CacheKey())
+	c.bytes -= int64(len(entry.Body))
+}
+
+// Claim marks key as being revalidated; returns false if another
+// revalidation for the key is already in flight
+func (c *Cache) Claim(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending[key] {
+		return false
+	}
+	c.pending[key] = true
+	return true
+}
+
+func (c *Cache) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, key)
+}
+
+func (c *Cache) Snapshot() CacheSnapshot {
+	c.mu.Lock()
+	snapshot := CacheSnapshot{
+		Entries:  len(c.items),
+		Bytes:    c.bytes,
+		MaxBytes: c.maxBytes,
+	}
+	c.mu.Unlock()
+
+	snapshot.Hits = atomic.LoadInt64(&c.Stats.Hits)
+	snapshot.Misses = atomic.LoadInt64(&c.Stats.Misses)
+	snapshot.Stale = atomic.LoadInt64(&c.Stats.Stale)
+	snapshot.Evictions = atomic.LoadInt64(&c.Stats.Evictions)
+	snapshot.Fills = atomic.LoadInt64(&c.Stats.Fills)
+	snapshot.BytesSaved = atomic.LoadInt64(&c.Stats.BytesSaved)
+	snapshot.Revalidations = atomic.LoadInt64(&c.Stats.Revalidations)
+	snapshot.NotModified = atomic.LoadInt64(&c.Stats.NotModified)
+	snapshot.Rejected = atomic.LoadInt64(&c.Stats.Rejected)
+
+	if lookups := snapshot.Hits + snapshot.Stale + snapshot.Misses; lookups > 0 {
+		snapshot.HitRatio = float64(snapshot.Hits+snapshot.Stale) / float64(lookups)
+	}
+	if snapshot.Fills > 0 {
+		snapshot.FillLatency = float64(atomic.LoadInt64(&c.Stats.FillNanos)) / float64(snapshot.Fills) / float64(time.Millisecond)
+	}
+	return snapshot
+}
@@ -0,0 +1,107 @@
+package s3site
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewCache(10)
+	cache.Set(&Entry{Key: "a", Body: []byte("12345")})
+	cache.Set(&Entry{Key: "b", Body: []byte("12345")})
+	cache.Get("a")
+	cache.Set(&Entry{Key: "c", Body: []byte("12345")})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("expected a to be retained")
+	}
+}
+
+func TestCacheRejectsOversizedEntries(t *testing.T) {
+	cache := NewCache(4)
+	cache.Set(&Entry{Key: "a", Body: []byte("12345")})
+
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expected oversized entry to be skipped")
+	}
+}
+
+func TestCacheTouch(t *testing.T) {
+	cache := NewCache(10)
+	cache.Set(&Entry{Key: "a", Body: []byte("1"), Fetched: time.Now().Add(-time.Hour)})
+	cache.Touch("a")
+
+	entry, _ := cache.Get("a")
+	if entry.Age() > time.Minute {
+		t.Errorf("expected entry to be refreshed, got age %v", entry.Age())
+	}
+}
+
+func TestCacheClaim(t *testing.T) {
+	cache := NewCache(10)
+	if !cache.Claim("a") {
+		t.Fatal("expected first claim to succeed")
+	}
+	if cache.Claim("a") {
+		t.Fatal("expected second claim to fail")
+	}
+	cache.Release("a")
+	if !cache.Claim("a") {
+		t.Fatal("expected claim after release to succeed")
+	}
+}
+
+func TestCacheDeletePrefix(t *testing.T) {
+	cache := NewCache(10)
+	cache.Set(&Entry{Key: "docs/a", Body: []byte("1")})
+	cache.Set(&Entry{Key: "docs/b", Body: []byte("1")})
+	cache.Set(&Entry{Key: "img/c", Body: []byte("1")})
+
+	if n := cache.DeletePrefix("docs/"); n != 2 {
+		t.Errorf("expected 2 entries purged, got %d", n)
+	}
+	if _, ok := cache.Get("img/c"); !ok {
+		t.Error("expected img/c to be retained")
+	}
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	cache := NewCache(10)
+	cache.Set(&Entry{Key: "a", Body: []byte("12345")})
+	cache.Set(&Entry{Key: "b", Body: []byte("12345")})
+	cache.Set(&Entry{Key: "c", Body: []byte("12345")})
+
+	entry, _ := cache.Get("c")
+	cache.Stats.Record(CacheHit, entry)
+	cache.Stats.Record(CacheStale, entry)
+	cache.Stats.Record(CacheMiss, nil)
+	cache.Stats.Record(CacheMiss, nil)
+
+	snapshot := cache.Snapshot()
+	if snapshot.Evictions != 1 {
+		t.Errorf("expected 1 eviction, got %d", snapshot.Evictions)
+	}
+	if snapshot.HitRatio != 0.5 {
+		t.Errorf("expected hit ratio of 0.5, got %v", snapshot.HitRatio)
+	}
+	if snapshot.BytesSaved != 10 {
+		t.Errorf("expected 10 bytes saved, got %d", snapshot.BytesSaved)
+	}
+}
+
+func TestCacheDeleteVariants(t *testing.T) {
+	cache := NewCache(10)
+	cache.Set(&Entry{Key: "a.png", Body: []byte("1")})
+	cache.Set(&Entry{Key: "a.png", Variant: "w=200", Body: []byte("1")})
+	cache.Set(&Entry{Key: "a.png.bak", Body: []byte("1")})
+
+	if n := cache.Delete("a.png"); n != 2 {
+		t.Errorf("expected 2 entries deleted, got %d", n)
+	}
+	if _, ok := cache.Get("a.png.bak"); !ok {
+		t.Error("expected a.png.bak to be retained")
+	}
+}
@@ -0,0 +1,137 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// CanaryPath reports, and on POST adjusts, the share of traffic served
+	// from the canary prefix
+	CanaryPath = "/-/canary"
+
+	// CanaryCookie holds the visitor's draw, 0-99, so each visitor sticks to
+	// one side of the split for as long as the split allows
+	CanaryCookie = "s3site_canary"
+
+	canaryCookieAge = 30 * 24 * 60 * 60
+)
+
+// canaryDraw returns the visitor's draw, 0-99; from the canary cookie, which
+// is set when missing, or a hash of the client address
+func (o *Options) canaryDraw(w http.ResponseWriter, req *http.Request) int {
+	if o.CanarySticky == "ip" {
+		h := fnv.New32a()
+		h.Write([]byte(clientIP(req)))
+		return int(h.Sum32() % 100)
+	}
+
+	if c, err := req.Cookie(CanaryCookie); err == nil {
+		if draw, err := strconv.Atoi(c.Value); err == nil && draw >= 0 && draw < 100 {
+			return draw
+		}
+	}
+	draw := rand.Intn(100)
+	http.SetCookie(w, &http.Cookie{
+		Name:     CanaryCookie,
+		Value:    strconv.Itoa(draw),
+		Path:     "/",
+		MaxAge:   canaryCookieAge,
+		HttpOnly: true,
+	})
+	return draw
+}
+
+// forCanary returns o serving the canary prefix when the visitor's draw
+// falls within the canary percent, otherwise o as is
+func (o *Options) forCanary(w http.ResponseWriter, req *http.Request) (*Options, bool) {
+	if o.CanaryPrefix == "" || o.CanaryPercent <= 0 {
+		return o, false
+	}
+	if o.canaryDraw(w, req) >= o.CanaryPercent {
+		return o, false
+	}
+
+	return o.withPrefix(o.CanaryPrefix), true
+}
+
+type canaryRequest struct {
+	Percent *int `json:"percent"`
+}
+
+type canaryResponse struct {
+	Prefix   string `json:"prefix"`
+	Percent  int    `json:"percent"`
+	Previous *int   `json:"previous,omitempty"`
+}
+
+// CanaryHandler reports the canary split on GET /-/canary and adjusts it on
+// POST /-/canary {"percent": 10}; a percent of 0 sends all traffic to the
+// stable prefix
+func CanaryHandler(live *LiveOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		opts := live.Load()
+		if !opts.IsAdmin(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if opts.CanaryPrefix == "" {
+			http.Error(w, "canary-prefix is not configured", http.StatusNotFound)
+			return
+		}
+
+		out := canaryResponse{}
+		switch req.Method {
+		case "GET":
+		case "POST":
+			in := canaryRequest{}
+			if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if in.Percent == nil || *in.Percent < 0 || *in.Percent > 100 {
+				http.Error(w, "percent, 0-100, is required", http.StatusBadRequest)
+				return
+			}
+			previous := live.Load().CanaryPercent
+			live.Update(func(o *Options) {
+				o.CanaryPercent = *in.Percent
+			})
+			out.Previous = &previous
+			logger.Info("adjusted canary", Fields{"prefix": opts.CanaryPrefix, "percent": *in.Percent, "previous": previous})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		current := live.Load()
+		out.Prefix, out.Percent = current.CanaryPrefix, current.CanaryPercent
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
@@ -0,0 +1,77 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForCanary(t *testing.T) {
+	opts := &Options{Prefix: "stable/", CanaryPrefix: "canary/", CanaryPercent: 10, IndexFile: "index.html"}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: CanaryCookie, Value: "5"})
+	w := httptest.NewRecorder()
+	site, canary := opts.forCanary(w, req)
+	if !canary || site.Key("/") != "canary/index.html" {
+		t.Errorf("expected draw 5 to be served the canary, got %s", site.Key("/"))
+	}
+	if w.Header().Get("Set-Cookie") != "" {
+		t.Error("expected the existing draw to be kept")
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: CanaryCookie, Value: "50"})
+	if site, canary := opts.forCanary(httptest.NewRecorder(), req); canary || site != opts {
+		t.Error("expected draw 50 to be served the stable prefix")
+	}
+
+	w = httptest.NewRecorder()
+	opts.forCanary(w, httptest.NewRequest("GET", "/", nil))
+	if cookie := w.Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, CanaryCookie+"=") {
+		t.Errorf("expected a new visitor to be assigned a draw, got %s", cookie)
+	}
+
+	opts.CanarySticky = "ip"
+	req = httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	draw := opts.canaryDraw(httptest.NewRecorder(), req)
+	for i := 0; i < 5; i++ {
+		if again := opts.canaryDraw(httptest.NewRecorder(), req); again != draw {
+			t.Fatalf("expected the same address to draw %d, got %d", draw, again)
+		}
+	}
+}
+
+func TestCanaryHandler(t *testing.T) {
+	opts := &Options{AdminToken: "secret", Prefix: "stable/", CanaryPrefix: "canary/", CanaryPercent: 5}
+	live := NewLiveOptions(opts, func() (*Options, error) { return opts, nil })
+	handler := CanaryHandler(live)
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, CanaryPath, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if body := strings.TrimSpace(serve("POST", `{"percent":25}`).Body.String()); body != `{"prefix":"canary/","percent":25,"previous":5}` {
+		t.Errorf("unexpected response %s", body)
+	}
+	if percent := live.Load().CanaryPercent; percent != 25 {
+		t.Errorf("expected 25 percent, got %d", percent)
+	}
+	if _, err := live.Reload(); err != nil || live.Load().CanaryPercent != 25 {
+		t.Errorf("expected reload to keep the adjusted percent, got %d", live.Load().CanaryPercent)
+	}
+	for _, body := range []string{`{}`, `{"percent":101}`, `{"percent":-1}`} {
+		if w := serve("POST", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	if body := strings.TrimSpace(serve("GET", "").Body.String()); body != `{"prefix":"canary/","percent":25}` {
+		t.Errorf("unexpected response %s", body)
+	}
+}
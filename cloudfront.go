@@ -0,0 +1,101 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+const cloudFrontEndpoint = "https://cloudfront.amazonaws.com/2020-05-31"
+
+// CloudFront invalidates paths in a cloudfront distribution fronting the site
+type CloudFront struct {
+	DistributionID string
+	Auth           aws.Auth
+	Client         *http.Client
+	Endpoint       string // defaults to the public cloudfront api
+}
+
+type invalidationBatch struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Items           []string `xml:"Paths>Items>Path"`
+	CallerReference string
+}
+
+type invalidation struct {
+	ID string `xml:"Id"`
+}
+
+// Invalidate creates an invalidation for paths, which may end in * to
+// match a prefix, and returns the invalidation id
+func (c *CloudFront) Invalidate(paths []string) (string, error) {
+	batch := invalidationBatch{
+		Quantity:        len(paths),
+		Items:           paths,
+		CallerReference: strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+	payload, err := xml.Marshal(batch)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = cloudFrontEndpoint
+	}
+
+	url := fmt.Sprintf("%s/distribution/%s/invalidation", endpoint, c.DistributionID)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	SignV4(req, c.Auth, "us-east-1", "cloudfront", payload, time.Now())
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("unable to invalidate cloudfront distribution %s: %s: %s", c.DistributionID, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	result := invalidation{}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
@@ -0,0 +1,39 @@
+package s3site
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCloudFrontInvalidate(t *testing.T) {
+	var batch invalidationBatch
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/distribution/E123/invalidation" {
+			t.Errorf("unexpected path %s", req.URL.Path)
+		}
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			t.Error("expected request to be signed")
+		}
+		xml.NewDecoder(req.Body).Decode(&batch)
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`<Invalidation><Id>I2J0I21PCUKOKK</Id></Invalidation>`))
+	}))
+	defer server.Close()
+
+	cdn := &CloudFront{DistributionID: "E123", Client: http.DefaultClient, Endpoint: server.URL}
+	id, err := cdn.Invalidate([]string{"/about.html", "/docs/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "I2J0I21PCUKOKK" {
+		t.Errorf("unexpected invalidation id %s", id)
+	}
+	if batch.Quantity != 2 || !reflect.DeepEqual(batch.Items, []string{"/about.html", "/docs/*"}) {
+		t.Errorf("unexpected invalidation batch %#v", batch)
+	}
+}
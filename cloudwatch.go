@@ -0,0 +1,148 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+// CloudWatch periodically publishes request rate, error rate, latency, and
+// egress bytes as cloudwatch custom metrics.  Latency is sent as a
+// distribution of values and counts so cloudwatch can compute percentiles
+// e.g. p99 itself.
+type CloudWatch struct {
+	Namespace  string
+	Region     string
+	Auth       aws.Auth
+	Client     *http.Client
+	Endpoint   string // defaults to the regional monitoring api
+	Dimensions map[string]string
+	Metrics    *Metrics
+
+	last RequestTotals
+}
+
+// Run publishes every interval
+func (c *CloudWatch) Run(interval time.Duration) {
+	c.last = c.Metrics.Totals()
+	for range time.Tick(interval) {
+		if err := c.Publish(); err != nil {
+			logger.Warn("unable to publish cloudwatch metrics", Fields{"namespace": c.Namespace, "error": err})
+		}
+	}
+}
+
+// Publish sends the change in counters since the previous call
+func (c *CloudWatch) Publish() error {
+	totals := c.Metrics.Totals()
+	delta := totals.Sub(c.last)
+	c.last = totals
+
+	params := url.Values{}
+	params.Set("Action", "PutMetricData")
+	params.Set("Version", "2010-08-01")
+	params.Set("Namespace", c.Namespace)
+
+	n := 0
+	datum := func(name, unit string) string {
+		n++
+		prefix := fmt.Sprintf("MetricData.member.%d.", n)
+		params.Set(prefix+"MetricName", name)
+		params.Set(prefix+"Unit", unit)
+		c.dimensions(params, prefix)
+		return prefix
+	}
+
+	params.Set(datum("Requests", "Count")+"Value", strconv.FormatUint(delta.Requests, 10))
+	params.Set(datum("Errors", "Count")+"Value", strconv.FormatUint(delta.Errors, 10))
+	params.Set(datum("BytesSent", "Bytes")+"Value", strconv.FormatUint(delta.Bytes, 10))
+
+	if delta.Requests > 0 {
+		prefix := datum("Latency", "Seconds")
+		member := 0
+		for i, count := range delta.Latency {
+			if count == 0 {
+				continue
+			}
+			value := latencyBuckets[len(latencyBuckets)-1]
+			if i < len(latencyBuckets) {
+				value = latencyBuckets[i]
+			}
+			member++
+			params.Set(fmt.Sprintf("%sValues.member.%d", prefix, member), strconv.FormatFloat(value, 'g', -1, 64))
+			params.Set(fmt.Sprintf("%sCounts.member.%d", prefix, member), strconv.FormatUint(count, 10))
+		}
+	}
+
+	return c.call(params)
+}
+
+func (c *CloudWatch) dimensions(params url.Values, prefix string) {
+	names := make([]string, 0, len(c.Dimensions))
+	for name := range c.Dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		params.Set(fmt.Sprintf("%sDimensions.member.%d.Name", prefix, i+1), name)
+		params.Set(fmt.Sprintf("%sDimensions.member.%d.Value", prefix, i+1), c.Dimensions[name])
+	}
+}
+
+func (c *CloudWatch) call(params url.Values) error {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://monitoring.%s.amazonaws.com/", c.Region)
+	}
+	payload := []byte(params.Encode())
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	SignV4(req, c.Auth, c.Region, "monitoring", payload, time.Now())
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
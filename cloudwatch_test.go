@@ -0,0 +1,80 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+func TestCloudWatchPublish(t *testing.T) {
+	var params url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		params = req.PostForm
+	}))
+	defer server.Close()
+
+	metrics := NewMetrics()
+	metrics.ObserveRequest(200, 100, 20*time.Millisecond)
+	cw := &CloudWatch{
+		Namespace:  "s3site",
+		Region:     "us-east-1",
+		Auth:       aws.Auth{AccessKey: "key", SecretKey: "secret"},
+		Client:     http.DefaultClient,
+		Endpoint:   server.URL,
+		Dimensions: map[string]string{"Bucket": "example"},
+		Metrics:    metrics,
+		last:       metrics.Totals(),
+	}
+
+	metrics.ObserveRequest(200, 300, 20*time.Millisecond)
+	metrics.ObserveRequest(503, 10, 3*time.Second)
+	if err := cw.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"Action":                         "PutMetricData",
+		"Namespace":                      "s3site",
+		"MetricData.member.1.MetricName": "Requests",
+		"MetricData.member.1.Value":      "2",
+		"MetricData.member.1.Dimensions.member.1.Name":  "Bucket",
+		"MetricData.member.1.Dimensions.member.1.Value": "example",
+		"MetricData.member.2.Value":                     "1",
+		"MetricData.member.3.Value":                     "310",
+		"MetricData.member.4.MetricName":                "Latency",
+		"MetricData.member.4.Values.member.1":           "0.025",
+		"MetricData.member.4.Counts.member.1":           "1",
+		"MetricData.member.4.Values.member.2":           "5",
+		"MetricData.member.4.Counts.member.2":           "1",
+	}
+	for key, value := range expected {
+		if got := params.Get(key); got != value {
+			t.Errorf("expected %s=%s, got %q", key, value, got)
+		}
+	}
+}
+
+func TestRequestTotalsPercentile(t *testing.T) {
+	metrics := NewMetrics()
+	for i := 0; i < 98; i++ {
+		metrics.ObserveRequest(200, 0, 3*time.Millisecond)
+	}
+	metrics.ObserveRequest(200, 0, 200*time.Millisecond)
+	metrics.ObserveRequest(200, 0, time.Minute)
+
+	totals := metrics.Totals()
+	if p := totals.Percentile(0.5); p != .005 {
+		t.Errorf("expected p50 of .005, got %g", p)
+	}
+	if p := totals.Percentile(0.99); p != .25 {
+		t.Errorf("expected p99 of .25, got %g", p)
+	}
+	if p := totals.Percentile(1); p != 10 {
+		t.Errorf("expected p100 to be capped at 10, got %g", p)
+	}
+}
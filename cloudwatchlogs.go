@@ -0,0 +1,208 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+// limits imposed by PutLogEvents
+const (
+	maxLogBatchEvents = 10000
+	maxLogBatchBytes  = 1048576
+	logEventOverhead  = 26
+)
+
+type logEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// CloudWatchLogs ships log lines to a cloudwatch logs stream in batches.
+// Writes block for up to BlockFor when the buffer is full, after which the
+// line is dropped and counted, so a stalled api slows rather than stops
+// the server.
+type CloudWatchLogs struct {
+	Group    string
+	Stream   string
+	Region   string
+	Auth     aws.Auth
+	Client   *http.Client
+	Endpoint string // defaults to the regional logs api
+	BlockFor time.Duration
+
+	events  chan logEvent
+	done    chan struct{}
+	Dropped int64
+}
+
+// NewCloudWatchLogs buffers up to size lines
+func NewCloudWatchLogs(group, stream, region string, auth aws.Auth, size int) *CloudWatchLogs {
+	return &CloudWatchLogs{
+		Group:    group,
+		Stream:   stream,
+		Region:   region,
+		Auth:     auth,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		BlockFor: time.Second,
+		events:   make(chan logEvent, size),
+		done:     make(chan struct{}),
+	}
+}
+
+// Write queues each line of p as a log event
+func (c *CloudWatchLogs) Write(p []byte) (int, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		event := logEvent{Timestamp: now, Message: line}
+		select {
+		case c.events <- event:
+		default:
+			select {
+			case c.events <- event:
+			case <-time.After(c.BlockFor):
+				atomic.AddInt64(&c.Dropped, 1)
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Run creates the stream, if needed, then ships batches every interval or
+// whenever a batch fills, until Close is called
+func (c *CloudWatchLogs) Run(interval time.Duration) {
+	defer close(c.done)
+
+	if err := c.call("CreateLogStream", map[string]string{"logGroupName": c.Group, "logStreamName": c.Stream}); err != nil && !strings.Contains(err.Error(), "ResourceAlreadyExistsException") {
+		c.warn("unable to create cloudwatch log stream", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var batch []logEvent
+	size := 0
+	flush := func() {
+		if len(batch) > 0 {
+			c.put(batch)
+		}
+		batch, size = nil, 0
+	}
+
+	for {
+		select {
+		case event, ok := <-c.events:
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) == maxLogBatchEvents || size+len(event.Message)+logEventOverhead > maxLogBatchBytes {
+				flush()
+			}
+			batch = append(batch, event)
+			size += len(event.Message) + logEventOverhead
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close ships any buffered lines and stops Run
+func (c *CloudWatchLogs) Close() error {
+	close(c.events)
+	<-c.done
+	return nil
+}
+
+// put ships batch, retrying with backoff on failure
+func (c *CloudWatchLogs) put(batch []logEvent) {
+	in := map[string]interface{}{
+		"logGroupName":  c.Group,
+		"logStreamName": c.Stream,
+		"logEvents":     batch,
+	}
+
+	backoff := 250 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := c.call("PutLogEvents", in)
+		if err == nil {
+			return
+		}
+		if attempt == 5 {
+			atomic.AddInt64(&c.Dropped, int64(len(batch)))
+			c.warn("unable to ship logs to cloudwatch", err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// warn reports to stderr rather than through logger, which may itself be
+// writing to c
+func (c *CloudWatchLogs) warn(msg string, err error) {
+	fallback := &Logger{Out: os.Stderr, JSON: logger.JSON, Level: LevelWarn}
+	fallback.Warn(msg, Fields{"group": c.Group, "stream": c.Stream, "error": err})
+}
+
+func (c *CloudWatchLogs) call(action string, in interface{}) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://logs.%s.amazonaws.com/", c.Region)
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+	SignV4(req, c.Auth, c.Region, "logs", payload, time.Now())
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
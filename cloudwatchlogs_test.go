@@ -0,0 +1,68 @@
+package s3site
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+func TestCloudWatchLogs(t *testing.T) {
+	mu := &sync.Mutex{}
+	var targets []string
+	var events []logEvent
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		target := req.Header.Get("X-Amz-Target")
+		targets = append(targets, target)
+		if target == "Logs_20140328.PutLogEvents" && failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		in := struct {
+			LogEvents []logEvent `json:"logEvents"`
+		}{}
+		data, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(data, &in)
+		events = append(events, in.LogEvents...)
+	}))
+	defer server.Close()
+
+	logs := NewCloudWatchLogs("group", "stream", "us-east-1", aws.Auth{AccessKey: "key", SecretKey: "secret"}, 10)
+	logs.Endpoint = server.URL
+	go logs.Run(time.Hour)
+
+	logs.Write([]byte("first\nsecond\n"))
+	logs.Write([]byte("third\n"))
+	logs.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(targets) != 3 || targets[0] != "Logs_20140328.CreateLogStream" {
+		t.Errorf("expected create then a retried put, got %v", targets)
+	}
+	if len(events) != 3 || events[0].Message != "first" || events[2].Message != "third" {
+		t.Errorf("unexpected events, %v", events)
+	}
+}
+
+func TestCloudWatchLogsBackpressure(t *testing.T) {
+	logs := NewCloudWatchLogs("group", "stream", "us-east-1", aws.Auth{}, 1)
+	logs.BlockFor = 10 * time.Millisecond
+
+	logs.Write([]byte("kept\n"))
+	logs.Write([]byte("dropped\n"))
+	if logs.Dropped != 1 {
+		t.Errorf("expected 1 dropped line once the buffer is full, got %d", logs.Dropped)
+	}
+}
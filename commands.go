@@ -0,0 +1,194 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// optionCommands take the serve flags, which LoadOptions reads from either
+// side of the command name
+var optionCommands = map[string]bool{"serve": true, "validate": true, "stop": true, "status": true}
+
+// Commands returns the subcommands; running s3site without one serves, as
+// earlier versions did
+func Commands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "serve",
+			Usage:  "serve the bucket over http; the default when no command is given",
+			Flags:  Flags(),
+			Action: Run,
+		},
+		{
+			Name:   "validate",
+			Usage:  "check credentials, the bucket, and the configuration, exiting non-zero on failure",
+			Flags:  Flags(),
+			Action: Validate,
+		},
+		{
+			Name:   "stop",
+			Usage:  "stop the server named by --pidfile, waiting for it to drain",
+			Flags:  Flags(),
+			Action: Stop,
+		},
+		{
+			Name:   "status",
+			Usage:  "report whether the server named by --pidfile is running, exiting 3 if not",
+			Flags:  Flags(),
+			Action: Status,
+		},
+		{
+			Name:  "deploy",
+			Usage: "upload a local directory to the bucket and prefix, skipping unchanged files e.g. deploy ./build",
+			Flags: append(Flags(),
+				cli.BoolFlag{"delete", "delete objects under the prefix with no matching local file", "S3SITE_DEPLOY_DELETE"},
+				cli.IntFlag{"parallel", 8, "number of files to upload at once", "S3SITE_DEPLOY_PARALLEL"},
+				cli.BoolFlag{"atomic", "upload into a new release and switch current.json to it once complete; serve with --releases", "S3SITE_DEPLOY_ATOMIC"},
+				cli.BoolFlag{"fingerprint", "rename css, js, images, and fonts with a hash of their content, rewrite references in html and css, and mark them immutable", "S3SITE_DEPLOY_FINGERPRINT"},
+			),
+			Action: Deploy,
+		},
+		{
+			Name:   "rollback",
+			Usage:  "switch current.json back to the previous release of an atomic deploy",
+			Flags:  Flags(),
+			Action: Rollback,
+		},
+		{
+			Name:  "purge",
+			Usage: "evict paths from the cache of a running server e.g. purge /index.html /docs/",
+			Flags: []cli.Flag{
+				cli.StringFlag{"url", "http://localhost:8080", "url of the running server", "S3SITE_URL"},
+				cli.StringFlag{"admin-token", "", "bearer token for the /-/ admin endpoints", "S3SITE_ADMIN_TOKEN"},
+				cli.BoolFlag{"prefix", "purge every path beginning with each argument", ""},
+			},
+			Action: Purge,
+		},
+		{
+			Name:  "check",
+			Usage: "request a health endpoint, exiting non-zero unless it answers 2xx; for container health checks",
+			Flags: []cli.Flag{
+				cli.StringFlag{"url", "http://localhost:8080" + HealthzPath, "url to request e.g. http://localhost:8080/readyz", "S3SITE_CHECK_URL"},
+				cli.DurationFlag{"timeout", 5 * time.Second, "time allowed for the response", "S3SITE_CHECK_TIMEOUT"},
+			},
+			Action: Check,
+		},
+		{
+			Name:  "service",
+			Usage: "install s3site as a systemd, launchd, or windows service with the serve flags that follow e.g. service install --name docs --bucket docs",
+			Subcommands: []cli.Command{
+				{Name: "install", Usage: "write the unit, then enable and start it", Flags: serviceFlags(), Action: ServiceCommand("install")},
+				{Name: "uninstall", Usage: "stop and disable the service, then remove its unit", Flags: serviceFlags(), Action: ServiceCommand("uninstall")},
+				{Name: "print", Usage: "print the unit without installing it", Flags: serviceFlags(), Action: ServiceCommand("print")},
+				{Name: "run", Usage: "serve as the installed service; started by the service manager", Flags: serviceFlags(), Action: ServiceRun},
+			},
+		},
+		{
+			Name:  "version",
+			Usage: "print the version, commit, and build date",
+			Action: func(c *cli.Context) {
+				fmt.Println(CurrentBuild())
+			},
+		},
+	}
+}
+
+// Check requests a health endpoint of a running server so probes needn't
+// ship curl in the image
+func Check(c *cli.Context) {
+	client := &http.Client{Timeout: c.Duration("timeout")}
+	check(healthCheck(client, c.String("url")))
+}
+
+func healthCheck(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unhealthy, %s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// Purge evicts the paths named by the arguments from a running server
+func Purge(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		check(fmt.Errorf("purge requires at least one path"))
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, path := range c.Args() {
+		in := purgeRequest{Path: path}
+		if c.Bool("prefix") {
+			in = purgeRequest{Prefix: path}
+		}
+
+		out, err := purge(client, c.String("url"), c.String("admin-token"), in)
+		check(err)
+		fmt.Printf("purged %d %s\n", out.Purged, path)
+		if out.Invalidation != "" {
+			fmt.Printf("cloudfront invalidation %s\n", out.Invalidation)
+		}
+	}
+}
+
+func purge(client *http.Client, url, token string, in purgeRequest) (*purgeResponse, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(url, "/")+"/-/purge", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to purge, %s", resp.Status)
+	}
+
+	out := &purgeResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
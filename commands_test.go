@@ -0,0 +1,60 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPurge(t *testing.T) {
+	opts := &Options{Prefix: "site", IndexFile: "index.html", AdminToken: "secret"}
+	cache := NewCache(100)
+	cache.Set(&Entry{Key: "site/docs/a.html", Body: []byte("1")})
+	cache.Set(&Entry{Key: "site/docs/b.html", Body: []byte("1")})
+	server := httptest.NewServer(PurgeHandler(NewLiveOptions(opts, nil), &KeyPolicy{}, cache, nil))
+	defer server.Close()
+
+	if _, err := purge(http.DefaultClient, server.URL, "wrong", purgeRequest{Prefix: "/docs/"}); err == nil {
+		t.Error("expected an invalid token to fail")
+	}
+
+	out, err := purge(http.DefaultClient, server.URL+"/", "secret", purgeRequest{Prefix: "/docs/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Purged != 2 {
+		t.Errorf("expected 2 entries purged, got %d", out.Purged)
+	}
+}
+
+func TestLoadOptionsAroundServe(t *testing.T) {
+	opts, err := LoadOptions([]string{"--bucket", "b", "serve", "--port", "9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Bucket != "b" || opts.Port != "9000" {
+		t.Errorf("expected flags on both sides of serve, got bucket %s port %s", opts.Bucket, opts.Port)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	ready := true
+	server := httptest.NewServer(Healthz(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})))
+	defer server.Close()
+
+	if err := healthCheck(http.DefaultClient, server.URL+HealthzPath); err != nil {
+		t.Errorf("expected healthz to pass, %v", err)
+	}
+	ready = false
+	if err := healthCheck(http.DefaultClient, server.URL+"/"); err == nil {
+		t.Error("expected a 503 to fail")
+	}
+	server.Close()
+	if err := healthCheck(http.DefaultClient, server.URL+HealthzPath); err == nil {
+		t.Error("expected a refused connection to fail")
+	}
+}
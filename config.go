@@ -0,0 +1,644 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// Config holds the settings read from a yaml or toml config file.  Keys are
+// flag names; each value is a string, a []string, or a map[string]string
+type Config struct {
+	Values map[string]interface{}
+
+	// Users maps usernames to passwords for basic auth
+	Users map[string]string
+
+	// Sites maps host names to the flag values of the site served there
+	Sites map[string]map[string]interface{}
+}
+
+// LoadConfig reads the config file at path, choosing the format by
+// extension; an empty path yields an empty config
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{
+		Values: map[string]interface{}{},
+		Users:  map[string]string{},
+		Sites:  map[string]map[string]interface{}{},
+	}
+	if path == "" {
+		return config, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var values map[string]interface{}
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		values, err = parseYAML(f)
+	case ".toml":
+		values, err = parseTOML(f)
+	default:
+		return nil, fmt.Errorf("unknown config format, %s", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for key, value := range values {
+		switch key {
+		case "config":
+		case "users":
+			users, ok := value.(map[string]string)
+			if !ok {
+				return nil, fmt.Errorf("%s: users must map usernames to passwords", path)
+			}
+			config.Users = users
+		case "sites":
+			return nil, fmt.Errorf("%s: sites must map host names to their options", path)
+		default:
+			if host := strings.TrimPrefix(key, "sites."); host != key {
+				options, ok := value.(map[string]string)
+				if !ok {
+					return nil, fmt.Errorf("%s: site %s must map options to values", path, host)
+				}
+				site := map[string]interface{}{}
+				for name, value := range options {
+					site[normalizeKey(name)] = parseValue(value)
+				}
+				config.Sites[strings.ToLower(host)] = site
+				continue
+			}
+			config.Values[key] = value
+		}
+	}
+	return config, nil
+}
+
+// LoadOptions reads the options from the command line arguments, environment,
+// and config file.  Flags may appear on either side of the serve or validate
+// command.
+func LoadOptions(args []string) (*Options, error) {
+	set, config, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := Opts(cli.NewContext(nil, set, set))
+	opts.Users = config.Users
+	return opts, nil
+}
+
+// parseFlags parses args into the serve flags, defaulted from the config
+// file they name
+func parseFlags(args []string) (*flag.FlagSet, *Config, error) {
+	flags := Flags()
+	applyLegacyEnv(flags)
+
+	config, err := LoadConfig(configPath(args))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := config.Apply(flags, args); err != nil {
+		return nil, nil, err
+	}
+
+	set := flag.NewFlagSet("s3site", flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	for _, f := range flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if optionCommands[set.Arg(0)] {
+		if err := set.Parse(set.Args()[1:]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return set, config, nil
+}
+
+// configPath returns the value of --config from the command line arguments,
+// falling back to the CONFIG environment variable
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		switch {
+		case arg == "--config" || arg == "-config":
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+		case strings.HasPrefix(arg, "--config="):
+			return strings.TrimPrefix(arg, "--config=")
+		case strings.HasPrefix(arg, "-config="):
+			return strings.TrimPrefix(arg, "-config=")
+		}
+	}
+	return os.Getenv("S3SITE_CONFIG")
+}
+
+// EnvPrefix prefixes the environment variable of every flag e.g. --cache-ttl
+// is read from S3SITE_CACHE_TTL
+const EnvPrefix = "S3SITE_"
+
+// legacyEnvVars lists the unprefixed environment variables read by earlier
+// versions; no others are read, as generic names e.g. DRY_RUN may be set for
+// other programs.  PORT and AWS_XRAY_DAEMON_ADDRESS are set by the platform
+// rather than the operator, and AWS_PROFILE is shared with the aws tools, so
+// they stay supported without a deprecation warning.
+var legacyEnvVars = map[string]string{
+	"S3SITE_PORT":        "PORT",
+	"S3SITE_USERNAME":    "USERNAME",
+	"S3SITE_PASSWORD":    "PASSWORD",
+	"S3SITE_REALM":       "REALM",
+	"S3SITE_BUCKET":      "BUCKET",
+	"S3SITE_PREFIX":      "PREFIX",
+	"S3SITE_MAX_AGE":     "MAX_AGE",
+	"S3SITE_VERBOSE":     "VERBOSE",
+	"S3SITE_INDEX_FILE":  "INDEX",
+	"S3SITE_STATSD_TAG":  "STATSD_TAGS",
+	"S3SITE_XRAY_DAEMON": "AWS_XRAY_DAEMON_ADDRESS",
+	"S3SITE_AWS_PROFILE": "AWS_PROFILE",
+}
+
+// EnvVar returns the environment variable read for the named flag
+func EnvVar(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// applyLegacyEnv copies the unprefixed environment variables read by earlier
+// versions onto their S3SITE_ names when those are unset, returning a map of
+// the deprecated names in use to their replacements
+func applyLegacyEnv(flags []cli.Flag) map[string]string {
+	deprecated := map[string]string{}
+	for _, flag := range flags {
+		_, envVar := flagFields(flag)
+		if envVar == "" || os.Getenv(envVar) != "" {
+			continue
+		}
+
+		legacy, ok := legacyEnvVars[envVar]
+		if !ok {
+			continue
+		}
+		value := os.Getenv(legacy)
+		if value == "" {
+			continue
+		}
+
+		os.Setenv(envVar, value)
+		if legacy != "PORT" && legacy != "AWS_XRAY_DAEMON_ADDRESS" && legacy != "AWS_PROFILE" {
+			deprecated[legacy] = envVar
+		}
+	}
+	return deprecated
+}
+
+// hasFlag reports whether flags includes the named flag
+func hasFlag(flags []cli.Flag, name string) bool {
+	for _, flag := range flags {
+		for _, n := range flagNames(flag) {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flagSet reports whether the named flag appears in the command line arguments
+func flagSet(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		arg = strings.TrimLeft(arg, "-")
+		if arg == name || strings.HasPrefix(arg, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply replaces the defaults of flags with values from the config file, so
+// values given on the command line or in the environment still take
+// precedence.  Keys that don't name a flag are rejected.
+func (c *Config) Apply(flags []cli.Flag, args []string) error {
+	index := map[string]int{}
+	for i, flag := range flags {
+		for _, name := range flagNames(flag) {
+			index[name] = i
+		}
+	}
+
+	keys := make([]string, 0, len(c.Values))
+	for key := range c.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := ConfigError{}
+	for _, key := range keys {
+		i, ok := index[key]
+		if !ok {
+			fix := "see s3site --help for the options"
+			if name := closest(key, index); name != "" {
+				fix = fmt.Sprintf("did you mean %s?", name)
+			}
+			problems = append(problems, ConfigProblem{Field: key, Problem: "unknown config key", Fix: fix})
+			continue
+		}
+		flag, err := withDefault(flags[i], c.Values[key], flagSet(args, key))
+		if err != nil {
+			problems = append(problems, ConfigProblem{Field: key, Problem: "invalid config value, " + err.Error(), Fix: "see s3site --help for its format"})
+			continue
+		}
+		flags[i] = flag
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// closest returns the option name nearest key, allowing for a typo or two
+func closest(key string, names map[string]int) string {
+	best, distance := "", 3
+	for name := range names {
+		if d := editDistance(key, name); d < distance || d == distance && name < best {
+			best, distance = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// flagFields returns the name and environment variable of flag
+func flagFields(flag cli.Flag) (name, envVar string) {
+	switch f := flag.(type) {
+	case cli.StringFlag:
+		return f.Name, f.EnvVar
+	case cli.BoolFlag:
+		return f.Name, f.EnvVar
+	case cli.BoolTFlag:
+		return f.Name, f.EnvVar
+	case cli.IntFlag:
+		return f.Name, f.EnvVar
+	case cli.DurationFlag:
+		return f.Name, f.EnvVar
+	case cli.Float64Flag:
+		return f.Name, f.EnvVar
+	case cli.StringSliceFlag:
+		return f.Name, f.EnvVar
+	}
+	return "", ""
+}
+
+func flagNames(flag cli.Flag) []string {
+	name, _ := flagFields(flag)
+	names := []string{}
+	for _, name := range strings.Split(name, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// withDefault returns flag with its default replaced by value.  String slices
+// append on the command line, so a slice given there replaces the file's
+// value rather than adding to it.
+func withDefault(flag cli.Flag, value interface{}, onCommandLine bool) (cli.Flag, error) {
+	if f, ok := flag.(cli.StringSliceFlag); ok {
+		if onCommandLine {
+			return f, nil
+		}
+		f.Value = &cli.StringSlice{}
+		switch v := value.(type) {
+		case string:
+			f.Value.Set(v)
+		case []string:
+			for _, item := range v {
+				f.Value.Set(item)
+			}
+		case map[string]string:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				f.Value.Set(key + "=" + v[key])
+			}
+		}
+		return f, nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a single value")
+	}
+
+	switch f := flag.(type) {
+	case cli.StringFlag:
+		f.Value = s
+		return f, nil
+
+	case cli.BoolFlag:
+		return boolFlag(f.Name, f.Usage, f.EnvVar, s)
+
+	case cli.BoolTFlag:
+		return boolFlag(f.Name, f.Usage, f.EnvVar, s)
+
+	case cli.IntFlag:
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		f.Value = v
+		return f, nil
+
+	case cli.DurationFlag:
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		f.Value = v
+		return f, nil
+
+	case cli.Float64Flag:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		f.Value = v
+		return f, nil
+	}
+
+	return nil, fmt.Errorf("unsupported flag type, %T", flag)
+}
+
+// boolFlag returns a flag defaulting to true or false per value; BoolFlag
+// has no default so true is expressed as a BoolTFlag
+func boolFlag(name, usage, envVar, value string) (cli.Flag, error) {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	if v {
+		return cli.BoolTFlag{name, usage, envVar}, nil
+	}
+	return cli.BoolFlag{name, usage, envVar}, nil
+}
+
+// parseYAML parses the subset of yaml used by config files: top level
+// scalars, inline [a, b] lists, and blocks holding either a "- item" list or
+// "key: value" pairs.  A block may nest one level of "key: value" blocks,
+// each kept as block.key e.g. sites.docs.example.com.
+func parseYAML(r io.Reader) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	block := ""
+	nested, nestedIndent := "", 0
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := stripComment(scanner.Text())
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		indented := indent > 0
+		line = strings.TrimSpace(line)
+
+		if nested != "" && indent > nestedIndent {
+			key, value, ok := splitPair(line, ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key: value", n)
+			}
+			values[nested].(map[string]string)[key] = value
+			continue
+		}
+		nested = ""
+
+		if !indented {
+			key, value, ok := splitPair(line, ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key: value", n)
+			}
+			key = normalizeKey(key)
+			block = ""
+			if value == "" {
+				block = key
+				continue
+			}
+			values[key] = parseValue(value)
+			continue
+		}
+
+		if block == "" {
+			return nil, fmt.Errorf("line %d: unexpected indentation", n)
+		}
+
+		if strings.HasPrefix(line, "-") {
+			list, _ := values[block].([]string)
+			if _, isMap := values[block].(map[string]string); isMap {
+				return nil, fmt.Errorf("line %d: mixed list and map in %s", n, block)
+			}
+			values[block] = append(list, unquote(strings.TrimSpace(line[1:])))
+			continue
+		}
+
+		key, value, ok := splitPair(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		if value == "" {
+			nested, nestedIndent = block+"."+key, indent
+			values[nested] = map[string]string{}
+			continue
+		}
+		m, _ := values[block].(map[string]string)
+		if m == nil {
+			if _, isList := values[block].([]string); isList {
+				return nil, fmt.Errorf("line %d: mixed list and map in %s", n, block)
+			}
+			m = map[string]string{}
+			values[block] = m
+		}
+		m[key] = unquote(value)
+	}
+	return values, scanner.Err()
+}
+
+// parseTOML parses the subset of toml used by config files: key = value
+// pairs, single line arrays, and [tables] of key = value pairs, including
+// [sites."docs.example.com"] tables naming a host
+func parseTOML(r io.Reader) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	var table map[string]string
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if host := strings.TrimPrefix(name, "sites."); host != name {
+				name = "sites." + unquote(host)
+			} else {
+				name = normalizeKey(name)
+			}
+			table = map[string]string{}
+			values[name] = table
+			continue
+		}
+
+		key, value, ok := splitPair(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		if table != nil {
+			table[key] = unquote(value)
+			continue
+		}
+		values[normalizeKey(key)] = parseValue(value)
+	}
+	return values, scanner.Err()
+}
+
+// splitPair splits line around the first sep outside of quotes
+func splitPair(line, sep string) (string, string, bool) {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.HasPrefix(line[i:], sep):
+			key := strings.TrimSpace(line[:i])
+			if key == "" {
+				return "", "", false
+			}
+			return unquote(key), strings.TrimSpace(line[i+len(sep):]), true
+		}
+	}
+	return "", "", false
+}
+
+// normalizeKey lets toml style snake_case keys name kebab-case flags
+func normalizeKey(key string) string {
+	return strings.Replace(key, "_", "-", -1)
+}
+
+// stripComment removes a trailing # comment that isn't inside quotes
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimRight(line[:i], " \t")
+		}
+	}
+	return strings.TrimRight(line, " \t")
+}
+
+// parseValue returns a []string for an inline [a, b] list and a string otherwise
+func parseValue(value string) interface{} {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return unquote(value)
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(value[1:len(value)-1], ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, unquote(item))
+		}
+	}
+	return list
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '"' && value[len(value)-1] == '"':
+			if s, err := strconv.Unquote(value); err == nil {
+				return s
+			}
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
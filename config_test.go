@@ -0,0 +1,284 @@
+package s3site
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+func TestParseYAML(t *testing.T) {
+	values, err := parseYAML(strings.NewReader(`---
+# site settings
+bucket: my-bucket
+realm: "Staff Only" # trailing comment
+max-age: 300
+warm: [/, /about/]
+cache-ttl:
+  /assets/: forever
+  '*.png': 1h
+cache-key-query:
+  - v
+  - lang
+users:
+  alice: "p#ss"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"bucket":          "my-bucket",
+		"realm":           "Staff Only",
+		"max-age":         "300",
+		"warm":            []string{"/", "/about/"},
+		"cache-ttl":       map[string]string{"/assets/": "forever", "*.png": "1h"},
+		"cache-key-query": []string{"v", "lang"},
+		"users":           map[string]string{"alice": "p#ss"},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML(strings.NewReader(`
+bucket = "my-bucket"
+cache_size = 64 # MB
+warm = ["/", "/about/"]
+
+[users]
+alice = "secret"
+bob_smith = 'hunter2'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"bucket":     "my-bucket",
+		"cache-size": "64",
+		"warm":       []string{"/", "/about/"},
+		"users":      map[string]string{"alice": "secret", "bob_smith": "hunter2"},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	config := &Config{Values: map[string]interface{}{
+		"bucket":     "from-file",
+		"realm":      "from-file",
+		"max-age":    "300",
+		"s3-timeout": "2s",
+		"analytics":  "true",
+		"cache-ttl":  map[string]string{"/assets/": "forever", "*.png": "1h"},
+		"warm":       []string{"/from-file/"},
+	}}
+
+	flags := []cli.Flag{
+		cli.StringFlag{"bucket", "", "", ""},
+		cli.StringFlag{"realm", "Realm", "", "S3SITE_TEST_REALM"},
+		cli.IntFlag{"max-age", 90, "", ""},
+		cli.DurationFlag{"s3-timeout", 10 * time.Second, "", ""},
+		cli.BoolFlag{"analytics", "", ""},
+		cli.StringSliceFlag{"cache-ttl", &cli.StringSlice{}, "", ""},
+		cli.StringSliceFlag{"warm", &cli.StringSlice{}, "", ""},
+	}
+	args := []string{"--bucket", "from-flag", "--warm", "/from-flag/"}
+	if err := config.Apply(flags, args); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("S3SITE_TEST_REALM", "from-env")
+	defer os.Unsetenv("S3SITE_TEST_REALM")
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	c := cli.NewContext(nil, set, set)
+
+	if v := c.String("bucket"); v != "from-flag" {
+		t.Errorf("expected flag to override file, got %s", v)
+	}
+	if v := c.String("realm"); v != "from-env" {
+		t.Errorf("expected env to override file, got %s", v)
+	}
+	if v := c.Int("max-age"); v != 300 {
+		t.Errorf("expected max-age 300, got %d", v)
+	}
+	if v := c.Duration("s3-timeout"); v != 2*time.Second {
+		t.Errorf("expected s3-timeout 2s, got %v", v)
+	}
+	if !c.Bool("analytics") {
+		t.Error("expected analytics to be enabled")
+	}
+	if v := c.StringSlice("cache-ttl"); !reflect.DeepEqual(v, []string{"*.png=1h", "/assets/=forever"}) {
+		t.Errorf("unexpected cache-ttl, %v", v)
+	}
+	if v := c.StringSlice("warm"); !reflect.DeepEqual(v, []string{"/from-flag/"}) {
+		t.Errorf("expected flag to replace file list, got %v", v)
+	}
+}
+
+func TestConfigApplyRejectsUnknown(t *testing.T) {
+	config := &Config{Values: map[string]interface{}{"buckt": "typo"}}
+	if err := config.Apply([]cli.Flag{cli.StringFlag{"bucket", "", "", ""}}, nil); err == nil {
+		t.Error("expected unknown key to be rejected")
+	}
+
+	config = &Config{Values: map[string]interface{}{"max-age": "soon"}}
+	if err := config.Apply([]cli.Flag{cli.IntFlag{"max-age", 90, "", ""}}, nil); err == nil {
+		t.Error("expected invalid value to be rejected")
+	}
+}
+
+func TestLoadConfigUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "site.yaml")
+	ioutil.WriteFile(path, []byte("bucket: b\nusers:\n  alice: a\n  bob: b\n"), 0644)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config.Values["users"]; ok {
+		t.Error("expected users to be kept out of flag values")
+	}
+
+	opts := &Options{Users: config.Users}
+	if !opts.RequiresAuth() {
+		t.Error("expected users to require auth")
+	}
+	if !opts.Authorized("bob", "b") || opts.Authorized("bob", "a") || opts.Authorized("carol", "") {
+		t.Error("unexpected authorization result")
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+	testCases := []struct {
+		Args     []string
+		Expected string
+	}{
+		{[]string{"--port", "80", "--config", "site.yaml"}, "site.yaml"},
+		{[]string{"-config=site.toml"}, "site.toml"},
+		{[]string{"--", "--config", "site.yaml"}, ""},
+	}
+	for _, tc := range testCases {
+		if path := configPath(tc.Args); path != tc.Expected {
+			t.Errorf("%v: expected %s, got %s", tc.Args, tc.Expected, path)
+		}
+	}
+}
+
+func TestFlagsUsePrefixedEnvVars(t *testing.T) {
+	for _, flag := range Flags() {
+		name, envVar := flagFields(flag)
+		if name == "" {
+			t.Errorf("unsupported flag type, %T", flag)
+			continue
+		}
+		if expected := EnvVar(name); envVar != expected {
+			t.Errorf("%s: expected env var %s, got %s", name, expected, envVar)
+		}
+	}
+}
+
+func TestApplyLegacyEnv(t *testing.T) {
+	flags := []cli.Flag{
+		cli.StringFlag{"port", "8080", "", "S3SITE_PORT"},
+		cli.StringFlag{"bucket", "", "", "S3SITE_BUCKET"},
+		cli.StringFlag{"index-file", "index.html", "", "S3SITE_INDEX_FILE"},
+		cli.StringFlag{"realm", "Realm", "", "S3SITE_REALM"},
+		cli.BoolFlag{"dry-run", "", "S3SITE_DRY_RUN"},
+	}
+	env := map[string]string{
+		"DRY_RUN":      "true",
+		"PORT":         "5000",
+		"BUCKET":       "legacy",
+		"INDEX":        "default.htm",
+		"REALM":        "legacy",
+		"S3SITE_REALM": "current",
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range env {
+			os.Unsetenv(key)
+		}
+		for _, key := range []string{"S3SITE_PORT", "S3SITE_BUCKET", "S3SITE_INDEX_FILE"} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	deprecated := applyLegacyEnv(flags)
+	expected := map[string]string{"BUCKET": "S3SITE_BUCKET", "INDEX": "S3SITE_INDEX_FILE"}
+	if !reflect.DeepEqual(deprecated, expected) {
+		t.Errorf("expected %v, got %v", expected, deprecated)
+	}
+
+	for key, value := range map[string]string{
+		"S3SITE_PORT":       "5000",
+		"S3SITE_BUCKET":     "legacy",
+		"S3SITE_INDEX_FILE": "default.htm",
+		"S3SITE_REALM":      "current",
+	} {
+		if v := os.Getenv(key); v != value {
+			t.Errorf("%s: expected %s, got %s", key, value, v)
+		}
+	}
+	if v := os.Getenv("S3SITE_DRY_RUN"); v != "" {
+		t.Errorf("expected DRY_RUN, which s3site never read, to be ignored, got %s", v)
+	}
+}
+
+func TestLoadOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "site.toml")
+	ioutil.WriteFile(path, []byte("bucket = \"from-file\"\nmax-age = 300\n\n[users]\nalice = \"a\"\n"), 0644)
+
+	opts, err := LoadOptions([]string{"--config", path, "--max-age", "60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Bucket != "from-file" || opts.MaxAge != 60 || opts.Users["alice"] != "a" {
+		t.Errorf("unexpected options, %+v", opts)
+	}
+}
+
+func TestApplySuggestsUnknownKeys(t *testing.T) {
+	config := &Config{Values: map[string]interface{}{"cache_tll": "1h", "nonsense": "1"}}
+	err := config.Apply(Flags(), nil)
+	problems, ok := err.(ConfigError)
+	if !ok || len(problems) != 2 {
+		t.Fatalf("expected both unknown keys to be reported, got %v", err)
+	}
+	if problems[0].Field != "cache_tll" || problems[0].Fix != "did you mean cache-ttl?" {
+		t.Errorf("expected a suggestion, got %+v", problems[0])
+	}
+	if problems[1].Fix != "see s3site --help for the options" {
+		t.Errorf("expected no suggestion for nonsense, got %+v", problems[1])
+	}
+}
@@ -0,0 +1,125 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const month = 30 * 24 * time.Hour
+
+// S3Pricing holds s3 list prices in dollars; the defaults are us-east-1
+// standard storage
+type S3Pricing struct {
+	GetPer1000  float64 // GET and HEAD
+	ListPer1000 float64 // LIST, PUT, and friends
+	EgressPerGB float64 // 0 when served from ec2 in the bucket's region
+}
+
+// S3Usage counts the s3 calls and bytes since startup
+type S3Usage struct {
+	Uptime     time.Duration
+	Gets       uint64
+	Lists      uint64
+	Bytes      int64
+	CacheHits  int64 // lookups served without a call to s3
+	BytesSaved int64
+}
+
+// S3Usage returns the calls made to s3 since startup
+func (m *Metrics) S3Usage() S3Usage {
+	usage := S3Usage{
+		Uptime: time.Since(m.started),
+		Bytes:  atomic.LoadInt64(&m.s3Bytes),
+	}
+
+	m.mu.Lock()
+	for call, n := range m.s3Calls {
+		switch strings.SplitN(call, "|", 2)[0] {
+		case "get", "head":
+			usage.Gets += n
+		default:
+			usage.Lists += n
+		}
+	}
+	m.mu.Unlock()
+
+	if snapshot, ok := m.CacheSnapshot(); ok {
+		usage.CacheHits = snapshot.Hits + snapshot.Stale
+		usage.BytesSaved = snapshot.BytesSaved
+	}
+	return usage
+}
+
+// CostEstimate projects usage to a 30 day month
+type CostEstimate struct {
+	UptimeSeconds  float64 `json:"uptime_seconds"`
+	Gets           uint64  `json:"gets"`
+	Lists          uint64  `json:"lists"`
+	Bytes          int64   `json:"bytes"`
+	RequestCost    float64 `json:"request_cost"`
+	EgressCost     float64 `json:"egress_cost"`
+	MonthlyCost    float64 `json:"estimated_monthly_cost"`
+	MonthlySavings float64 `json:"estimated_monthly_savings"`
+}
+
+// Estimate prices usage so far and projects it to a month at the same rate
+func (p *S3Pricing) Estimate(usage S3Usage) CostEstimate {
+	requests := float64(usage.Gets)/1000*p.GetPer1000 + float64(usage.Lists)/1000*p.ListPer1000
+	egress := float64(usage.Bytes) / (1 << 30) * p.EgressPerGB
+	saved := float64(usage.CacheHits)/1000*p.GetPer1000 + float64(usage.BytesSaved)/(1<<30)*p.EgressPerGB
+
+	scale := 0.0
+	if usage.Uptime > 0 {
+		scale = float64(month) / float64(usage.Uptime)
+	}
+
+	return CostEstimate{
+		UptimeSeconds:  usage.Uptime.Seconds(),
+		Gets:           usage.Gets,
+		Lists:          usage.Lists,
+		Bytes:          usage.Bytes,
+		RequestCost:    requests,
+		EgressCost:     egress,
+		MonthlyCost:    (requests + egress) * scale,
+		MonthlySavings: saved * scale,
+	}
+}
+
+// CostHandler reports s3 usage and the projected monthly cost as json
+func CostHandler(live *LiveOptions, metrics *Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		opts := live.Load()
+		if !opts.IsAdmin(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metrics.Pricing.Estimate(metrics.S3Usage()))
+	}
+}
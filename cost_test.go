@@ -0,0 +1,71 @@
+package s3site
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestS3PricingEstimate(t *testing.T) {
+	pricing := &S3Pricing{GetPer1000: 0.0004, ListPer1000: 0.005, EgressPerGB: 0.09}
+	estimate := pricing.Estimate(S3Usage{
+		Uptime:     24 * time.Hour,
+		Gets:       1000000,
+		Lists:      1000,
+		Bytes:      10 << 30,
+		CacheHits:  2000000,
+		BytesSaved: 20 << 30,
+	})
+
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	if !near(estimate.RequestCost, 0.405) || !near(estimate.EgressCost, 0.9) {
+		t.Errorf("unexpected costs, %+v", estimate)
+	}
+	if !near(estimate.MonthlyCost, 1.305*30) {
+		t.Errorf("expected a day of usage to be projected over 30 days, got %g", estimate.MonthlyCost)
+	}
+	if !near(estimate.MonthlySavings, (0.8+1.8)*30) {
+		t.Errorf("unexpected savings, %g", estimate.MonthlySavings)
+	}
+}
+
+func TestS3Usage(t *testing.T) {
+	metrics := NewMetrics()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: metrics.Transport(http.DefaultTransport)}
+	for _, path := range []string{"/bucket/a", "/bucket/b", "/bucket/?prefix=docs/"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	usage := metrics.S3Usage()
+	if usage.Gets != 2 || usage.Lists != 1 || usage.Bytes != 15 {
+		t.Errorf("unexpected usage, %+v", usage)
+	}
+
+	metrics.Pricing = &S3Pricing{GetPer1000: 1}
+	req := httptest.NewRequest("GET", "/-/cost", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	CostHandler(NewLiveOptions(&Options{AdminToken: "secret"}, nil), metrics)(w, req)
+
+	estimate := CostEstimate{}
+	if err := json.Unmarshal(w.Body.Bytes(), &estimate); err != nil {
+		t.Fatal(err)
+	}
+	if estimate.Gets != 2 || estimate.RequestCost != 0.002 {
+		t.Errorf("unexpected estimate, %+v", estimate)
+	}
+}
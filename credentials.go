@@ -0,0 +1,204 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+// roleRenewal is how long before an assumed role session expires that it is
+// renewed
+const roleRenewal = 5 * time.Minute
+
+// Credentials signs a site's requests to s3 in its region, with the site's
+// own keys or else those of the environment.  When RoleARN is set the keys
+// are used only to assume the role, whose session is renewed before it
+// expires.
+type Credentials struct {
+	Base    aws.Auth
+	Region  aws.Region
+	RoleARN string
+	Client  *http.Client
+	STS     string // defaults to the global sts endpoint
+
+	mu      sync.Mutex
+	session aws.Auth
+	expires time.Time
+}
+
+// NewCredentials returns the credentials of the site described by opts,
+// assuming its role straight away so a misconfigured role fails at startup.
+// Keys are taken from s3-access-key, else the aws-profile, else the
+// environment; the region and role from the s3- options, else the profile.
+func NewCredentials(opts *Options) (*Credentials, error) {
+	auth := aws.Auth{AccessKey: opts.S3AccessKey, SecretKey: opts.S3SecretKey}
+	regionName, roleARN := opts.S3Region, opts.S3RoleARN
+
+	if opts.AWSProfile != "" {
+		profile, err := LoadProfile(opts.AWSProfile)
+		if err != nil {
+			return nil, err
+		}
+		if auth.AccessKey == "" {
+			auth = profile.Auth
+		}
+		if regionName == "" {
+			regionName = profile.Region
+		}
+		if roleARN == "" {
+			roleARN = profile.RoleARN
+		}
+	}
+	if auth.AccessKey == "" {
+		var err error
+		if auth, err = aws.EnvAuth(); err != nil {
+			return nil, err
+		}
+	}
+
+	region, ok := aws.Regions[regionName]
+	if regionName == "" {
+		region, ok = aws.USEast, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown s3 region, %s", regionName)
+	}
+
+	creds := &Credentials{
+		Base:    auth,
+		Region:  region,
+		RoleARN: roleARN,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	if creds.RoleARN != "" {
+		if err := creds.assumeRole(); err != nil {
+			return nil, err
+		}
+	}
+	return creds, nil
+}
+
+// Auth returns the credentials to sign with, renewing an assumed role
+// session that is about to expire.  Should renewal fail, the current session
+// is used until it expires.
+func (c *Credentials) Auth() aws.Auth {
+	if c.RoleARN == "" {
+		return c.Base
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Until(c.expires) < roleRenewal {
+		if err := c.assumeRoleLocked(); err != nil {
+			logger.Warn("unable to renew role session", Fields{"role": c.RoleARN, "expires": c.expires, "error": err})
+		}
+	}
+	return c.session
+}
+
+// Bucket returns the named bucket signed with the current credentials and
+// fetched with client
+func (c *Credentials) Bucket(name string, client *http.Client) *s3.Bucket {
+	api := s3.New(c.Auth(), c.Region)
+	api.HTTPClient = func() *http.Client {
+		return client
+	}
+	return api.Bucket(name)
+}
+
+// Sign returns bucket signed with the current credentials, which only
+// change when a role is assumed; nil Credentials leave bucket as it is
+func (c *Credentials) Sign(bucket *s3.Bucket) *s3.Bucket {
+	if c == nil || c.RoleARN == "" {
+		return bucket
+	}
+	api := *bucket.S3
+	api.Auth = c.Auth()
+	return api.Bucket(bucket.Name)
+}
+
+type assumeRoleResponse struct {
+	AccessKeyID     string    `xml:"AssumeRoleResult>Credentials>AccessKeyId"`
+	SecretAccessKey string    `xml:"AssumeRoleResult>Credentials>SecretAccessKey"`
+	SessionToken    string    `xml:"AssumeRoleResult>Credentials>SessionToken"`
+	Expiration      time.Time `xml:"AssumeRoleResult>Credentials>Expiration"`
+}
+
+func (c *Credentials) assumeRole() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.assumeRoleLocked()
+}
+
+// assumeRoleLocked calls sts AssumeRole; c.mu must be held
+func (c *Credentials) assumeRoleLocked() error {
+	endpoint := c.STS
+	if endpoint == "" {
+		endpoint = "https://sts.amazonaws.com/"
+	}
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {c.RoleARN},
+		"RoleSessionName": {"s3site"},
+	}
+	payload := []byte(params.Encode())
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	SignV4(req, c.Base, "us-east-1", "sts", payload, time.Now())
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unable to assume role %s, %s: %s", c.RoleARN, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	out := assumeRoleResponse{}
+	if err := xml.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return err
+	}
+	c.session = aws.Auth{AccessKey: out.AccessKeyID, SecretKey: out.SecretAccessKey, Token: out.SessionToken}
+	c.expires = out.Expiration
+	logger.Debug("assumed role", Fields{"role": c.RoleARN, "expires": c.expires})
+	return nil
+}
@@ -0,0 +1,116 @@
+package s3site
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+func fakeSTS(expires time.Time, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		req.ParseForm()
+		if req.Form.Get("Action") != "AssumeRole" || req.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/site" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(req.Header.Get("Authorization"), "Credential=base/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+<AccessKeyId>session-%d</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+<SessionToken>token-%d</SessionToken><Expiration>%s</Expiration>
+</Credentials></AssumeRoleResult></AssumeRoleResponse>`, n, n, expires.UTC().Format(time.RFC3339))
+	}))
+}
+
+func TestCredentialsAssumeRole(t *testing.T) {
+	var calls int32
+	server := fakeSTS(time.Now().Add(time.Hour), &calls)
+	defer server.Close()
+
+	creds := &Credentials{
+		Base:    aws.Auth{AccessKey: "base", SecretKey: "secret"},
+		Region:  aws.USWest2,
+		RoleARN: "arn:aws:iam::123456789012:role/site",
+		Client:  http.DefaultClient,
+		STS:     server.URL,
+	}
+	if auth := creds.Auth(); auth.AccessKey != "session-1" || auth.Token != "token-1" {
+		t.Fatalf("expected the role session, got %+v", auth)
+	}
+	creds.Auth()
+	if calls != 1 {
+		t.Errorf("expected the session to be reused until it nears expiry, got %d calls", calls)
+	}
+
+	bucket := s3.New(creds.Base, creds.Region).Bucket("site")
+	if signed := creds.Sign(bucket); signed.Auth.AccessKey != "session-1" || signed.Name != "site" || bucket.Auth.AccessKey != "base" {
+		t.Errorf("expected Sign to return a copy signed with the session, got %+v", signed.Auth)
+	}
+}
+
+func TestCredentialsRenewal(t *testing.T) {
+	var calls int32
+	server := fakeSTS(time.Now().Add(time.Minute), &calls)
+	defer server.Close()
+
+	creds := &Credentials{
+		Base:    aws.Auth{AccessKey: "base", SecretKey: "secret"},
+		RoleARN: "arn:aws:iam::123456789012:role/site",
+		Client:  http.DefaultClient,
+		STS:     server.URL,
+	}
+	creds.Auth()
+	if auth := creds.Auth(); auth.AccessKey != "session-2" {
+		t.Errorf("expected a session expiring within %v to be renewed, got %s", roleRenewal, auth.AccessKey)
+	}
+
+	server.Close()
+	if auth := creds.Auth(); auth.AccessKey != "session-2" {
+		t.Errorf("expected the current session to be kept when renewal fails, got %s", auth.AccessKey)
+	}
+}
+
+func TestNewCredentials(t *testing.T) {
+	creds, err := NewCredentials(&Options{S3Region: "us-west-2", S3AccessKey: "key", S3SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Auth().AccessKey != "key" || creds.Region.Name != "us-west-2" {
+		t.Errorf("expected the site's keys and region, got %+v in %s", creds.Auth(), creds.Region.Name)
+	}
+	if creds.Sign(nil) != nil {
+		t.Error("expected Sign to leave buckets alone without a role")
+	}
+
+	if _, err := NewCredentials(&Options{S3Region: "mars-1", S3AccessKey: "key", S3SecretKey: "secret"}); err == nil {
+		t.Error("expected an unknown region to be rejected")
+	}
+}
+
+func TestGetObjectSendsSessionToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Amz-Security-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	auth := aws.Auth{AccessKey: "key", SecretKey: "secret", Token: "token"}
+	bucket := s3.New(auth, aws.Region{S3Endpoint: server.URL}).Bucket("site")
+	resp, err := getObject(context.Background(), http.DefaultClient, bucket, "index.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,168 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// daemonEnv marks a process started by Daemonize, so it serves rather than
+// daemonizing again; upgrades inherit it
+const daemonEnv = "S3SITE_DAEMONIZED"
+
+// Daemonize starts this binary again with the same arguments in a new
+// session, detached from the terminal, with its stdout and stderr appended
+// to logPath.  It returns once the new process has survived its first
+// second, so a bad flag is reported rather than lost in the log.
+func Daemonize(logPath string) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return daemonize(exe, os.Args[1:], logPath, time.Second)
+}
+
+func daemonize(exe string, args []string, logPath string, settle time.Duration) (*os.Process, error) {
+	log, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout, cmd.Stderr = log, log
+	cmd.SysProcAttr = detached()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return nil, fmt.Errorf("s3site exited on startup, %v; see %s", err, logPath)
+	case <-time.After(settle):
+		return cmd.Process, nil
+	}
+}
+
+func daemonized() bool {
+	return os.Getenv(daemonEnv) != ""
+}
+
+// ReadPidfile returns the process id in path and whether that process is
+// running
+func ReadPidfile(path string) (int, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid pidfile %s, %s", path, err)
+	}
+	return pid, running(pid), nil
+}
+
+// WritePidfile writes this process's id to path, refusing if the process
+// already named there is running.  An upgrade replaces the pidfile of the
+// process it upgrades, which is still running.
+func WritePidfile(path string, upgrading bool) error {
+	if pid, ok, err := ReadPidfile(path); err == nil && ok && !upgrading && pid != os.Getpid() {
+		return fmt.Errorf("s3site is already running as pid %d, per %s", pid, path)
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// RemovePidfile removes path unless it names another process e.g. the one
+// this process was upgraded to
+func RemovePidfile(path string) {
+	if pid, _, err := ReadPidfile(path); err == nil && pid == os.Getpid() {
+		os.Remove(path)
+	}
+}
+
+// Stop signals the server named by the pidfile to drain and exit, waiting
+// until it has
+func Stop(c *cli.Context) {
+	opts, err := LoadOptions(os.Args[1:])
+	check(err)
+	if opts.PidFile == "" {
+		check(fmt.Errorf("stop requires a pidfile"))
+	}
+	check(stop(opts.PidFile, opts.DrainTimeout+5*time.Second))
+}
+
+func stop(path string, timeout time.Duration) error {
+	pid, ok, err := ReadPidfile(path)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Printf("s3site is not running; pid %d in %s has exited\n", pid, path)
+		return nil
+	}
+	if err := terminate(pid); err != nil {
+		return err
+	}
+
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if !running(pid) {
+			fmt.Printf("stopped s3site, pid %d\n", pid)
+			return nil
+		}
+	}
+	return fmt.Errorf("s3site, pid %d, still running after %s", pid, timeout)
+}
+
+// Status reports whether the server named by the pidfile is running,
+// exiting 3 when it isn't as init scripts expect
+func Status(c *cli.Context) {
+	opts, err := LoadOptions(os.Args[1:])
+	check(err)
+	if opts.PidFile == "" {
+		check(fmt.Errorf("status requires a pidfile"))
+	}
+
+	pid, ok, err := ReadPidfile(opts.PidFile)
+	switch {
+	case os.IsNotExist(err):
+		fmt.Println("s3site is not running")
+		os.Exit(3)
+	case err != nil:
+		check(err)
+	case !ok:
+		fmt.Printf("s3site is not running; pid %d in %s has exited\n", pid, opts.PidFile)
+		os.Exit(3)
+	}
+	fmt.Printf("s3site is running as pid %d\n", pid)
+}
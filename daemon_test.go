@@ -0,0 +1,98 @@
+package s3site
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPidfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pidfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s3site.pid")
+
+	if err := WritePidfile(path, false); err != nil {
+		t.Fatal(err)
+	}
+	if pid, ok, err := ReadPidfile(path); err != nil || !ok || pid != os.Getpid() {
+		t.Errorf("expected this process, got %d %v %v", pid, ok, err)
+	}
+
+	sleep := exec.Command("sleep", "10")
+	if err := sleep.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sleep.Process.Kill()
+	ioutil.WriteFile(path, []byte(strconv.Itoa(sleep.Process.Pid)), 0644)
+
+	if err := WritePidfile(path, false); err == nil {
+		t.Error("expected a running process to block the pidfile")
+	}
+	RemovePidfile(path)
+	if _, err := os.Stat(path); err != nil {
+		t.Error("expected the pidfile of another process to be kept")
+	}
+	if err := WritePidfile(path, true); err != nil {
+		t.Errorf("expected an upgrade to replace the pidfile, got %v", err)
+	}
+	RemovePidfile(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected the pidfile to be removed")
+	}
+}
+
+func TestStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pidfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s3site.pid")
+
+	sleep := exec.Command("sleep", "10")
+	if err := sleep.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go sleep.Wait()
+	ioutil.WriteFile(path, []byte(strconv.Itoa(sleep.Process.Pid)), 0644)
+
+	if err := stop(path, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := ReadPidfile(path); ok {
+		t.Error("expected the process to have stopped")
+	}
+}
+
+func TestDaemonize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "s3site.log")
+
+	if _, err := daemonize("/bin/sh", []string{"-c", "echo bad flag; exit 1"}, log, 5*time.Second); err == nil {
+		t.Error("expected a process exiting on startup to be reported")
+	}
+
+	process, err := daemonize("/bin/sh", []string{"-c", "echo marked $" + daemonEnv + "; sleep 10"}, log, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Kill()
+
+	time.Sleep(100 * time.Millisecond)
+	data, _ := ioutil.ReadFile(log)
+	if !strings.Contains(string(data), "bad flag") || !strings.Contains(string(data), "marked 1") {
+		t.Errorf("expected the output of both processes in the log, got %q", data)
+	}
+}
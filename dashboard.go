@@ -0,0 +1,204 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	dashboardWindow = 10 // seconds of samples used for rates
+	recentErrors    = 20
+)
+
+type dashboardSample struct {
+	at     time.Time
+	totals RequestTotals
+}
+
+// DashboardError is a recent 5xx response
+type DashboardError struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// DashboardStats is the data behind the dashboard page
+type DashboardStats struct {
+	RPS          float64          `json:"rps"`
+	ErrorRate    float64          `json:"error_rate"`
+	InFlight     int64            `json:"in_flight"`
+	CacheHit     float64          `json:"cache_hit_ratio"`
+	TopPaths     []AnalyticsCount `json:"top_paths"`
+	RecentErrors []DashboardError `json:"recent_errors"`
+}
+
+// Dashboard serves a self refreshing status page on the admin listener
+type Dashboard struct {
+	Metrics   *Metrics
+	Analytics *Analytics // optional source of top paths
+
+	mu      sync.Mutex
+	samples []dashboardSample
+	errors  []DashboardError
+}
+
+// Run samples the request counters every second
+func (d *Dashboard) Run() {
+	for now := range time.Tick(time.Second) {
+		d.sample(now)
+	}
+}
+
+func (d *Dashboard) sample(now time.Time) {
+	totals := d.Metrics.Totals()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.samples = append(d.samples, dashboardSample{at: now, totals: totals})
+	if len(d.samples) > dashboardWindow+1 {
+		d.samples = d.samples[1:]
+	}
+}
+
+// Wrap records the 5xx responses served by h
+func (d *Dashboard) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, req)
+		if recorder.Status() < 500 {
+			return
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.errors = append(d.errors, DashboardError{
+			Time:      time.Now(),
+			Method:    req.Method,
+			Path:      req.URL.Path,
+			Status:    recorder.Status(),
+			RequestID: requestID(req),
+		})
+		if len(d.errors) > recentErrors {
+			d.errors = d.errors[1:]
+		}
+	})
+}
+
+// Stats returns the current rates, newest errors first
+func (d *Dashboard) Stats() DashboardStats {
+	stats := DashboardStats{
+		InFlight:     d.Metrics.InFlight(),
+		TopPaths:     []AnalyticsCount{},
+		RecentErrors: []DashboardError{},
+	}
+
+	d.mu.Lock()
+	if n := len(d.samples); n > 1 {
+		first, last := d.samples[0], d.samples[n-1]
+		delta := last.totals.Sub(first.totals)
+		if elapsed := last.at.Sub(first.at).Seconds(); elapsed > 0 {
+			stats.RPS = float64(delta.Requests) / elapsed
+		}
+		if delta.Requests > 0 {
+			stats.ErrorRate = float64(delta.Errors) / float64(delta.Requests)
+		}
+	}
+	for i := len(d.errors) - 1; i >= 0; i-- {
+		stats.RecentErrors = append(stats.RecentErrors, d.errors[i])
+	}
+	d.mu.Unlock()
+
+	if snapshot, ok := d.Metrics.CacheSnapshot(); ok {
+		stats.CacheHit = snapshot.HitRatio
+	}
+	if d.Analytics != nil {
+		stats.TopPaths = d.Analytics.Report(10).Paths
+	}
+	return stats
+}
+
+var dashboardPage = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html><head><title>s3site</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.tiles div { display: inline-block; width: 10em; margin-right: 1em; padding: 1em; background: #eee; }
+.tiles b { display: block; font-size: 2em; }
+td { padding-right: 2em; }
+</style></head>
+<body>
+<div class="tiles">
+<div><b id="rps">-</b>requests/s</div>
+<div><b id="errors">-</b>5xx rate</div>
+<div><b id="hits">-</b>cache hit ratio</div>
+<div><b id="inflight">-</b>in flight</div>
+</div>
+<h2>Top paths</h2><table id="paths"></table>
+<h2>Recent errors</h2><table id="recent"></table>
+<script>
+function rows(id, items, cells) {
+  var table = document.getElementById(id);
+  table.innerHTML = "";
+  items.forEach(function(item) {
+    var tr = table.insertRow();
+    cells(item).forEach(function(cell) { tr.insertCell().textContent = cell; });
+  });
+}
+function refresh() {
+  fetch("{{.}}").then(function(r) { return r.json(); }).then(function(s) {
+    document.getElementById("rps").textContent = s.rps.toFixed(1);
+    document.getElementById("errors").textContent = (s.error_rate * 100).toFixed(1) + "%";
+    document.getElementById("hits").textContent = (s.cache_hit_ratio * 100).toFixed(1) + "%";
+    document.getElementById("inflight").textContent = s.in_flight;
+    rows("paths", s.top_paths, function(p) { return [p.key, p.count]; });
+    rows("recent", s.recent_errors, function(e) { return [e.time, e.status, e.method + " " + e.path, e.request_id || ""]; });
+  });
+}
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body></html>
+`))
+
+// ServeHTTP serves the page at / and its data at /dashboard.json
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/dashboard.json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(d.Stats())
+		return
+	}
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dashboardPage.Execute(w, "/dashboard.json")
+}
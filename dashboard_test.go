@@ -0,0 +1,62 @@
+package s3site
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardStats(t *testing.T) {
+	metrics := NewMetrics()
+	dashboard := &Dashboard{Metrics: metrics, Analytics: NewAnalytics(1)}
+
+	started := time.Now()
+	dashboard.sample(started)
+	for i := 0; i < 19; i++ {
+		metrics.ObserveRequest(200, 0, time.Millisecond)
+	}
+	metrics.ObserveRequest(502, 0, time.Millisecond)
+	dashboard.sample(started.Add(2 * time.Second))
+
+	h := dashboard.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/broken", nil))
+	dashboard.Analytics.Record(httptest.NewRequest("GET", "/index.html", nil), 200, 1)
+
+	w := httptest.NewRecorder()
+	dashboard.ServeHTTP(w, httptest.NewRequest("GET", "/dashboard.json", nil))
+	stats := DashboardStats{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.RPS != 10 || stats.ErrorRate != 0.05 {
+		t.Errorf("expected 10 rps at 5%% errors, got %g at %g", stats.RPS, stats.ErrorRate)
+	}
+	if len(stats.RecentErrors) != 1 || stats.RecentErrors[0].Path != "/broken" {
+		t.Errorf("unexpected recent errors, %v", stats.RecentErrors)
+	}
+	if len(stats.TopPaths) != 1 || stats.TopPaths[0].Key != "/index.html" {
+		t.Errorf("unexpected top paths, %v", stats.TopPaths)
+	}
+}
+
+func TestDashboardPage(t *testing.T) {
+	dashboard := &Dashboard{Metrics: NewMetrics()}
+
+	w := httptest.NewRecorder()
+	dashboard.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/dashboard.json") {
+		t.Errorf("expected dashboard page, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	dashboard.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,347 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+// localFile is a file in the directory being deployed
+type localFile struct {
+	Path string // on disk
+	Rel  string // slash separated path within the directory
+	Key  string // in the bucket
+	Size int64
+	MD5  string
+
+	// Data, if set, is uploaded in place of the file on disk e.g. once
+	// references have been rewritten
+	Data         []byte
+	CacheControl string
+}
+
+// DeployPlan lists the changes needed to make the bucket match a directory
+type DeployPlan struct {
+	Upload    []localFile
+	Copy      []copyOp
+	Delete    []string
+	Unchanged int
+}
+
+// copyOp copies an unchanged object from the previous release
+type copyOp struct {
+	From string
+	To   string
+}
+
+// Deployer syncs a local directory to the bucket under Prefix
+type Deployer struct {
+	Bucket   *s3.Bucket
+	Prefix   string
+	Delete   bool // remove keys with no local file
+	Parallel int
+
+	// Fingerprint renames assets with a hash of their content; see Fingerprint
+	Fingerprint bool
+
+	// Base, if set, is the prefix of the previous release; files are
+	// compared against it and unchanged ones copied within s3 rather than
+	// uploaded
+	Base string
+	Out  io.Writer
+
+	// Types, if set, is consulted for content types before the built in
+	// types
+	Types MimeTypes
+}
+
+// Deploy uploads the directory named by the first argument
+func Deploy(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		check(fmt.Errorf("deploy requires a directory"))
+	}
+	opts := Opts(c)
+	if opts.Bucket == "" {
+		check(fmt.Errorf("deploy requires a bucket"))
+	}
+
+	auth, err := aws.EnvAuth()
+	check(err)
+
+	deployer := &Deployer{
+		Bucket:   s3.New(auth, aws.USEast).Bucket(opts.Bucket),
+		Prefix:   opts.keyPrefix("/"),
+		Delete:   c.Bool("delete"),
+		Parallel: c.Int("parallel"),
+		Out:      os.Stdout,
+
+		Fingerprint: c.Bool("fingerprint"),
+	}
+	deployer.Types, err = LoadMimeTypes(opts.MimeTypesFile, opts.MimeTypes)
+	check(err)
+	if c.Bool("atomic") {
+		check(DeployRelease(deployer, c.Args()[0], time.Now()))
+		return
+	}
+	plan, err := deployer.Plan(c.Args()[0])
+	check(err)
+	check(deployer.Apply(plan))
+}
+
+// Plan compares the files in dir with the keys under the prefix; files
+// whose size or md5 differ from the object's ETag are uploaded
+func (d *Deployer) Plan(dir string) (*DeployPlan, error) {
+	local, err := localFiles(dir, d.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	if d.Fingerprint {
+		if local, err = Fingerprint(local, d.Prefix); err != nil {
+			return nil, err
+		}
+	}
+	base := d.Prefix
+	if d.Base != "" {
+		base = d.Base
+	}
+	remote, err := d.remoteKeys(base)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &DeployPlan{}
+	for _, file := range local {
+		key, ok := remote[file.Rel]
+		delete(remote, file.Rel)
+		switch {
+		case !ok || key.Size != file.Size || !etagMatches(key.ETag, file.MD5):
+			plan.Upload = append(plan.Upload, file)
+		case d.Base != "":
+			plan.Copy = append(plan.Copy, copyOp{From: key.Key, To: file.Key})
+		default:
+			plan.Unchanged++
+		}
+	}
+
+	if d.Delete && d.Base == "" {
+		for rel, key := range remote {
+			if isReleaseKey(rel) {
+				continue
+			}
+			plan.Delete = append(plan.Delete, key.Key)
+		}
+		sort.Strings(plan.Delete)
+	}
+	return plan, nil
+}
+
+// etagMatches compares an ETag with a file's md5; the ETag of a multipart
+// upload isn't an md5, so such objects are compared by size alone
+func etagMatches(etag, sum string) bool {
+	etag = strings.Trim(etag, `"`)
+	return strings.Contains(etag, "-") || etag == sum
+}
+
+// Apply uploads and deletes per plan, Parallel at a time
+func (d *Deployer) Apply(plan *DeployPlan) error {
+	parallel := d.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var mu sync.Mutex
+	var failed error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failed == nil {
+			failed = err
+		}
+	}
+
+	work := make(chan func() error)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fn := range work {
+				if err := fn(); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	for _, file := range plan.Upload {
+		file := file
+		work <- func() error {
+			if err := d.upload(file); err != nil {
+				return fmt.Errorf("unable to upload %s, %s", file.Key, err)
+			}
+			d.printf("upload %s\n", file.Key)
+			return nil
+		}
+	}
+	for _, op := range plan.Copy {
+		op := op
+		work <- func() error {
+			if err := d.Bucket.Copy(op.From, op.To, s3.Private); err != nil {
+				return fmt.Errorf("unable to copy %s, %s", op.From, err)
+			}
+			return nil
+		}
+	}
+	for _, key := range plan.Delete {
+		key := key
+		work <- func() error {
+			if err := d.Bucket.Del(key); err != nil {
+				return fmt.Errorf("unable to delete %s, %s", key, err)
+			}
+			d.printf("delete %s\n", key)
+			return nil
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if failed != nil {
+		return failed
+	}
+	d.printf("%d uploaded, %d copied, %d deleted, %d unchanged\n", len(plan.Upload), len(plan.Copy), len(plan.Delete), plan.Unchanged)
+	return nil
+}
+
+func (d *Deployer) printf(format string, args ...interface{}) {
+	if d.Out != nil {
+		fmt.Fprintf(d.Out, format, args...)
+	}
+}
+
+func (d *Deployer) upload(file localFile) error {
+	var body io.ReadSeeker
+	if file.Data != nil {
+		body = bytes.NewReader(file.Data)
+	} else {
+		f, err := os.Open(file.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		body = f
+	}
+
+	header := map[string][]string{"Content-Type": {contentType(d.Types, body, file.Rel)}}
+	if file.CacheControl != "" {
+		header["Cache-Control"] = []string{file.CacheControl}
+	}
+	return d.Bucket.PutReaderHeader(file.Key, body, file.Size, header, s3.Private)
+}
+
+// contentType returns the type for the file's extension, falling back to
+// sniffing its first 512 bytes
+func contentType(types MimeTypes, r io.ReadSeeker, name string) string {
+	if t := types.TypeByExtension(name); t != "" {
+		return t
+	}
+	data := make([]byte, 512)
+	n, _ := io.ReadFull(r, data)
+	r.Seek(0, io.SeekStart)
+	return http.DetectContentType(data[:n])
+}
+
+// remoteKeys lists every key under prefix by its path relative to prefix
+func (d *Deployer) remoteKeys(prefix string) (map[string]s3.Key, error) {
+	keys := map[string]s3.Key{}
+	marker := ""
+	for {
+		list, err := d.Bucket.List(prefix, "", marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range list.Contents {
+			keys[strings.TrimPrefix(key.Key, prefix)] = key
+			marker = key.Key
+		}
+		if !list.IsTruncated || len(list.Contents) == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// localFiles walks dir, returning each regular file along with its key
+func localFiles(dir, prefix string) ([]localFile, error) {
+	files := []localFile{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		sum, err := md5File(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, localFile{
+			Path: p,
+			Rel:  filepath.ToSlash(rel),
+			Key:  prefix + filepath.ToSlash(rel),
+			Size: info.Size(),
+			MD5:  sum,
+		})
+		return nil
+	})
+	return files, err
+}
+
+func md5File(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
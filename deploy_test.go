@@ -0,0 +1,95 @@
+package s3site
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDeployer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>new</html>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "about.html"), []byte("about"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "css", "site.css"), []byte("body{}"), 0644)
+
+	var mu sync.Mutex
+	uploaded := map[string]string{}
+	deleted := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch req.Method {
+		case "GET":
+			w.Write([]byte(`<ListBucketResult><Name>bucket</Name>
+<Contents><Key>site/index.html</Key><Size>16</Size><ETag>"old"</ETag></Contents>
+<Contents><Key>site/about.html</Key><Size>5</Size><ETag>"` + md5Hex("about") + `"</ETag></Contents>
+<Contents><Key>site/removed.html</Key><Size>1</Size><ETag>"x"</ETag></Contents>
+</ListBucketResult>`))
+		case "PUT":
+			uploaded[strings.TrimPrefix(req.URL.Path, "/bucket/")] = req.Header.Get("Content-Type")
+		case "DELETE":
+			deleted = append(deleted, strings.TrimPrefix(req.URL.Path, "/bucket/"))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	api := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{S3Endpoint: server.URL})
+	deployer := &Deployer{Bucket: api.Bucket("bucket"), Prefix: "site/", Delete: true, Parallel: 2}
+
+	plan, err := deployer.Plan(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Unchanged != 1 || len(plan.Upload) != 2 || !reflect.DeepEqual(plan.Delete, []string{"site/removed.html"}) {
+		t.Errorf("unexpected plan, %+v", plan)
+	}
+
+	if err := deployer.Apply(plan); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"site/index.html": "text/html; charset=utf-8", "site/css/site.css": "text/css; charset=utf-8"}
+	if !reflect.DeepEqual(uploaded, expected) {
+		t.Errorf("expected uploads %v, got %v", expected, uploaded)
+	}
+	sort.Strings(deleted)
+	if !reflect.DeepEqual(deleted, []string{"site/removed.html"}) {
+		t.Errorf("unexpected deletes, %v", deleted)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	sum := md5Hex("data")
+	if !etagMatches(`"`+sum+`"`, sum) {
+		t.Error("expected quoted etag to match")
+	}
+	if etagMatches(`"abc"`, sum) {
+		t.Error("expected different etag not to match")
+	}
+	if !etagMatches(`"abc-2"`, sum) {
+		t.Error("expected multipart etag to defer to size")
+	}
+}
@@ -0,0 +1,103 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+// Diagnosis explains why a request couldn't be served from s3, for
+// operators setting up a site
+type Diagnosis struct {
+	Path   string
+	Bucket string
+	Region string
+	Key    string
+	Err    error
+	Hint   string
+}
+
+// Diagnose works out the likely cause of err, returned fetching key from
+// bucket.  A missing key is told apart from an empty prefix by listing the
+// prefix.
+func Diagnose(bucket *s3.Bucket, opts *Options, urlPath, key string, err error) *Diagnosis {
+	d := &Diagnosis{
+		Path:   urlPath,
+		Bucket: bucket.Name,
+		Region: bucket.Region.Name,
+		Key:    key,
+		Err:    err,
+	}
+
+	e, ok := err.(*s3.Error)
+	switch {
+	case ok && e.StatusCode == http.StatusForbidden:
+		d.Hint = "access was denied; check the credentials allow s3:GetObject, and s3:ListBucket so missing keys are reported as such, on the bucket"
+	case ok && e.StatusCode == http.StatusMovedPermanently:
+		d.Hint = fmt.Sprintf("the bucket is not in %s; set s3-region to the bucket's region", d.Region)
+	case ok && e.StatusCode == http.StatusNotFound:
+		prefix := opts.keyPrefix("/")
+		list, listErr := bucket.List(prefix, "", "", 1)
+		switch {
+		case listErr != nil:
+			d.Hint = fmt.Sprintf("the key does not exist, and listing %s failed, %s", prefix, listErr)
+		case len(list.Contents) == 0:
+			d.Hint = fmt.Sprintf("there are no objects under s3://%s/%s; deploy the site, or check bucket and prefix", d.Bucket, prefix)
+		case strings.HasSuffix(urlPath, "/"):
+			d.Hint = fmt.Sprintf("the index file %s is missing from this directory; deploy it, or set index-file", opts.IndexFile)
+		default:
+			d.Hint = "the key does not exist"
+		}
+	default:
+		d.Hint = fmt.Sprintf("s3 could not be reached; check the network and that the bucket is in %s", d.Region)
+	}
+	return d
+}
+
+var diagnosisPage = template.Must(template.New("diagnosis").Parse(`<!DOCTYPE html>
+<html><head><title>s3site: unable to serve {{.Path}}</title></head>
+<body>
+<h1>Unable to serve {{.Path}}</h1>
+<p>{{.Hint}}</p>
+<table>
+<tr><td>bucket</td><td>{{.Bucket}}</td></tr>
+<tr><td>region</td><td>{{.Region}}</td></tr>
+<tr><td>key</td><td>{{.Key}}</td></tr>
+<tr><td>error</td><td>{{.Err}}</td></tr>
+</table>
+<p>This page is shown because s3site is running with --diagnostics, which should be turned off once the site is set up.</p>
+</body></html>
+`))
+
+// Write writes the diagnosis as an html page with the given status
+func (d *Diagnosis) Write(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	diagnosisPage.Execute(w, d)
+}
@@ -0,0 +1,56 @@
+package s3site
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+func TestDiagnose(t *testing.T) {
+	missing := &s3.Error{StatusCode: http.StatusNotFound, Message: "404 Not Found"}
+	testCases := []struct {
+		Keys []string
+		Path string
+		Err  error
+		Hint string
+	}{
+		{nil, "/", missing, "there are no objects under s3://bucket/site/"},
+		{[]string{"site/about.html"}, "/", missing, "the index file index.html is missing"},
+		{[]string{"site/index.html"}, "/about.html", missing, "the key does not exist"},
+		{nil, "/", &s3.Error{StatusCode: http.StatusForbidden}, "access was denied"},
+		{nil, "/", errors.New("dial tcp: i/o timeout"), "s3 could not be reached"},
+	}
+
+	for _, tc := range testCases {
+		server := fakeS3("us-east-1", tc.Keys...)
+		bucket := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{Name: "us-east-1", S3Endpoint: server.URL}).Bucket("bucket")
+		opts := &Options{Bucket: "bucket", Prefix: "site", IndexFile: "index.html"}
+
+		d := Diagnose(bucket, opts, tc.Path, opts.Key(tc.Path), tc.Err)
+		if !strings.HasPrefix(d.Hint, tc.Hint) {
+			t.Errorf("%s with %v: expected hint %q, got %q", tc.Path, tc.Err, tc.Hint, d.Hint)
+		}
+		server.Close()
+	}
+}
+
+func TestDiagnosisWrite(t *testing.T) {
+	d := &Diagnosis{Path: "/<script>", Bucket: "bucket", Region: "us-east-1", Key: "site/index.html", Err: errors.New("denied"), Hint: "access was denied"}
+	w := httptest.NewRecorder()
+	d.Write(w, http.StatusNotFound)
+
+	if w.Code != http.StatusNotFound || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("unexpected response, %d %v", w.Code, w.Header())
+	}
+	body := w.Body.String()
+	for _, expected := range []string{"site/index.html", "access was denied", "&lt;script&gt;"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in the page", expected)
+		}
+	}
+}
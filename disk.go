@@ -0,0 +1,310 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const diskIndexFile = "index.json"
+
+type diskRecord struct {
+	Key          string    `json:"key"`
+	Variant      string    `json:"variant,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	CacheControl string    `json:"cache_control,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+	Used         time.Time `json:"used"`
+	Size         int64     `json:"size"`
+
+	restored bool
+}
+
+// DiskStore is the on-disk tier of the cache.  Its index of keys, etags,
+// and fetch times is persisted so that a restart keeps the cache warm;
+// entries restored from a previous run are revalidated on first use.
+type DiskStore struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+	index map[string]*diskRecord
+	dirty bool
+}
+
+// OpenDiskStore opens, creating if required, the cache in dir
+func OpenDiskStore(dir string, maxBytes int64) (*DiskStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0755); err != nil {
+		return nil, err
+	}
+
+	d := &DiskStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+		index:    map[string]*diskRecord{},
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, diskIndexFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	records := []*diskRecord{}
+	if err == nil {
+		if err := json.Unmarshal(data, &records); err != nil {
+			logger.Warn("ignoring corrupt cache index", Fields{"dir": dir, "error": err})
+			records = nil
+		}
+	}
+	for _, record := range records {
+		record.restored = true
+		d.index[CacheKey(record.Key, record.Variant)] = record
+		d.bytes += record.Size
+	}
+	if err := d.removeOrphans(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// removeOrphans deletes the objects missing from the index e.g. those written
+// after the index was last saved by a process that crashed, which would
+// otherwise take up space the store doesn't account for
+func (d *DiskStore) removeOrphans() error {
+	indexed := map[string]bool{}
+	for cacheKey := range d.index {
+		indexed[filepath.Base(d.filename(cacheKey))] = true
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(d.dir, "objects"))
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, file := range files {
+		if indexed[file.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(d.dir, "objects", file.Name())); err != nil {
+			return err
+		}
+		removed++
+	}
+	if removed > 0 {
+		logger.Info("removed unindexed cache objects", Fields{"dir": d.dir, "removed": removed})
+	}
+	return nil
+}
+
+func (d *DiskStore) filename(cacheKey string) string {
+	sum := sha1.Sum([]byte(cacheKey))
+	return filepath.Join(d.dir, "objects", hex.EncodeToString(sum[:]))
+}
+
+func (d *DiskStore) Get(cacheKey string) (*Entry, bool) {
+	d.mu.Lock()
+	record, ok := d.index[cacheKey]
+	if ok {
+		record.Used = time.Now()
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	body, err := ioutil.ReadFile(d.filename(cacheKey))
+	if err != nil || int64(len(body)) != record.Size {
+		d.Remove(cacheKey)
+		return nil, false
+	}
+
+	return &Entry{
+		Key:          record.Key,
+		Variant:      record.Variant,
+		Body:         body,
+		ContentType:  record.ContentType,
+		ETag:         record.ETag,
+		LastModified: record.LastModified,
+		CacheControl: record.CacheControl,
+		Fetched:      record.Fetched,
+		Restored:     record.restored,
+	}, true
+}
+
+func (d *DiskStore) Put(entry *Entry) {
+	size := int64(len(entry.Body))
+	if size > d.maxBytes {
+		return
+	}
+
+	cacheKey := entry.CacheKey()
+	if err := ioutil.WriteFile(d.filename(cacheKey), entry.Body, 0644); err != nil {
+		logger.Error("unable to write to disk cache", Fields{"key": cacheKey, "error": err})
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if record, ok := d.index[cacheKey]; ok {
+		d.bytes -= record.Size
+	}
+	d.index[cacheKey] = &diskRecord{
+		Key:          entry.Key,
+		Variant:      entry.Variant,
+		ContentType:  entry.ContentType,
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+		CacheControl: entry.CacheControl,
+		Fetched:      entry.Fetched,
+		Used:         time.Now(),
+		Size:         size,
+	}
+	d.bytes += size
+	d.dirty = true
+
+	if d.bytes > d.maxBytes {
+		d.evict()
+	}
+}
+
+// evict removes the least recently used records until the store fits
+func (d *DiskStore) evict() {
+	records := make([]*diskRecord, 0, len(d.index))
+	for _, record := range d.index {
+		records = append(records, record)
+	}
+	sort.Sort(byUsed(records))
+
+	for _, record := range records {
+		if d.bytes <= d.maxBytes {
+			break
+		}
+		d.removeLocked(CacheKey(record.Key, record.Variant))
+	}
+}
+
+// Touch records that cacheKey was confirmed against s3 at fetched
+func (d *DiskStore) Touch(cacheKey string, fetched time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if record, ok := d.index[cacheKey]; ok {
+		record.Fetched = fetched
+		record.restored = false
+		d.dirty = true
+	}
+}
+
+// Remove deletes the entries matching any of the cache keys and returns
+// the keys that were present
+func (d *DiskStore) Remove(cacheKeys ...string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	removed := []string{}
+	for _, cacheKey := range cacheKeys {
+		if d.removeLocked(cacheKey) {
+			removed = append(removed, cacheKey)
+		}
+	}
+	return removed
+}
+
+func (d *DiskStore) removeLocked(cacheKey string) bool {
+	record, ok := d.index[cacheKey]
+	if !ok {
+		return false
+	}
+	delete(d.index, cacheKey)
+	d.bytes -= record.Size
+	d.dirty = true
+	os.Remove(d.filename(cacheKey))
+	return true
+}
+
+// Keys returns the cache keys of every entry on disk
+func (d *DiskStore) Keys() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keys := make([]string, 0, len(d.index))
+	for key := range d.index {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Save persists the index if it has changed since last saved
+func (d *DiskStore) Save() error {
+	d.mu.Lock()
+	if !d.dirty {
+		d.mu.Unlock()
+		return nil
+	}
+	records := make([]*diskRecord, 0, len(d.index))
+	for _, record := range d.index {
+		copied := *record
+		records = append(records, &copied)
+	}
+	d.dirty = false
+	d.mu.Unlock()
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	// write then rename so a crash never leaves a partial index behind
+	tmp := filepath.Join(d.dir, diskIndexFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(d.dir, diskIndexFile))
+}
+
+// Run saves the index every interval
+func (d *DiskStore) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := d.Save(); err != nil {
+			logger.Error("unable to save cache index", Fields{"dir": d.dir, "error": err})
+		}
+	}
+}
+
+type byUsed []*diskRecord
+
+func (b byUsed) Len() int           { return len(b) }
+func (b byUsed) Less(i, j int) bool { return b[i].Used.Before(b[j].Used) }
+func (b byUsed) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,115 @@
+package s3site
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskStoreSurvivesRestart(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "s3site")
+	defer os.RemoveAll(dir)
+
+	disk, err := OpenDiskStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := time.Now().Add(-time.Minute).Round(time.Second)
+	disk.Put(&Entry{Key: "a.html", Body: []byte("hello"), ETag: `"v1"`, Fetched: fetched})
+	if err := disk.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	disk, err = OpenDiskStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := disk.Get("a.html")
+	if !ok {
+		t.Fatal("expected entry to survive restart")
+	}
+	if string(entry.Body) != "hello" || entry.ETag != `"v1"` || !entry.Fetched.Equal(fetched) {
+		t.Errorf("unexpected entry %#v", entry)
+	}
+	if !entry.Restored {
+		t.Error("expected entry to be flagged for revalidation")
+	}
+
+	disk.Touch("a.html", time.Now())
+	if entry, _ := disk.Get("a.html"); entry.Restored {
+		t.Error("expected touched entry to be validated")
+	}
+}
+
+func TestDiskStoreRemovesOrphans(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "s3site")
+	defer os.RemoveAll(dir)
+
+	disk, err := OpenDiskStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk.Put(&Entry{Key: "a.html", Body: []byte("hello")})
+	if err := disk.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// written after the last save by a process that then crashed
+	disk.Put(&Entry{Key: "b.html", Body: []byte("lost")})
+	stray := filepath.Join(dir, "objects", "stray")
+	ioutil.WriteFile(stray, []byte("stray"), 0644)
+
+	disk, err = OpenDiskStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Error("expected the stray object to be removed")
+	}
+	if _, err := os.Stat(disk.filename("b.html")); !os.IsNotExist(err) {
+		t.Error("expected the unindexed object to be removed")
+	}
+	if _, ok := disk.Get("a.html"); !ok {
+		t.Error("expected the indexed object to be kept")
+	}
+}
+
+func TestDiskStoreEvicts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "s3site")
+	defer os.RemoveAll(dir)
+
+	disk, _ := OpenDiskStore(dir, 10)
+	disk.Put(&Entry{Key: "a", Body: []byte("12345")})
+	time.Sleep(time.Millisecond)
+	disk.Put(&Entry{Key: "b", Body: []byte("12345")})
+	time.Sleep(time.Millisecond)
+	disk.Put(&Entry{Key: "c", Body: []byte("12345")})
+
+	if _, ok := disk.Get("a"); ok {
+		t.Error("expected least recently used entry to be evicted")
+	}
+	if _, ok := disk.Get("c"); !ok {
+		t.Error("expected c to be retained")
+	}
+}
+
+func TestCachePromotesAndPurgesDisk(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "s3site")
+	defer os.RemoveAll(dir)
+
+	cache := NewCache(5)
+	cache.Disk, _ = OpenDiskStore(dir, 1<<20)
+	cache.Set(&Entry{Key: "docs/a", Body: []byte("12345")})
+	cache.Set(&Entry{Key: "docs/b", Body: []byte("12345")})
+
+	if _, ok := cache.Get("docs/a"); !ok {
+		t.Error("expected evicted entry to be served from disk")
+	}
+	if n := cache.DeletePrefix("docs/"); n != 2 {
+		t.Errorf("expected 2 entries purged, got %d", n)
+	}
+	if _, ok := cache.Get("docs/b"); ok {
+		t.Error("expected purge to reach the disk tier")
+	}
+}
@@ -0,0 +1,214 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// secretFlags are redacted from the dry run output
+var secretFlags = map[string]bool{
+	"password":      true,
+	"s3-secret-key": true,
+	"admin-token":   true,
+	"sentry-dsn":    true,
+	"alert-webhook": true,
+	"form-secret":   true,
+}
+
+// DryRun writes the options resolved from args and where each came from,
+// followed by each site they describe: its routes, auth, response headers,
+// and cache policies
+func DryRun(w io.Writer, args []string) error {
+	set, config, err := parseFlags(args)
+	if err != nil {
+		return err
+	}
+	opts := Opts(cli.NewContext(nil, set, set))
+	opts.Users = config.Users
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "config")
+	for _, flag := range Flags() {
+		name, envVar := flagFields(flag)
+		if name == "config" {
+			continue
+		}
+		value := set.Lookup(name).Value.String()
+		if secretFlags[name] && value != "" {
+			value = "********"
+		}
+
+		source := "default"
+		switch _, inConfig := config.Values[name]; {
+		case flagSet(args, name):
+			source = "flag"
+		case os.Getenv(envVar) != "":
+			source = "env " + envVar
+		case inConfig:
+			source = "config " + configPath(args)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", name, value, source)
+	}
+	tw.Flush()
+
+	sites, err := LoadSites(args)
+	if err != nil {
+		return err
+	}
+	if opts.Bucket != "" || len(sites) == 0 {
+		fmt.Fprintln(w)
+		writeSite(w, "*", opts)
+	}
+	for _, host := range sortedHosts(sites) {
+		fmt.Fprintln(w)
+		writeSite(w, host, sites[host])
+	}
+	return nil
+}
+
+// writeSite describes how opts serves requests for host
+func writeSite(w io.Writer, host string, opts *Options) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+	line := func(name, format string, args ...interface{}) {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, fmt.Sprintf(format, args...))
+	}
+
+	fmt.Fprintln(tw, "site "+host)
+	origin := "s3://" + opts.Bucket + "/" + opts.siteRoot()
+	if opts.Releases {
+		origin += " (release named by current.json)"
+	}
+	if opts.BlueGreen() {
+		origin += " (" + opts.Slot + " of blue/green)"
+	}
+	line("origin", "%s", origin)
+	creds := "from the environment"
+	switch {
+	case opts.S3AccessKey != "":
+		creds = "access key " + opts.S3AccessKey
+	case opts.AWSProfile != "":
+		creds = "aws profile " + opts.AWSProfile
+	}
+	if opts.S3RoleARN != "" {
+		creds += ", assuming " + opts.S3RoleARN
+	}
+	region := opts.S3Region
+	if region == "" {
+		region = "the default region"
+	}
+	line("credentials", "%s in %s", creds, region)
+	line("index", "%s", opts.IndexFile)
+
+	listen := opts.Listen
+	if len(listen) == 0 {
+		listen = []string{":" + opts.Port}
+	}
+	if opts.AdminPort != "" {
+		listen = append(listen, "admin=:"+opts.AdminPort)
+	}
+	line("listen", "%s", strings.Join(listen, ", "))
+
+	line("route", "%s\t%s", HealthzPath+", "+ReadyzPath, "health checks")
+	if opts.AdminToken != "" {
+		routes := []string{"/-/purge", "/-/cache", "/-/cost", ReloadPath, NotFoundPath}
+		if opts.Analytics {
+			routes = append(routes, AnalyticsPath)
+		}
+		line("route", "%s\t%s", strings.Join(routes, ", "), "admin, bearer token")
+	}
+	line("route", "%s\t%s", "/", "s3 objects under "+origin)
+
+	switch {
+	case !opts.RequiresAuth():
+		line("auth", "none")
+	default:
+		users := []string{}
+		if opts.Username != "" && opts.Password != "" {
+			users = append(users, opts.Username)
+		}
+		for user := range opts.Users {
+			users = append(users, user)
+		}
+		sort.Strings(users)
+		line("auth", "basic, realm %q, users %s", opts.Realm, strings.Join(users, ", "))
+	}
+
+	if opts.MaxAge > 0 {
+		line("header", "Cache-Control: max-age=%d", opts.MaxAge)
+	}
+	if opts.SurrogateMaxAge > 0 {
+		line("header", "Surrogate-Control: max-age=%d", opts.SurrogateMaxAge)
+	}
+	if opts.SurrogateKeyHeader != "" {
+		line("header", "%s: <path and its directories>", opts.SurrogateKeyHeader)
+	}
+	if opts.VersionHeader {
+		line("header", "Server: s3site/%s", Version)
+		line("header", "X-S3Site-Version: %s", Version)
+	}
+
+	if opts.CacheSize <= 0 {
+		line("cache", "none")
+		return
+	}
+	cache := fmt.Sprintf("memory %dMB, admission %s", opts.CacheSize, opts.CacheAdmit)
+	if opts.CacheDir != "" {
+		cache += fmt.Sprintf(", disk %s %dMB", opts.CacheDir, opts.CacheDirSize)
+	}
+	if opts.MaxObject > 0 {
+		cache += fmt.Sprintf(", objects up to %dKB", opts.MaxObject)
+	}
+	line("cache", "%s", cache)
+	if len(opts.CacheTypes) > 0 {
+		line("cache types", "%s", strings.Join(opts.CacheTypes, ", "))
+	}
+
+	rules, _ := ParseTTLRules(opts.CacheTTL)
+	for _, rule := range rules {
+		line("cache ttl", "%s\t%s", rule.Pattern, ttlString(rule.TTL))
+	}
+	line("cache ttl", "%s\t%s", "*", ttlString(opts.CacheFresh))
+	stale := "indefinitely"
+	if opts.MaxStale > 0 {
+		stale = "for " + opts.MaxStale.String()
+	}
+	line("cache stale", "%s while revalidating, %s more on s3 errors", stale, opts.StaleIfError)
+}
+
+// ttlString formats a cache ttl, which may be Forever
+func ttlString(d time.Duration) string {
+	if d == Forever {
+		return "forever"
+	}
+	return d.String()
+}
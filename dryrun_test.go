@@ -0,0 +1,52 @@
+package s3site
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	args := []string{
+		"--bucket", "site", "--prefix", "www",
+		"--username", "admin", "--password", "hunter2",
+		"--cache-size", "64", "--cache-ttl", "*.png=forever",
+		"--dry-run",
+	}
+
+	buf := &bytes.Buffer{}
+	if err := DryRun(buf, args); err != nil {
+		t.Fatal(err)
+	}
+	out := strings.Join(strings.Fields(buf.String()), " ")
+
+	for _, expected := range []string{
+		"bucket site flag",
+		"index-file index.html default",
+		"origin s3://site/www/",
+		`auth basic, realm "Realm", users admin`,
+		"header Cache-Control: max-age=90",
+		"cache memory 64MB, admission always",
+		"cache ttl *.png forever",
+		"cache ttl * 30s",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in the dry run", expected)
+		}
+	}
+	if strings.Contains(out, "hunter2") {
+		t.Error("expected the password to be redacted")
+	}
+}
+
+func TestDryRunWithoutCache(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := DryRun(buf, []string{"--bucket", "site"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"auth none", "cache none", "listen :8080"} {
+		if !strings.Contains(strings.Join(strings.Fields(buf.String()), " "), expected) {
+			t.Errorf("expected %q in the dry run\n%s", expected, buf.String())
+		}
+	}
+}
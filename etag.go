@@ -0,0 +1,81 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// StrongETag returns a strong validator derived from the content of body,
+// for responses whose body no longer matches the etag s3 reported
+func StrongETag(body []byte) string {
+	sum := sha1.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// VariantETag derives the etag of a variant of the representation tagged
+// etag e.g. a resized image, minified file, or encoding; "abc" => "abc-min"
+func VariantETag(etag, variant string) string {
+	if etag == "" || variant == "" {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + variant + `"`
+}
+
+// setValidators writes the ETag and Last-Modified headers and reports
+// whether the client's copy, per If-None-Match, is still current.  A
+// response with a Content-Encoding, which must be set first, is tagged as
+// that encoding's variant so its validator differs from the identity body's.
+func setValidators(w http.ResponseWriter, req *http.Request, etag, lastModified string) bool {
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		etag = VariantETag(etag, encoding)
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if lastModified != "" {
+		w.Header().Set("Last-Modified", lastModified)
+	}
+	return etag != "" && etagMatch(req.Header.Get("If-None-Match"), etag)
+}
+
+// etagMatch performs the weak comparison If-None-Match calls for
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package s3site
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVariantETag(t *testing.T) {
+	if etag := VariantETag(`"abc"`, "gzip"); etag != `"abc-gzip"` {
+		t.Errorf("unexpected etag %s", etag)
+	}
+	if etag := VariantETag(`"abc"`, ""); etag != `"abc"` {
+		t.Errorf("unexpected etag %s", etag)
+	}
+}
+
+func TestSetValidatorsEncoding(t *testing.T) {
+	testCases := []struct {
+		encoding, ifNoneMatch, etag string
+		notModified                 bool
+	}{
+		{"", `"abc"`, `"abc"`, true},
+		{"identity", `"abc"`, `"abc"`, true},
+		{"gzip", `"abc"`, `"abc-gzip"`, false},
+		{"gzip", `"abc-gzip"`, `"abc-gzip"`, true},
+		{"br", `"abc-gzip"`, `"abc-br"`, false},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("If-None-Match", tc.ifNoneMatch)
+		w := httptest.NewRecorder()
+		if tc.encoding != "" {
+			w.Header().Set("Content-Encoding", tc.encoding)
+		}
+
+		notModified := setValidators(w, req, `"abc"`, "")
+		if etag := w.Header().Get("ETag"); etag != tc.etag || notModified != tc.notModified {
+			t.Errorf("%s, If-None-Match %s: expected %s %v, got %s %v", tc.encoding, tc.ifNoneMatch, tc.etag, tc.notModified, etag, notModified)
+		}
+	}
+}
+
+func TestStrongETag(t *testing.T) {
+	if StrongETag([]byte("<p>a</p>")) == StrongETag([]byte("<p>b</p>")) {
+		t.Error("expected different bodies to be tagged differently")
+	}
+	if etag := StrongETag([]byte("<p>a</p>")); !strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, "W/") {
+		t.Errorf("expected a strong etag, got %s", etag)
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	testCases := []struct {
+		Header   string
+		Expected bool
+	}{
+		{"", false},
+		{"*", true},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz"`, false},
+	}
+	for _, tc := range testCases {
+		if got := etagMatch(tc.Header, `"abc"`); got != tc.Expected {
+			t.Errorf("%s: expected %v, got %v", tc.Header, tc.Expected, got)
+		}
+	}
+}
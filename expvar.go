@@ -0,0 +1,60 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"expvar"
+	"runtime"
+)
+
+// PublishVars exposes runtime and application counters via expvar, in
+// addition to the cmdline and memstats expvar publishes itself.  It may
+// only be called once per process.
+func PublishVars(m *Metrics) {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("gc", expvar.Func(func() interface{} {
+		stats := runtime.MemStats{}
+		runtime.ReadMemStats(&stats)
+		return map[string]interface{}{
+			"num_gc":         stats.NumGC,
+			"pause_total_ns": stats.PauseTotalNs,
+			"heap_alloc":     stats.HeapAlloc,
+			"heap_objects":   stats.HeapObjects,
+		}
+	}))
+	expvar.Publish("open_connections", expvar.Func(func() interface{} {
+		return m.OpenConns()
+	}))
+	expvar.Publish("in_flight", expvar.Func(func() interface{} {
+		return m.InFlight()
+	}))
+	expvar.Publish("cache", expvar.Func(func() interface{} {
+		snapshot, ok := m.CacheSnapshot()
+		if !ok {
+			return nil
+		}
+		return snapshot
+	}))
+}
@@ -0,0 +1,30 @@
+package s3site
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"testing"
+)
+
+func TestPublishVars(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.AddCache(NewCache(10))
+	metrics.ConnState(nil, http.StateNew)
+	PublishVars(metrics)
+
+	if v := expvar.Get("open_connections").String(); v != "1" {
+		t.Errorf("expected 1 open connection, got %s", v)
+	}
+	if v := expvar.Get("in_flight").String(); v != "0" {
+		t.Errorf("expected 0 in flight, got %s", v)
+	}
+
+	cache := CacheSnapshot{}
+	if err := json.Unmarshal([]byte(expvar.Get("cache").String()), &cache); err != nil || cache.MaxBytes != 10 {
+		t.Errorf("expected cache snapshot, got %s", expvar.Get("cache").String())
+	}
+	if expvar.Get("goroutines") == nil || expvar.Get("gc") == nil {
+		t.Errorf("expected runtime vars to be published")
+	}
+}
@@ -0,0 +1,92 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// FallbackHeader marks responses proxied from the fallback origin
+const FallbackHeader = "X-Fallback-Origin"
+
+type basicAuthKey struct{}
+
+// withBasicAuth marks req as authorized by s3site's own basic auth, whose
+// credentials are the site's rather than an origin's
+func withBasicAuth(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), basicAuthKey{}, true))
+}
+
+// basicAuthConsumed reports whether req's Authorization header held the
+// credentials of s3site's own basic auth
+func basicAuthConsumed(req *http.Request) bool {
+	consumed, _ := req.Context().Value(basicAuthKey{}).(bool)
+	return consumed
+}
+
+// dropBasicAuth removes the Authorization header s3site's basic auth
+// consumed, so the site's credentials aren't passed on to an origin
+func dropBasicAuth(r *httputil.ProxyRequest) {
+	if basicAuthConsumed(r.In) {
+		r.Out.Header.Del("Authorization")
+	}
+}
+
+// NewFallback returns a reverse proxy to origin e.g. https://old.example.com,
+// serving the paths missing from the bucket while a site moves into it.
+// It returns nil when origin is empty.
+func NewFallback(origin string, timeout time.Duration) (*httputil.ReverseProxy, error) {
+	if origin == "" {
+		return nil, nil
+	}
+	target, err := url.Parse(origin)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid fallback origin, %s", origin)
+	}
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			// the origin sees its own host name, which virtual hosts need
+			r.SetURL(target)
+			r.SetXForwarded()
+			dropBasicAuth(r)
+		},
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: timeout,
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Set(FallbackHeader, target.Host)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.Warn("unable to reach fallback origin", Fields{"request_id": requestID(req), "origin": origin, "path": req.URL.Path, "error": err})
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}, nil
+}
@@ -0,0 +1,78 @@
+package s3site
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewFallback(t *testing.T) {
+	if proxy, err := NewFallback("", time.Second); proxy != nil || err != nil {
+		t.Error("expected no fallback without an origin")
+	}
+	for _, origin := range []string{"old.example.com", "ftp://old.example.com", "https://"} {
+		if _, err := NewFallback(origin, time.Second); err == nil {
+			t.Errorf("%s: expected an error", origin)
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s %s %s", req.Host, req.URL.RequestURI(), req.Header.Get("X-Forwarded-Host"))
+	}))
+	defer legacy.Close()
+
+	proxy, err := NewFallback(legacy.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "http://www.example.com/old/page.php?id=7", nil))
+
+	host := strings.TrimPrefix(legacy.URL, "http://")
+	if expected := host + " /old/page.php?id=7 www.example.com"; w.Body.String() != expected {
+		t.Errorf("expected %q; got %q", expected, w.Body.String())
+	}
+	if w.Header().Get(FallbackHeader) != host {
+		t.Errorf("expected the response marked as proxied; got %q", w.Header().Get(FallbackHeader))
+	}
+
+	legacy.Close()
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "http://www.example.com/gone", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 when the origin is down; got %d", w.Code)
+	}
+}
+
+func TestFallbackDropsBasicAuth(t *testing.T) {
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, req.Header.Get("Authorization"))
+	}))
+	defer legacy.Close()
+
+	proxy, err := NewFallback(legacy.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/old", nil)
+	req.SetBasicAuth("user", "secret")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, withBasicAuth(req))
+	if w.Body.String() != "" {
+		t.Errorf("expected the site's credentials to be withheld, got %q", w.Body.String())
+	}
+
+	req.Header.Set("Authorization", "Bearer origin")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Body.String() != "Bearer origin" {
+		t.Errorf("expected other credentials to be passed on, got %q", w.Body.String())
+	}
+}
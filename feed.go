@@ -0,0 +1,402 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+const (
+	// maxFrontMatter bounds what's read of each post for its title and date
+	maxFrontMatter = 64 << 10
+
+	// RSSFile and AtomFile are served beneath each feed's path e.g.
+	// /blog/rss.xml and /blog/atom.xml
+	RSSFile  = "rss.xml"
+	AtomFile = "atom.xml"
+)
+
+var (
+	htmlTitle = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+	// dateLayouts are the layouts front matter and metadata dates may use
+	dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02", time.RFC1123, time.RFC1123Z}
+)
+
+// parseFrontMatter splits the key: value lines between --- lines at the
+// start of src from the rest of it
+func parseFrontMatter(src []byte) (map[string]string, []byte) {
+	src = bytes.TrimPrefix(src, []byte("\xef\xbb\xbf"))
+	if !bytes.HasPrefix(src, []byte("---\n")) && !bytes.HasPrefix(src, []byte("---\r\n")) {
+		return nil, src
+	}
+	rest := src[bytes.IndexByte(src, '\n')+1:]
+	fields := map[string]string{}
+	for len(rest) > 0 {
+		line := rest
+		next := []byte(nil)
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, next = rest[:i], rest[i+1:]
+		}
+		rest = next
+		line = bytes.TrimRight(line, "\r")
+		if string(line) == "---" {
+			return fields, rest
+		}
+		if kv := strings.SplitN(string(line), ":", 2); len(kv) == 2 {
+			value := strings.TrimSpace(kv[1])
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+			fields[strings.ToLower(strings.TrimSpace(kv[0]))] = value
+		}
+	}
+	// no closing ---, so it wasn't front matter after all
+	return nil, src
+}
+
+// parseDate parses value in any of dateLayouts
+func parseDate(value string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// FeedItem is a post listed in a feed
+type FeedItem struct {
+	Title     string
+	Path      string
+	Summary   string
+	Published time.Time
+	Updated   time.Time
+}
+
+// Feed serves RSS and Atom feeds of the markdown and html posts beneath a
+// path, titled and dated by their front matter, their x-amz-meta-title and
+// x-amz-meta-date metadata, or failing those their title or first heading
+// and modification time
+type Feed struct {
+	// Path holds the posts e.g. /blog/
+	Path  string
+	Title string
+	// BaseURL e.g. https://www.example.com precedes each path; the
+	// request's scheme and host are used when empty
+	BaseURL   string
+	Limit     int
+	IndexFile string
+
+	mu    sync.RWMutex
+	items []FeedItem
+	// known holds each post's item by key and etag, so unchanged posts
+	// aren't read again
+	known map[string]FeedItem
+}
+
+// ParseFeeds parses feeds of the form /path/[=title]
+func ParseFeeds(values []string) ([]*Feed, error) {
+	feeds := []*Feed{}
+	for _, value := range values {
+		urlPath, title := value, ""
+		if i := strings.Index(value, "="); i >= 0 {
+			urlPath, title = value[:i], value[i+1:]
+		}
+		if !strings.HasPrefix(urlPath, "/") || !strings.HasSuffix(urlPath, "/") {
+			return nil, fmt.Errorf("invalid feed path, %s", urlPath)
+		}
+		if title == "" {
+			title = strings.Trim(urlPath, "/")
+		}
+		feeds = append(feeds, &Feed{Path: urlPath, Title: title})
+	}
+	return feeds, nil
+}
+
+// isPost returns true for the keys of posts
+func isPost(key string) bool {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".md", ".markdown", ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+// Refresh reads the posts under prefix, the key prefix of f.Path,
+// replacing the items served
+func (f *Feed) Refresh(bucket *s3.Bucket, prefix string) error {
+	f.mu.RLock()
+	known := f.known
+	f.mu.RUnlock()
+
+	items := []FeedItem{}
+	seen := map[string]FeedItem{}
+	marker := ""
+	for {
+		list, err := bucket.List(prefix, "", marker, 1000)
+		if err != nil {
+			return err
+		}
+		for _, key := range list.Contents {
+			name := strings.TrimPrefix(key.Key, prefix)
+			if !isPost(name) || name == f.IndexFile {
+				continue
+			}
+			urlPath := f.Path + name
+			if path.Base(name) == f.IndexFile {
+				urlPath = strings.TrimSuffix(urlPath, f.IndexFile)
+			}
+
+			cacheKey := key.Key + "\x00" + key.ETag
+			item, ok := known[cacheKey]
+			if !ok {
+				modified, _ := time.Parse(time.RFC3339, key.LastModified)
+				item, ok, err = readPost(bucket, key.Key, urlPath, modified)
+				if err != nil {
+					logger.Warn("unable to read post", Fields{"bucket": bucket.Name, "key": key.Key, "error": err})
+					continue
+				}
+				if !ok {
+					// drafts are remembered, but not listed
+					item.Path = ""
+				}
+			}
+			seen[cacheKey] = item
+			if item.Path != "" {
+				items = append(items, item)
+			}
+		}
+		if !list.IsTruncated || len(list.Contents) == 0 {
+			break
+		}
+		marker = list.NextMarker
+		if marker == "" {
+			marker = list.Contents[len(list.Contents)-1].Key
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Published.After(items[j].Published) })
+	if f.Limit > 0 && len(items) > f.Limit {
+		items = items[:f.Limit]
+	}
+
+	f.mu.Lock()
+	f.items, f.known = items, seen
+	f.mu.Unlock()
+	return nil
+}
+
+// readPost reads the title, dates, and summary of the post at key; it
+// returns false for drafts
+func readPost(bucket *s3.Bucket, key, urlPath string, modified time.Time) (FeedItem, bool, error) {
+	item := FeedItem{Path: urlPath, Title: path.Base(strings.TrimSuffix(urlPath, "/")), Published: modified, Updated: modified}
+
+	resp, err := bucket.GetResponse(key)
+	if err != nil {
+		return item, false, err
+	}
+	defer resp.Body.Close()
+	src, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFrontMatter))
+	if err != nil {
+		return item, false, err
+	}
+
+	fields, rest := parseFrontMatter(src)
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	if fields["draft"] == "true" {
+		return item, false, nil
+	}
+	for _, name := range []string{"title", "date"} {
+		if _, ok := fields[name]; !ok {
+			if value := resp.Header.Get("X-Amz-Meta-" + name); value != "" {
+				fields[name] = value
+			}
+		}
+	}
+
+	switch {
+	case fields["title"] != "":
+		item.Title = fields["title"]
+	case strings.HasSuffix(key, ".md") || strings.HasSuffix(key, ".markdown"):
+		if m := firstHeading.FindSubmatch(rest); m != nil {
+			item.Title = string(m[1])
+		}
+	default:
+		if m := htmlTitle.FindSubmatch(rest); m != nil {
+			item.Title = html.UnescapeString(strings.TrimSpace(string(m[1])))
+		}
+	}
+	if date, ok := parseDate(fields["date"]); ok {
+		item.Published = date
+	}
+	if updated, ok := parseDate(fields["updated"]); ok {
+		item.Updated = updated
+	}
+	item.Summary = fields["summary"]
+	if item.Summary == "" {
+		item.Summary = fields["description"]
+	}
+	return item, true, nil
+}
+
+// Run refreshes the feed of the site live serves every interval, and
+// whenever its options change e.g. when a release or slot is deployed
+func (f *Feed) Run(live *LiveOptions, bucket *s3.Bucket, creds *Credentials, interval time.Duration) {
+	refresh := func(opts *Options) {
+		prefix := opts.keyPrefix(f.Path)
+		if err := f.Refresh(creds.Sign(bucket), prefix); err != nil {
+			logger.Warn("unable to refresh feed", Fields{"bucket": bucket.Name, "prefix": prefix, "error": err})
+		}
+	}
+	live.RunEvery(interval, refresh)
+}
+
+// Serves returns true for the paths of the feed
+func (f *Feed) Serves(urlPath string) bool {
+	return urlPath == f.Path+RSSFile || urlPath == f.Path+AtomFile
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description,omitempty"`
+}
+
+type rss struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+		Items         []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string   `xml:"title"`
+	Link      atomLink `xml:"link"`
+	ID        string   `xml:"id"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Summary   string   `xml:"summary,omitempty"`
+}
+
+type atom struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// ServeHTTP writes the feed as rss or atom, per the file requested
+func (f *Feed) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.RLock()
+	items := f.items
+	f.mu.RUnlock()
+
+	base := siteURL(f.BaseURL, req)
+	updated := time.Time{}
+	for _, item := range items {
+		if item.Updated.After(updated) {
+			updated = item.Updated
+		}
+	}
+
+	var v interface{}
+	contentType := "application/rss+xml; charset=utf-8"
+	if path.Base(req.URL.Path) == AtomFile {
+		feed := atom{
+			Title:   f.Title,
+			ID:      base + f.Path,
+			Links:   []atomLink{{Href: base + f.Path}, {Href: base + req.URL.Path, Rel: "self"}},
+			Updated: updated.UTC().Format(time.RFC3339),
+			Entries: []atomEntry{},
+		}
+		for _, item := range items {
+			feed.Entries = append(feed.Entries, atomEntry{
+				Title:     item.Title,
+				Link:      atomLink{Href: base + item.Path},
+				ID:        base + item.Path,
+				Published: item.Published.UTC().Format(time.RFC3339),
+				Updated:   item.Updated.UTC().Format(time.RFC3339),
+				Summary:   item.Summary,
+			})
+		}
+		v, contentType = feed, "application/atom+xml; charset=utf-8"
+	} else {
+		feed := rss{Version: "2.0"}
+		feed.Channel.Title = f.Title
+		feed.Channel.Link = base + f.Path
+		feed.Channel.Description = f.Title
+		if !updated.IsZero() {
+			feed.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+		}
+		for _, item := range items {
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       item.Title,
+				Link:        base + item.Path,
+				GUID:        base + item.Path,
+				PubDate:     item.Published.UTC().Format(time.RFC1123Z),
+				Description: item.Summary,
+			})
+		}
+		v = feed
+	}
+
+	data, err := xml.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
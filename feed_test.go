@@ -0,0 +1,124 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	fields, rest := parseFrontMatter([]byte("---\ntitle: \"Hello: World\"\ndate: 2016-01-02\n---\n# Body\n"))
+	if fields["title"] != "Hello: World" || fields["date"] != "2016-01-02" {
+		t.Errorf("expected title and date; got %v", fields)
+	}
+	if string(rest) != "# Body\n" {
+		t.Errorf("expected the body after the front matter; got %q", rest)
+	}
+
+	for _, src := range []string{"# No front matter\n", "---\ntitle: unclosed\n"} {
+		if fields, rest := parseFrontMatter([]byte(src)); fields != nil || string(rest) != src {
+			t.Errorf("%q: expected no front matter; got %v", src, fields)
+		}
+	}
+}
+
+func TestParseFeeds(t *testing.T) {
+	feeds, err := ParseFeeds([]string{"/blog/=My Blog", "/news/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feeds[0].Path != "/blog/" || feeds[0].Title != "My Blog" || feeds[1].Title != "news" {
+		t.Errorf("unexpected feeds, %+v %+v", feeds[0], feeds[1])
+	}
+	if _, err := ParseFeeds([]string{"blog"}); err == nil {
+		t.Error("expected a path without slashes to fail")
+	}
+}
+
+func TestFeed(t *testing.T) {
+	mem := &memS3{objects: map[string][]byte{
+		"site/blog/index.html":        []byte("<title>Blog</title>"),
+		"site/blog/first.md":          []byte("---\ntitle: First Post\ndate: 2016-01-01\nsummary: the first\n---\n# Ignored\n"),
+		"site/blog/second/index.html": []byte("<html><head><title>Second &amp; Last</title></head></html>"),
+		"site/blog/third.md":          []byte("# Third Post\n"),
+		"site/blog/draft.md":          []byte("---\ntitle: Draft\ndraft: true\n---\n"),
+		"site/blog/style.css":         []byte("a{}"),
+		"site/other.md":               []byte("# Other\n"),
+	}}
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, ".md") || strings.HasSuffix(req.URL.Path, ".html") {
+			atomic.AddInt32(&gets, 1)
+		}
+		if strings.HasSuffix(req.URL.Path, "/second/index.html") {
+			w.Header().Set("X-Amz-Meta-Date", "2016-02-01T10:00:00Z")
+		}
+		if strings.HasSuffix(req.URL.Path, "/third.md") {
+			w.Header().Set("X-Amz-Meta-Date", "2015-12-01")
+		}
+		mem.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+	bucket := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{Name: "us-east-1", S3Endpoint: server.URL}).Bucket("bucket")
+
+	feed := &Feed{Path: "/blog/", Title: "Blog", IndexFile: "index.html", Limit: 10}
+	if err := feed.Refresh(bucket, "site/blog/"); err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, item := range feed.items {
+		titles = append(titles, item.Title+" "+item.Path)
+	}
+	expected := "Second & Last /blog/second/,First Post /blog/first.md,Third Post /blog/third.md"
+	if strings.Join(titles, ",") != expected {
+		t.Errorf("expected %s; got %s", expected, strings.Join(titles, ","))
+	}
+	if feed.items[1].Summary != "the first" || !feed.items[1].Published.Equal(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected front matter summary and date; got %+v", feed.items[1])
+	}
+
+	before := atomic.LoadInt32(&gets)
+	if err := feed.Refresh(bucket, "site/blog/"); err != nil {
+		t.Fatal(err)
+	}
+	if after := atomic.LoadInt32(&gets); after != before {
+		t.Errorf("expected unchanged posts not to be read again; got %d more reads", after-before)
+	}
+
+	feed.Limit = 2
+	mem.objects["site/blog/first.md"] = []byte("---\ntitle: First, Revised\ndate: 2016-01-01\n---\n")
+	if err := feed.Refresh(bucket, "site/blog/"); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.items) != 2 || feed.items[1].Title != "First, Revised" {
+		t.Errorf("expected the revised post, limited to 2; got %+v", feed.items)
+	}
+
+	w := httptest.NewRecorder()
+	feed.ServeHTTP(w, httptest.NewRequest("GET", "http://www.example.com/blog/rss.xml", nil))
+	if !strings.Contains(w.Body.String(), `<rss version="2.0"><channel><title>Blog</title><link>http://www.example.com/blog/</link>`) ||
+		!strings.Contains(w.Body.String(), "<pubDate>Mon, 01 Feb 2016 10:00:00 +0000</pubDate>") {
+		t.Errorf("unexpected rss, %s", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/rss+xml") {
+		t.Errorf("expected rss; got %s", ct)
+	}
+
+	feed.BaseURL = "https://blog.example.com/"
+	w = httptest.NewRecorder()
+	feed.ServeHTTP(w, httptest.NewRequest("GET", "/blog/atom.xml", nil))
+	if !strings.Contains(w.Body.String(), `<feed xmlns="http://www.w3.org/2005/Atom"><title>Blog</title>`) ||
+		!strings.Contains(w.Body.String(), `<link href="https://blog.example.com/blog/atom.xml" rel="self"></link>`) ||
+		!strings.Contains(w.Body.String(), "<title>Second &amp; Last</title>") {
+		t.Errorf("unexpected atom, %s", w.Body.String())
+	}
+	if !feed.Serves("/blog/atom.xml") || feed.Serves("/blog/feed.xml") {
+		t.Error("expected only rss.xml and atom.xml served")
+	}
+}
@@ -0,0 +1,179 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ImmutableCacheControl is set on fingerprinted assets; their content never
+// changes under a given name, so browsers and cdns may keep them for a year
+const ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+// fingerprinted lists the extensions of assets renamed with a content hash.
+// html is referenced by url so keeps its name, as do files such as
+// favicon.ico and robots.txt that browsers and crawlers request by name.
+var fingerprinted = map[string]bool{
+	".css":   true,
+	".js":    true,
+	".png":   true,
+	".jpg":   true,
+	".jpeg":  true,
+	".gif":   true,
+	".svg":   true,
+	".webp":  true,
+	".woff":  true,
+	".woff2": true,
+	".ttf":   true,
+	".eot":   true,
+}
+
+var (
+	htmlRef = regexp.MustCompile(`(?i)\b(?:src|href)\s*=\s*["']?([^"'\s>]+)`)
+	cssRef  = regexp.MustCompile(`(?i)url\(\s*["']?([^"')\s]+)`)
+)
+
+// Fingerprint renames assets with a hash of their content e.g.
+// css/site.css becomes css/site.1a2b3c4d.css, and rewrites references to
+// them in html and css files.  Renamed assets are marked immutable.
+// Stylesheets are rewritten before being hashed so that a change to an
+// image they reference also changes their name.
+func Fingerprint(files []localFile, prefix string) ([]localFile, error) {
+	renames := map[string]string{}
+
+	// assets referencing nothing first, then stylesheets, then html
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	stage := func(file localFile) int {
+		switch ext := strings.ToLower(path.Ext(file.Rel)); {
+		case ext == ".css":
+			return 1
+		case fingerprinted[ext]:
+			return 0
+		case ext == ".html" || ext == ".htm":
+			return 2
+		}
+		return 3
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return stage(files[order[i]]) < stage(files[order[j]])
+	})
+
+	for _, i := range order {
+		file := &files[i]
+		if stage(*file) == 3 {
+			continue
+		}
+
+		data, err := file.content()
+		if err != nil {
+			return nil, err
+		}
+		dir := path.Dir(file.Rel)
+		switch ext := strings.ToLower(path.Ext(file.Rel)); {
+		case ext == ".css":
+			data = rewriteRefs(data, cssRef, dir, renames)
+		case ext == ".html" || ext == ".htm":
+			data = rewriteRefs(data, htmlRef, dir, renames)
+			data = rewriteRefs(data, cssRef, dir, renames) // inline styles
+		}
+
+		sum := md5.Sum(data)
+		file.Data = data
+		file.Size = int64(len(data))
+		file.MD5 = hex.EncodeToString(sum[:])
+
+		if ext := path.Ext(file.Rel); fingerprinted[strings.ToLower(ext)] {
+			rel := strings.TrimSuffix(file.Rel, ext) + "." + file.MD5[:8] + ext
+			renames[file.Rel] = rel
+			file.Rel = rel
+			file.Key = prefix + rel
+			file.CacheControl = ImmutableCacheControl
+		}
+	}
+
+	return files, nil
+}
+
+// rewriteRefs replaces references, captured by the first group of re, to
+// renamed files; dir is the directory of the file containing them
+func rewriteRefs(data []byte, re *regexp.Regexp, dir string, renames map[string]string) []byte {
+	matches := re.FindAllSubmatchIndex(data, -1)
+	if len(matches) == 0 {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	last := 0
+	for _, m := range matches {
+		start, end := m[2], m[3]
+		ref := string(data[start:end])
+		out = append(out, data[last:start]...)
+		out = append(out, renameRef(ref, dir, renames)...)
+		last = end
+	}
+	return append(out, data[last:]...)
+}
+
+// renameRef returns ref pointing at the renamed file, keeping it relative or
+// absolute as written along with any query string or fragment
+func renameRef(ref, dir string, renames map[string]string) string {
+	if strings.Contains(ref, ":") || strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "#") {
+		return ref
+	}
+
+	target, suffix := ref, ""
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		target, suffix = ref[:i], ref[i:]
+	}
+
+	var rel string
+	if strings.HasPrefix(target, "/") {
+		rel = strings.TrimPrefix(path.Clean(target), "/")
+	} else {
+		rel = path.Join(dir, target)
+	}
+
+	renamed, ok := renames[rel]
+	if !ok {
+		return ref
+	}
+	return target[:strings.LastIndex(target, "/")+1] + path.Base(renamed) + suffix
+}
+
+// content returns the file's data, reading it from disk if it hasn't been
+// rewritten
+func (f *localFile) content() ([]byte, error) {
+	if f.Data != nil {
+		return f.Data, nil
+	}
+	return ioutil.ReadFile(f.Path)
+}
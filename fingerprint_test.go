@@ -0,0 +1,65 @@
+package s3site
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	files := []localFile{
+		{Rel: "index.html", Data: []byte(`<link href="/css/site.css"><img src="img/logo.png?v=1"><a href="about.html">about</a><script src="https://cdn.example.com/x.js"></script>`)},
+		{Rel: "about.html", Data: []byte(`<img src='../img/missing.png'><div style="background: url(img/logo.png)"></div>`)},
+		{Rel: "css/site.css", Data: []byte(`body { background: url("../img/logo.png") }`)},
+		{Rel: "img/logo.png", Data: []byte("png")},
+		{Rel: "robots.txt", Data: []byte("User-agent: *")},
+	}
+
+	files, err := Fingerprint(files, "site/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := map[string]localFile{}
+	for _, file := range files {
+		byName[strings.SplitN(file.Rel, ".", 2)[0]] = file
+	}
+
+	logo := byName["img/logo"]
+	if logo.Rel != "img/logo."+md5Hex("png")[:8]+".png" || logo.Key != "site/"+logo.Rel {
+		t.Errorf("unexpected logo name, %s %s", logo.Rel, logo.Key)
+	}
+	if logo.CacheControl != ImmutableCacheControl {
+		t.Errorf("expected fingerprinted assets to be immutable, got %s", logo.CacheControl)
+	}
+
+	css := byName["css/site"]
+	logoName := strings.TrimPrefix(logo.Rel, "img/")
+	if expected := `body { background: url("../img/` + logoName + `") }`; string(css.Data) != expected {
+		t.Errorf("unexpected css, %s", css.Data)
+	}
+	if css.Rel != "css/site."+md5Hex(string(css.Data))[:8]+".css" {
+		t.Errorf("expected css to be hashed after rewriting, got %s", css.Rel)
+	}
+
+	index := string(byName["index"].Data)
+	for _, expected := range []string{`href="/` + css.Rel + `"`, `src="img/` + logoName + `?v=1"`, `href="about.html"`, `https://cdn.example.com/x.js`} {
+		if !strings.Contains(index, expected) {
+			t.Errorf("expected %s in %s", expected, index)
+		}
+	}
+	if byName["index"].Rel != "index.html" || byName["index"].CacheControl != "" {
+		t.Error("expected html to keep its name and default caching")
+	}
+
+	about := string(byName["about"].Data)
+	if !strings.Contains(about, "../img/missing.png") {
+		t.Errorf("expected unresolved references to be left alone, got %s", about)
+	}
+	if !strings.Contains(about, "url(img/"+logoName+")") {
+		t.Errorf("expected inline styles to be rewritten, got %s", about)
+	}
+
+	if robots := byName["robots"]; robots.Rel != "robots.txt" {
+		t.Errorf("expected robots.txt to keep its name, got %s", robots.Rel)
+	}
+}
@@ -0,0 +1,400 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+const (
+	// maxFormBytes bounds the size of a submission
+	maxFormBytes = 64 << 10
+
+	// maxFieldBytes bounds the size of each field
+	maxFieldBytes = 8 << 10
+)
+
+// Form accepts submissions POSTed to Path, delivering them by email through
+// ses, or as messages through sns or sqs
+type Form struct {
+	Path string
+	// Kind is ses, sns, or sqs
+	Kind string
+	// Target is the email address, topic arn, or queue url delivered to
+	Target string
+	// From is the address ses sends from, Target by default
+	From     string
+	Subject  string
+	Required []string
+	// Emails holds the fields that must be email addresses; the first
+	// becomes the Reply-To of emails
+	Emails   []string
+	Redirect string
+
+	// Honeypot names a field hidden from people; submissions filling it in
+	// are discarded
+	Honeypot string
+	// MinTime rejects submissions made sooner after loading a page
+	MinTime time.Duration
+	// Rate limits each client address to Rate submissions every Period
+	Rate   int
+	Period time.Duration
+	// Captcha is recaptcha or hcaptcha, verified with CaptchaSecret
+	Captcha       string
+	CaptchaSecret string
+}
+
+// ParseForm parses forms of the form path=kind:target[;option=value...]
+// e.g. /contact=ses:team@example.com;required=name,email;email=email;redirect=/thanks/;honeypot=website;min-time=3s;rate=5/1h;captcha=hcaptcha:{env:HCAPTCHA_SECRET}
+func ParseForm(value string) (*Form, error) {
+	options := strings.Split(value, ";")
+	parts := strings.SplitN(options[0], "=", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+		return nil, fmt.Errorf("invalid form, %s", value)
+	}
+	destination := strings.SplitN(parts[1], ":", 2)
+	if len(destination) != 2 || destination[1] == "" {
+		return nil, fmt.Errorf("invalid form destination, %s; use ses:address, sns:arn, or sqs:url", parts[1])
+	}
+
+	f := &Form{Path: parts[0], Kind: destination[0], Target: destination[1], Subject: "Form submission: " + parts[0]}
+	switch f.Kind {
+	case "ses":
+		if _, err := mail.ParseAddress(f.Target); err != nil {
+			return nil, fmt.Errorf("invalid form email address, %s", f.Target)
+		}
+	case "sns":
+		if len(strings.Split(f.Target, ":")) < 6 || !strings.HasPrefix(f.Target, "arn:") {
+			return nil, fmt.Errorf("invalid form topic arn, %s", f.Target)
+		}
+	case "sqs":
+		if _, err := sqsRegion(f.Target); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown form destination, %s; use ses, sns, or sqs", f.Kind)
+	}
+
+	for _, option := range options[1:] {
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid form option, %s", option)
+		}
+		switch kv[0] {
+		case "required":
+			f.Required = strings.Split(kv[1], ",")
+		case "email":
+			f.Emails = strings.Split(kv[1], ",")
+		case "redirect":
+			f.Redirect = kv[1]
+		case "subject":
+			f.Subject = kv[1]
+		case "from":
+			f.From = kv[1]
+		case "honeypot":
+			f.Honeypot = kv[1]
+		case "min-time":
+			d, err := time.ParseDuration(kv[1])
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid form min-time, %s", kv[1])
+			}
+			f.MinTime = d
+		case "rate":
+			count, period, err := parseRate(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			f.Rate, f.Period = count, period
+		case "captcha":
+			captcha := strings.SplitN(kv[1], ":", 2)
+			if _, ok := captchaVerifiers[captcha[0]]; !ok || len(captcha) != 2 || captcha[1] == "" {
+				return nil, fmt.Errorf("invalid form captcha, %s; use recaptcha:secret or hcaptcha:secret", kv[1])
+			}
+			f.Captcha, f.CaptchaSecret = captcha[0], expandEnv(captcha[1])
+		default:
+			return nil, fmt.Errorf("unknown form option, %s", kv[0])
+		}
+	}
+	return f, nil
+}
+
+// sqsRegion returns the region of a queue url e.g.
+// https://sqs.us-east-1.amazonaws.com/123456789012/name
+func sqsRegion(queueURL string) (string, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return "", err
+	}
+	segments := strings.Split(u.Host, ".")
+	if len(segments) < 2 || segments[0] != "sqs" {
+		return "", fmt.Errorf("unable to determine region from sqs queue url, %s", queueURL)
+	}
+	return segments[1], nil
+}
+
+// Validate returns the first problem with fields, or nil
+func (f *Form) Validate(fields url.Values) error {
+	for _, name := range f.Required {
+		if strings.TrimSpace(fields.Get(name)) == "" {
+			return fmt.Errorf("%s is required", name)
+		}
+	}
+	for _, name := range f.Emails {
+		if value := fields.Get(name); value != "" {
+			if _, err := mail.ParseAddress(value); err != nil {
+				return fmt.Errorf("%s must be an email address", name)
+			}
+		}
+	}
+	for name, values := range fields {
+		if name == f.Honeypot || name == captchaFields[f.Captcha] {
+			continue
+		}
+		for _, value := range values {
+			if len(value) > maxFieldBytes {
+				return fmt.Errorf("%s is too long", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Forms delivers the submissions of each form
+type Forms struct {
+	List []*Form
+	// Region is the ses region
+	Region string
+	Auth   func() aws.Auth
+	Client *http.Client
+	// Endpoint replaces the aws endpoints e.g. for tests
+	Endpoint string
+	// Secret signs the cookies that time submissions
+	Secret []byte
+	// Verifiers replaces the verification url of captcha services e.g. for
+	// tests
+	Verifiers map[string]string
+
+	mu      sync.Mutex
+	windows map[string]*formWindow
+}
+
+// NewForms returns the forms of values, each as ParseForm reads them.  It
+// returns nil when there are none.  Timing cookies are signed with secret,
+// or a random key when empty, which expires them whenever s3site restarts.
+func NewForms(values []string, region string, auth func() aws.Auth, secret string) (*Forms, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	forms := &Forms{Region: region, Auth: auth, Client: &http.Client{Timeout: 10 * time.Second}, Secret: []byte(secret)}
+	if secret == "" {
+		forms.Secret = make([]byte, 32)
+		if _, err := rand.Read(forms.Secret); err != nil {
+			return nil, err
+		}
+	}
+	for _, value := range values {
+		form, err := ParseForm(value)
+		if err != nil {
+			return nil, err
+		}
+		forms.List = append(forms.List, form)
+	}
+	return forms, nil
+}
+
+// Match returns the form req submits, or nil
+func (f *Forms) Match(req *http.Request) *Form {
+	if f == nil || req.Method != "POST" {
+		return nil
+	}
+	for _, form := range f.List {
+		if req.URL.Path == form.Path {
+			return form
+		}
+	}
+	return nil
+}
+
+// formText returns fields as name: value lines, sorted by name
+func formText(fields url.Values) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		for _, value := range fields[name] {
+			fmt.Fprintf(&b, "%s: %s\n", name, value)
+		}
+	}
+	return b.String()
+}
+
+// Deliver sends fields, submitted to form, to its destination
+func (f *Forms) Deliver(form *Form, fields url.Values) error {
+	params := url.Values{}
+	var region, service, endpoint string
+	switch form.Kind {
+	case "ses":
+		region, service = f.Region, "email"
+		endpoint = fmt.Sprintf("https://email.%s.amazonaws.com/", region)
+		from := form.From
+		if from == "" {
+			from = form.Target
+		}
+		params.Set("Action", "SendEmail")
+		params.Set("Version", "2010-12-01")
+		params.Set("Source", from)
+		params.Set("Destination.ToAddresses.member.1", form.Target)
+		params.Set("Message.Subject.Data", form.Subject)
+		params.Set("Message.Body.Text.Data", formText(fields))
+		for _, name := range form.Emails {
+			if value := fields.Get(name); value != "" {
+				params.Set("ReplyToAddresses.member.1", value)
+				break
+			}
+		}
+
+	case "sns":
+		region, service = strings.Split(form.Target, ":")[3], "sns"
+		endpoint = fmt.Sprintf("https://sns.%s.amazonaws.com/", region)
+		params.Set("Action", "Publish")
+		params.Set("Version", "2010-03-31")
+		params.Set("TopicArn", form.Target)
+		params.Set("Subject", form.Subject)
+		params.Set("Message", formText(fields))
+
+	case "sqs":
+		region, _ = sqsRegion(form.Target)
+		service, endpoint = "sqs", form.Target
+		body, err := json.Marshal(struct {
+			Form      string              `json:"form"`
+			Submitted time.Time           `json:"submitted"`
+			Fields    map[string][]string `json:"fields"`
+		}{Form: form.Path, Submitted: time.Now().UTC(), Fields: fields})
+		if err != nil {
+			return err
+		}
+		params.Set("Action", "SendMessage")
+		params.Set("Version", "2012-11-05")
+		params.Set("MessageBody", string(body))
+	}
+
+	if f.Endpoint != "" {
+		endpoint = f.Endpoint
+	}
+	payload := []byte(params.Encode())
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	SignV4(req, f.Auth(), region, service, payload, time.Now())
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// Serve validates and delivers the submission req makes to form, then
+// redirects to the form's thank-you page, or answers with json when the
+// request accepts it
+func (f *Forms) Serve(w http.ResponseWriter, req *http.Request, form *Form) {
+	respond := func(status int, message string) {
+		if wantsJSON(req) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(struct {
+				OK      bool   `json:"ok"`
+				Message string `json:"message,omitempty"`
+			}{OK: status == http.StatusOK, Message: message})
+			return
+		}
+		if status == http.StatusOK {
+			redirect := form.Redirect
+			if redirect == "" {
+				redirect = "/"
+			}
+			http.Redirect(w, req, redirect, http.StatusSeeOther)
+			return
+		}
+		http.Error(w, message, status)
+	}
+
+	if !f.allow(form, clientIP(req), time.Now()) {
+		logger.Info("form rate limited", Fields{"request_id": requestID(req), "form": form.Path, "client_ip": clientIP(req)})
+		respond(http.StatusTooManyRequests, "too many submissions; please try again later")
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxFormBytes)
+	if err := req.ParseMultipartForm(maxFormBytes); err != nil && err != http.ErrNotMultipart {
+		respond(http.StatusBadRequest, "unable to read form")
+		return
+	}
+	fields := req.PostForm
+	if err := f.Screen(w, req, form, fields); err == errSpam {
+		logger.Info("discarded form spam", Fields{"request_id": requestID(req), "form": form.Path, "client_ip": clientIP(req)})
+		respond(http.StatusOK, "")
+		return
+	} else if err != nil {
+		respond(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := form.Validate(fields); err != nil {
+		respond(http.StatusBadRequest, err.Error())
+		return
+	}
+	delete(fields, form.Honeypot)
+	delete(fields, captchaFields[form.Captcha])
+
+	if err := f.Deliver(form, fields); err != nil {
+		logger.Warn("unable to deliver form", Fields{"request_id": requestID(req), "form": form.Path, "destination": form.Kind, "error": err})
+		respond(http.StatusBadGateway, "unable to deliver form")
+		return
+	}
+	logger.Info("delivered form", Fields{"request_id": requestID(req), "form": form.Path, "destination": form.Kind})
+	respond(http.StatusOK, "")
+}
@@ -0,0 +1,141 @@
+package s3site
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+func TestParseForm(t *testing.T) {
+	form, err := ParseForm("/contact=ses:team@example.com;required=name,email;email=email;redirect=/thanks/;from=web@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Path != "/contact" || form.Kind != "ses" || form.Target != "team@example.com" || form.Redirect != "/thanks/" || form.From != "web@example.com" ||
+		len(form.Required) != 2 || form.Emails[0] != "email" || form.Subject != "Form submission: /contact" {
+		t.Errorf("unexpected form, %+v", form)
+	}
+	for _, value := range []string{
+		"/signup=sns:arn:aws:sns:us-west-2:123456789012:signups",
+		"/leads=sqs:https://sqs.eu-west-1.amazonaws.com/123456789012/leads;subject=Lead",
+	} {
+		if _, err := ParseForm(value); err != nil {
+			t.Errorf("%s: %v", value, err)
+		}
+	}
+
+	for _, value := range []string{
+		"contact=ses:team@example.com",
+		"/contact=team@example.com",
+		"/contact=ses:not an address",
+		"/contact=sns:topic",
+		"/contact=sqs:https://example.com/queue",
+		"/contact=smtp:team@example.com",
+		"/contact=ses:team@example.com;colour=red",
+		"/contact=ses:team@example.com;required",
+	} {
+		if _, err := ParseForm(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestFormValidate(t *testing.T) {
+	form, _ := ParseForm("/contact=ses:team@example.com;required=name,email;email=email")
+	testCases := []struct {
+		Fields url.Values
+		Err    string
+	}{
+		{url.Values{"name": {"Matt"}, "email": {"matt@example.com"}}, ""},
+		{url.Values{"name": {" "}, "email": {"matt@example.com"}}, "name is required"},
+		{url.Values{"name": {"Matt"}, "email": {"matt"}}, "email must be an email address"},
+		{url.Values{"name": {"Matt"}, "email": {"matt@example.com"}, "message": {strings.Repeat("x", maxFieldBytes+1)}}, "message is too long"},
+	}
+	for _, tc := range testCases {
+		err := form.Validate(tc.Fields)
+		if (err == nil && tc.Err != "") || (err != nil && err.Error() != tc.Err) {
+			t.Errorf("%v: expected %q; got %v", tc.Fields, tc.Err, err)
+		}
+	}
+}
+
+func TestFormsServe(t *testing.T) {
+	var received []url.Values
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		data, _ := ioutil.ReadAll(req.Body)
+		params, _ := url.ParseQuery(string(data))
+		received = append(received, params)
+		if params.Get("Action") == "Publish" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer endpoint.Close()
+
+	forms, err := NewForms([]string{
+		"/contact=ses:team@example.com;required=name,email;email=email;redirect=/thanks/",
+		"/signup=sns:arn:aws:sns:us-west-2:123456789012:signups",
+		"/leads=sqs:https://sqs.eu-west-1.amazonaws.com/123456789012/leads",
+	}, "us-east-1", func() aws.Auth { return aws.Auth{AccessKey: "key", SecretKey: "secret"} }, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forms.Endpoint = endpoint.URL
+
+	submit := func(target, body string, json bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if json {
+			req.Header.Set("Accept", "application/json")
+		}
+		w := httptest.NewRecorder()
+		forms.Serve(w, req, forms.Match(req))
+		return w
+	}
+
+	w := submit("/contact", "name=Matt&email=matt%40example.com&message=Hello", false)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/thanks/" {
+		t.Errorf("expected a redirect to /thanks/; got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if len(received) != 1 || received[0].Get("Action") != "SendEmail" || received[0].Get("Destination.ToAddresses.member.1") != "team@example.com" ||
+		received[0].Get("ReplyToAddresses.member.1") != "matt@example.com" || received[0].Get("Message.Body.Text.Data") != "email: matt@example.com\nmessage: Hello\nname: Matt\n" {
+		t.Errorf("unexpected ses request, %v", received)
+	}
+
+	w = submit("/contact", "name=Matt", true)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"message":"email is required"`) || len(received) != 1 {
+		t.Errorf("expected the invalid form rejected undelivered; got %d %s", w.Code, w.Body.String())
+	}
+
+	w = submit("/leads", "name=Matt", true)
+	var message struct {
+		Form   string
+		Fields map[string][]string
+	}
+	json.Unmarshal([]byte(received[len(received)-1].Get("MessageBody")), &message)
+	if w.Code != http.StatusOK || message.Form != "/leads" || message.Fields["name"][0] != "Matt" {
+		t.Errorf("expected the lead queued; got %d %+v", w.Code, message)
+	}
+
+	w = submit("/signup", "email=matt%40example.com", false)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 when delivery fails; got %d", w.Code)
+	}
+
+	if forms.Match(httptest.NewRequest("GET", "/contact", nil)) != nil || forms.Match(httptest.NewRequest("POST", "/other", nil)) != nil {
+		t.Error("expected only POSTs to forms matched")
+	}
+	var none *Forms
+	if none.Match(httptest.NewRequest("POST", "/contact", nil)) != nil {
+		t.Error("expected no forms matched without forms")
+	}
+}
@@ -0,0 +1,261 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+// maxFunctionBytes bounds the request bodies passed to functions, leaving
+// room within lambda's 6MB payload limit for base64 and the event around it
+const maxFunctionBytes = 4 << 20
+
+// functionName matches function names, partial arns, and arns, each
+// optionally followed by a version or alias
+var functionName = regexp.MustCompile(`^(arn:aws[a-z-]*:lambda:[a-z0-9-]+:\d{12}:function:|\d{12}:function:)?[A-Za-z0-9_-]{1,64}(:[A-Za-z0-9_$-]+)?$`)
+
+// Function invokes a lambda function with the requests made to Path
+type Function struct {
+	Path string
+	// Name is the function's name or arn, optionally with a version or alias
+	Name string
+	// Methods are the methods invoking the function; POST and PUT by default
+	Methods []string
+}
+
+// ParseFunction parses functions of the form path=name[;methods=POST,PUT]
+// e.g. /api/subscribe=subscribe:live
+func ParseFunction(value string) (*Function, error) {
+	options := strings.Split(value, ";")
+	parts := strings.SplitN(options[0], "=", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+		return nil, fmt.Errorf("invalid function, %s", value)
+	}
+	if !functionName.MatchString(parts[1]) {
+		return nil, fmt.Errorf("invalid function name, %s", parts[1])
+	}
+
+	f := &Function{Path: parts[0], Name: parts[1], Methods: []string{"POST", "PUT"}}
+	for _, option := range options[1:] {
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid function option, %s", option)
+		}
+		switch kv[0] {
+		case "methods":
+			f.Methods = strings.Split(strings.ToUpper(kv[1]), ",")
+			for _, method := range f.Methods {
+				if method != "POST" && method != "PUT" && method != "PATCH" && method != "DELETE" {
+					return nil, fmt.Errorf("unsupported function method, %s; use POST, PUT, PATCH, or DELETE", method)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown function option, %s", kv[0])
+		}
+	}
+	return f, nil
+}
+
+// region returns the region of the function's arn, or def for plain names
+func (f *Function) region(def string) string {
+	if strings.HasPrefix(f.Name, "arn:") {
+		return strings.Split(f.Name, ":")[3]
+	}
+	return def
+}
+
+// Functions invokes the function each request is routed to
+type Functions struct {
+	List []*Function
+	// Region is the region of functions given by name
+	Region string
+	Auth   func() aws.Auth
+	Client *http.Client
+	// Endpoint replaces the lambda endpoint e.g. for tests
+	Endpoint string
+}
+
+// NewFunctions returns the functions of values, each as ParseFunction reads
+// them, allowing each invocation timeout.  It returns nil when there are
+// none.
+func NewFunctions(values []string, region string, auth func() aws.Auth, timeout time.Duration) (*Functions, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	functions := &Functions{Region: region, Auth: auth, Client: &http.Client{Timeout: timeout}}
+	for _, value := range values {
+		function, err := ParseFunction(value)
+		if err != nil {
+			return nil, err
+		}
+		functions.List = append(functions.List, function)
+	}
+	return functions, nil
+}
+
+// Match returns the function req invokes, or nil
+func (f *Functions) Match(req *http.Request) *Function {
+	if f == nil {
+		return nil
+	}
+	for _, function := range f.List {
+		if req.URL.Path != function.Path {
+			continue
+		}
+		for _, method := range function.Methods {
+			if req.Method == method {
+				return function
+			}
+		}
+	}
+	return nil
+}
+
+// functionEvent describes req, with its body, as the version 2.0 event a
+// function url would send, so the same function serves either
+func functionEvent(req *http.Request, body []byte) *lambdaEvent {
+	event := &lambdaEvent{
+		Version:        "2.0",
+		RawPath:        req.URL.EscapedPath(),
+		RawQueryString: req.URL.RawQuery,
+		Headers:        map[string]string{},
+		Body:           string(body),
+	}
+	for k, values := range req.Header {
+		// the site's credentials are s3site's, not the function's
+		if k == "Authorization" && basicAuthConsumed(req) {
+			continue
+		}
+		if k == "Cookie" {
+			for _, value := range values {
+				event.Cookies = append(event.Cookies, strings.Split(value, "; ")...)
+			}
+			continue
+		}
+		event.Headers[strings.ToLower(k)] = strings.Join(values, ",")
+	}
+	event.Headers["host"] = req.Host
+	if !utf8.Valid(body) {
+		event.Body = base64.StdEncoding.EncodeToString(body)
+		event.IsBase64Encoded = true
+	}
+	event.RequestContext.DomainName = req.Host
+	event.RequestContext.HTTP.Method = req.Method
+	event.RequestContext.HTTP.SourceIP = clientIP(req)
+	return event
+}
+
+// Invoke calls function synchronously with payload, returning its result
+func (f *Functions) Invoke(function *Function, payload []byte) ([]byte, error) {
+	region := function.region(f.Region)
+	endpoint := fmt.Sprintf("https://lambda.%s.amazonaws.com", region)
+	if f.Endpoint != "" {
+		endpoint = f.Endpoint
+	}
+	req, err := http.NewRequest("POST", endpoint+"/2015-03-31/functions/"+function.Name+"/invocations", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	SignV4(req, f.Auth(), region, "lambda", payload, time.Now())
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if kind := resp.Header.Get("X-Amz-Function-Error"); kind != "" {
+		return nil, fmt.Errorf("function error, %s: %s", kind, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// Serve invokes function with req and writes its response.  Results that
+// aren't a response object, with a statusCode, are returned as json the
+// way function urls return them.
+func (f *Functions) Serve(w http.ResponseWriter, req *http.Request, function *Function) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxFunctionBytes))
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	payload, err := json.Marshal(functionEvent(req, body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	started := time.Now()
+	result, err := f.Invoke(function, payload)
+	if err != nil {
+		logger.Warn("unable to invoke function", Fields{"request_id": requestID(req), "function": function.Name, "error": err})
+		http.Error(w, "unable to invoke function", http.StatusBadGateway)
+		return
+	}
+	logger.Debug("invoked function", Fields{"request_id": requestID(req), "function": function.Name, "duration": time.Since(started).Seconds()})
+
+	resp := &lambdaResponse{}
+	if err := json.Unmarshal(result, resp); err != nil || resp.StatusCode == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(result)
+		return
+	}
+
+	data := []byte(resp.Body)
+	if resp.IsBase64Encoded {
+		if data, err = base64.StdEncoding.DecodeString(resp.Body); err != nil {
+			logger.Warn("unable to decode function response", Fields{"request_id": requestID(req), "function": function.Name, "error": err})
+			http.Error(w, "unable to invoke function", http.StatusBadGateway)
+			return
+		}
+	}
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	for k, values := range resp.MultiValueHeaders {
+		w.Header()[http.CanonicalHeaderKey(k)] = values
+	}
+	for _, cookie := range resp.Cookies {
+		w.Header().Add("Set-Cookie", cookie)
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(data)
+}
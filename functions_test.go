@@ -0,0 +1,136 @@
+package s3site
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+func TestParseFunction(t *testing.T) {
+	function, err := ParseFunction("/api/subscribe=subscribe:live;methods=post")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if function.Path != "/api/subscribe" || function.Name != "subscribe:live" || len(function.Methods) != 1 || function.Methods[0] != "POST" {
+		t.Errorf("unexpected function, %+v", function)
+	}
+	function, err = ParseFunction("/api/vote=arn:aws:lambda:eu-west-1:123456789012:function:vote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if function.region("us-east-1") != "eu-west-1" || len(function.Methods) != 2 {
+		t.Errorf("unexpected function, %+v", function)
+	}
+
+	for _, value := range []string{
+		"api=subscribe",
+		"/api/subscribe",
+		"/api/subscribe=../subscribe",
+		"/api/subscribe=subscribe;methods=GET",
+		"/api/subscribe=subscribe;timeout=3s",
+	} {
+		if _, err := ParseFunction(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestFunctionsServe(t *testing.T) {
+	var event lambdaEvent
+	var invoked string
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		invoked = req.URL.Path
+		data, _ := ioutil.ReadAll(req.Body)
+		event = lambdaEvent{}
+		json.Unmarshal(data, &event)
+
+		switch {
+		case strings.Contains(invoked, "/vote"):
+			w.Write([]byte(`{"votes":3}`))
+		case strings.Contains(invoked, "/broken"):
+			w.Header().Set("X-Amz-Function-Error", "Unhandled")
+			w.Write([]byte(`{"errorMessage":"boom"}`))
+		default:
+			w.Write([]byte(`{"statusCode":201,"headers":{"Content-Type":"text/plain"},"cookies":["a=1"],"body":"` +
+				base64.StdEncoding.EncodeToString([]byte("subscribed")) + `","isBase64Encoded":true}`))
+		}
+	}))
+	defer endpoint.Close()
+
+	functions, err := NewFunctions([]string{
+		"/api/subscribe=subscribe:live",
+		"/api/vote=vote;methods=PUT",
+		"/api/broken=broken",
+	}, "us-east-1", func() aws.Auth { return aws.Auth{AccessKey: "key", SecretKey: "secret"} }, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	functions.Endpoint = endpoint.URL
+
+	req := httptest.NewRequest("POST", "/api/subscribe?list=news", strings.NewReader(`{"email":"matt@example.com"}`))
+	req.Header.Set("Cookie", "session=1; theme=dark")
+	if functions.Match(httptest.NewRequest("GET", "/api/subscribe", nil)) != nil || functions.Match(httptest.NewRequest("POST", "/api/vote", nil)) != nil {
+		t.Error("expected only the function's methods to match")
+	}
+	w := httptest.NewRecorder()
+	functions.Serve(w, req, functions.Match(req))
+	if invoked != "/2015-03-31/functions/subscribe:live/invocations" {
+		t.Errorf("unexpected invocation, %s", invoked)
+	}
+	if event.Version != "2.0" || event.RawPath != "/api/subscribe" || event.RawQueryString != "list=news" || event.RequestContext.HTTP.Method != "POST" ||
+		event.Body != `{"email":"matt@example.com"}` || len(event.Cookies) != 2 || event.Headers["host"] != "example.com" {
+		t.Errorf("unexpected event, %+v", event)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != "subscribed" || w.Header().Get("Content-Type") != "text/plain" || w.Header().Get("Set-Cookie") != "a=1" {
+		t.Errorf("unexpected response, %v %v %q", w.Code, w.Header(), w.Body.String())
+	}
+
+	req = httptest.NewRequest("PUT", "/api/vote", strings.NewReader("\xff\xfe"))
+	w = httptest.NewRecorder()
+	functions.Serve(w, req, functions.Match(req))
+	if !event.IsBase64Encoded || w.Code != http.StatusOK || w.Body.String() != `{"votes":3}` || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected response, %v %+v %q", w.Code, event, w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/api/broken", nil)
+	w = httptest.NewRecorder()
+	functions.Serve(w, req, functions.Match(req))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected %v; got %v", http.StatusBadGateway, w.Code)
+	}
+}
+
+func TestFunctionEventEscaped(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/a%20b", nil)
+	event := functionEvent(req, nil)
+	if event.RawPath != "/api/a%20b" {
+		t.Errorf("expected the escaped path, got %s", event.RawPath)
+	}
+	if roundTrip, err := event.Request(req.Context()); err != nil || roundTrip.URL.Path != "/api/a b" {
+		t.Errorf("expected the path to survive a round trip, got %v %v", roundTrip, err)
+	}
+}
+
+func TestFunctionEventDropsBasicAuth(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/subscribe", nil)
+	req.SetBasicAuth("user", "secret")
+	if event := functionEvent(withBasicAuth(req), nil); event.Headers["authorization"] != "" {
+		t.Errorf("expected the site's credentials to be withheld, got %q", event.Headers["authorization"])
+	}
+
+	req.Header.Set("Authorization", "Bearer function")
+	if event := functionEvent(req, nil); event.Headers["authorization"] != "Bearer function" {
+		t.Errorf("expected other credentials to be passed on, got %q", event.Headers["authorization"])
+	}
+}
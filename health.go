@@ -0,0 +1,123 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+const (
+	// HealthzPath answers liveness probes
+	HealthzPath = "/healthz"
+
+	// ReadyzPath answers readiness probes
+	ReadyzPath = "/readyz"
+)
+
+// healthz reports the process is up; it deliberately touches neither s3
+// nor auth so load balancers can probe it cheaply
+func healthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		io.WriteString(w, "ok\n")
+	}
+}
+
+// Healthz serves HealthzPath ahead of h
+func Healthz(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == HealthzPath {
+			healthz(w, req)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// Readiness answers readiness probes by running Check at most once per TTL
+type Readiness struct {
+	Check func() error
+	TTL   time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// BucketCheck confirms credentials, region, and bucket permissions by
+// issuing a HEAD for key.  A 404 still proves access; a 403 or any other
+// failure does not.
+func BucketCheck(bucket *s3.Bucket, key string) func() error {
+	return func() error {
+		resp, err := bucket.Head(key)
+		if err != nil {
+			if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+				return nil
+			}
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+}
+
+// Err returns the result of the most recent check, running a new one when
+// the previous result is older than TTL
+func (r *Readiness) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.checked.IsZero() || time.Since(r.checked) > r.TTL {
+		r.err = r.Check()
+		r.checked = time.Now()
+		if r.err != nil {
+			logger.Warn("readiness check failed", Fields{"error": r.err})
+		}
+	}
+	return r.err
+}
+
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.Err(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if req.Method != "HEAD" {
+			io.WriteString(w, err.Error()+"\n")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		io.WriteString(w, "ok\n")
+	}
+}
@@ -0,0 +1,79 @@
+package s3site
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+func TestHealthz(t *testing.T) {
+	h := Healthz(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("expected 200 ok, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/index.html", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected other paths to pass through, got %d", w.Code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	var err error
+	calls := 0
+	ready := &Readiness{
+		Check: func() error { calls++; return err },
+		TTL:   time.Hour,
+	}
+
+	err = errors.New("access denied")
+	w := httptest.NewRecorder()
+	ready.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", w.Code)
+	}
+
+	err = nil
+	w = httptest.NewRecorder()
+	ready.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable || calls != 1 {
+		t.Errorf("expected cached 503 after 1 check, got %d after %d", w.Code, calls)
+	}
+
+	ready.checked = time.Now().Add(-2 * time.Hour)
+	w = httptest.NewRecorder()
+	ready.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK || calls != 2 {
+		t.Errorf("expected 200 after 2 checks, got %d after %d", w.Code, calls)
+	}
+}
+
+func TestBucketCheck(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	api := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{S3Endpoint: server.URL})
+	check := BucketCheck(api.Bucket("bucket"), "index.html")
+
+	if err := check(); err != nil {
+		t.Errorf("expected 404 to confirm access, got %v", err)
+	}
+	status = http.StatusForbidden
+	if err := check(); err == nil {
+		t.Errorf("expected 403 to fail the check")
+	}
+}
@@ -0,0 +1,153 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import "net/http"
+
+// Hook extends request handling at fixed points, so features compose around
+// the handler rather than growing it.  Each field is optional; hooks run in
+// the order given to New.
+type Hook struct {
+	// OnRequest runs first; returning false ends the request, the hook
+	// having written the response
+	OnRequest func(w http.ResponseWriter, req *http.Request) bool
+
+	// OnAuth decides whether the request may proceed given the decision of
+	// basic auth, which is true when no credentials are configured
+	OnAuth func(req *http.Request, allowed bool) bool
+
+	// OnOriginFetch returns the key to fetch from s3 in place of key
+	OnOriginFetch func(req *http.Request, key string) string
+
+	// OnResponse runs as the response headers are about to be written; key
+	// is empty if the request was refused before it was resolved
+	OnResponse func(w http.ResponseWriter, req *http.Request, key string)
+}
+
+type hooks []Hook
+
+// RouteHook adapts route, which maps the path requested to the one served or
+// else redirects, to an OnRequest hook that sets req.URL.Path to the path
+// served.  Locales, aliases, and rewrites route through it.
+func RouteHook(route func(w http.ResponseWriter, req *http.Request, urlPath string) (string, bool)) Hook {
+	return Hook{OnRequest: func(w http.ResponseWriter, req *http.Request) bool {
+		urlPath, redirected := route(w, req, req.URL.Path)
+		if urlPath != req.URL.Path {
+			req.URL.Path, req.URL.RawPath = urlPath, ""
+		}
+		return !redirected
+	}}
+}
+
+func (h hooks) request(w http.ResponseWriter, req *http.Request) bool {
+	for _, hook := range h {
+		if hook.OnRequest != nil && !hook.OnRequest(w, req) {
+			return false
+		}
+	}
+	return true
+}
+
+// route runs the hooks on a copy of req whose path is urlPath, returning the
+// path they route it to and false if one of them ended the request
+func (h hooks) route(w http.ResponseWriter, req *http.Request, urlPath string) (string, bool) {
+	routed, u := new(http.Request), *req.URL
+	*routed = *req
+	routed.URL = &u
+	routed.URL.Path, routed.URL.RawPath = urlPath, ""
+	if !h.request(w, routed) {
+		return "", false
+	}
+	return routed.URL.Path, true
+}
+
+func (h hooks) auth(req *http.Request, allowed bool) bool {
+	for _, hook := range h {
+		if hook.OnAuth != nil {
+			allowed = hook.OnAuth(req, allowed)
+		}
+	}
+	return allowed
+}
+
+func (h hooks) originFetch(req *http.Request, key string) string {
+	for _, hook := range h {
+		if hook.OnOriginFetch != nil {
+			key = hook.OnOriginFetch(req, key)
+		}
+	}
+	return key
+}
+
+// wrap returns w, calling the OnResponse hooks before its headers are
+// written.  key is read then, so it may be set after wrapping.
+func (h hooks) wrap(w http.ResponseWriter, req *http.Request, key *string) http.ResponseWriter {
+	respond := []func(http.ResponseWriter, *http.Request, string){}
+	for _, hook := range h {
+		if hook.OnResponse != nil {
+			respond = append(respond, hook.OnResponse)
+		}
+	}
+	if len(respond) == 0 {
+		return w
+	}
+	return &hookWriter{ResponseWriter: w, req: req, key: key, respond: respond}
+}
+
+type hookWriter struct {
+	http.ResponseWriter
+	req     *http.Request
+	key     *string
+	respond []func(http.ResponseWriter, *http.Request, string)
+	written bool
+}
+
+func (w *hookWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		for _, fn := range w.respond {
+			fn(w.ResponseWriter, w.req, *w.key)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *hookWriter) Write(data []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hookWriter) Flush() {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *hookWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
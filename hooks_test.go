@@ -0,0 +1,110 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	responded := ""
+	opts := DefaultOptions()
+	opts.Bucket = "docs"
+	h, err := New(opts,
+		Hook{OnRequest: func(w http.ResponseWriter, req *http.Request) bool {
+			if req.URL.Path == "/blocked" {
+				w.WriteHeader(http.StatusForbidden)
+				return false
+			}
+			return true
+		}},
+		Hook{OnAuth: func(req *http.Request, allowed bool) bool {
+			return allowed && req.Header.Get("X-Allowed") != ""
+		}},
+		Hook{OnResponse: func(w http.ResponseWriter, req *http.Request, key string) {
+			w.Header().Set("X-Hooked", "1")
+			responded = req.URL.Path
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/blocked", nil))
+	if w.Code != http.StatusForbidden || responded != "" {
+		t.Errorf("expected OnRequest to end the request, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/page", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected OnAuth to refuse the request, got %d", w.Code)
+	}
+	if w.Header().Get("X-Hooked") != "1" || responded != "/page" {
+		t.Errorf("expected OnResponse to run before the headers were written, %v", w.Header())
+	}
+}
+
+func TestHooksOriginFetch(t *testing.T) {
+	hs := hooks{
+		{OnOriginFetch: func(req *http.Request, key string) string { return "v2/" + key }},
+		{},
+		{OnOriginFetch: func(req *http.Request, key string) string { return key + ".gz" }},
+	}
+	if key := hs.originFetch(nil, "index.html"); key != "v2/index.html.gz" {
+		t.Errorf("expected hooks to apply in order, got %s", key)
+	}
+}
+
+func TestHooksRoute(t *testing.T) {
+	routes := hooks{
+		RouteHook(func(w http.ResponseWriter, req *http.Request, urlPath string) (string, bool) {
+			return "/en" + urlPath, false
+		}),
+		RouteHook(func(w http.ResponseWriter, req *http.Request, urlPath string) (string, bool) {
+			if urlPath == "/en/old" {
+				http.Redirect(w, req, "/en/new", http.StatusMovedPermanently)
+				return urlPath, true
+			}
+			return urlPath, false
+		}),
+	}
+
+	req := httptest.NewRequest("GET", "/page", nil)
+	if urlPath, ok := routes.route(httptest.NewRecorder(), req, "/page"); !ok || urlPath != "/en/page" {
+		t.Errorf("expected /en/page, got %s %v", urlPath, ok)
+	}
+	if req.URL.Path != "/page" {
+		t.Errorf("expected the request's own path to be left alone, got %s", req.URL.Path)
+	}
+
+	w := httptest.NewRecorder()
+	if _, ok := routes.route(w, httptest.NewRequest("GET", "/old", nil), "/old"); ok || w.Code != http.StatusMovedPermanently {
+		t.Errorf("expected a redirect to end the request, got %d", w.Code)
+	}
+}
+
+func TestHookWriterFlush(t *testing.T) {
+	hs := hooks{{OnResponse: func(w http.ResponseWriter, req *http.Request, key string) {
+		w.Header().Set("X-Hooked", "1")
+	}}}
+	key := ""
+	rec := httptest.NewRecorder()
+	w := hs.wrap(rec, httptest.NewRequest("GET", "/", nil), &key)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("expected the hooked writer to flush")
+	}
+	flusher.Flush()
+	if !rec.Flushed || rec.Header().Get("X-Hooked") != "1" {
+		t.Errorf("expected the flush to reach the writer after the hooks ran, %v", rec.Header())
+	}
+}
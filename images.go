@@ -0,0 +1,231 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxImageBytes bounds the originals read for resizing
+	maxImageBytes = 32 << 20
+
+	// maxImagePixels bounds the decoded size of originals, so a small file
+	// can't decode to an enormous image
+	maxImagePixels = 50 << 20
+)
+
+// ImageSize is a resize requested by the w, h, and q query parameters
+type ImageSize struct {
+	Width   int
+	Height  int
+	Quality int
+}
+
+// String returns the size as a cache variant
+func (s ImageSize) String() string {
+	return fmt.Sprintf("w=%d&h=%d&q=%d", s.Width, s.Height, s.Quality)
+}
+
+// Images resizes jpeg, png, and gif images on request e.g.
+// /img/photo.jpg?w=400&q=70, caching each size once rendered
+type Images struct {
+	// MaxSize bounds the width and height requested
+	MaxSize int
+	// Quality is the jpeg quality used when the request gives none
+	Quality int
+	Cache   *Cache
+}
+
+// isImage returns true for keys of images that can be resized
+func isImage(key string) bool {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// Requested returns true when req asks for a resized image
+func (im *Images) Requested(req *http.Request) bool {
+	query := req.URL.Query()
+	return im != nil && (query.Get("w") != "" || query.Get("h") != "" || query.Get("q") != "")
+}
+
+// ParseSize reads the size requested by req
+func (im *Images) ParseSize(req *http.Request) (ImageSize, error) {
+	query := req.URL.Query()
+	size := ImageSize{Quality: im.Quality}
+	for name, value := range map[string]*int{"w": &size.Width, "h": &size.Height, "q": &size.Quality} {
+		s := query.Get(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return size, fmt.Errorf("invalid %s, %s", name, s)
+		}
+		*value = n
+	}
+	if size.Width > im.MaxSize || size.Height > im.MaxSize {
+		return size, fmt.Errorf("images are resized to at most %dpx", im.MaxSize)
+	}
+	if size.Quality > 100 {
+		return size, fmt.Errorf("invalid q, %d; use 1-100", size.Quality)
+	}
+	return size, nil
+}
+
+// Serve writes the image read from body, stored at key with etag, resized
+// as req asks.  Each size is tagged with an etag of its own, so caches and
+// conditional requests tell the sizes apart.
+func (im *Images) Serve(w http.ResponseWriter, req *http.Request, key, etag, lastModified string, body io.Reader) {
+	size, err := im.ParseSize(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if setValidators(w, req, VariantETag(etag, size.String()), lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	cacheKey := CacheKey(key, size.String()+"&etag="+etag)
+	if entry, ok := im.Cache.Get(cacheKey); ok && etag != "" {
+		w.Header().Set("Content-Type", entry.ContentType)
+		w.Write(entry.Body)
+		return
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxImageBytes))
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	resized, contentType, err := ResizeImage(data, size)
+	if err != nil {
+		logger.Warn("unable to resize image", Fields{"request_id": requestID(req), "key": key, "error": err})
+		http.Error(w, "unable to resize image", http.StatusUnprocessableEntity)
+		return
+	}
+
+	im.Cache.Set(&Entry{Key: key, Variant: size.String() + "&etag=" + etag, Body: resized, ContentType: contentType, ETag: etag, Fetched: time.Now()})
+	w.Header().Set("Content-Type", contentType)
+	w.Write(resized)
+}
+
+// ResizeImage scales the jpeg, png, or gif in data down to fit size, keeping
+// its aspect ratio, and encodes it as a jpeg, or as a png when the original
+// isn't a jpeg.  Images are never scaled up.
+func ResizeImage(data []byte, size ImageSize) ([]byte, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, "", fmt.Errorf("image too large to resize, %dx%d", config.Width, config.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	width, height := fitSize(config.Width, config.Height, size.Width, size.Height)
+	var img image.Image = src
+	if width != config.Width || height != config.Height {
+		img = scaleImage(src, width, height)
+	}
+
+	buf := &bytes.Buffer{}
+	if format == "jpeg" {
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: size.Quality})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err = png.Encode(buf, img)
+	return buf.Bytes(), "image/png", err
+}
+
+// fitSize returns the largest size, no larger than the original, fitting
+// within maxWidth and maxHeight, either of which may be 0 for no limit
+func fitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && maxWidth < width {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && float64(maxHeight) < float64(height)*scale {
+		scale = float64(maxHeight) / float64(height)
+	}
+	w, h := int(float64(width)*scale+0.5), int(float64(height)*scale+0.5)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// scaleImage scales src to width by height, averaging the source pixels
+// covered by each destination pixel
+func scaleImage(src image.Image, width, height int) image.Image {
+	b := src.Bounds()
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
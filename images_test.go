@@ -0,0 +1,123 @@
+package s3site
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testImage(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResizeImage(t *testing.T) {
+	data := testImage(t, 100, 50)
+
+	testCases := []struct {
+		Size          ImageSize
+		Width, Height int
+	}{
+		{ImageSize{Width: 40}, 40, 20},
+		{ImageSize{Height: 10}, 20, 10},
+		{ImageSize{Width: 40, Height: 5}, 10, 5},
+		{ImageSize{Width: 400}, 100, 50},
+	}
+	for _, tc := range testCases {
+		resized, contentType, err := ResizeImage(data, tc.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "image/png" {
+			t.Errorf("expected a png, got %s", contentType)
+		}
+		config, _, _ := image.DecodeConfig(bytes.NewReader(resized))
+		if config.Width != tc.Width || config.Height != tc.Height {
+			t.Errorf("%+v: expected %dx%d, got %dx%d", tc.Size, tc.Width, tc.Height, config.Width, config.Height)
+		}
+	}
+
+	img, _ := png.Decode(bytes.NewReader(data))
+	buf := &bytes.Buffer{}
+	jpeg.Encode(buf, img, nil)
+	low, contentType, err := ResizeImage(buf.Bytes(), ImageSize{Width: 50, Quality: 10})
+	if err != nil || contentType != "image/jpeg" {
+		t.Fatalf("expected a jpeg, got %s %v", contentType, err)
+	}
+	high, _, _ := ResizeImage(buf.Bytes(), ImageSize{Width: 50, Quality: 95})
+	if len(low) >= len(high) {
+		t.Errorf("expected a lower quality to be smaller, got %d and %d bytes", len(low), len(high))
+	}
+
+	if _, _, err := ResizeImage([]byte("not an image"), ImageSize{Width: 10}); err == nil {
+		t.Error("expected an error for data that isn't an image")
+	}
+}
+
+func TestImagesServe(t *testing.T) {
+	images := &Images{MaxSize: 500, Quality: 80, Cache: NewCache(1 << 20)}
+	data := testImage(t, 100, 50)
+
+	for _, query := range []string{"w=0", "w=abc", "w=1000", "q=101"} {
+		w := httptest.NewRecorder()
+		images.Serve(w, httptest.NewRequest("GET", "/photo.png?"+query, nil), "site/photo.png", `"v1"`, "", bytes.NewReader(data))
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/photo.png?w=20", nil)
+	if !images.Requested(req) || images.Requested(httptest.NewRequest("GET", "/photo.png", nil)) {
+		t.Error("expected only requests with a size to be resized")
+	}
+	w := httptest.NewRecorder()
+	images.Serve(w, req, "site/photo.png", `"v1"`, "", bytes.NewReader(data))
+	if w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("unexpected response, %d %v", w.Code, w.Header())
+	}
+	first := w.Body.String()
+	etag := w.Header().Get("ETag")
+	if etag == `"v1"` || etag != VariantETag(`"v1"`, "w=20&h=0&q=80") {
+		t.Errorf("expected an etag of the size, got %s", etag)
+	}
+
+	conditional := httptest.NewRequest("GET", "/photo.png?w=20", nil)
+	conditional.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	images.Serve(w, conditional, "site/photo.png", `"v1"`, "", strings.NewReader("unread"))
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	images.Serve(w, req, "site/photo.png", `"v1"`, "", strings.NewReader("unread"))
+	if w.Body.String() != first {
+		t.Error("expected the resized image to be served from the cache")
+	}
+	if images.Cache.Delete("site/photo.png") != 1 {
+		t.Error("expected purging the original to remove its sizes")
+	}
+
+	conditional = httptest.NewRequest("GET", "/photo.png?w=40", nil)
+	conditional.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	images.Serve(w, conditional, "site/photo.png", `"v1"`, "", bytes.NewReader(data))
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("expected other sizes to be served under their own etag, got %d %s", w.Code, w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,51 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"path"
+	"strings"
+)
+
+// KeyPolicy normalizes requests so that equivalent urls share a single
+// cache entry and s3 fetch.  The query string never changes the object
+// fetched, so it's never part of the key; ?utm_source=... and the like share
+// the entry of the page they decorate.
+type KeyPolicy struct {
+	// Lowercase folds request paths, and so the s3 keys fetched, to lower
+	// case; objects stored under mixed case keys are no longer found
+	Lowercase bool
+}
+
+// Path cleans dot segments and duplicate slashes from the request path,
+// preserving any trailing slash
+func (p *KeyPolicy) Path(urlPath string) string {
+	cleaned := path.Clean("/" + urlPath)
+	if strings.HasSuffix(urlPath, "/") && cleaned != "/" {
+		cleaned = cleaned + "/"
+	}
+	if p.Lowercase {
+		cleaned = strings.ToLower(cleaned)
+	}
+	return cleaned
+}
@@ -0,0 +1,33 @@
+package s3site
+
+import "testing"
+
+func TestKeyPolicyPath(t *testing.T) {
+	policy := &KeyPolicy{Lowercase: true}
+
+	testCases := map[string]string{
+		"/":                 "/",
+		"/Docs/":            "/docs/",
+		"//docs//a.html":    "/docs/a.html",
+		"/docs/../About":    "/about",
+		"/docs/./img/":      "/docs/img/",
+		"/../../etc/passwd": "/etc/passwd",
+	}
+	for input, expected := range testCases {
+		if got := policy.Path(input); got != expected {
+			t.Errorf("%s: expected %s, got %s", input, expected, got)
+		}
+	}
+}
+
+func TestKeyPolicyLowercaseFetch(t *testing.T) {
+	opts := &Options{Prefix: "site", IndexFile: "index.html"}
+
+	// lowercasing changes the s3 key fetched, not just the cache entry
+	if key := opts.Key((&KeyPolicy{Lowercase: true}).Path("/Docs/About.html")); key != "site/docs/about.html" {
+		t.Errorf("expected the lower case key to be fetched, got %s", key)
+	}
+	if key := opts.Key((&KeyPolicy{}).Path("/Docs/About.html")); key != "site/Docs/About.html" {
+		t.Errorf("expected the key to keep its case by default, got %s", key)
+	}
+}
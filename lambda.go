@@ -0,0 +1,290 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// LambdaRuntimeAPI is set by AWS Lambda to the address of the runtime api;
+// when present s3site serves invocations rather than listening
+const LambdaRuntimeAPI = "AWS_LAMBDA_RUNTIME_API"
+
+// lambdaEvent is an invocation from API Gateway or a function URL.  REST
+// APIs send version 1.0 events; HTTP APIs and function URLs send 2.0.
+type lambdaEvent struct {
+	Version string `json:"version"`
+
+	// version 1.0
+	HTTPMethod                      string              `json:"httpMethod"`
+	Path                            string              `json:"path"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+
+	// version 2.0
+	RawPath        string   `json:"rawPath"`
+	RawQueryString string   `json:"rawQueryString"`
+	Cookies        []string `json:"cookies"`
+
+	Headers               map[string]string `json:"headers"`
+	QueryStringParameters map[string]string `json:"queryStringParameters"`
+	Body                  string            `json:"body"`
+	IsBase64Encoded       bool              `json:"isBase64Encoded"`
+	RequestContext        struct {
+		DomainName string `json:"domainName"`
+		Identity   struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"`
+		HTTP struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+func (e *lambdaEvent) v2() bool {
+	return e.Version == "2.0"
+}
+
+// Request converts the event into the request it describes
+func (e *lambdaEvent) Request(ctx context.Context) (*http.Request, error) {
+	method, path, sourceIP := e.HTTPMethod, e.Path, e.RequestContext.Identity.SourceIP
+	rawPath := ""
+	query := url.Values{}
+	if e.v2() {
+		// unlike v1's path, rawPath is escaped as the client sent it
+		unescaped, err := url.PathUnescape(e.RawPath)
+		if err != nil {
+			return nil, err
+		}
+		method, path, rawPath, sourceIP = e.RequestContext.HTTP.Method, unescaped, e.RawPath, e.RequestContext.HTTP.SourceIP
+		query, _ = url.ParseQuery(e.RawQueryString)
+	} else if len(e.MultiValueQueryStringParameters) > 0 {
+		query = url.Values(e.MultiValueQueryStringParameters)
+	} else {
+		for k, v := range e.QueryStringParameters {
+			query.Set(k, v)
+		}
+	}
+	if method == "" || path == "" {
+		return nil, fmt.Errorf("unsupported lambda event; expected an API Gateway or function url request")
+	}
+
+	body := []byte(e.Body)
+	if e.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(e.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	u := &url.URL{Path: path, RawPath: rawPath, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if len(e.MultiValueHeaders) > 0 {
+		for k, values := range e.MultiValueHeaders {
+			for _, v := range values {
+				req.Header.Add(k, v)
+			}
+		}
+	} else {
+		for k, v := range e.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+	if len(e.Cookies) > 0 {
+		req.Header.Set("Cookie", strings.Join(e.Cookies, "; "))
+	}
+
+	req.Host = req.Header.Get("Host")
+	if req.Host == "" {
+		req.Host = e.RequestContext.DomainName
+	}
+	if sourceIP != "" {
+		req.RemoteAddr = net.JoinHostPort(sourceIP, "0")
+	}
+	return req, nil
+}
+
+// lambdaResponse is the reply API Gateway turns back into http
+type lambdaResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Cookies           []string            `json:"cookies,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// lambdaWriter buffers a response so it can be returned as a lambdaResponse
+type lambdaWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *lambdaWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *lambdaWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *lambdaWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+// Response returns the buffered response in the shape the event expects;
+// bodies that aren't utf-8 text are base64 encoded
+func (w *lambdaWriter) Response(v2 bool) *lambdaResponse {
+	resp := &lambdaResponse{StatusCode: w.status, Body: w.body.String()}
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	if !utf8.Valid(w.body.Bytes()) {
+		resp.Body = base64.StdEncoding.EncodeToString(w.body.Bytes())
+		resp.IsBase64Encoded = true
+	}
+
+	if !v2 {
+		resp.MultiValueHeaders = w.header
+		return resp
+	}
+	resp.Headers = map[string]string{}
+	for k, values := range w.header {
+		if k == "Set-Cookie" {
+			resp.Cookies = values
+			continue
+		}
+		resp.Headers[k] = strings.Join(values, ",")
+	}
+	return resp
+}
+
+// LambdaRuntime serves Lambda invocations with Handler, so the same auth and
+// routing run behind API Gateway or a function url as behind a listener
+type LambdaRuntime struct {
+	API     string
+	Handler http.Handler
+	Client  *http.Client
+}
+
+// Run serves invocations until the runtime api fails
+func (l *LambdaRuntime) Run() error {
+	for {
+		if err := l.Next(); err != nil {
+			return err
+		}
+	}
+}
+
+// Next waits for an invocation, serves it, and posts the response
+func (l *LambdaRuntime) Next() error {
+	resp, err := l.Client.Get(l.url("invocation/next"))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("lambda runtime returned %d", resp.StatusCode)
+	}
+
+	id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+	ctx := context.Background()
+	if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Unix(0, ms*int64(time.Millisecond)))
+		defer cancel()
+	}
+
+	event := &lambdaEvent{}
+	err = json.NewDecoder(resp.Body).Decode(event)
+	var req *http.Request
+	if err == nil {
+		req, err = event.Request(ctx)
+	}
+	if err != nil {
+		logger.Error("unable to handle lambda event", Fields{"request_id": id, "error": err})
+		return l.post("invocation/"+id+"/error", map[string]string{
+			"errorMessage": err.Error(),
+			"errorType":    "InvalidEvent",
+		})
+	}
+
+	if trace := resp.Header.Get("Lambda-Runtime-Trace-Id"); trace != "" && req.Header.Get("X-Amzn-Trace-Id") == "" {
+		req.Header.Set("X-Amzn-Trace-Id", trace)
+	}
+	w := &lambdaWriter{header: http.Header{}}
+	l.Handler.ServeHTTP(w, req)
+	return l.post("invocation/"+id+"/response", w.Response(event.v2()))
+}
+
+func (l *LambdaRuntime) url(path string) string {
+	return "http://" + l.API + "/2018-06-01/runtime/" + path
+}
+
+func (l *LambdaRuntime) post(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := l.Client.Post(l.url(path), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("lambda runtime returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// lambdaAuth exposes the session token Lambda provides under the name the
+// aws package reads
+func lambdaAuth() {
+	if os.Getenv("AWS_SECURITY_TOKEN") == "" {
+		os.Setenv("AWS_SECURITY_TOKEN", os.Getenv("AWS_SESSION_TOKEN"))
+	}
+}
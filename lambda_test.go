@@ -0,0 +1,142 @@
+package s3site
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLambdaEventV1(t *testing.T) {
+	event := &lambdaEvent{}
+	json.Unmarshal([]byte(`{
+		"httpMethod": "GET",
+		"path": "/docs/",
+		"multiValueHeaders": {"Host": ["example.com"], "Accept": ["text/html"]},
+		"multiValueQueryStringParameters": {"v": ["1", "2"]},
+		"requestContext": {"identity": {"sourceIp": "198.51.100.9"}}
+	}`), event)
+
+	req, err := event.Request(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "GET" || req.URL.Path != "/docs/" || req.URL.RawQuery != "v=1&v=2" {
+		t.Errorf("unexpected request, %s %s", req.Method, req.URL)
+	}
+	if req.Host != "example.com" || req.Header.Get("Accept") != "text/html" || clientIP(req) != "198.51.100.9" {
+		t.Errorf("unexpected request, %s %v %s", req.Host, req.Header, req.RemoteAddr)
+	}
+}
+
+func TestLambdaEventV2(t *testing.T) {
+	event := &lambdaEvent{}
+	json.Unmarshal([]byte(`{
+		"version": "2.0",
+		"rawPath": "/upload",
+		"rawQueryString": "a=b",
+		"cookies": ["a=1", "b=2"],
+		"headers": {"content-type": "text/plain"},
+		"body": "aGVsbG8=",
+		"isBase64Encoded": true,
+		"requestContext": {"domainName": "abc.lambda-url.us-east-1.on.aws", "http": {"method": "POST", "sourceIp": "203.0.113.7"}}
+	}`), event)
+
+	req, err := event.Request(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	if req.Method != "POST" || req.URL.String() != "/upload?a=b" || string(body) != "hello" {
+		t.Errorf("unexpected request, %s %s %q", req.Method, req.URL, body)
+	}
+	if req.Host != "abc.lambda-url.us-east-1.on.aws" || req.Header.Get("Cookie") != "a=1; b=2" {
+		t.Errorf("unexpected request, %s %v", req.Host, req.Header)
+	}
+
+	if _, err := (&lambdaEvent{}).Request(context.Background()); err == nil {
+		t.Error("expected an event that isn't an http request to be rejected")
+	}
+}
+
+func TestLambdaEventV2Escaped(t *testing.T) {
+	event := &lambdaEvent{Version: "2.0", RawPath: "/a%20b.html"}
+	event.RequestContext.HTTP.Method = "GET"
+
+	req, err := event.Request(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.Path != "/a b.html" || req.URL.EscapedPath() != "/a%20b.html" {
+		t.Errorf("expected the path to be unescaped once, got %q %q", req.URL.Path, req.URL.EscapedPath())
+	}
+
+	event.RawPath = "/%zz"
+	if _, err := event.Request(context.Background()); err == nil {
+		t.Error("expected an invalid escape to be rejected")
+	}
+}
+
+func TestLambdaWriterResponse(t *testing.T) {
+	w := &lambdaWriter{header: http.Header{}}
+	w.Header().Add("Set-Cookie", "a=1")
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("hello"))
+
+	resp := w.Response(true)
+	if resp.StatusCode != http.StatusOK || resp.Body != "hello" || resp.IsBase64Encoded {
+		t.Errorf("unexpected response, %+v", resp)
+	}
+	if resp.Headers["Content-Type"] != "text/plain" || len(resp.Cookies) != 1 {
+		t.Errorf("unexpected headers, %v %v", resp.Headers, resp.Cookies)
+	}
+
+	w = &lambdaWriter{header: http.Header{}}
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte{0xff, 0xd8, 0xff})
+	resp = w.Response(false)
+	if resp.StatusCode != http.StatusNotFound || !resp.IsBase64Encoded || resp.Body != base64.StdEncoding.EncodeToString([]byte{0xff, 0xd8, 0xff}) {
+		t.Errorf("expected a base64 body, got %+v", resp)
+	}
+}
+
+func TestLambdaRuntimeNext(t *testing.T) {
+	var posted *lambdaResponse
+	var path string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/2018-06-01/runtime/invocation/next":
+			w.Header().Set("Lambda-Runtime-Aws-Request-Id", "abc")
+			w.Header().Set("Lambda-Runtime-Trace-Id", "Root=1-5759e988-bd862e3fe1be46a994272793")
+			w.Write([]byte(`{"version": "2.0", "rawPath": "/", "requestContext": {"http": {"method": "GET"}}}`))
+		default:
+			path = req.URL.Path
+			posted = &lambdaResponse{}
+			json.NewDecoder(req.Body).Decode(posted)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer api.Close()
+
+	runtime := &LambdaRuntime{
+		API:    strings.TrimPrefix(api.URL, "http://"),
+		Client: http.DefaultClient,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte(req.Header.Get("X-Amzn-Trace-Id")))
+		}),
+	}
+	if err := runtime.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/2018-06-01/runtime/invocation/abc/response" {
+		t.Errorf("expected the response to be posted, got %s", path)
+	}
+	if posted.StatusCode != http.StatusTeapot || !strings.HasPrefix(posted.Body, "Root=1-") {
+		t.Errorf("unexpected response, %+v", posted)
+	}
+}
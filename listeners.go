@@ -0,0 +1,334 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd
+const listenFDsStart = 3
+
+// InheritedListener is a listening socket passed by systemd socket
+// activation.  Name is the socket's FileDescriptorName, which defaults to
+// the name of the .socket unit.
+type InheritedListener struct {
+	net.Listener
+	Name string
+}
+
+// SystemdListeners returns the sockets passed per LISTEN_PID and LISTEN_FDS,
+// or none when the process wasn't socket activated.  The variables are
+// cleared so that child processes don't mistake the sockets for their own.
+func SystemdListeners() ([]InheritedListener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	return inheritedListeners(listenFDsStart, n, names)
+}
+
+func inheritedListeners(start, n int, names []string) ([]InheritedListener, error) {
+	listeners := []InheritedListener{}
+	for i := 0; i < n; i++ {
+		fd := start + i
+		closeOnExec(fd)
+
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), "systemd:"+name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to use inherited socket %d, %s", fd, err)
+		}
+		listeners = append(listeners, InheritedListener{Listener: ln, Name: name})
+	}
+	return listeners, nil
+}
+
+// Listen opens addr, either host:port or unix:/path.  A host of [::] binds
+// both ipv4 and ipv6; prefix the address with tcp4:// or tcp6:// to bind just
+// one family.  Unix sockets are created with mode and, if set, owner as user
+// or user:group; a stale socket left by a previous run is replaced.
+func Listen(addr string, mode os.FileMode, owner string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		network, address, err := tcpAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		return net.Listen(network, address)
+	}
+
+	path := strings.TrimPrefix(addr, "unix:")
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket %s is in use", path)
+		}
+		os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if owner != "" {
+		uid, gid, err := lookupOwner(owner)
+		if err == nil {
+			err = os.Chown(path, uid, gid)
+		}
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// tcpAddr splits addr into its network and host:port, checking that an ipv6
+// host is bracketed e.g. [::1]:8080
+func tcpAddr(addr string) (string, string, error) {
+	network, address := "tcp", addr
+	for _, prefix := range []string{"tcp://", "tcp4://", "tcp6://"} {
+		if strings.HasPrefix(addr, prefix) {
+			network, address = strings.TrimSuffix(prefix, "://"), strings.TrimPrefix(addr, prefix)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid listen address, %s; use host:port, with ipv6 hosts in brackets", addr)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if network == "tcp4" && ip.To4() == nil || network == "tcp6" && ip.To4() != nil {
+			return "", "", fmt.Errorf("listen address %s doesn't match %s", host, network)
+		}
+	}
+	return network, address, nil
+}
+
+// lookupOwner resolves user or user:group to ids; -1 leaves the group as is
+func lookupOwner(owner string) (int, int, error) {
+	name, group := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		name, group = owner[:i], owner[i+1:]
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	gid := -1
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, err
+		}
+	}
+	return uid, gid, nil
+}
+
+// ListenSpec is a parsed --listen value, [admin=]address, where address is
+// host:port, tls://host:port, or unix:/path
+type ListenSpec struct {
+	Admin bool
+	TLS   bool
+	Addr  string
+}
+
+func ParseListenSpec(value string) (ListenSpec, error) {
+	spec := ListenSpec{Addr: value}
+	if strings.HasPrefix(spec.Addr, "admin=") {
+		spec.Admin = true
+		spec.Addr = strings.TrimPrefix(spec.Addr, "admin=")
+	}
+	if strings.HasPrefix(spec.Addr, "tls://") {
+		spec.TLS = true
+		spec.Addr = strings.TrimPrefix(spec.Addr, "tls://")
+	}
+	if spec.Addr == "" || strings.Contains(spec.Addr, "=") {
+		return ListenSpec{}, fmt.Errorf("invalid listen address, %s", value)
+	}
+	return spec, nil
+}
+
+// Listeners are the open sockets for the site and admin handlers
+type Listeners struct {
+	Site  []net.Listener
+	Admin []net.Listener
+
+	// Sockets are the underlying sockets, unwrapped, named for the address
+	// they were opened for or as inherited
+	Sockets []InheritedListener
+}
+
+// Close closes each listener
+func (l *Listeners) Close() {
+	for _, ln := range l.Sockets {
+		ln.Close()
+	}
+}
+
+// OpenListeners opens a listener per --listen value, plus the admin port,
+// alongside any sockets inherited from systemd; inherited sockets named
+// admin serve the admin handler.  When there's no site listener the site is
+// served on the port.  With proxy-protocol, site listeners expect a PROXY
+// header on each connection.  An inherited socket named for a listen address,
+// as passed by Upgrade, is used in place of opening that address.
+func OpenListeners(opts *Options, inherited []InheritedListener) (*Listeners, error) {
+	specs := []ListenSpec{}
+	for _, value := range opts.Listen {
+		spec, err := ParseListenSpec(value)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	if opts.AdminPort != "" {
+		specs = append(specs, ListenSpec{Admin: true, Addr: ":" + opts.AdminPort})
+	}
+
+	listeners := &Listeners{}
+	reuse := map[string]net.Listener{}
+	for _, ln := range inherited {
+		switch {
+		case strings.Contains(ln.Name, ":"):
+			reuse[ln.Name] = ln.Listener
+			continue
+		case ln.Name == "admin":
+			listeners.Admin = append(listeners.Admin, ln.Listener)
+		case opts.ProxyProtocol:
+			listeners.Site = append(listeners.Site, &ProxyListener{Listener: ln.Listener})
+		default:
+			listeners.Site = append(listeners.Site, ln.Listener)
+		}
+		listeners.Sockets = append(listeners.Sockets, ln)
+	}
+	if len(listeners.Site) == 0 && !hasSiteSpec(specs) {
+		specs = append(specs, ListenSpec{Addr: ":" + opts.Port})
+	}
+
+	mode, err := strconv.ParseUint(opts.SocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket mode, %s", opts.SocketMode)
+	}
+
+	var config *tls.Config
+	for _, spec := range specs {
+		ln, ok := reuse[spec.Addr]
+		delete(reuse, spec.Addr)
+		if !ok {
+			if ln, err = Listen(spec.Addr, os.FileMode(mode), opts.SocketOwner); err != nil {
+				listeners.Close()
+				return nil, err
+			}
+		}
+		listeners.Sockets = append(listeners.Sockets, InheritedListener{Listener: ln, Name: spec.Addr})
+
+		// the PROXY header precedes the tls handshake
+		if opts.ProxyProtocol && !spec.Admin {
+			ln = &ProxyListener{Listener: ln}
+		}
+		if spec.TLS {
+			if config == nil {
+				cert, err := tlsKeyPair(opts.TLSCert, opts.TLSKey)
+				if err != nil {
+					listeners.Close()
+					return nil, fmt.Errorf("tls listener %s requires tls-cert and tls-key, %s", spec.Addr, err)
+				}
+				config = &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2", "http/1.1"}}
+			}
+			ln = tls.NewListener(ln, config)
+		}
+
+		if spec.Admin {
+			listeners.Admin = append(listeners.Admin, ln)
+		} else {
+			listeners.Site = append(listeners.Site, ln)
+		}
+	}
+
+	// sockets for addresses no longer listened on are let go
+	for _, ln := range reuse {
+		ln.Close()
+	}
+	return listeners, nil
+}
+
+// tlsKeyPair loads the certificate for tls:// listeners
+func tlsKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, fmt.Errorf("no certificate configured")
+	}
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+func hasSiteSpec(specs []ListenSpec) bool {
+	for _, spec := range specs {
+		if !spec.Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// addrs returns the addresses of listeners for logging
+func addrs(listeners []net.Listener) []string {
+	values := []string{}
+	for _, ln := range listeners {
+		values = append(values, ln.Addr().String())
+	}
+	return values
+}
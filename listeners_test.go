@@ -0,0 +1,215 @@
+//go:build !windows
+
+package s3site
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestSystemdListenersIgnoresOtherProcesses(t *testing.T) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+
+	listeners, err := SystemdListeners()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("expected no listeners for another pid, got %v %v", listeners, err)
+	}
+}
+
+func TestInheritedListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// inheritedListeners takes ownership of the descriptor it's given, so
+	// hand it a copy rather than one f will close again later
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, err := inheritedListeners(fd, 1, []string{"http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 || listeners[0].Name != "http" {
+		t.Fatalf("unexpected listeners, %v", listeners)
+	}
+
+	opened, err := OpenListeners(&Options{SocketMode: "0660"}, listeners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opened.Admin) != 0 || len(opened.Site) != 1 {
+		t.Fatalf("expected the http socket to be picked for serving, got %v", opened)
+	}
+	inherited := opened.Site[0]
+	defer inherited.Close()
+
+	go http.Serve(inherited, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	resp, err := http.Get("http://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("expected the inherited socket to serve, got %d", resp.StatusCode)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s3site.sock")
+
+	ln, err := Listen("unix:"+path, 0600, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected socket with mode 0600, got %v %v", info.Mode(), err)
+	}
+	if _, err := Listen("unix:"+path, 0600, ""); err == nil {
+		t.Error("expected a socket in use to be refused")
+	}
+
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) { return net.Dial("unix", path) },
+	}}
+	resp, err := client.Get("http://s3site/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("expected the unix socket to serve, got %d", resp.StatusCode)
+	}
+	ln.Close()
+
+	// a socket file left behind by a crash is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err = Listen("unix:"+path, 0660, "")
+	if err != nil {
+		t.Fatalf("expected a stale socket to be replaced, %v", err)
+	}
+	ln.Close()
+}
+
+func TestParseListenSpec(t *testing.T) {
+	testCases := map[string]ListenSpec{
+		":80":                    {Addr: ":80"},
+		"tls://:443":             {TLS: true, Addr: ":443"},
+		"admin=127.0.0.1:9090":   {Admin: true, Addr: "127.0.0.1:9090"},
+		"admin=unix:/run/a.sock": {Admin: true, Addr: "unix:/run/a.sock"},
+	}
+	for value, expected := range testCases {
+		spec, err := ParseListenSpec(value)
+		if err != nil || spec != expected {
+			t.Errorf("%s: expected %+v, got %+v %v", value, expected, spec, err)
+		}
+	}
+	for _, value := range []string{"", "admin=", "site=:80"} {
+		if _, err := ParseListenSpec(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+func TestOpenListeners(t *testing.T) {
+	opts := &Options{
+		Listen:     []string{"127.0.0.1:0", "127.0.0.1:0", "admin=127.0.0.1:0"},
+		SocketMode: "0660",
+	}
+	listeners, err := OpenListeners(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners.Close()
+	if len(listeners.Site) != 2 || len(listeners.Admin) != 1 {
+		t.Fatalf("expected 2 site and 1 admin listener, got %v", listeners)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})}
+	go Serve(server, listeners.Site, 0)
+	defer server.Close()
+	for _, ln := range listeners.Site {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTeapot {
+			t.Errorf("expected %s to serve, got %d", ln.Addr(), resp.StatusCode)
+		}
+	}
+
+	opts = &Options{Listen: []string{"tls://127.0.0.1:0"}, SocketMode: "0660"}
+	if _, err := OpenListeners(opts, nil); err == nil {
+		t.Error("expected a tls listener without a certificate to fail")
+	}
+}
+
+func TestTCPAddr(t *testing.T) {
+	testCases := map[string][2]string{
+		":8080":               {"tcp", ":8080"},
+		"0.0.0.0:8080":        {"tcp", "0.0.0.0:8080"},
+		"[::1]:8080":          {"tcp", "[::1]:8080"},
+		"tcp://[::]:8080":     {"tcp", "[::]:8080"},
+		"tcp4://0.0.0.0:8080": {"tcp4", "0.0.0.0:8080"},
+		"tcp6://[::]:8080":    {"tcp6", "[::]:8080"},
+	}
+	for addr, expected := range testCases {
+		network, address, err := tcpAddr(addr)
+		if err != nil || network != expected[0] || address != expected[1] {
+			t.Errorf("%s: expected %v, got %s %s %v", addr, expected, network, address, err)
+		}
+	}
+
+	for _, addr := range []string{"8080", "::1:8080", "tcp4://[::1]:8080", "tcp6://127.0.0.1:8080"} {
+		if _, _, err := tcpAddr(addr); err == nil {
+			t.Errorf("%s: expected an error", addr)
+		}
+	}
+}
+
+func TestListenIPv6(t *testing.T) {
+	ln, err := Listen("tcp6://[::1]:0", 0, "")
+	if err != nil {
+		t.Skipf("ipv6 unavailable, %v", err)
+	}
+	defer ln.Close()
+	if addr := ln.Addr().(*net.TCPAddr); addr.IP.To4() != nil {
+		t.Errorf("expected an ipv6 listener, got %s", addr)
+	}
+}
@@ -0,0 +1,280 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+// maxListing bounds the entries in a directory listing
+const maxListing = 5000
+
+// Listing is the content of a directory, listed from s3 when the directory
+// has no index file
+type Listing struct {
+	Path      string         `json:"path"`
+	Crumbs    []Crumb        `json:"-"`
+	Entries   []ListingEntry `json:"entries"`
+	Sort      string         `json:"sort"`
+	Order     string         `json:"order"`
+	Truncated bool           `json:"truncated,omitempty"`
+}
+
+// Crumb is one directory of the path to a listing
+type Crumb struct {
+	Name string
+	Path string
+}
+
+// ListingEntry is a file, or a directory, in a listing
+type ListingEntry struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Dir      bool      `json:"dir,omitempty"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// MarshalJSON omits the modification time of directories, which have none
+func (e ListingEntry) MarshalJSON() ([]byte, error) {
+	type entry ListingEntry
+	out := struct {
+		entry
+		Modified *time.Time `json:"modified,omitempty"`
+	}{entry: entry(e)}
+	if !e.Dir {
+		out.Modified = &e.Modified
+	}
+	return json.Marshal(out)
+}
+
+// wantsJSON returns true when req asks for a listing as json, by its Accept
+// header or ?format=json
+func wantsJSON(req *http.Request) bool {
+	if format := req.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		if mediaType := strings.TrimSpace(strings.SplitN(accept, ";", 2)[0]); mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// ListDirectory lists the files and directories directly under prefix,
+// served at urlPath
+func ListDirectory(bucket *s3.Bucket, prefix, urlPath string) (*Listing, error) {
+	listing := &Listing{Path: urlPath, Crumbs: crumbs(urlPath)}
+	marker := ""
+	for {
+		list, err := bucket.List(prefix, "/", marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range list.CommonPrefixes {
+			name := strings.TrimPrefix(dir, prefix)
+			listing.Entries = append(listing.Entries, ListingEntry{Name: name, Path: urlPath + name, Dir: true})
+		}
+		for _, key := range list.Contents {
+			name := strings.TrimPrefix(key.Key, prefix)
+			if name == "" {
+				// the directory's own marker object
+				continue
+			}
+			modified, _ := time.Parse(time.RFC3339, key.LastModified)
+			listing.Entries = append(listing.Entries, ListingEntry{Name: name, Path: urlPath + name, Size: key.Size, Modified: modified})
+		}
+
+		if len(listing.Entries) >= maxListing {
+			listing.Truncated = list.IsTruncated
+			break
+		}
+		if !list.IsTruncated {
+			break
+		}
+		marker = list.NextMarker
+		if marker == "" && len(list.Contents) > 0 {
+			marker = list.Contents[len(list.Contents)-1].Key
+		}
+		if marker == "" && len(list.CommonPrefixes) > 0 {
+			marker = list.CommonPrefixes[len(list.CommonPrefixes)-1]
+		}
+	}
+	return listing, nil
+}
+
+// crumbs returns each directory of urlPath from the root down
+func crumbs(urlPath string) []Crumb {
+	crumbs := []Crumb{{Name: "/", Path: "/"}}
+	path := "/"
+	for _, name := range strings.Split(strings.Trim(urlPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		path += name + "/"
+		crumbs = append(crumbs, Crumb{Name: name, Path: path})
+	}
+	return crumbs
+}
+
+// SortBy orders the entries by name, size, or modified, ascending unless
+// order is desc, with directories first
+func (l *Listing) SortBy(by, order string) {
+	if by != "size" && by != "modified" {
+		by = "name"
+	}
+	if order != "desc" {
+		order = "asc"
+	}
+	l.Sort, l.Order = by, order
+
+	sort.SliceStable(l.Entries, func(i, j int) bool {
+		a, b := l.Entries[i], l.Entries[j]
+		if a.Dir != b.Dir {
+			return a.Dir
+		}
+		if order == "desc" {
+			a, b = b, a
+		}
+		switch by {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "modified":
+			if !a.Modified.Equal(b.Modified) {
+				return a.Modified.Before(b.Modified)
+			}
+		}
+		return a.Name < b.Name
+	})
+}
+
+// SortURL returns the query sorting the listing by column, reversing the
+// order when it's already sorted by column
+func (l *Listing) SortURL(column string) string {
+	order := "asc"
+	if l.Sort == column && l.Order == "asc" {
+		order = "desc"
+	}
+	return "?" + url.Values{"sort": {column}, "order": {order}}.Encode()
+}
+
+// HumanSize formats a size in bytes e.g. 1.5 MB
+func (e ListingEntry) HumanSize() string {
+	if e.Dir {
+		return "-"
+	}
+	const unit = 1024
+	if e.Size < unit {
+		return fmt.Sprintf("%d B", e.Size)
+	}
+	div, exp := int64(unit), 0
+	for n := e.Size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(e.Size)/float64(div), "KMGTPE"[exp])
+}
+
+// LoadListingTemplate parses the listing template at path, or returns the
+// built in template when path is empty.  The template is executed with a
+// *Listing.
+func LoadListingTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return listingPage, nil
+	}
+	return template.ParseFiles(path)
+}
+
+var listingPage = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Index of {{.Path}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; color: #222; }
+nav a { text-decoration: none; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: .35em .75em; text-align: left; border-bottom: 1px solid #eee; }
+th a { color: inherit; }
+td.size, th.size { text-align: right; }
+@media (prefers-color-scheme: dark) { body { background: #111; color: #ddd; } a { color: #8ab4f8; } th, td { border-color: #333; } }
+</style></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<nav>{{range $i, $c := .Crumbs}}{{if $i}} / {{end}}<a href="{{$c.Path}}">{{if $i}}{{$c.Name}}{{else}}home{{end}}</a>{{end}}</nav>
+<table>
+<tr><th><a href="{{.SortURL "name"}}">Name</a></th><th class="size"><a href="{{.SortURL "size"}}">Size</a></th><th><a href="{{.SortURL "modified"}}">Modified</a></th></tr>
+{{if gt (len .Crumbs) 1}}<tr><td><a href="../">../</a></td><td class="size">-</td><td></td></tr>{{end}}
+{{range .Entries}}<tr><td><a href="{{.Path}}">{{.Name}}</a></td><td class="size">{{.HumanSize}}</td><td>{{if not .Dir}}{{.Modified.Format "2006-01-02 15:04"}}{{end}}</td></tr>
+{{end}}</table>
+{{if .Truncated}}<p>Only the first {{len .Entries}} entries are listed.</p>{{end}}
+</body></html>
+`))
+
+// serveListing writes the listing of the directory at urlPath, as json when
+// asked for, returning false when the directory is empty or can't be listed
+func serveListing(w http.ResponseWriter, req *http.Request, bucket *s3.Bucket, opts *Options, urlPath string, tmpl *template.Template) bool {
+	listing, err := ListDirectory(bucket, opts.keyPrefix(urlPath), urlPath)
+	if err != nil {
+		logger.Warn("unable to list directory", Fields{"request_id": requestID(req), "bucket": bucket.Name, "path": urlPath, "error": err})
+		return false
+	}
+	if len(listing.Entries) == 0 {
+		return false
+	}
+
+	query := req.URL.Query()
+	listing.SortBy(query.Get("sort"), query.Get("order"))
+	setCacheHeaders(w, opts, urlPath)
+	w.Header().Add("Vary", "Accept")
+	if wantsJSON(req) {
+		listing.WriteJSON(w)
+		return true
+	}
+	listing.Write(w, tmpl)
+	return true
+}
+
+// WriteJSON writes the listing as json
+func (l *Listing) WriteJSON(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l)
+}
+
+// Write writes the listing as html
+func (l *Listing) Write(w http.ResponseWriter, tmpl *template.Template) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, l); err != nil {
+		logger.Warn("unable to render listing", Fields{"path": l.Path, "error": err})
+	}
+}
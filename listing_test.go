@@ -0,0 +1,183 @@
+package s3site
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+// fakeListing serves s3 listings of objects, by key, with their sizes;
+// honoring the delimiter, marker, and max-keys
+func fakeListing(objects map[string]int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		prefix, delimiter, marker := query.Get("prefix"), query.Get("delimiter"), query.Get("marker")
+		maxKeys, _ := strconv.Atoi(query.Get("max-keys"))
+
+		names := []string{}
+		seen, dirs := map[string]bool{}, map[string]bool{}
+		for key := range objects {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			name := key
+			if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+				name = key[:len(prefix)+i+1]
+				dirs[name] = true
+			}
+			if !seen[name] && name > marker {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		truncated := len(names) > maxKeys
+		if truncated {
+			names = names[:maxKeys]
+		}
+
+		fmt.Fprintf(w, `<ListBucketResult><Name>bucket</Name><IsTruncated>%v</IsTruncated>`, truncated)
+		for i, name := range names {
+			if dirs[name] {
+				fmt.Fprintf(w, `<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>`, name)
+				continue
+			}
+			fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size><LastModified>2016-01-0%dT10:00:00.000Z</LastModified></Contents>`, name, objects[name], i%9+1)
+		}
+		fmt.Fprint(w, `</ListBucketResult>`)
+	}))
+}
+
+func TestListDirectory(t *testing.T) {
+	objects := map[string]int64{
+		"site/docs/":             0,
+		"site/docs/a.pdf":        2048,
+		"site/docs/b.txt":        10,
+		"site/docs/images/x.png": 5,
+		"site/docs/notes/y.md":   5,
+		"site/other.html":        1,
+	}
+	for i := 0; i < 1500; i++ {
+		objects[fmt.Sprintf("site/big/%04d.txt", i)] = 1
+	}
+	server := fakeListing(objects)
+	defer server.Close()
+	bucket := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{Name: "us-east-1", S3Endpoint: server.URL}).Bucket("bucket")
+
+	listing, err := ListDirectory(bucket, "site/docs/", "/docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range listing.Entries {
+		names = append(names, entry.Path)
+	}
+	if expected := []string{"/docs/images/", "/docs/notes/", "/docs/a.pdf", "/docs/b.txt"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+	if crumbs := listing.Crumbs; len(crumbs) != 2 || crumbs[1] != (Crumb{Name: "docs", Path: "/docs/"}) {
+		t.Errorf("unexpected crumbs, %v", crumbs)
+	}
+
+	listing.SortBy("size", "desc")
+	if listing.Entries[0].Name != "notes/" || listing.Entries[2].Name != "a.pdf" {
+		t.Errorf("expected directories first, then the largest file, got %v", listing.Entries)
+	}
+	if url := listing.SortURL("size"); url != "?order=asc&sort=size" {
+		t.Errorf("expected the sort to be reversible, got %s", url)
+	}
+
+	big, err := ListDirectory(bucket, "site/big/", "/big/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(big.Entries) != 1500 || big.Truncated {
+		t.Errorf("expected every page to be listed, got %d", len(big.Entries))
+	}
+}
+
+func TestListingWrite(t *testing.T) {
+	listing := &Listing{
+		Path:   "/docs/",
+		Crumbs: crumbs("/docs/"),
+		Entries: []ListingEntry{
+			{Name: "images/", Path: "/docs/images/", Dir: true},
+			{Name: "<a>.pdf", Path: "/docs/<a>.pdf", Size: 1536},
+		},
+	}
+	listing.SortBy("", "")
+
+	w := httptest.NewRecorder()
+	listing.Write(w, listingPage)
+	body := w.Body.String()
+	for _, expected := range []string{"Index of /docs/", `href="/docs/images/"`, "1.5 KB", "&lt;a&gt;.pdf", `href="../"`, `?order=desc&amp;sort=name`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in the listing\n%s", expected, body)
+		}
+	}
+
+	dir, _ := ioutil.TempDir("", "listing")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "listing.html")
+	ioutil.WriteFile(path, []byte(`{{range .Entries}}{{.Name}} {{.HumanSize}};{{end}}`), 0644)
+	tmpl, err := LoadListingTemplate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	listing.Write(w, tmpl)
+	if body := w.Body.String(); body != "images/ -;&lt;a&gt;.pdf 1.5 KB;" {
+		t.Errorf("unexpected listing from the template, %s", body)
+	}
+}
+
+func TestListingJSON(t *testing.T) {
+	testCases := map[string]bool{
+		"Accept: application/json":                  true,
+		"Accept: text/html, application/json;q=0.9": true,
+		"Accept: text/html":                         false,
+		"?format=json":                              true,
+		"?format=html":                              false,
+	}
+	for tc, expected := range testCases {
+		req := httptest.NewRequest("GET", "/docs/", nil)
+		if strings.HasPrefix(tc, "?") {
+			req = httptest.NewRequest("GET", "/docs/"+tc, nil)
+		} else {
+			req.Header.Set("Accept", strings.TrimPrefix(tc, "Accept: "))
+		}
+		if actual := wantsJSON(req); actual != expected {
+			t.Errorf("%s: expected %v, got %v", tc, expected, actual)
+		}
+	}
+
+	listing := &Listing{
+		Path: "/docs/",
+		Entries: []ListingEntry{
+			{Name: "images/", Path: "/docs/images/", Dir: true},
+			{Name: "a.pdf", Path: "/docs/a.pdf", Size: 2048, Modified: time.Date(2016, 1, 2, 10, 0, 0, 0, time.UTC)},
+		},
+	}
+	listing.SortBy("", "")
+	w := httptest.NewRecorder()
+	listing.WriteJSON(w)
+	expected := `{"path":"/docs/","entries":[{"name":"images/","path":"/docs/images/","dir":true,"size":0},{"name":"a.pdf","path":"/docs/a.pdf","size":2048,"modified":"2016-01-02T10:00:00Z"}],"sort":"name","order":"asc"}`
+	if body := strings.TrimSpace(w.Body.String()); body != expected {
+		t.Errorf("expected %s, got %s", expected, body)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("unexpected content type, %s", contentType)
+	}
+}
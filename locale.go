@@ -0,0 +1,151 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// localeCookieAge is how long an explicit choice of locale is remembered
+const localeCookieAge = 365 * 24 * 60 * 60
+
+var localeName = regexp.MustCompile(`^[a-zA-Z]{2,8}(-[a-zA-Z0-9]{1,8})*$`)
+
+// Locales sends requests for / to the site's translation, e.g. /fr/, that
+// best matches the Accept-Language header, or the locale chosen explicitly
+// by ?locale= and remembered in Cookie
+type Locales struct {
+	// List holds the locales served, the first being the default
+	List   []string
+	Cookie string
+	// Rewrite serves the translation at / rather than redirecting to it
+	Rewrite bool
+}
+
+// NewLocales returns the locales of list, routed per mode, redirect or
+// rewrite.  It returns nil when there are none.
+func NewLocales(list []string, cookie, mode string) (*Locales, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	l := &Locales{Cookie: cookie}
+	for _, value := range list {
+		for _, locale := range strings.Split(value, ",") {
+			locale = strings.TrimSpace(locale)
+			if !localeName.MatchString(locale) {
+				return nil, fmt.Errorf("invalid locale, %s", locale)
+			}
+			l.List = append(l.List, locale)
+		}
+	}
+	switch mode {
+	case "", "redirect":
+	case "rewrite":
+		l.Rewrite = true
+	default:
+		return nil, fmt.Errorf("unknown locale mode, %s", mode)
+	}
+	return l, nil
+}
+
+// find returns the configured locale named name, ignoring case, or ""
+func (l *Locales) find(name string) string {
+	for _, locale := range l.List {
+		if strings.EqualFold(locale, name) {
+			return locale
+		}
+	}
+	return ""
+}
+
+// Negotiate returns the locale req prefers: its explicit choice, or the
+// best match of its Accept-Language header, or the default
+func (l *Locales) Negotiate(req *http.Request) string {
+	if c, err := req.Cookie(l.Cookie); err == nil {
+		if locale := l.find(c.Value); locale != "" {
+			return locale
+		}
+	}
+
+	best, bestQ := l.List[0], 0.0
+	for _, part := range strings.Split(req.Header.Get("Accept-Language"), ",") {
+		params := strings.Split(part, ";")
+		tag := strings.TrimSpace(params[0])
+		q := 1.0
+		for _, param := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				q, _ = strconv.ParseFloat(kv[1], 64)
+			}
+		}
+		if tag == "" || q <= bestQ {
+			continue
+		}
+		// fr-CA matches fr-CA, then fr
+		locale := l.find(tag)
+		if locale == "" {
+			locale = l.find(strings.SplitN(tag, "-", 2)[0])
+		}
+		if locale != "" {
+			best, bestQ = locale, q
+		}
+	}
+	return best
+}
+
+// Route sends a request for / to its locale, returning the path to serve in
+// its place, or true when it redirected
+func (l *Locales) Route(w http.ResponseWriter, req *http.Request, urlPath string) (string, bool) {
+	if l == nil || urlPath != "/" {
+		return urlPath, false
+	}
+
+	query := req.URL.Query()
+	if locale := l.find(query.Get("locale")); locale != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:   l.Cookie,
+			Value:  locale,
+			Path:   "/",
+			MaxAge: localeCookieAge,
+		})
+		req.AddCookie(&http.Cookie{Name: l.Cookie, Value: locale})
+		query.Del("locale")
+	}
+
+	locale := l.Negotiate(req)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Add("Vary", "Cookie")
+	if l.Rewrite {
+		return "/" + locale + "/", false
+	}
+
+	target := "/" + locale + "/"
+	if encoded := query.Encode(); encoded != "" {
+		target += "?" + encoded
+	}
+	http.Redirect(w, req, target, http.StatusFound)
+	return urlPath, true
+}
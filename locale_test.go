@@ -0,0 +1,91 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalesNegotiate(t *testing.T) {
+	locales, err := NewLocales([]string{"en", "fr,pt-BR"}, "s3site_locale", "redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		AcceptLanguage string
+		Cookie         string
+		Locale         string
+	}{
+		{"", "", "en"},
+		{"fr-CA,fr;q=0.9,en;q=0.8", "", "fr"},
+		{"de,en;q=0.5,fr;q=0.7", "", "fr"},
+		{"pt-br", "", "pt-BR"},
+		{"pt-PT", "", "en"},
+		{"de", "", "en"},
+		{"fr", "pt-BR", "pt-BR"},
+		{"fr", "xx", "fr"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", tc.AcceptLanguage)
+		if tc.Cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "s3site_locale", Value: tc.Cookie})
+		}
+		if locale := locales.Negotiate(req); locale != tc.Locale {
+			t.Errorf("%q, cookie %q: expected %s; got %s", tc.AcceptLanguage, tc.Cookie, tc.Locale, locale)
+		}
+	}
+
+	for _, list := range [][]string{{"en_US"}, {"en", ""}} {
+		if _, err := NewLocales(list, "c", "redirect"); err == nil {
+			t.Errorf("%v: expected an invalid locale to fail", list)
+		}
+	}
+	if _, err := NewLocales([]string{"en"}, "c", "proxy"); err == nil {
+		t.Error("expected an unknown mode to fail")
+	}
+}
+
+func TestLocalesRoute(t *testing.T) {
+	locales, _ := NewLocales([]string{"en", "fr"}, "s3site_locale", "redirect")
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?utm=x", nil)
+	req.Header.Set("Accept-Language", "fr")
+	if _, redirected := locales.Route(w, req, "/"); !redirected || w.Code != http.StatusFound || w.Header().Get("Location") != "/fr/?utm=x" {
+		t.Errorf("expected a redirect to /fr/; got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if vary := w.Header()["Vary"]; len(vary) != 2 || vary[0] != "Accept-Language" || vary[1] != "Cookie" {
+		t.Errorf("expected to vary by Accept-Language and Cookie; got %v", vary)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/?locale=en", nil)
+	req.Header.Set("Accept-Language", "fr")
+	locales.Route(w, req, "/")
+	if w.Header().Get("Location") != "/en/" {
+		t.Errorf("expected the explicit choice to win; got %s", w.Header().Get("Location"))
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Value != "en" {
+		t.Errorf("expected the choice remembered; got %v", cookies)
+	}
+
+	w = httptest.NewRecorder()
+	if urlPath, redirected := locales.Route(w, httptest.NewRequest("GET", "/docs/", nil), "/docs/"); redirected || urlPath != "/docs/" {
+		t.Errorf("expected only / routed; got %s", urlPath)
+	}
+
+	locales.Rewrite = true
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", "fr")
+	if urlPath, redirected := locales.Route(w, req, "/"); redirected || urlPath != "/fr/" || w.Code != http.StatusOK {
+		t.Errorf("expected / rewritten to /fr/; got %s %v", urlPath, redirected)
+	}
+
+	var none *Locales
+	if urlPath, redirected := none.Route(httptest.NewRecorder(), req, "/"); redirected || urlPath != "/" {
+		t.Error("expected nothing routed without locales")
+	}
+}
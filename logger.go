@@ -0,0 +1,242 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// ParseLevel parses one of debug, info, warn, or error
+func ParseLevel(s string) (Level, error) {
+	for level := LevelDebug; level <= LevelError; level++ {
+		if s == level.String() {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level, %s", s)
+}
+
+// ParseSampling parses level=rate pairs e.g. debug=0.01 into per level
+// sampling rates
+func ParseSampling(values []string) (map[Level]float64, error) {
+	rates := map[Level]float64{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid log sample, %s; expected level=rate", value)
+		}
+		level, err := ParseLevel(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid log sample rate, %s; expected 0 to 1", parts[1])
+		}
+		rates[level] = rate
+	}
+	return rates, nil
+}
+
+// levelWriter is implemented by outputs, such as syslog, that record the
+// level of each line
+type levelWriter interface {
+	WriteLevel(level Level, p []byte) (int, error)
+}
+
+// Fields are the structured attributes attached to a log line
+type Fields map[string]interface{}
+
+// Logger writes leveled log lines either as text or as json, one object
+// per line, for ingestion by log aggregators
+type Logger struct {
+	mu    sync.Mutex
+	Out   io.Writer
+	JSON  bool
+	Level Level
+
+	// Sample, if set, keeps only the given fraction of lines at a level
+	Sample map[Level]float64
+}
+
+var logger = &Logger{Out: os.Stderr, Level: LevelInfo}
+
+// SetOutput replaces Out once the logger may be in use
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Out = w
+}
+
+func (l *Logger) Debug(msg string, fields Fields) { l.log(LevelDebug, msg, fields) }
+func (l *Logger) Info(msg string, fields Fields)  { l.log(LevelInfo, msg, fields) }
+func (l *Logger) Warn(msg string, fields Fields)  { l.log(LevelWarn, msg, fields) }
+func (l *Logger) Error(msg string, fields Fields) { l.log(LevelError, msg, fields) }
+
+func (l *Logger) log(level Level, msg string, fields Fields) {
+	if level < l.Level {
+		return
+	}
+	if rate, ok := l.Sample[level]; ok && mathrand.Float64() >= rate {
+		return
+	}
+
+	now := time.Now()
+	var line []byte
+	if l.JSON {
+		record := Fields{}
+		for key, value := range fields {
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			record[key] = value
+		}
+		record["time"] = now.UTC().Format(time.RFC3339Nano)
+		record["level"] = level.String()
+		record["msg"] = msg
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			data, _ = json.Marshal(Fields{"time": record["time"], "level": "error", "msg": "unable to encode log line: " + err.Error()})
+		}
+		line = append(data, '\n')
+
+	} else {
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		text := now.Format("2006/01/02 15:04:05") + " [" + level.String() + "] " + msg
+		for _, key := range keys {
+			value := fmt.Sprint(fields[key])
+			if strings.ContainsAny(value, " \"=") {
+				value = fmt.Sprintf("%q", value)
+			}
+			text += " " + key + "=" + value
+		}
+		line = []byte(text + "\n")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lw, ok := l.Out.(levelWriter); ok {
+		lw.WriteLevel(level, line)
+		return
+	}
+	l.Out.Write(line)
+}
+
+type contextKey int
+
+const requestIDKey contextKey = 0
+
+// requestID returns the id assigned to req by RequestLogger
+func requestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	data := make([]byte, 8)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+// clientIP returns the address of the peer that sent req
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// RequestLogger assigns each request an id, reusing any X-Request-Id the
+// client supplied, and logs a summary line once the request completes
+func RequestLogger(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started := time.Now()
+
+		id := req.Header.Get("X-Request-Id")
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-Id", id)
+		req = req.WithContext(context.WithValue(req.Context(), requestIDKey, id))
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, req)
+
+		fields := Fields{
+			"request_id": id,
+			"method":     req.Method,
+			"path":       req.URL.Path,
+			"status":     recorder.Status(),
+			"duration":   time.Since(started).Seconds(),
+			"bytes":      recorder.bytes,
+			"client_ip":  clientIP(req),
+		}
+		if id := traceID(req); id != "" {
+			fields["trace_id"] = id
+		}
+		logger.Info("request", fields)
+	})
+}
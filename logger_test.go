@@ -0,0 +1,102 @@
+package s3site
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoggerJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := &Logger{Out: buf, JSON: true, Level: LevelInfo}
+	l.Debug("hidden", nil)
+	l.Info("hello", Fields{"path": "/a b"})
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single json line, got %s", buf.String())
+	}
+	if record["level"] != "info" || record["msg"] != "hello" || record["path"] != "/a b" || record["time"] == nil {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := &Logger{Out: buf, Level: LevelDebug}
+	l.Warn("slow", Fields{"path": "/a b", "status": 200})
+
+	if line := buf.String(); !strings.HasSuffix(line, `[warn] slow path="/a b" status=200`+"\n") {
+		t.Errorf("unexpected line %s", line)
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer func(original *Logger) { logger = original }(logger)
+	logger = &Logger{Out: buf, JSON: true, Level: LevelInfo}
+
+	var seen string
+	handler := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = requestID(req)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req, _ := http.NewRequest("GET", "/index.html", nil)
+	req.Header.Set("X-Request-Id", "abc123")
+	req.RemoteAddr = "10.0.0.1:4321"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if seen != "abc123" || w.Header().Get("X-Request-Id") != "abc123" {
+		t.Errorf("expected request id to propagate, got %s", seen)
+	}
+
+	record := map[string]interface{}{}
+	json.Unmarshal(buf.Bytes(), &record)
+	if record["status"] != float64(http.StatusTeapot) || record["client_ip"] != "10.0.0.1" || record["request_id"] != "abc123" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if parsed, err := ParseLevel(level.String()); err != nil || parsed != level {
+			t.Errorf("expected %s to round trip, got %s, %v", level, parsed, err)
+		}
+	}
+	if _, err := ParseLevel("trace"); err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+}
+
+func TestLoggerSampling(t *testing.T) {
+	rates, err := ParseSampling([]string{"debug=0", "info=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseSampling([]string{"debug=2"}); err == nil {
+		t.Errorf("expected an error for a rate above 1")
+	}
+
+	out := &bytes.Buffer{}
+	l := &Logger{Out: out, Level: LevelDebug, Sample: rates}
+	for i := 0; i < 10; i++ {
+		l.Debug("dropped", nil)
+		l.Info("kept", nil)
+		l.Warn("unsampled", nil)
+	}
+
+	if n := strings.Count(out.String(), "dropped"); n != 0 {
+		t.Errorf("expected debug lines to be dropped, got %d", n)
+	}
+	if n := strings.Count(out.String(), "kept"); n != 10 {
+		t.Errorf("expected all info lines, got %d", n)
+	}
+	if n := strings.Count(out.String(), "unsampled"); n != 10 {
+		t.Errorf("expected all warn lines, got %d", n)
+	}
+}
@@ -20,16 +20,19 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-package main
+package s3site
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"log"
-	"mime"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/mitchellh/goamz/aws"
@@ -37,122 +40,1436 @@ import (
 )
 
 type Options struct {
-	Port      string
-	Username  string
-	Password  string
-	Realm     string
-	Bucket    string
-	Prefix    string
-	MaxAge    int
-	Verbose   bool
-	IndexFile string
+	Port              string
+	Listen            []string
+	TLSCert           string
+	TLSKey            string
+	SocketMode        string
+	SocketOwner       string
+	ProxyProtocol     bool
+	TrustedProxies    []string
+	Username          string
+	Password          string
+	Users             map[string]string
+	Realm             string
+	Bucket            string
+	Prefix            string
+	S3Region          string
+	S3AccessKey       string
+	S3SecretKey       string
+	S3RoleARN         string
+	AWSProfile        string
+	Releases          bool
+	ReleasePoll       time.Duration
+	MaxAge            int
+	Verbose           bool
+	LogLevel          string
+	LogSample         []string
+	LogFormat         string
+	AccessLog         string
+	IndexFile         string
+	CacheSize         int
+	CacheDir          string
+	CacheDirSize      int
+	MaxObject         int
+	CacheTypes        []string
+	CacheAdmit        string
+	CacheFresh        time.Duration
+	CacheTTL          []string
+	MaxStale          time.Duration
+	StaleIfError      time.Duration
+	S3Timeout         time.Duration
+	DrainTimeout      time.Duration
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	RequestTimeout    time.Duration
+	VersionHeader     bool
+	Diagnostics       bool
+	AutoIndex         bool
+	AutoIndexTemplate string
+	Markdown          bool
+	MarkdownLayout    string
+	Templates         []string
+	SSI               bool
+	InjectHead        []string
+	InjectBody        []string
+	Images            bool
+	ImageSidecars     bool
+	Minify            []string
+	Feeds             []string
+	FeedURL           string
+	FeedLimit         int
+	FeedRefresh       time.Duration
+	Forms             []string
+	FormRegion        string
+	FormSecret        string
+	Functions         []string
+	Zips              []string
+	ZipMaxSize        int
+	FunctionRegion    string
+	FunctionTimeout   time.Duration
+	FallbackOrigin    string
+	Upstreams         []string
+	UpstreamTimeout   time.Duration
+	Aliases           []string
+	AliasObject       string
+	AliasRefresh      time.Duration
+	Rewrites          []string
+	Locales           []string
+	LocaleCookie      string
+	LocaleMode        string
+	Media             bool
+	MediaPaths        []string
+	Search            bool
+	SearchExclude     []string
+	SearchIndex       string
+	SearchRefresh     time.Duration
+	Sitemap           bool
+	SitemapURL        string
+	SitemapInclude    []string
+	SitemapExclude    []string
+	SitemapRefresh    time.Duration
+	MinifyCacheSize   int
+	ImageMaxSize      int
+	ImageQuality      int
+	ImageCacheSize    int
+	TemplateVars      []string
+	DryRun            bool
+	Daemon            bool
+	DaemonLog         string
+	PidFile           string
+
+	// BluePrefix and GreenPrefix, when set, replace the prefix; Slot names
+	// the one serving traffic and is switched at runtime via /-/switch
+	BluePrefix  string
+	GreenPrefix string
+	Slot        string
+
+	// CanaryPrefix serves CanaryPercent of visitors, adjusted at runtime via
+	// /-/canary; visitors stick to their side by cookie or client address
+	CanaryPrefix  string
+	CanaryPercent int
+	CanarySticky  string
+
+	MimeTypesFile string
+	MimeTypes     []string
+	Sniff         bool
+	DefaultType   string
+	Charset       string
+
+	Variants      []string
+	VariantCookie string
+
+	// Release is the atomic deploy being served, resolved from the release
+	// pointer rather than set by a flag
+	Release      string
+	KeyLowercase bool
+	KeyQuery     []string // deprecated and ignored
+	AdminToken   string
+	Queue        string
+	Warm         []string
+	WarmManifest string
+
+	SurrogateMaxAge    int
+	SurrogateKeyHeader string
+	DeployID           string
+	Distribution       string
+	AdminPort          string
+	OTLPEndpoint       string
+	XRay               bool
+	XRayDaemon         string
+	Pprof              bool
+
+	CloudWatchNamespace string
+	CloudWatchRegion    string
+	CloudWatchInterval  time.Duration
+
+	SentryDSN string
+
+	AlertWebhook       string
+	AlertFormat        string
+	AlertErrorRate     float64
+	AlertS3FailureRate float64
+	AlertAuthFailures  int
+	AlertWindow        time.Duration
+
+	AccessLogFile string
+	ErrorLogFile  string
+	LogMaxSize    int
+	LogMaxAge     time.Duration
+	LogMaxBackups int
+	LogCompress   bool
+
+	SlowRequest time.Duration
+	Pricing     S3Pricing
+	Analytics   bool
+
+	LogsGroup  string
+	LogsStream string
+
+	Syslog         string
+	SyslogFacility string
+	SyslogTag      string
+
+	StatsDAddr     string
+	StatsDPrefix   string
+	StatsDTags     []string
+	StatsDInterval time.Duration
+	DogStatsD      bool
 }
 
 func (o *Options) RequiresAuth() bool {
-	return o.Username != "" && o.Password != ""
+	return (o.Username != "" && o.Password != "") || len(o.Users) > 0
+}
+
+// Authorized returns true if the credentials match the username and password
+// or one of the users from the config file
+func (o *Options) Authorized(username, password string) bool {
+	if expected, ok := o.Users[username]; ok {
+		return password == expected
+	}
+	return o.Username != "" && username == o.Username && password == o.Password
+}
+
+// IsAdmin returns true if the request carries the admin bearer token
+func (o *Options) IsAdmin(req *http.Request) bool {
+	return o.AdminToken != "" && req.Header.Get("Authorization") == "Bearer "+o.AdminToken
+}
+
+// Key maps a request path onto the s3 key that serves it
+func (o *Options) Key(urlPath string) string {
+	key := o.keyPrefix(urlPath)
+	if strings.HasSuffix(urlPath, "/") {
+		key = key + o.IndexFile
+	}
+	return key
+}
+
+func (o *Options) keyPrefix(urlPath string) string {
+	prefix := o.livePrefix()
+	if o.Release != "" {
+		prefix += "/" + releasePath(o.Release)
+	}
+	key := fmt.Sprintf("%s%s", prefix, urlPath)
+	if strings.Contains(key, "//") {
+		key = strings.Replace(key, "//", "/", -1)
+	}
+	if strings.HasPrefix(key, "/") {
+		key = key[1:]
+	}
+	return key
+}
+
+// siteRoot returns the key prefix of the site, under which atomic deploys
+// keep their releases
+func (o *Options) siteRoot() string {
+	root := *o
+	root.Release = ""
+	return root.keyPrefix("/")
+}
+
+// LogFile returns a log file at path rotated per the log options
+func (o *Options) LogFile(path string) *RotatingFile {
+	return &RotatingFile{
+		Path:       path,
+		MaxBytes:   int64(o.LogMaxSize) << 20,
+		MaxAge:     o.LogMaxAge,
+		MaxBackups: o.LogMaxBackups,
+		Compress:   o.LogCompress,
+	}
 }
 
 func Opts(c *cli.Context) *Options {
 	return &Options{
-		Port:      c.String("port"),
-		Username:  c.String("username"),
-		Password:  c.String("password"),
-		Realm:     c.String("realm"),
-		Bucket:    c.String("bucket"),
-		Prefix:    c.String("prefix"),
-		MaxAge:    c.Int("max-age"),
-		Verbose:   c.Bool("verbose"),
-		IndexFile: c.String("index-file"),
+		Port:              c.String("port"),
+		Listen:            c.StringSlice("listen"),
+		TLSCert:           c.String("tls-cert"),
+		TLSKey:            c.String("tls-key"),
+		SocketMode:        c.String("socket-mode"),
+		SocketOwner:       c.String("socket-owner"),
+		ProxyProtocol:     c.Bool("proxy-protocol"),
+		TrustedProxies:    c.StringSlice("trusted-proxies"),
+		Username:          c.String("username"),
+		Password:          c.String("password"),
+		Realm:             c.String("realm"),
+		Bucket:            c.String("bucket"),
+		Prefix:            expandEnv(c.String("prefix")),
+		BluePrefix:        expandEnv(c.String("blue-prefix")),
+		GreenPrefix:       expandEnv(c.String("green-prefix")),
+		Slot:              c.String("live-slot"),
+		CanaryPrefix:      expandEnv(c.String("canary-prefix")),
+		CanaryPercent:     c.Int("canary-percent"),
+		CanarySticky:      c.String("canary-sticky"),
+		MimeTypesFile:     c.String("mime-types"),
+		MimeTypes:         c.StringSlice("mime-type"),
+		AutoIndex:         c.Bool("autoindex"),
+		AutoIndexTemplate: c.String("autoindex-template"),
+		Markdown:          c.Bool("markdown"),
+		MarkdownLayout:    c.String("markdown-layout"),
+		Templates:         c.StringSlice("template"),
+		SSI:               c.Bool("ssi"),
+		InjectHead:        c.StringSlice("inject-head"),
+		InjectBody:        c.StringSlice("inject-body"),
+		Images:            c.Bool("images"),
+		ImageSidecars:     c.Bool("image-sidecars"),
+		Minify:            c.StringSlice("minify"),
+		Feeds:             c.StringSlice("feed"),
+		FeedURL:           c.String("feed-url"),
+		FeedLimit:         c.Int("feed-limit"),
+		FeedRefresh:       c.Duration("feed-refresh"),
+		Forms:             c.StringSlice("form"),
+		FormRegion:        c.String("form-region"),
+		FormSecret:        expandEnv(c.String("form-secret")),
+		Functions:         c.StringSlice("function"),
+		Zips:              c.StringSlice("zip"),
+		ZipMaxSize:        c.Int("zip-max-size"),
+		FunctionRegion:    c.String("function-region"),
+		FunctionTimeout:   c.Duration("function-timeout"),
+		FallbackOrigin:    c.String("fallback-origin"),
+		Upstreams:         c.StringSlice("upstream"),
+		UpstreamTimeout:   c.Duration("upstream-timeout"),
+		Aliases:           c.StringSlice("alias"),
+		AliasObject:       c.String("alias-object"),
+		AliasRefresh:      c.Duration("alias-refresh"),
+		Rewrites:          c.StringSlice("rewrite"),
+		Locales:           c.StringSlice("locale"),
+		LocaleCookie:      c.String("locale-cookie"),
+		LocaleMode:        c.String("locale-mode"),
+		Media:             c.Bool("media"),
+		MediaPaths:        c.StringSlice("media-path"),
+		Search:            c.Bool("search"),
+		SearchExclude:     c.StringSlice("search-exclude"),
+		SearchIndex:       c.String("search-index"),
+		SearchRefresh:     c.Duration("search-refresh"),
+		Sitemap:           c.Bool("sitemap"),
+		SitemapURL:        c.String("sitemap-url"),
+		SitemapInclude:    c.StringSlice("sitemap-include"),
+		SitemapExclude:    c.StringSlice("sitemap-exclude"),
+		SitemapRefresh:    c.Duration("sitemap-refresh"),
+		MinifyCacheSize:   c.Int("minify-cache-size"),
+		ImageMaxSize:      c.Int("image-max-size"),
+		ImageQuality:      c.Int("image-quality"),
+		ImageCacheSize:    c.Int("image-cache-size"),
+		TemplateVars:      c.StringSlice("template-var"),
+		Sniff:             c.BoolT("sniff"),
+		DefaultType:       c.String("default-type"),
+		Charset:           c.String("charset"),
+		Variants:          c.StringSlice("variant"),
+		VariantCookie:     c.String("variant-cookie"),
+		S3Region:          c.String("s3-region"),
+		S3AccessKey:       c.String("s3-access-key"),
+		S3SecretKey:       c.String("s3-secret-key"),
+		S3RoleARN:         c.String("s3-role-arn"),
+		AWSProfile:        c.String("aws-profile"),
+		Releases:          c.Bool("releases"),
+		ReleasePoll:       c.Duration("release-poll"),
+		MaxAge:            c.Int("max-age"),
+		Verbose:           c.Bool("verbose"),
+		LogLevel:          c.String("log-level"),
+		LogSample:         c.StringSlice("log-sample"),
+		LogFormat:         c.String("log-format"),
+		AccessLog:         c.String("access-log"),
+		IndexFile:         c.String("index-file"),
+		CacheSize:         c.Int("cache-size"),
+		CacheDir:          c.String("cache-dir"),
+		CacheDirSize:      c.Int("cache-dir-size"),
+		MaxObject:         c.Int("cache-max-object"),
+		CacheTypes:        c.StringSlice("cache-content-type"),
+		CacheAdmit:        c.String("cache-admission"),
+		CacheFresh:        c.Duration("cache-fresh"),
+		CacheTTL:          c.StringSlice("cache-ttl"),
+		MaxStale:          c.Duration("cache-max-stale"),
+		StaleIfError:      c.Duration("cache-stale-if-error"),
+		S3Timeout:         c.Duration("s3-timeout"),
+		DrainTimeout:      c.Duration("drain-timeout"),
+		ReadTimeout:       c.Duration("read-timeout"),
+		ReadHeaderTimeout: c.Duration("read-header-timeout"),
+		WriteTimeout:      c.Duration("write-timeout"),
+		IdleTimeout:       c.Duration("idle-timeout"),
+		RequestTimeout:    c.Duration("request-timeout"),
+		VersionHeader:     c.Bool("version-header"),
+		Diagnostics:       c.Bool("diagnostics"),
+		DryRun:            c.Bool("dry-run"),
+		Daemon:            c.Bool("daemon"),
+		DaemonLog:         c.String("daemon-log"),
+		PidFile:           c.String("pidfile"),
+		KeyLowercase:      c.Bool("cache-key-lowercase"),
+		KeyQuery:          c.StringSlice("cache-key-query"),
+		AdminToken:        c.String("admin-token"),
+		Queue:             c.String("invalidation-queue"),
+		Warm:              c.StringSlice("warm"),
+		WarmManifest:      c.String("warm-manifest"),
+
+		SurrogateMaxAge:    c.Int("surrogate-max-age"),
+		SurrogateKeyHeader: c.String("surrogate-key-header"),
+		DeployID:           c.String("deploy-id"),
+		Distribution:       c.String("cloudfront-distribution"),
+		AdminPort:          c.String("admin-port"),
+		OTLPEndpoint:       c.String("otlp-endpoint"),
+		XRay:               c.Bool("xray"),
+		XRayDaemon:         c.String("xray-daemon"),
+		Pprof:              c.Bool("pprof"),
+
+		CloudWatchNamespace: c.String("cloudwatch-namespace"),
+		CloudWatchRegion:    c.String("cloudwatch-region"),
+		CloudWatchInterval:  c.Duration("cloudwatch-interval"),
+
+		SentryDSN: c.String("sentry-dsn"),
+
+		AlertWebhook:       c.String("alert-webhook"),
+		AlertFormat:        c.String("alert-format"),
+		AlertErrorRate:     c.Float64("alert-5xx-rate"),
+		AlertS3FailureRate: c.Float64("alert-s3-failure-rate"),
+		AlertAuthFailures:  c.Int("alert-auth-failures"),
+		AlertWindow:        c.Duration("alert-window"),
+
+		AccessLogFile: c.String("access-log-file"),
+		ErrorLogFile:  c.String("error-log-file"),
+		LogMaxSize:    c.Int("log-max-size"),
+		LogMaxAge:     c.Duration("log-max-age"),
+		LogMaxBackups: c.Int("log-max-backups"),
+		LogCompress:   c.Bool("log-compress"),
+
+		SlowRequest: c.Duration("slow-request"),
+		Pricing: S3Pricing{
+			GetPer1000:  c.Float64("s3-price-get"),
+			ListPer1000: c.Float64("s3-price-list"),
+			EgressPerGB: c.Float64("s3-price-egress"),
+		},
+		Analytics: c.Bool("analytics"),
+
+		LogsGroup:  c.String("cloudwatch-logs-group"),
+		LogsStream: c.String("cloudwatch-logs-stream"),
+
+		Syslog:         c.String("syslog"),
+		SyslogFacility: c.String("syslog-facility"),
+		SyslogTag:      c.String("syslog-tag"),
+
+		StatsDAddr:     c.String("statsd-addr"),
+		StatsDPrefix:   c.String("statsd-prefix"),
+		StatsDTags:     c.StringSlice("statsd-tag"),
+		StatsDInterval: c.Duration("statsd-interval"),
+		DogStatsD:      c.Bool("dogstatsd"),
 	}
 }
 
-func main() {
-	app := cli.NewApp()
-	app.Flags = []cli.Flag{
-		cli.StringFlag{"port", "8080", "port to run on", "PORT"},
-		cli.StringFlag{"username", "", "the username to prompt for", "USERNAME"},
-		cli.StringFlag{"password", "", "the password to prompt for", "PASSWORD"},
-		cli.StringFlag{"realm", "Realm", "the challenge realm", "REALM"},
-		cli.StringFlag{"bucket", "", "the name of the s3 bucket to serve from", "BUCKET"},
-		cli.StringFlag{"prefix", "", "the optional prefix to serve from e.g. s3://bucket/prefix/...", "PREFIX"},
-		cli.IntFlag{"max-age", 90, "the cache-control header; max-age", "MAX_AGE"},
-		cli.BoolFlag{"verbose", "enable enhanced logging", "VERBOSE"},
-		cli.StringFlag{"index-file", "index.html", "file to search for indexes", "INDEX"},
+// Flags returns the command line flags; each may also be set through an
+// S3SITE_ prefixed environment variable or a config file
+func Flags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{"config", "", "yaml or toml file of flag values plus users; flags and the environment override it", "S3SITE_CONFIG"},
+		cli.BoolFlag{"dry-run", "print the resolved configuration, routes, auth, headers, and cache policies, then exit", "S3SITE_DRY_RUN"},
+		cli.BoolFlag{"daemon", "run in the background, detached from the terminal; see stop and status", "S3SITE_DAEMON"},
+		cli.StringFlag{"daemon-log", "s3site.log", "file the daemon's stdout and stderr are appended to", "S3SITE_DAEMON_LOG"},
+		cli.StringFlag{"pidfile", "", "file to write the process id to, as read by stop and status", "S3SITE_PIDFILE"},
+		cli.StringFlag{"port", "8080", "port to run on", "S3SITE_PORT"},
+		cli.StringSliceFlag{"listen", &cli.StringSlice{}, "address to serve on, repeatable; host:port, [::1]:port, tcp4:// or tcp6://host:port, tls://host:port, or unix:/path/to.sock, prefixed admin= for the admin listener; overrides port", "S3SITE_LISTEN"},
+		cli.StringFlag{"tls-cert", "", "pem certificate for tls:// listeners", "S3SITE_TLS_CERT"},
+		cli.StringFlag{"tls-key", "", "pem private key for tls:// listeners", "S3SITE_TLS_KEY"},
+		cli.StringFlag{"socket-mode", "0660", "permissions of a unix socket listener", "S3SITE_SOCKET_MODE"},
+		cli.StringFlag{"socket-owner", "", "owner of a unix socket listener as user or user:group", "S3SITE_SOCKET_OWNER"},
+		cli.BoolFlag{"proxy-protocol", "expect a PROXY protocol v1 or v2 header on site connections, as sent by an AWS NLB or haproxy", "S3SITE_PROXY_PROTOCOL"},
+		cli.StringSliceFlag{"trusted-proxies", &cli.StringSlice{}, "cidrs of proxies whose X-Forwarded-For and X-Real-IP headers name the client e.g. 10.0.0.0/8", "S3SITE_TRUSTED_PROXIES"},
+		cli.StringFlag{"username", "", "the username to prompt for", "S3SITE_USERNAME"},
+		cli.StringFlag{"password", "", "the password to prompt for", "S3SITE_PASSWORD"},
+		cli.StringFlag{"admin-port", "", "port for the admin listener serving a dashboard, /metrics, /healthz, and /debug/vars; disabled when empty", "S3SITE_ADMIN_PORT"},
+		cli.BoolFlag{"pprof", "serve /debug/pprof/ on the admin listener; requires admin-port and admin-token", "S3SITE_PPROF"},
+		cli.StringFlag{"realm", "Realm", "the challenge realm", "S3SITE_REALM"},
+		cli.StringFlag{"bucket", "", "the name of the s3 bucket to serve from", "S3SITE_BUCKET"},
+		cli.StringFlag{"prefix", "", "the optional prefix to serve from e.g. s3://bucket/prefix/...; may include {env:NAME}, {date:2006-01-02}, or {header:X-Tenant}", "S3SITE_PREFIX"},
+		cli.StringFlag{"blue-prefix", "", "the blue prefix for blue/green deploys; replaces prefix, with the live one switched by POST /-/switch", "S3SITE_BLUE_PREFIX"},
+		cli.StringFlag{"green-prefix", "", "the green prefix for blue/green deploys; replaces prefix, with the live one switched by POST /-/switch", "S3SITE_GREEN_PREFIX"},
+		cli.StringFlag{"canary-prefix", "", "a prefix serving canary-percent of visitors, e.g. a new build, while the rest are served the prefix", "S3SITE_CANARY_PREFIX"},
+		cli.IntFlag{"canary-percent", 0, "the percent of visitors, 0-100, served the canary-prefix; adjusted at runtime by POST /-/canary", "S3SITE_CANARY_PERCENT"},
+		cli.StringFlag{"canary-sticky", "cookie", "how visitors stick to one side of the canary split; cookie or ip", "S3SITE_CANARY_STICKY"},
+		cli.BoolFlag{"autoindex", "list the files in directories without an index file", "S3SITE_AUTOINDEX"},
+		cli.StringFlag{"autoindex-template", "", "an html/template file rendering directory listings, in place of the built in one", "S3SITE_AUTOINDEX_TEMPLATE"},
+		cli.BoolFlag{"markdown", "render .md files as html", "S3SITE_MARKDOWN"},
+		cli.StringFlag{"markdown-layout", "", "an html/template file laying out rendered markdown, in place of the built in one", "S3SITE_MARKDOWN_LAYOUT"},
+		cli.BoolFlag{"ssi", "expand server side includes, <!--#include virtual=\"/partials/nav.html\" -->, in html pages", "S3SITE_SSI"},
+		cli.StringSliceFlag{"inject-head", &cli.StringSlice{}, "html injected before </head> of html pages, or @file to read it from a file; repeatable", "S3SITE_INJECT_HEAD"},
+		cli.StringSliceFlag{"inject-body", &cli.StringSlice{}, "html injected before </body> of html pages, or @file to read it from a file; repeatable", "S3SITE_INJECT_BODY"},
+		cli.BoolFlag{"images", "resize jpeg, png, and gif images on request e.g. /photo.jpg?w=400&h=300&q=70", "S3SITE_IMAGES"},
+		cli.BoolFlag{"image-sidecars", "serve photo.jpg.avif or photo.jpg.webp in place of photo.jpg to clients that accept them", "S3SITE_IMAGE_SIDECARS"},
+		cli.StringSliceFlag{"feed", &cli.StringSlice{}, "serve rss.xml and atom.xml feeds of the posts beneath a path, as /path/ or /path/=title; repeatable", "S3SITE_FEED"},
+		cli.StringFlag{"feed-url", "", "scheme and host of feed urls e.g. https://www.example.com; the request's when empty", "S3SITE_FEED_URL"},
+		cli.IntFlag{"feed-limit", 20, "the most recent posts listed in each feed", "S3SITE_FEED_LIMIT"},
+		cli.DurationFlag{"feed-refresh", 15 * time.Minute, "how often feeds are rebuilt; they're also rebuilt on reload and whenever a release or slot is deployed", "S3SITE_FEED_REFRESH"},
+		cli.StringFlag{"fallback-origin", "", "url of an origin e.g. https://old.example.com that paths missing from the bucket are proxied to", "S3SITE_FALLBACK_ORIGIN"},
+		cli.StringSliceFlag{"form", &cli.StringSlice{}, "accept form submissions POSTed to a path, as path=ses:address, path=sns:topic-arn, or path=sqs:queue-url, optionally followed by ;required=a,b ;email=field ;redirect=/thanks/ ;subject=text ;from=address ;honeypot=field ;min-time=3s ;rate=5/1h ;captcha=recaptcha:secret or hcaptcha:secret; repeatable", "S3SITE_FORM"},
+		cli.StringFlag{"form-region", "us-east-1", "region of the ses api forms email through", "S3SITE_FORM_REGION"},
+		cli.StringFlag{"form-secret", "", "key signing the cookies that time form submissions, shared by instances behind one load balancer; random when empty", "S3SITE_FORM_SECRET"},
+		cli.StringSliceFlag{"zip", &cli.StringSlice{}, "directories, and those beneath them, that may be downloaded as a zip archive with ?download=zip e.g. /artifacts/; / allows any; repeatable", "S3SITE_ZIP"},
+		cli.IntFlag{"zip-max-size", 1024, "the most MB a zip archive may hold; 0 allows any", "S3SITE_ZIP_MAX_SIZE"},
+		cli.StringSliceFlag{"function", &cli.StringSlice{}, "invoke a lambda function with the POST and PUT requests to a path, as path=name e.g. /api/subscribe=subscribe:live, optionally followed by ;methods=POST,PUT,PATCH,DELETE; repeatable", "S3SITE_FUNCTION"},
+		cli.StringFlag{"function-region", "us-east-1", "region of functions given by name rather than arn", "S3SITE_FUNCTION_REGION"},
+		cli.DurationFlag{"function-timeout", 30 * time.Second, "how long functions have to respond", "S3SITE_FUNCTION_TIMEOUT"},
+		cli.StringSliceFlag{"upstream", &cli.StringSlice{}, "proxy the paths beneath a prefix to a backend, as prefix=url e.g. /api/=http://10.0.0.5:8080; a path on the url replaces the prefix; repeatable", "S3SITE_UPSTREAM"},
+		cli.DurationFlag{"upstream-timeout", 30 * time.Second, "how long upstreams have to connect, and then to respond", "S3SITE_UPSTREAM_TIMEOUT"},
+		cli.StringSliceFlag{"alias", &cli.StringSlice{}, "short path served as, or redirected to, another, as \"from to [status]\" e.g. \"/install /downloads/install.sh\" or \"/gh https://github.com/me 301\"; repeatable", "S3SITE_ALIAS"},
+		cli.StringFlag{"alias-object", "", "key, relative to the prefix, of an object listing further aliases one per line e.g. _aliases", "S3SITE_ALIAS_OBJECT"},
+		cli.DurationFlag{"alias-refresh", time.Minute, "how often the alias object is read again", "S3SITE_ALIAS_REFRESH"},
+		cli.StringSliceFlag{"rewrite", &cli.StringSlice{}, "serve the key of another path, as \"pattern replacement [last] [redirect[=301]] [header:name~pattern] [cookie:name=value]\" e.g. \"^/blog/(\\d+)/(.*)$ /posts/$1-$2 last\"; applied in order, repeatable", "S3SITE_REWRITE"},
+		cli.StringSliceFlag{"locale", &cli.StringSlice{}, "locale served beneath /locale/ e.g. en or pt-BR; requests for / go to the best match of Accept-Language, the first by default; repeatable", "S3SITE_LOCALE"},
+		cli.StringFlag{"locale-cookie", "s3site_locale", "cookie remembering a locale chosen with /?locale=", "S3SITE_LOCALE_COOKIE"},
+		cli.StringFlag{"locale-mode", "redirect", "how requests for / reach their locale; redirect, or rewrite to serve it at /", "S3SITE_LOCALE_MODE"},
+		cli.BoolFlag{"media", "stream audio, video, hls, and dash files with ranges, correct types, and no buffering", "S3SITE_MEDIA"},
+		cli.StringSliceFlag{"media-path", &cli.StringSlice{}, "pattern of paths streamed as media regardless of extension e.g. /video/; repeatable", "S3SITE_MEDIA_PATH"},
+		cli.BoolFlag{"search", "serve /-/search?q=, searching the site's html, markdown, and text pages", "S3SITE_SEARCH"},
+		cli.StringSliceFlag{"search-exclude", &cli.StringSlice{}, "pattern of pages left out of search e.g. /drafts/ or 404.html; repeatable", "S3SITE_SEARCH_EXCLUDE"},
+		cli.StringFlag{"search-index", "", "key, relative to the prefix, the search index is persisted to e.g. _search/index.json; in memory only when empty", "S3SITE_SEARCH_INDEX"},
+		cli.DurationFlag{"search-refresh", 15 * time.Minute, "how often the search index is refreshed; it's also refreshed on reload and whenever a release or slot is deployed", "S3SITE_SEARCH_REFRESH"},
+		cli.BoolFlag{"sitemap", "serve /sitemap.xml, built from the bucket listing", "S3SITE_SITEMAP"},
+		cli.StringFlag{"sitemap-url", "", "scheme and host of sitemap urls e.g. https://www.example.com; the request's when empty", "S3SITE_SITEMAP_URL"},
+		cli.StringSliceFlag{"sitemap-include", &cli.StringSlice{}, "pattern of pages listed in the sitemap e.g. *.html or /docs/; repeatable, *.html when none", "S3SITE_SITEMAP_INCLUDE"},
+		cli.StringSliceFlag{"sitemap-exclude", &cli.StringSlice{}, "pattern of pages left out of the sitemap e.g. /drafts/ or 404.html; repeatable", "S3SITE_SITEMAP_EXCLUDE"},
+		cli.DurationFlag{"sitemap-refresh", time.Hour, "how often the sitemap is rebuilt; it's also rebuilt on reload and whenever a release or slot is deployed", "S3SITE_SITEMAP_REFRESH"},
+		cli.StringSliceFlag{"minify", &cli.StringSlice{}, "minify html, css, or js as it's served; repeatable or comma separated", "S3SITE_MINIFY"},
+		cli.IntFlag{"minify-cache-size", 16, "MB of minified files to keep", "S3SITE_MINIFY_CACHE_SIZE"},
+		cli.IntFlag{"image-max-size", 2048, "the largest width or height, in pixels, images are resized to", "S3SITE_IMAGE_MAX_SIZE"},
+		cli.IntFlag{"image-quality", 80, "the jpeg quality of resized images when the request gives no q", "S3SITE_IMAGE_QUALITY"},
+		cli.IntFlag{"image-cache-size", 64, "MB of resized images to keep", "S3SITE_IMAGE_CACHE_SIZE"},
+		cli.StringSliceFlag{"template", &cli.StringSlice{}, "files rendered as Go html templates, by path, directory, or file name pattern as for cache-ttl e.g. /index.html or *.tmpl.html; repeatable", "S3SITE_TEMPLATE"},
+		cli.StringSliceFlag{"template-var", &cli.StringSlice{}, "a variable for templates, name=value, read as {{.Vars.name}}; the value may include {env:NAME} or {header:X-Name}; repeatable", "S3SITE_TEMPLATE_VAR"},
+		cli.StringFlag{"mime-types", "", "a mime.types file of content types and their extensions, consulted before the built in types", "S3SITE_MIME_TYPES"},
+		cli.StringSliceFlag{"mime-type", &cli.StringSlice{}, "the content type for an extension, .ext=type e.g. .glb=model/gltf-binary; repeatable, and takes precedence over mime-types", "S3SITE_MIME_TYPE"},
+		cli.BoolTFlag{"sniff", "sniff the content type of files when neither their extension nor s3 gives one", "S3SITE_SNIFF"},
+		cli.StringFlag{"default-type", "application/octet-stream", "the content type for files with an unknown extension; when empty, browsers guess", "S3SITE_DEFAULT_TYPE"},
+		cli.StringFlag{"charset", "utf-8", "the charset added to text content types that don't name one; disabled when empty", "S3SITE_CHARSET"},
+		cli.StringSliceFlag{"variant", &cli.StringSlice{}, "an experiment variant served from its own prefix, name=prefix or name:weight=prefix; repeatable, with visitors assigned one by cookie", "S3SITE_VARIANT"},
+		cli.StringFlag{"variant-cookie", "s3site_variant", "the cookie holding the visitor's variant; rename it to reassign visitors for a new experiment", "S3SITE_VARIANT_COOKIE"},
+		cli.StringFlag{"live-slot", SlotBlue, "the blue/green slot live at startup; blue or green", "S3SITE_LIVE_SLOT"},
+		cli.StringFlag{"s3-region", "", "aws region of the bucket; defaults to the aws-profile's region, else us-east-1", "S3SITE_S3_REGION"},
+		cli.StringFlag{"s3-access-key", "", "aws access key for the bucket; defaults to AWS_ACCESS_KEY_ID", "S3SITE_S3_ACCESS_KEY"},
+		cli.StringFlag{"s3-secret-key", "", "aws secret key for the bucket; defaults to AWS_SECRET_ACCESS_KEY", "S3SITE_S3_SECRET_KEY"},
+		cli.StringFlag{"s3-role-arn", "", "arn of an iam role to assume for reading the bucket e.g. one in another account", "S3SITE_S3_ROLE_ARN"},
+		cli.StringFlag{"aws-profile", "", "profile in ~/.aws/credentials and ~/.aws/config to read the bucket with, honoring its region and role_arn", "S3SITE_AWS_PROFILE"},
+		cli.BoolFlag{"releases", "serve the release named by current.json under the prefix, as written by deploy --atomic", "S3SITE_RELEASES"},
+		cli.DurationFlag{"release-poll", 10 * time.Second, "how often to check current.json for a new release", "S3SITE_RELEASE_POLL"},
+		cli.IntFlag{"max-age", 90, "the cache-control header; max-age", "S3SITE_MAX_AGE"},
+		cli.BoolFlag{"verbose", "enable enhanced logging; deprecated, equivalent to --log-level debug", "S3SITE_VERBOSE"},
+		cli.StringFlag{"log-level", "info", "minimum level logged; debug, info, warn, or error", "S3SITE_LOG_LEVEL"},
+		cli.StringSliceFlag{"log-sample", &cli.StringSlice{}, "fraction of lines to keep at a level e.g. debug=0.01", "S3SITE_LOG_SAMPLE"},
+		cli.StringFlag{"log-format", "text", "log output format; text or json", "S3SITE_LOG_FORMAT"},
+		cli.StringFlag{"access-log", "none", "access log written to stdout; none, common, or combined", "S3SITE_ACCESS_LOG"},
+		cli.StringFlag{"access-log-file", "", "write the access log to this file rather than stdout", "S3SITE_ACCESS_LOG_FILE"},
+		cli.StringFlag{"error-log-file", "", "write the application log to this file rather than stderr", "S3SITE_ERROR_LOG_FILE"},
+		cli.IntFlag{"log-max-size", 100, "rotate log files once they exceed this size in MB; 0 disables", "S3SITE_LOG_MAX_SIZE"},
+		cli.DurationFlag{"log-max-age", 24 * time.Hour, "rotate log files once they have been open this long; 0 disables", "S3SITE_LOG_MAX_AGE"},
+		cli.IntFlag{"log-max-backups", 7, "number of rotated log files to keep; 0 keeps all", "S3SITE_LOG_MAX_BACKUPS"},
+		cli.BoolFlag{"log-compress", "gzip rotated log files", "S3SITE_LOG_COMPRESS"},
+		cli.DurationFlag{"slow-request", time.Second, "log a warning with a latency breakdown for requests slower than this; 0 disables", "S3SITE_SLOW_REQUEST"},
+		cli.Float64Flag{"s3-price-get", 0.0004, "s3 price in dollars per 1000 GET or HEAD requests, for cost estimates", "S3SITE_S3_PRICE_GET"},
+		cli.Float64Flag{"s3-price-list", 0.005, "s3 price in dollars per 1000 LIST requests, for cost estimates", "S3SITE_S3_PRICE_LIST"},
+		cli.Float64Flag{"s3-price-egress", 0.09, "s3 price in dollars per GB transferred out; 0 when running in the bucket's region", "S3SITE_S3_PRICE_EGRESS"},
+		cli.BoolFlag{"analytics", "keep 24 hours of traffic aggregates, reported to admins at /-/analytics", "S3SITE_ANALYTICS"},
+		cli.StringFlag{"cloudwatch-logs-group", "", "ship access and application logs to this cloudwatch logs group rather than stdout/stderr", "S3SITE_CLOUDWATCH_LOGS_GROUP"},
+		cli.StringFlag{"cloudwatch-logs-stream", "", "cloudwatch logs stream; defaults to the hostname", "S3SITE_CLOUDWATCH_LOGS_STREAM"},
+		cli.StringFlag{"syslog", "", "send access and application logs to syslog rather than stdout/stderr; local, udp://host:port, or tcp://host:port", "S3SITE_SYSLOG"},
+		cli.StringFlag{"syslog-facility", "local0", "syslog facility e.g. daemon or local0 through local7", "S3SITE_SYSLOG_FACILITY"},
+		cli.StringFlag{"syslog-tag", "s3site", "syslog app name", "S3SITE_SYSLOG_TAG"},
+		cli.BoolFlag{"xray", "send traces to the aws x-ray daemon", "S3SITE_XRAY"},
+		cli.StringFlag{"xray-daemon", "127.0.0.1:2000", "address of the x-ray daemon", "S3SITE_XRAY_DAEMON"},
+		cli.StringFlag{"otlp-endpoint", "", "otlp/http endpoint to export traces to e.g. http://localhost:4318/v1/traces", "S3SITE_OTLP_ENDPOINT"},
+		cli.StringFlag{"index-file", "index.html", "file to search for indexes", "S3SITE_INDEX_FILE"},
+		cli.IntFlag{"cache-size", 0, "size of the in-memory cache in MB; 0 disables caching", "S3SITE_CACHE_SIZE"},
+		cli.StringFlag{"cache-dir", "", "directory for an on-disk cache tier that survives restarts; requires cache-size", "S3SITE_CACHE_DIR"},
+		cli.IntFlag{"cache-dir-size", 1024, "size of the on-disk cache in MB", "S3SITE_CACHE_DIR_SIZE"},
+		cli.IntFlag{"cache-max-object", 0, "largest object to cache in KB; larger objects are streamed; 0 means no limit", "S3SITE_CACHE_MAX_OBJECT"},
+		cli.StringSliceFlag{"cache-content-type", &cli.StringSlice{}, "content type eligible for caching e.g. text/*; all types when unset", "S3SITE_CACHE_CONTENT_TYPE"},
+		cli.StringFlag{"cache-admission", "always", "cache admission policy; always or tinylfu", "S3SITE_CACHE_ADMISSION"},
+		cli.StringFlag{"invalidation-queue", "", "url of an sqs queue receiving s3 event notifications used to invalidate the cache", "S3SITE_INVALIDATION_QUEUE"},
+		cli.StringSliceFlag{"warm", &cli.StringSlice{}, "path to prefetch into the cache as serving starts", "S3SITE_WARM"},
+		cli.StringFlag{"warm-manifest", "", "s3 key of a file listing paths to prefetch into the cache as serving starts, one per line", "S3SITE_WARM_MANIFEST"},
+		cli.StringFlag{"admin-token", "", "bearer token for the /-/ admin endpoints; admin endpoints are disabled when empty", "S3SITE_ADMIN_TOKEN"},
+		cli.DurationFlag{"cache-fresh", 30 * time.Second, "how long cached objects are served before being revalidated in the background", "S3SITE_CACHE_FRESH"},
+		cli.StringSliceFlag{"cache-ttl", &cli.StringSlice{}, "cache freshness by path or content type e.g. text/html=30s, *.png=1h, /assets/=forever", "S3SITE_CACHE_TTL"},
+		cli.DurationFlag{"cache-max-stale", 0, "how long past its ttl a cached object is served while revalidating in the background; 0 means indefinitely", "S3SITE_CACHE_MAX_STALE"},
+		cli.DurationFlag{"cache-stale-if-error", 24 * time.Hour, "how long past max-stale a cached object is served when s3 is unavailable", "S3SITE_CACHE_STALE_IF_ERROR"},
+		cli.BoolFlag{"cache-key-lowercase", "lowercase request paths, and the s3 keys fetched, so differently cased urls share one object; objects must be stored under lower case keys", "S3SITE_CACHE_KEY_LOWERCASE"},
+		cli.StringSliceFlag{"cache-key-query", &cli.StringSlice{}, "deprecated and ignored; the query never changes the object fetched, so never distinguishes cache entries", "S3SITE_CACHE_KEY_QUERY"},
+		cli.IntFlag{"surrogate-max-age", 0, "the cdn facing Surrogate-Control header; max-age, 0 omits the header", "S3SITE_SURROGATE_MAX_AGE"},
+		cli.StringFlag{"surrogate-key-header", "", "header to carry purge tags for a cdn e.g. Surrogate-Key or Cache-Tag", "S3SITE_SURROGATE_KEY_HEADER"},
+		cli.StringFlag{"deploy-id", "", "identifier of the current deploy, included as a surrogate key", "S3SITE_DEPLOY_ID"},
+		cli.StringFlag{"cloudfront-distribution", "", "id of a cloudfront distribution to invalidate whenever the cache is purged", "S3SITE_CLOUDFRONT_DISTRIBUTION"},
+		cli.DurationFlag{"s3-timeout", 10 * time.Second, "how long to wait for s3 to respond", "S3SITE_S3_TIMEOUT"},
+		cli.DurationFlag{"drain-timeout", 30 * time.Second, "on SIGTERM or SIGINT, how long to wait for in flight requests before exiting", "S3SITE_DRAIN_TIMEOUT"},
+		cli.DurationFlag{"read-header-timeout", 10 * time.Second, "time allowed to read a request's headers", "S3SITE_READ_HEADER_TIMEOUT"},
+		cli.DurationFlag{"read-timeout", 30 * time.Second, "time allowed to read a whole request, body included", "S3SITE_READ_TIMEOUT"},
+		cli.DurationFlag{"write-timeout", 0, "time allowed from the end of the request headers to the end of the response; 0 for none, so large objects can stream", "S3SITE_WRITE_TIMEOUT"},
+		cli.DurationFlag{"idle-timeout", 2 * time.Minute, "how long a keep-alive connection may wait for its next request", "S3SITE_IDLE_TIMEOUT"},
+		cli.DurationFlag{"request-timeout", 0, "overall deadline for serving each request, s3 fetch included; 0 for none", "S3SITE_REQUEST_TIMEOUT"},
+		cli.BoolFlag{"diagnostics", "answer requests s3 can't serve with a page explaining why e.g. a missing index file or denied access; reveals the bucket layout, so only for setting up", "S3SITE_DIAGNOSTICS"},
+		cli.BoolFlag{"version-header", "identify the version in the Server and X-S3Site-Version headers of each response", "S3SITE_VERSION_HEADER"},
+		cli.StringFlag{"cloudwatch-namespace", "", "publish request metrics to this cloudwatch namespace; disabled when empty", "S3SITE_CLOUDWATCH_NAMESPACE"},
+		cli.StringFlag{"cloudwatch-region", "us-east-1", "region to publish cloudwatch metrics and logs to", "S3SITE_CLOUDWATCH_REGION"},
+		cli.DurationFlag{"cloudwatch-interval", time.Minute, "how often to publish cloudwatch metrics", "S3SITE_CLOUDWATCH_INTERVAL"},
+		cli.StringFlag{"sentry-dsn", "", "report panics and 5xx responses to this sentry dsn", "S3SITE_SENTRY_DSN"},
+		cli.StringFlag{"alert-webhook", "", "url to post alerts to when a threshold is crossed; disabled when empty", "S3SITE_ALERT_WEBHOOK"},
+		cli.StringFlag{"alert-format", "json", "alert payload; json or slack", "S3SITE_ALERT_FORMAT"},
+		cli.Float64Flag{"alert-5xx-rate", 0.05, "alert when this fraction of requests fail with a 5xx; 0 disables", "S3SITE_ALERT_5XX_RATE"},
+		cli.Float64Flag{"alert-s3-failure-rate", 0.1, "alert when this fraction of calls to s3 fail; 0 disables", "S3SITE_ALERT_S3_FAILURE_RATE"},
+		cli.IntFlag{"alert-auth-failures", 100, "alert when more than this many requests fail authentication in a window; 0 disables", "S3SITE_ALERT_AUTH_FAILURES"},
+		cli.DurationFlag{"alert-window", time.Minute, "window over which alert thresholds are evaluated", "S3SITE_ALERT_WINDOW"},
+		cli.StringFlag{"statsd-addr", "", "emit metrics over statsd to this host:port; disabled when empty", "S3SITE_STATSD_ADDR"},
+		cli.StringFlag{"statsd-prefix", "s3site.", "prefix for statsd metric names", "S3SITE_STATSD_PREFIX"},
+		cli.StringSliceFlag{"statsd-tag", &cli.StringSlice{}, "tag added to each statsd metric e.g. env:prod; requires dogstatsd", "S3SITE_STATSD_TAG"},
+		cli.DurationFlag{"statsd-interval", 10 * time.Second, "how often to emit statsd metrics", "S3SITE_STATSD_INTERVAL"},
+		cli.BoolFlag{"dogstatsd", "use the dogstatsd format, which supports tags", "S3SITE_DOGSTATSD"},
 	}
+}
+
+// NewApp returns the s3site command line, its flags defaulted from any
+// config file named by args
+func NewApp(args []string) (*cli.App, error) {
+	app := cli.NewApp()
+	app.Usage = "serve a website from an s3 bucket"
+	app.Version = Version
+	app.Flags = Flags()
+	app.Commands = Commands()
 	app.Action = Run
-	app.Run(os.Args)
+
+	for legacy, envVar := range applyLegacyEnv(app.Flags) {
+		logger.Warn("deprecated environment variable", Fields{"name": legacy, "use": envVar})
+	}
+
+	config, err := LoadConfig(configPath(args))
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Apply(app.Flags, args); err != nil {
+		return nil, err
+	}
+	for _, command := range app.Commands {
+		if hasFlag(command.Flags, "config") {
+			if err := config.Apply(command.Flags, args); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return app, nil
 }
 
 func check(err error) {
 	if err != nil {
-		log.Fatalln(err)
+		logger.Error(err.Error(), nil)
+		os.Exit(1)
 	}
 }
 
+// Run serves the bucket.  Options are read with LoadOptions rather than
+// from c so that flags given before and after the serve command both apply.
 func Run(c *cli.Context) {
-	opts := Opts(c)
-
-	handler, err := S3Handler(opts)
+	opts, err := LoadOptions(os.Args[1:])
 	check(err)
+	check(opts.Validate())
+	if opts.DryRun {
+		check(DryRun(os.Stdout, os.Args[1:]))
+		return
+	}
+	if opts.Daemon && !daemonized() {
+		process, err := Daemonize(opts.DaemonLog)
+		check(err)
+		fmt.Printf("started s3site as pid %d, logging to %s\n", process.Pid, opts.DaemonLog)
+		return
+	}
+
+	lambda := os.Getenv(LambdaRuntimeAPI)
+	if lambda != "" {
+		lambdaAuth()
+	}
+
+	var syslog *Syslog
+	if opts.Syslog != "" {
+		var err error
+		syslog, err = NewSyslog(opts.Syslog, opts.SyslogFacility, opts.SyslogTag)
+		check(err)
+		logger.Out = syslog
+	}
+	var logs *CloudWatchLogs
+	if opts.LogsGroup != "" {
+		auth, err := aws.EnvAuth()
+		check(err)
 
+		stream := opts.LogsStream
+		if stream == "" {
+			stream, _ = os.Hostname()
+		}
+		logs = NewCloudWatchLogs(opts.LogsGroup, stream, opts.CloudWatchRegion, auth, 10000)
+		go logs.Run(5 * time.Second)
+		logger.Out = logs
+	}
+	if opts.ErrorLogFile != "" {
+		logger.Out = opts.LogFile(opts.ErrorLogFile)
+	}
+	logger.JSON = opts.LogFormat == "json"
+
+	level, err := ParseLevel(opts.LogLevel)
+	check(err)
 	if opts.Verbose {
-		log.Printf("starting server on port %s\n", opts.Port)
+		level = LevelDebug
+	}
+	logger.Level = level
+	if len(opts.KeyQuery) > 0 {
+		logger.Warn("ignoring cache-key-query; the query never changes the object fetched", Fields{"values": opts.KeyQuery})
+	}
+
+	logger.Sample, err = ParseSampling(opts.LogSample)
+	check(err)
+
+	var exporters []SpanExporter
+	if opts.OTLPEndpoint != "" {
+		exporters = append(exporters, NewOTLPExporter(opts.OTLPEndpoint, "s3site"))
+	}
+	if opts.XRay {
+		exporters = append(exporters, NewXRayExporter(opts.XRayDaemon, "s3site"))
+	}
+	if len(exporters) > 0 {
+		tracer = NewTracer(exporters...)
+		go tracer.Run(time.Second)
+	}
+
+	live := NewLiveOptions(opts, func() (*Options, error) {
+		return LoadOptions(os.Args[1:])
+	})
+	go live.ReloadOnSignal()
+
+	metrics := NewMetrics()
+	metrics.Pricing = &opts.Pricing
+	sites, err := LoadSites(os.Args[1:])
+	check(err)
+	router := &SiteRouter{Sites: map[string]http.Handler{}}
+	if opts.Bucket != "" || len(sites) == 0 {
+		router.Default, err = S3Handler(live, metrics)
+		check(err)
+	}
+	for host, siteOpts := range sites {
+		host := host
+		live := NewLiveOptions(siteOpts, func() (*Options, error) {
+			sites, err := LoadSites(os.Args[1:])
+			if err != nil {
+				return nil, err
+			}
+			if opts, ok := sites[host]; ok {
+				return opts, nil
+			}
+			return nil, fmt.Errorf("site %s is no longer configured", host)
+		})
+		go live.ReloadOnSignal()
+
+		router.Sites[host], err = S3Handler(live, metrics)
+		check(err)
+		logger.Info("serving site", Fields{"host": host, "bucket": siteOpts.Bucket, "prefix": siteOpts.livePrefix()})
+	}
+
+	var analytics *Analytics
+	if opts.Analytics {
+		analytics = NewAnalytics(24)
+	}
+
+	listeners := &Listeners{}
+	var inherited, handover []InheritedListener
+	if lambda == "" {
+		inherited, err = SystemdListeners()
+		check(err)
+		handover, err = UpgradeListeners()
+		check(err)
+		listeners, err = OpenListeners(opts, append(inherited, handover...))
+		check(err)
+		if opts.PidFile != "" {
+			check(WritePidfile(opts.PidFile, len(handover) > 0))
+		}
+		go UpgradeOnSignal(listeners.Sockets)
+	}
+
+	if opts.Pprof && len(listeners.Admin) == 0 {
+		check(fmt.Errorf("pprof requires an admin-port"))
+	}
+	var dashboard *Dashboard
+	if len(listeners.Admin) > 0 {
+		dashboard = &Dashboard{Metrics: metrics, Analytics: analytics}
+		go dashboard.Run()
+
+		admin := http.NewServeMux()
+		admin.Handle("/", dashboard)
+		admin.Handle("/metrics", metrics)
+		admin.HandleFunc(HealthzPath, healthz)
+		admin.Handle("/debug/vars", VarsHandler(live))
+		PublishVars(metrics)
+		if opts.Pprof {
+			if opts.AdminToken == "" {
+				check(fmt.Errorf("pprof requires an admin-token"))
+			}
+			admin.Handle("/debug/pprof/", PprofHandler(live))
+		}
+
+		logger.Info("starting admin server", Fields{"addrs": addrs(listeners.Admin)})
+		for _, ln := range listeners.Admin {
+			go func(ln net.Listener) {
+				check(opts.Server(admin).Serve(ln))
+			}(ln)
+		}
 	}
-	err = http.ListenAndServe(":"+opts.Port, handler)
+
+	if opts.CloudWatchNamespace != "" {
+		auth, err := aws.EnvAuth()
+		check(err)
+
+		cw := &CloudWatch{
+			Namespace:  opts.CloudWatchNamespace,
+			Region:     opts.CloudWatchRegion,
+			Auth:       auth,
+			Client:     http.DefaultClient,
+			Dimensions: map[string]string{"Bucket": opts.Bucket},
+			Metrics:    metrics,
+		}
+		go cw.Run(opts.CloudWatchInterval)
+	}
+
+	if opts.AlertWebhook != "" {
+		if opts.AlertFormat != "json" && opts.AlertFormat != "slack" {
+			check(fmt.Errorf("unknown alert format, %s", opts.AlertFormat))
+		}
+		alerter := &Alerter{
+			Webhook:       opts.AlertWebhook,
+			Slack:         opts.AlertFormat == "slack",
+			Client:        &http.Client{Timeout: 10 * time.Second},
+			Metrics:       metrics,
+			Bucket:        opts.Bucket,
+			ErrorRate:     opts.AlertErrorRate,
+			S3FailureRate: opts.AlertS3FailureRate,
+			AuthFailures:  int64(opts.AlertAuthFailures),
+			Window:        opts.AlertWindow,
+		}
+		go alerter.Run()
+	}
+
+	if opts.StatsDAddr != "" {
+		statsd := &StatsD{
+			Addr:      opts.StatsDAddr,
+			Prefix:    opts.StatsDPrefix,
+			Tags:      opts.StatsDTags,
+			DogStatsD: opts.DogStatsD,
+			Metrics:   metrics,
+		}
+		go statsd.Run(opts.StatsDInterval)
+	}
+
+	var accessOut io.Writer = os.Stdout
+	if syslog != nil {
+		accessOut = syslog.WithSeverity(severityInfo)
+	}
+	if logs != nil {
+		accessOut = logs
+	}
+	if opts.AccessLogFile != "" {
+		accessOut = opts.LogFile(opts.AccessLogFile)
+	}
+	accessLog, err := NewAccessLog(accessOut, opts.AccessLog)
 	check(err)
+
+	var reporter *Sentry
+	if opts.SentryDSN != "" {
+		reporter, err = NewSentry(opts.SentryDSN)
+		check(err)
+		reporter.Tags["bucket"] = opts.Bucket
+	}
+
+	var h http.Handler = Recover(router, reporter)
+	if dashboard != nil {
+		h = dashboard.Wrap(h)
+	}
+	if opts.SlowRequest > 0 {
+		h = SlowLog(h, opts.SlowRequest)
+	}
+	if analytics != nil {
+		h = analytics.Wrap(live, h)
+	}
+	h = NewNotFoundTracker().Wrap(live, h)
+	h = RequestLogger(h)
+	if accessLog != nil {
+		h = accessLog.Wrap(h)
+	}
+	h = Trace(h)
+	if len(opts.TrustedProxies) > 0 {
+		proxies, err := ParseTrustedProxies(opts.TrustedProxies)
+		check(err)
+		h = proxies.Wrap(h)
+	}
+
+	if opts.VersionHeader {
+		h = VersionHeader(h)
+	}
+	h = Healthz(metrics.Instrument(h))
+	if opts.RequestTimeout > 0 {
+		h = RequestTimeout(h, opts.RequestTimeout)
+	}
+	if lambda != "" {
+		logger.Info("starting lambda runtime", Fields{"bucket": opts.Bucket})
+		runtime := &LambdaRuntime{API: lambda, Handler: h, Client: &http.Client{}}
+		err = runtime.Run()
+	} else {
+		logger.Info("starting server", Fields{"addrs": addrs(listeners.Site), "bucket": opts.Bucket, "socket_activated": len(inherited) > 0})
+		server := opts.Server(h)
+		server.ConnState = metrics.ConnState
+		if len(handover) > 0 {
+			check(upgraded())
+		}
+		err = Serve(server, listeners.Site, opts.DrainTimeout)
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+	}
+
+	// the server has stopped; persist what would otherwise be lost on exit
+	for _, cache := range metrics.Caches() {
+		if cache.Disk == nil {
+			continue
+		}
+		if err := cache.Disk.Save(); err != nil {
+			logger.Error("unable to save cache index", Fields{"dir": cache.Disk.dir, "error": err})
+		}
+	}
+	if tracer != nil {
+		tracer.Flush()
+	}
+	if opts.PidFile != "" {
+		RemovePidfile(opts.PidFile)
+	}
+	logger.Info("stopped", nil)
+	if logs != nil {
+		logger.SetOutput(os.Stderr)
+		logs.Close()
+	}
+	if err != nil && err != context.DeadlineExceeded {
+		check(err)
+	}
 }
 
-func S3Handler(opts *Options) (http.HandlerFunc, error) {
-	auth, err := aws.EnvAuth()
+func S3Handler(live *LiveOptions, metrics *Metrics, extensions ...Hook) (http.HandlerFunc, error) {
+	opts := live.Load()
+	creds, err := NewCredentials(opts)
 	if err != nil {
 		return nil, err
 	}
+	auth := creds.Base
 
-	api := s3.New(auth, aws.USEast)
-	bucket := api.Bucket(opts.Bucket)
-	if opts.Verbose {
-		log.Printf("s3 bucket: %s\n", opts.Bucket)
+	client := &http.Client{
+		Transport: PropagateTrace(metrics.Transport(&http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: opts.S3Timeout,
+		})),
+	}
+	bucket := creds.Bucket(opts.Bucket, client)
+
+	if opts.Releases {
+		if err := resolveRelease(live, bucket); err != nil {
+			return nil, err
+		}
+		opts = live.Load()
+		go WatchRelease(live, bucket, creds, opts.ReleasePoll)
+	}
+
+	var cache *Cache
+	var origin *Origin
+	if opts.CacheSize > 0 {
+		rules, err := ParseTTLRules(opts.CacheTTL)
+		if err != nil {
+			return nil, err
+		}
+
+		cache = NewCache(int64(opts.CacheSize) << 20)
+		switch opts.CacheAdmit {
+		case "always":
+		case "tinylfu":
+			cache.Frequency = NewSketch(opts.CacheSize << 8)
+		default:
+			return nil, fmt.Errorf("unknown cache admission policy, %s", opts.CacheAdmit)
+		}
+		if opts.CacheDir != "" {
+			disk, err := OpenDiskStore(opts.CacheDir, int64(opts.CacheDirSize)<<20)
+			if err != nil {
+				return nil, err
+			}
+			cache.Disk = disk
+			go disk.Run(30 * time.Second)
+		}
+		origin = &Origin{
+			Bucket:      bucket,
+			Credentials: creds,
+			Cache:       cache,
+			Client:      client,
+			Admission: &Admission{
+				MaxObject:    int64(opts.MaxObject) << 10,
+				ContentTypes: opts.CacheTypes,
+			},
+			TTL: &TTLPolicy{
+				Prefix:  opts.keyPrefix("/"),
+				Rules:   rules,
+				Default: opts.CacheFresh,
+			},
+			MaxStale:     opts.MaxStale,
+			StaleIfError: opts.StaleIfError,
+		}
+		live.OnReload(func(opts *Options) {
+			rules, _ := ParseTTLRules(opts.CacheTTL)
+			origin.TTL.SetRules(rules, opts.CacheFresh)
+			origin.TTL.SetPrefix(opts.keyPrefix("/"))
+		})
+	}
+
+	if opts.Queue != "" && cache != nil {
+		invalidator, err := NewInvalidator(opts.Queue, auth, opts.Bucket, cache)
+		if err != nil {
+			return nil, err
+		}
+		go invalidator.Run()
+	}
+
+	if cache != nil {
+		metrics.AddCache(cache)
+	}
+	if metrics.Pricing == nil {
+		metrics.Pricing = &opts.Pricing
+	}
+
+	keys := &KeyPolicy{
+		Lowercase: opts.KeyLowercase,
+	}
+
+	// warming runs alongside serving, so a long manifest doesn't hold up
+	// the listeners or health checks
+	if origin != nil && (len(opts.Warm) > 0 || opts.WarmManifest != "") {
+		go func(opts *Options) {
+			paths, err := WarmPaths(opts, origin)
+			if err != nil {
+				logger.Error("unable to read warm manifest", Fields{"bucket": opts.Bucket, "key": opts.WarmManifest, "error": err})
+				return
+			}
+			Warm(opts, keys, origin, paths)
+		}(opts)
+	}
+
+	var cdn *CloudFront
+	if opts.Distribution != "" {
+		cdn = &CloudFront{
+			DistributionID: opts.Distribution,
+			Auth:           auth,
+			Client:         http.DefaultClient,
+		}
+	}
+
+	ready := &Readiness{
+		Check: func() error { return BucketCheck(creds.Sign(bucket), live.Load().Key("/"))() },
+		TTL:   10 * time.Second,
+	}
+
+	purge := PurgeHandler(live, keys, cache, cdn)
+	admin := http.NewServeMux()
+	admin.Handle("/-/purge", purge)
+	admin.Handle("/-/cache", CacheStatsHandler(live, cache))
+	admin.Handle("/-/cost", CostHandler(live, metrics))
+	admin.Handle(ReloadPath, ReloadHandler(live))
+	admin.Handle(SwitchPath, SwitchHandler(live))
+	admin.Handle(CanaryPath, CanaryHandler(live))
+
+	variants, err := ParseVariants(opts.VariantCookie, opts.Variants)
+	if err != nil {
+		return nil, err
+	}
+	types, err := LoadMimeTypes(opts.MimeTypesFile, opts.MimeTypes)
+	if err != nil {
+		return nil, err
+	}
+	listingTemplate, err := LoadListingTemplate(opts.AutoIndexTemplate)
+	if err != nil {
+		return nil, err
+	}
+	markdownLayout, err := LoadMarkdownLayout(opts.MarkdownLayout)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := NewTemplates(opts.Templates, opts.TemplateVars)
+	if err != nil {
+		return nil, err
+	}
+	snippets, err := LoadSnippets(opts.InjectHead, opts.InjectBody)
+	if err != nil {
+		return nil, err
+	}
+	var images *Images
+	if opts.Images {
+		images = &Images{
+			MaxSize: opts.ImageMaxSize,
+			Quality: opts.ImageQuality,
+			Cache:   NewCache(int64(opts.ImageCacheSize) << 20),
+		}
+	}
+	minifier, err := NewMinifier(opts.Minify, opts.MinifyCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	feeds, err := ParseFeeds(opts.Feeds)
+	if err != nil {
+		return nil, err
+	}
+	for _, feed := range feeds {
+		feed.BaseURL, feed.Limit, feed.IndexFile = opts.FeedURL, opts.FeedLimit, opts.IndexFile
+		go feed.Run(live, bucket, creds, opts.FeedRefresh)
+	}
+	fallback, err := NewFallback(opts.FallbackOrigin, opts.S3Timeout)
+	if err != nil {
+		return nil, err
+	}
+	forms, err := NewForms(opts.Forms, opts.FormRegion, creds.Auth, opts.FormSecret)
+	if err != nil {
+		return nil, err
+	}
+	zips := NewZips(opts.Zips, opts.ZipMaxSize)
+	functions, err := NewFunctions(opts.Functions, opts.FunctionRegion, creds.Auth, opts.FunctionTimeout)
+	if err != nil {
+		return nil, err
+	}
+	upstreams, err := ParseUpstreams(opts.Upstreams, opts.UpstreamTimeout)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := NewAliases(opts.Aliases, opts.AliasObject)
+	if err != nil {
+		return nil, err
+	}
+	if aliases != nil && aliases.Key != "" {
+		go aliases.Run(live, bucket, creds, opts.AliasRefresh)
+	}
+	rewrites, err := ParseRewrites(opts.Rewrites)
+	if err != nil {
+		return nil, err
+	}
+	locales, err := NewLocales(opts.Locales, opts.LocaleCookie, opts.LocaleMode)
+	if err != nil {
+		return nil, err
+	}
+	var media *Media
+	if opts.Media {
+		media = &Media{Paths: opts.MediaPaths}
+	}
+	var search *Search
+	if opts.Search {
+		search = &Search{
+			Exclude:   opts.SearchExclude,
+			IndexKey:  opts.SearchIndex,
+			IndexFile: opts.IndexFile,
+		}
+		go search.Run(live, bucket, creds, opts.SearchRefresh)
+	}
+	var sitemap *Sitemap
+	if opts.Sitemap {
+		sitemap = &Sitemap{
+			Include:   opts.SitemapInclude,
+			Exclude:   opts.SitemapExclude,
+			BaseURL:   opts.SitemapURL,
+			IndexFile: opts.IndexFile,
+		}
+		go sitemap.Run(live, bucket, creds, opts.SitemapRefresh)
+	}
+	var sidecars *Sidecars
+	if opts.ImageSidecars {
+		sidecars = &Sidecars{
+			Exists: func(key string) (bool, error) {
+				resp, err := creds.Sign(bucket).Head(key)
+				if err != nil {
+					if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+						return false, nil
+					}
+					return false, err
+				}
+				resp.Body.Close()
+				return true, nil
+			},
+			TTL: time.Minute,
+		}
+	}
+
+	includes := &Includes{
+		Fetch: func(ctx context.Context, key string) ([]byte, error) {
+			if origin != nil {
+				entry, _, err := origin.Get(ctx, key, "")
+				if _, stale := err.(*StaleError); err != nil && !stale {
+					return nil, err
+				}
+				if entry.Stream != nil {
+					defer entry.Stream.Close()
+					return ioutil.ReadAll(io.LimitReader(entry.Stream, maxRendered))
+				}
+				return entry.Body, nil
+			}
+			resp, err := getObject(ctx, client, creds.Sign(bucket), key, nil)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			return ioutil.ReadAll(io.LimitReader(resp.Body, maxRendered))
+		},
+	}
+
+	// serve writes the body of the object at key, rendering markdown,
+	// templates, and includes, and minifying.  Each transformation tags the
+	// response with an etag of its own, answering 304 when the client's copy
+	// of that representation is current.
+	serve := func(w http.ResponseWriter, req *http.Request, opts *Options, urlPath, key, etag, lastModified, storedType string, body io.Reader) {
+		notModified := func(etag, lastModified string) bool {
+			if setValidators(w, req, etag, lastModified) {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+			return false
+		}
+
+		// html pages are injected with the snippets
+		inject := snippets.Variant()
+		if snippets != nil {
+			iw := snippets.Wrap(w)
+			defer iw.Close()
+			w = iw
+		}
+		if isImage(key) && images.Requested(req) {
+			images.Serve(w, req, key, etag, lastModified, body)
+			return
+		}
+		if opts.Markdown && isMarkdown(key) {
+			if notModified(VariantETag(VariantETag(etag, "markdown"), inject), lastModified) {
+				return
+			}
+			serveMarkdown(w, body, urlPath, markdownLayout)
+			return
+		}
+		if templates.Matches(urlPath, opts.IndexFile) {
+			templates.Serve(w, req, body, key, etag, urlPath, inject)
+			return
+		}
+		contentType, body := opts.contentType(types, key, storedType, body)
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		if strings.HasPrefix(contentType, "text/html") {
+			etag = VariantETag(etag, inject)
+		}
+		if opts.SSI && isHTMLPath(key) {
+			page, err := ioutil.ReadAll(io.LimitReader(body, maxRendered))
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			page = includes.Expand(req.Context(), opts, page, urlPath)
+			page = minifier.Minify(minifier.Kind(key, contentType), page)
+			// pages change with the files they include, so are tagged by
+			// content rather than by the page's own etag
+			if notModified(VariantETag(StrongETag(page), inject), "") {
+				return
+			}
+			w.Write(page)
+			return
+		}
+		if kind := minifier.Kind(key, contentType); kind != "" {
+			minifier.Serve(w, req, key, etag, lastModified, kind, body)
+			return
+		}
+		if notModified(etag, lastModified) {
+			return
+		}
+		copyPooled(w, body)
 	}
 
+	hs := hooks(extensions)
+	routes := hooks{RouteHook(locales.Route), RouteHook(aliases.Route), RouteHook(rewrites.Route)}
 	return func(w http.ResponseWriter, req *http.Request) {
+		opts, canary := live.Load().forCanary(w, req)
+		if variant := variants.Assign(w, req); variant != nil {
+			opts = opts.withPrefix(variant.Prefix)
+			w.Header().Set(VariantHeader, variant.Name)
+			w.Header().Add("Vary", "Cookie")
+			metrics.ObserveVariant(variant.Name)
+		}
+		opts, err := opts.forRequest(w, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !hs.request(w, req) {
+			return
+		}
+		var path string
+		w = hs.wrap(w, req, &path)
+
+		if req.Method == "PURGE" {
+			purge(w, req)
+			return
+		}
+		if req.URL.Path == ReadyzPath {
+			ready.ServeHTTP(w, req)
+			return
+		}
+		// search results are pages of the site, so are only answered once
+		// the request is authorized
+		if strings.HasPrefix(req.URL.Path, "/-/") && req.URL.Path != SearchPath {
+			admin.ServeHTTP(w, req)
+			return
+		}
+
+		ok, u := true, ""
 		if opts.RequiresAuth() {
-			u, p, _ := req.BasicAuth()
-			if opts.Verbose {
-				log.Printf("Authorization: %s/%s\n", u, p)
+			_, span := StartSpan(req.Context(), "auth", SpanInternal)
+			u, p, present := req.BasicAuth()
+			span.SetAttribute("auth.username", u)
+			ok = opts.Authorized(u, p)
+			span.SetAttribute("auth.ok", ok)
+			span.Finish()
+			if ok && present {
+				req = withBasicAuth(req)
 			}
+		}
+		if !hs.auth(req, ok) {
+			logger.Debug("authorization failed", Fields{"request_id": requestID(req), "username": u})
+			metrics.ObserveAuthFailure()
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=\"%s\"", opts.Realm))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 
-			if u != opts.Username || p != opts.Password {
-				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=\"%s\"", opts.Realm))
-				w.WriteHeader(http.StatusUnauthorized)
+		if form := forms.Match(req); form != nil {
+			forms.Serve(w, req, form)
+			return
+		}
+		forms.Stamp(w, req)
+		if function := functions.Match(req); function != nil {
+			functions.Serve(w, req, function)
+			return
+		}
+		if upstream := upstreams.Match(req.URL.Path); upstream != nil {
+			upstream.Proxy.ServeHTTP(w, req)
+			return
+		}
+		if req.URL.Path == SearchPath {
+			search.ServeHTTP(w, req)
+			return
+		}
+		for _, feed := range feeds {
+			if feed.Serves(req.URL.Path) {
+				feed.ServeHTTP(w, req)
 				return
 			}
 		}
+		if sitemap.Serves(req.URL.Path) {
+			sitemap.ServeHTTP(w, req)
+			return
+		}
 
-		path := fmt.Sprintf("%s%s", opts.Prefix, req.URL.Path)
-		if strings.Contains(path, "//") {
-			path = strings.Replace(path, "//", "/", -1)
+		urlPath, routed := routes.route(w, req, keys.Path(req.URL.Path))
+		if !routed {
+			return
+		}
+		path = hs.originFetch(req, opts.Key(urlPath))
+		if sidecars != nil && isImage(path) && !images.Requested(req) {
+			w.Header().Add("Vary", "Accept")
+			path = sidecars.Negotiate(req, path)
 		}
-		if strings.HasPrefix(path, "/") {
-			path = path[1:]
+		logger.Debug("resolved", Fields{"request_id": requestID(req), "path": req.URL.Path, "bucket": opts.Bucket, "key": path, "canary": canary})
+
+		if opts.AutoIndex && strings.HasSuffix(urlPath, "/") && wantsJSON(req) && serveListing(w, req, creds.Sign(bucket), opts, urlPath, listingTemplate) {
+			return
 		}
-		if strings.HasSuffix(req.URL.Path, "/") {
-			path = path + opts.IndexFile
+
+		// archives are streamed from s3 directly, bypassing the cache
+		if zips.Matches(req, urlPath) && zips.Serve(w, req, creds.Sign(bucket), opts.keyPrefix(urlPath), urlPath, func(key string) (*http.Response, error) {
+			started := time.Now()
+			defer track(req.Context(), "s3_get", started)
+			return getObject(req.Context(), client, creds.Sign(bucket), key, nil)
+		}) {
+			return
+		}
+
+		// media isn't cached, so ranges of it are served by s3 directly
+		if media.Matches(urlPath) {
+			media.Serve(w, req, opts, types, urlPath, path, func(header http.Header) (*http.Response, error) {
+				started := time.Now()
+				defer track(req.Context(), "s3_get", started)
+				return getObject(req.Context(), client, creds.Sign(bucket), path, header)
+			})
+			return
 		}
-		if opts.Verbose {
-			log.Printf("> %s => s3://%s/%s\n", req.URL.Path, opts.Bucket, path)
+
+		if origin != nil {
+			entry, status, err := origin.Get(req.Context(), path, "")
+			w.Header().Set("X-Cache", status)
+			if stale, ok := err.(*StaleError); ok {
+				logger.Warn("serving stale content", Fields{"request_id": requestID(req), "bucket": opts.Bucket, "key": path, "error": stale.Err})
+				w.Header().Set("Warning", `111 - "Revalidation Failed"`)
+				err = nil
+			}
+			if err != nil {
+				if opts.AutoIndex && strings.HasSuffix(urlPath, "/") && serveListing(w, req, creds.Sign(bucket), opts, urlPath, listingTemplate) {
+					return
+				}
+				if fallback != nil && isMissing(err) {
+					fallback.ServeHTTP(w, req)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=\"%s\"", opts.Realm))
+				if opts.Diagnostics {
+					Diagnose(creds.Sign(bucket), opts, urlPath, path, err).Write(w, http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if entry.Stream != nil {
+				defer entry.Stream.Close()
+			}
+
+			setCacheHeaders(w, opts, urlPath)
+			if entry.CacheControl != "" {
+				w.Header().Set("Cache-Control", entry.CacheControl)
+			}
+			var body io.Reader = bytes.NewReader(entry.Body)
+			if entry.Stream != nil {
+				body = entry.Stream
+			}
+			serve(w, req, opts, urlPath, path, entry.ETag, entry.LastModified, entry.ContentType, body)
+			return
 		}
 
-		readCloser, err := bucket.GetReader(path)
+		s3ctx, span := StartSpan(req.Context(), "s3.GetObject", SpanClient)
+		span.SetAttribute("s3.bucket", opts.Bucket)
+		span.SetAttribute("s3.key", path)
+		started := time.Now()
+		resp, err := getObject(s3ctx, client, creds.Sign(bucket), path, nil)
+		track(req.Context(), "s3_get", started)
+		span.SetError(err)
+		span.Finish()
 		if err != nil {
+			if opts.AutoIndex && strings.HasSuffix(urlPath, "/") && serveListing(w, req, creds.Sign(bucket), opts, urlPath, listingTemplate) {
+				return
+			}
+			if fallback != nil && isMissing(err) {
+				fallback.ServeHTTP(w, req)
+				return
+			}
 			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=\"%s\"", opts.Realm))
+			if opts.Diagnostics {
+				Diagnose(creds.Sign(bucket), opts, urlPath, path, err).Write(w, http.StatusNotFound)
+				return
+			}
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		defer readCloser.Close()
+		defer resp.Body.Close()
 
-		contentType := mime.TypeByExtension(path)
-		w.Header().Set("Content-Type", contentType)
-
-		io.Copy(w, readCloser)
+		setCacheHeaders(w, opts, urlPath)
+		if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		serve(w, req, opts, urlPath, path, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), resp.Header.Get("Content-Type"), resp.Body)
 	}, nil
 }
@@ -0,0 +1,413 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxRendered bounds the size of files rendered server side, e.g. markdown,
+// templates, and pages with includes
+const maxRendered = 4 << 20
+
+// isMarkdown returns true for keys with a markdown extension
+func isMarkdown(key string) bool {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// RenderMarkdown renders the common subset of markdown to html: headings,
+// paragraphs, emphasis, code spans, fenced code blocks with highlighting,
+// lists, block quotes, rules, links, and images.  Raw html is escaped
+// rather than passed through.
+func RenderMarkdown(src []byte) []byte {
+	lines := strings.Split(strings.Replace(string(src), "\r\n", "\n", -1), "\n")
+	buf := &bytes.Buffer{}
+	renderBlocks(buf, lines)
+	return buf.Bytes()
+}
+
+var (
+	headingLine = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	ruleLine    = regexp.MustCompile(`^ {0,3}(?:(?:- *){3,}|(?:\* *){3,}|(?:_ *){3,})$`)
+	fenceLine   = regexp.MustCompile("^ {0,3}(```+|~~~+)\\s*([A-Za-z0-9_+-]*)")
+	itemLine    = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])( +|$)`)
+)
+
+func renderBlocks(buf *bytes.Buffer, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case fenceLine.MatchString(line):
+			m := fenceLine.FindStringSubmatch(line)
+			code := []string{}
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]); i++ {
+				code = append(code, lines[i])
+			}
+			i++
+			lang := strings.ToLower(m[2])
+			if lang != "" {
+				fmt.Fprintf(buf, "<pre><code class=\"language-%s\">", lang)
+			} else {
+				buf.WriteString("<pre><code>")
+			}
+			buf.WriteString(highlight(strings.Join(code, "\n")+"\n", lang))
+			buf.WriteString("</code></pre>\n")
+
+		case headingLine.MatchString(line):
+			m := headingLine.FindStringSubmatch(line)
+			fmt.Fprintf(buf, "<h%d id=\"%s\">%s</h%d>\n", len(m[1]), slug(m[2]), renderInline(m[2]), len(m[1]))
+			i++
+
+		case ruleLine.MatchString(line):
+			buf.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(strings.TrimLeft(line, " "), ">"):
+			quoted := []string{}
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+				l := strings.TrimPrefix(strings.TrimLeft(lines[i], " "), ">")
+				quoted = append(quoted, strings.TrimPrefix(l, " "))
+			}
+			buf.WriteString("<blockquote>\n")
+			renderBlocks(buf, quoted)
+			buf.WriteString("</blockquote>\n")
+
+		case itemLine.MatchString(line):
+			i = renderList(buf, lines, i)
+
+		case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"):
+			code := []string{}
+			for ; i < len(lines) && (strings.HasPrefix(lines[i], "    ") || strings.HasPrefix(lines[i], "\t") || strings.TrimSpace(lines[i]) == ""); i++ {
+				code = append(code, strings.TrimPrefix(strings.TrimPrefix(lines[i], "\t"), "    "))
+			}
+			for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+				code = code[:len(code)-1]
+			}
+			buf.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")+"\n") + "</code></pre>\n")
+
+		default:
+			para := []string{}
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i]); i++ {
+				para = append(para, lines[i])
+			}
+			buf.WriteString("<p>" + renderInline(strings.Join(para, "\n")) + "</p>\n")
+		}
+	}
+}
+
+// startsBlock returns true for lines interrupting a paragraph
+func startsBlock(line string) bool {
+	return headingLine.MatchString(line) || ruleLine.MatchString(line) || fenceLine.MatchString(line) ||
+		strings.HasPrefix(strings.TrimLeft(line, " "), ">") || itemLine.MatchString(line)
+}
+
+// renderList renders the list starting at lines[i], returning the index of
+// the line after it
+func renderList(buf *bytes.Buffer, lines []string, i int) int {
+	m := itemLine.FindStringSubmatch(lines[i])
+	ordered := m[2][0] >= '0' && m[2][0] <= '9'
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	buf.WriteString("<" + tag + ">\n")
+
+	for i < len(lines) {
+		m := itemLine.FindStringSubmatch(lines[i])
+		if m == nil || (m[2][0] >= '0' && m[2][0] <= '9') != ordered {
+			break
+		}
+		indent := len(m[0])
+		item := []string{lines[i][len(m[0]):]}
+		for i++; i < len(lines); i++ {
+			l := lines[i]
+			if strings.TrimSpace(l) == "" {
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) >= indent {
+					item = append(item, "")
+					continue
+				}
+				break
+			}
+			if leadingSpaces(l) >= indent && leadingSpaces(l) > 0 {
+				item = append(item, l[min(indent, leadingSpaces(l)):])
+				continue
+			}
+			if itemLine.MatchString(l) || startsBlock(l) {
+				break
+			}
+			// a lazy continuation of the item's paragraph
+			item = append(item, l)
+		}
+
+		buf.WriteString("<li>")
+		if len(item) == 1 || !containsBlock(item[1:]) {
+			buf.WriteString(renderInline(strings.Join(item, "\n")))
+		} else {
+			inner := &bytes.Buffer{}
+			renderBlocks(inner, item)
+			s := strings.TrimSuffix(inner.String(), "\n")
+			if j := strings.Index(s, "</p>\n"); tight(item) && strings.HasPrefix(s, "<p>") && j >= 0 {
+				// a tight item's leading text isn't wrapped in a paragraph
+				s = s[3:j] + "\n" + s[j+5:]
+			}
+			buf.WriteString(s)
+		}
+		buf.WriteString("</li>\n")
+
+		for i < len(lines) && strings.TrimSpace(lines[i]) == "" && i+1 < len(lines) && itemLine.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+	buf.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// tight returns true for list items without blank lines
+func tight(lines []string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func containsBlock(lines []string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" || startsBlock(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+var (
+	codeSpan   = regexp.MustCompile("(`+)(.+?)(`+)")
+	imageSpan  = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)(?:\s+&#34;([^&]*)&#34;)?\)`)
+	link       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)(?:\s+&#34;([^&]*)&#34;)?\)`)
+	autoLink   = regexp.MustCompile(`&lt;(https?://[^\s&]+)&gt;`)
+	strong     = regexp.MustCompile(`(\*\*|__)([^\s*_](?:.*?[^\s])?)(\*\*|__)`)
+	emphasis   = regexp.MustCompile(`(^|[^\w*])[*_]([^\s*_](?:[^*_]*?[^\s*_])?)[*_]`)
+	strike     = regexp.MustCompile(`~~([^~]+)~~`)
+	hardBreak  = regexp.MustCompile(` {2,}\n`)
+	unsafeLink = regexp.MustCompile(`(?i)^\s*(javascript|vbscript|data):`)
+)
+
+// renderInline renders the spans of text, escaping any html
+func renderInline(text string) string {
+	// code spans are set aside so their content isn't formatted
+	codes := []string{}
+	text = codeSpan.ReplaceAllStringFunc(text, func(s string) string {
+		m := codeSpan.FindStringSubmatch(s)
+		if m[1] != m[3] {
+			return s
+		}
+		codes = append(codes, "<code>"+html.EscapeString(strings.TrimSpace(m[2]))+"</code>")
+		return fmt.Sprintf("\x00%d\x00", len(codes)-1)
+	})
+
+	text = html.EscapeString(text)
+	text = imageSpan.ReplaceAllStringFunc(text, func(s string) string {
+		m := imageSpan.FindStringSubmatch(s)
+		out := fmt.Sprintf(`<img src="%s" alt="%s"`, safeURL(m[2]), m[1])
+		if m[3] != "" {
+			out += fmt.Sprintf(` title="%s"`, m[3])
+		}
+		return out + ">"
+	})
+	text = link.ReplaceAllStringFunc(text, func(s string) string {
+		m := link.FindStringSubmatch(s)
+		out := fmt.Sprintf(`<a href="%s"`, safeURL(m[2]))
+		if m[3] != "" {
+			out += fmt.Sprintf(` title="%s"`, m[3])
+		}
+		return out + ">" + m[1] + "</a>"
+	})
+	text = autoLink.ReplaceAllString(text, `<a href="$1">$1</a>`)
+	text = strong.ReplaceAllStringFunc(text, func(s string) string {
+		m := strong.FindStringSubmatch(s)
+		if m[1] != m[3] {
+			return s
+		}
+		return "<strong>" + m[2] + "</strong>"
+	})
+	text = emphasis.ReplaceAllString(text, "$1<em>$2</em>")
+	text = strike.ReplaceAllString(text, "<del>$1</del>")
+	text = hardBreak.ReplaceAllString(text, "<br>\n")
+
+	for i, code := range codes {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), code, 1)
+	}
+	return text
+}
+
+// safeURL drops urls with schemes able to run script
+func safeURL(u string) string {
+	if unsafeLink.MatchString(html.UnescapeString(u)) {
+		return "#"
+	}
+	return u
+}
+
+var slugStrip = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug returns the anchor for a heading e.g. "Getting started" is
+// getting-started
+func slug(heading string) string {
+	return strings.Trim(slugStrip.ReplaceAllString(strings.ToLower(heading), "-"), "-")
+}
+
+var highlightKeywords = map[string][]string{
+	"go":         {"break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough", "for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return", "select", "struct", "switch", "type", "var", "nil", "true", "false"},
+	"javascript": {"async", "await", "break", "case", "catch", "class", "const", "continue", "default", "delete", "do", "else", "export", "extends", "finally", "for", "from", "function", "if", "import", "in", "instanceof", "let", "new", "of", "return", "switch", "this", "throw", "try", "typeof", "var", "while", "yield", "null", "undefined", "true", "false"},
+	"python":     {"and", "as", "assert", "async", "await", "break", "class", "continue", "def", "del", "elif", "else", "except", "finally", "for", "from", "global", "if", "import", "in", "is", "lambda", "not", "or", "pass", "raise", "return", "try", "while", "with", "yield", "None", "True", "False"},
+	"shell":      {"case", "do", "done", "elif", "else", "esac", "export", "fi", "for", "function", "if", "in", "local", "return", "then", "until", "while"},
+	"json":       {"true", "false", "null"},
+}
+
+var highlightAliases = map[string]string{
+	"golang": "go", "js": "javascript", "ts": "javascript", "typescript": "javascript", "jsx": "javascript",
+	"py": "python", "sh": "shell", "bash": "shell", "zsh": "shell", "console": "shell", "yaml": "json", "yml": "json",
+}
+
+// highlightToken matches, in order: comments, strings, numbers, and words
+var highlightToken = regexp.MustCompile("(//[^\n]*|/\\*(?s:.*?)\\*/|#[^\n]*)|(\"(?:[^\"\\\\\n]|\\\\.)*\"|'(?:[^'\\\\\n]|\\\\.)*'|`[^`]*`)|(\\b\\d+(?:\\.\\d+)?\\b)|([A-Za-z_]\\w*)")
+
+// highlight escapes code, wrapping the comments, strings, numbers, and
+// keywords of known languages in spans with the classes c, s, n, and k
+func highlight(code, lang string) string {
+	if alias, ok := highlightAliases[lang]; ok {
+		lang = alias
+	}
+	words, ok := highlightKeywords[lang]
+	if !ok {
+		return html.EscapeString(code)
+	}
+	keywords := map[string]bool{}
+	for _, word := range words {
+		keywords[word] = true
+	}
+	hashComments := lang == "python" || lang == "shell" || lang == "json"
+
+	buf := &bytes.Buffer{}
+	last := 0
+	for _, m := range highlightToken.FindAllStringSubmatchIndex(code, -1) {
+		class := ""
+		switch {
+		case m[2] >= 0:
+			if (code[m[2]] == '#') == hashComments {
+				class = "c"
+			}
+		case m[4] >= 0:
+			class = "s"
+		case m[6] >= 0:
+			class = "n"
+		case m[8] >= 0 && keywords[code[m[8]:m[9]]]:
+			class = "k"
+		}
+		if class == "" {
+			continue
+		}
+		buf.WriteString(html.EscapeString(code[last:m[0]]))
+		fmt.Fprintf(buf, `<span class="%s">%s</span>`, class, html.EscapeString(code[m[0]:m[1]]))
+		last = m[1]
+	}
+	buf.WriteString(html.EscapeString(code[last:]))
+	return buf.String()
+}
+
+// markdownPage is the data the markdown layout is executed with
+type markdownPage struct {
+	Title   string
+	Path    string
+	Content template.HTML
+}
+
+var firstHeading = regexp.MustCompile(`(?m)^#\s+(.*?)\s*#*\s*$`)
+
+// LoadMarkdownLayout parses the layout at path, or returns the built in
+// layout when path is empty.  The layout is executed with the page's
+// .Title, .Path, and rendered .Content.
+func LoadMarkdownLayout(path string) (*template.Template, error) {
+	if path == "" {
+		return markdownLayout, nil
+	}
+	return template.ParseFiles(path)
+}
+
+var markdownLayout = template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; line-height: 1.6; margin: 2em auto; max-width: 48em; padding: 0 1em; color: #222; }
+pre { background: #f6f8fa; padding: 1em; overflow: auto; border-radius: 4px; }
+code { font-family: SFMono-Regular, Consolas, Menlo, monospace; font-size: 90%; }
+blockquote { margin: 0; padding-left: 1em; border-left: 4px solid #ddd; color: #555; }
+img { max-width: 100%; }
+.k { color: #d73a49; } .s { color: #032f62; } .c { color: #6a737d; font-style: italic; } .n { color: #005cc5; }
+</style></head>
+<body>
+{{.Content}}
+</body></html>
+`))
+
+// serveMarkdown renders the markdown read from body into the layout
+func serveMarkdown(w http.ResponseWriter, body io.Reader, urlPath string, layout *template.Template) {
+	src, err := ioutil.ReadAll(io.LimitReader(body, maxRendered))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	fields, src := parseFrontMatter(src)
+	page := markdownPage{Path: urlPath, Title: path.Base(urlPath), Content: template.HTML(RenderMarkdown(src))}
+	if title := fields["title"]; title != "" {
+		page.Title = title
+	} else if m := firstHeading.FindSubmatch(src); m != nil {
+		page.Title = string(m[1])
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := layout.Execute(w, page); err != nil {
+		logger.Warn("unable to render markdown", Fields{"path": urlPath, "error": err})
+	}
+}
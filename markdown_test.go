@@ -0,0 +1,77 @@
+package s3site
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	testCases := map[string]string{
+		"# Getting started":                    `<h1 id="getting-started">Getting started</h1>`,
+		"Some *emphasis*, **bold**, and `a<b`": "<p>Some <em>emphasis</em>, <strong>bold</strong>, and <code>a&lt;b</code></p>",
+		"See [the docs](/docs/ \"Docs\")":      `<p>See <a href="/docs/" title="Docs">the docs</a></p>`,
+		"![logo](/logo.png)":                   `<p><img src="/logo.png" alt="logo"></p>`,
+		"[x](javascript:alert(1))":             `<a href="#">x</a>`,
+		"<script>alert(1)</script>":            "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>",
+		"<https://example.com>":                `<p><a href="https://example.com">https://example.com</a></p>`,
+		"snake_case_name":                      "<p>snake_case_name</p>",
+		"---":                                  "<hr>",
+		"> quoted\n> text":                     "<blockquote>\n<p>quoted\ntext</p>\n</blockquote>",
+		"- one\n- two\n  - nested":             "<ul>\n<li>one</li>\n<li>two\n<ul>\n<li>nested</li>\n</ul></li>\n</ul>",
+		"1. first\n2. second":                  "<ol>\n<li>first</li>\n<li>second</li>\n</ol>",
+		"    indented <code>":                  "<pre><code>indented &lt;code&gt;\n</code></pre>",
+		"```\nplain <b>\n```":                  "<pre><code>plain &lt;b&gt;\n</code></pre>",
+		"para\n# heading":                      "<p>para</p>\n<h1 id=\"heading\">heading</h1>",
+	}
+	for src, expected := range testCases {
+		if actual := strings.TrimSpace(string(RenderMarkdown([]byte(src)))); !strings.Contains(actual, expected) {
+			t.Errorf("%q: expected %s, got %s", src, expected, actual)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	code := "func main() { // start\n\tfmt.Println(\"a<b\", 42)\n}\n"
+	expected := `<span class="k">func</span> main() { <span class="c">// start</span>` + "\n" +
+		`	fmt.Println(<span class="s">&#34;a&lt;b&#34;</span>, <span class="n">42</span>)` + "\n}\n"
+	if actual := highlight(code, "go"); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+
+	if actual := highlight("echo $HOME # home\n", "bash"); actual != `echo $HOME <span class="c"># home</span>`+"\n" {
+		t.Errorf("unexpected shell highlighting, %s", actual)
+	}
+	if actual := highlight("<unknown>", "cobol"); actual != "&lt;unknown&gt;" {
+		t.Errorf("expected unknown languages to be escaped only, got %s", actual)
+	}
+
+	html := string(RenderMarkdown([]byte("```go\nreturn nil\n```")))
+	if !strings.Contains(html, `<pre><code class="language-go"><span class="k">return</span> <span class="k">nil</span>`) {
+		t.Errorf("expected a highlighted fenced block, got %s", html)
+	}
+}
+
+func TestServeMarkdown(t *testing.T) {
+	w := httptest.NewRecorder()
+	serveMarkdown(w, strings.NewReader("# Install\n\nRun it."), "/docs/install.md", markdownLayout)
+
+	body := w.Body.String()
+	if w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("unexpected content type, %s", w.Header().Get("Content-Type"))
+	}
+	for _, expected := range []string{"<title>Install</title>", `<h1 id="install">Install</h1>`, "<p>Run it.</p>"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %s in the page\n%s", expected, body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	serveMarkdown(w, strings.NewReader("---\ntitle: Getting Started\n---\n# Install\n"), "/docs/install.md", markdownLayout)
+	if body := w.Body.String(); !strings.Contains(body, "<title>Getting Started</title>") || strings.Contains(body, "---") {
+		t.Errorf("expected the front matter's title, and the front matter left out\n%s", body)
+	}
+	if !isMarkdown("docs/README.MD") || isMarkdown("docs/readme.txt") {
+		t.Error("unexpected markdown detection")
+	}
+}
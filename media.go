@@ -0,0 +1,190 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+// mediaTypes are the types of streaming manifests, segments, and captions,
+// which s3 commonly stores as binary/octet-stream or text/plain, and which
+// players refuse unless typed correctly
+var mediaTypes = MimeTypes{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".m3u":  "audio/mpegurl",
+	".mpd":  "application/dash+xml",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".cmfv": "video/mp4",
+	".cmfa": "audio/mp4",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".m4a":  "audio/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".vtt":  "text/vtt; charset=utf-8",
+}
+
+// Media streams audio, video, and their manifests: ranges are passed to
+// s3, bodies are flushed as they arrive, and responses are marked
+// no-transform so proxies neither compress nor buffer them
+type Media struct {
+	// Paths holds patterns e.g. /video/ of paths streamed regardless of
+	// extension
+	Paths []string
+}
+
+// Matches returns true for paths streamed as media
+func (m *Media) Matches(urlPath string) bool {
+	if m == nil {
+		return false
+	}
+	if _, ok := mediaTypes[strings.ToLower(path.Ext(urlPath))]; ok {
+		return true
+	}
+	for _, pattern := range m.Paths {
+		if (TTLRule{Pattern: pattern}).Matches(urlPath, "") {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve streams the object at key, fetched by fetch with the request's
+// range, if any
+func (m *Media) Serve(w http.ResponseWriter, req *http.Request, opts *Options, types MimeTypes, urlPath, key string, fetch func(header http.Header) (*http.Response, error)) {
+	header := http.Header{}
+	if r := req.Header.Get("Range"); r != "" {
+		header.Set("Range", r)
+	}
+	resp, err := fetch(header)
+	if err == nil && resp.StatusCode == http.StatusPartialContent && !ifRangeMatch(req.Header.Get("If-Range"), resp) {
+		// the client's copy is stale, so it gets the whole object
+		resp.Body.Close()
+		resp, err = fetch(nil)
+	}
+	if err != nil {
+		if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer resp.Body.Close()
+
+	if setValidators(w, req, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	setCacheHeaders(w, opts, urlPath)
+	if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl+", no-transform")
+	} else {
+		w.Header().Set("Cache-Control", "no-transform")
+	}
+
+	// mediaTypes take the place of the system's table, which e.g. types .ts
+	// as typescript or qt linguist, but not of types configured explicitly
+	ext := strings.ToLower(path.Ext(key))
+	contentType, ok := types[ext]
+	if !ok {
+		contentType, ok = mediaTypes[ext]
+	}
+	if !ok {
+		contentType = types.TypeByExtension(key)
+	}
+	if stored := resp.Header.Get("Content-Type"); contentType == "" && stored != s3DefaultType {
+		contentType = stored
+	}
+	if contentType == "" {
+		contentType = opts.DefaultType
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("X-Accel-Buffering", "no")
+	for _, name := range []string{"Content-Length", "Content-Range"} {
+		if value := resp.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	flushCopy(w, resp.Body)
+}
+
+// ifRangeMatch returns true when ifRange, an etag or date, names the
+// object in resp, or is empty
+func ifRangeMatch(ifRange string, resp *http.Response) bool {
+	switch {
+	case ifRange == "":
+		return true
+	case strings.HasPrefix(ifRange, `"`):
+		return ifRange == resp.Header.Get("ETag")
+	default:
+		return ifRange == resp.Header.Get("Last-Modified")
+	}
+}
+
+// flushCopy copies src to w, flushing each read so clients see data as s3
+// sends it
+func flushCopy(w http.ResponseWriter, src io.Reader) (int64, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return copyPooled(w, src)
+	}
+
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	var written int64
+	for {
+		n, err := src.Read(*buf)
+		if n > 0 {
+			if _, err := w.Write((*buf)[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			flusher.Flush()
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package s3site
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+// fakeMedia returns a fetch serving data as s3 would, honoring ranges
+func fakeMedia(data []byte, etag string, fetches *int) func(http.Header) (*http.Response, error) {
+	return func(header http.Header) (*http.Response, error) {
+		*fetches++
+		req := httptest.NewRequest("GET", "/segment", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "binary/octet-stream")
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+		resp := w.Result()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return nil, &s3.Error{StatusCode: resp.StatusCode}
+		}
+		return resp, nil
+	}
+}
+
+func TestMediaMatches(t *testing.T) {
+	media := &Media{Paths: []string{"/video/"}}
+	for urlPath, expected := range map[string]bool{
+		"/live/index.m3u8":  true,
+		"/live/seg-001.ts":  true,
+		"/dash/stream.mpd":  true,
+		"/video/clip":       true,
+		"/index.html":       false,
+		"/docs/readme.txt":  false,
+		"/captions/en.VTT":  true,
+		"/images/photo.jpg": false,
+	} {
+		if media.Matches(urlPath) != expected {
+			t.Errorf("%s: expected %v", urlPath, expected)
+		}
+	}
+	var none *Media
+	if none.Matches("/live/index.m3u8") {
+		t.Error("expected nothing streamed without media")
+	}
+}
+
+func TestMediaServe(t *testing.T) {
+	data := []byte("0123456789")
+	opts := &Options{MaxAge: 60, DefaultType: "application/octet-stream"}
+	media := &Media{}
+
+	fetches := 0
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/live/seg.ts", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	media.Serve(w, req, opts, MimeTypes{}, "/live/seg.ts", "site/live/seg.ts", fakeMedia(data, `"v1"`, &fetches))
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Errorf("expected bytes 2-5; got %d %q", w.Code, w.Body.String())
+	}
+	for name, expected := range map[string]string{
+		"Content-Type":      "video/mp2t",
+		"Content-Range":     "bytes 2-5/10",
+		"Content-Length":    "4",
+		"Accept-Ranges":     "bytes",
+		"Cache-Control":     "max-age=60, no-transform",
+		"X-Accel-Buffering": "no",
+	} {
+		if value := w.Header().Get(name); value != expected {
+			t.Errorf("expected %s %q; got %q", name, expected, value)
+		}
+	}
+	if !w.Flushed {
+		t.Error("expected the body flushed")
+	}
+
+	fetches = 0
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/live/seg.ts", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	req.Header.Set("If-Range", `"v0"`)
+	media.Serve(w, req, opts, MimeTypes{}, "/live/seg.ts", "site/live/seg.ts", fakeMedia(data, `"v1"`, &fetches))
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" || fetches != 2 {
+		t.Errorf("expected the whole object for a stale If-Range; got %d %q after %d fetches", w.Code, w.Body.String(), fetches)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/live/seg.ts", nil)
+	req.Header.Set("Range", "bytes=20-30")
+	media.Serve(w, req, opts, MimeTypes{}, "/live/seg.ts", "site/live/seg.ts", fakeMedia(data, `"v1"`, &fetches))
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected 416; got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/live/index.m3u8", nil)
+	media.Serve(w, req, &Options{}, MimeTypes{".m3u8": "application/x-mpegurl"}, "/live/index.m3u8", "site/live/index.m3u8", fakeMedia(data, `"v1"`, &fetches))
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-mpegurl" {
+		t.Errorf("expected the configured type to win; got %s", ct)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-transform" {
+		t.Errorf("expected no-transform; got %s", cc)
+	}
+}
@@ -0,0 +1,560 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, braces(labels), h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(labels), h.count)
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// Metrics collects request and s3 statistics for export in the
+// prometheus text format
+type Metrics struct {
+	inFlight  int64
+	openConns int64
+	s3Bytes   int64
+	authFails int64
+	started   time.Time
+
+	mu          sync.Mutex
+	requests    map[int]uint64
+	latency     *histogram
+	routes      map[string]*histogram
+	bytesServed uint64
+	s3Calls     map[string]uint64
+	s3Latency   map[string]*histogram
+	variants    map[string]uint64
+	caches      []*Cache
+
+	// Pricing, if set, is used to report an estimated monthly s3 cost
+	Pricing *S3Pricing
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  map[int]uint64{},
+		latency:   newHistogram(),
+		routes:    map[string]*histogram{},
+		s3Calls:   map[string]uint64{},
+		s3Latency: map[string]*histogram{},
+		variants:  map[string]uint64{},
+		started:   time.Now(),
+	}
+}
+
+// ObserveRequest records a completed request
+func (m *Metrics) ObserveRequest(status int, bytes int64, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[status]++
+	m.latency.observe(elapsed.Seconds())
+	m.bytesServed += uint64(bytes)
+}
+
+// ObserveRoute records the latency of a completed request by route class,
+// cache outcome, and status code
+func (m *Metrics) ObserveRoute(route, cache string, status int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf("route=\"%s\",cache=\"%s\",code=\"%d\"", route, cache, status)
+	h, ok := m.routes[key]
+	if !ok {
+		h = newHistogram()
+		m.routes[key] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// ObserveVariant records a request served an experiment variant
+func (m *Metrics) ObserveVariant(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.variants[name]++
+}
+
+// routeClass buckets urlPath as admin, listing (a directory), html, or asset
+func routeClass(urlPath string) string {
+	switch {
+	case strings.HasPrefix(urlPath, "/-/"):
+		return "admin"
+	case strings.HasSuffix(urlPath, "/"):
+		return "listing"
+	case strings.HasSuffix(urlPath, ".html"), strings.HasSuffix(urlPath, ".htm"):
+		return "html"
+	default:
+		return "asset"
+	}
+}
+
+// ObserveAuthFailure records a request rejected for bad credentials
+func (m *Metrics) ObserveAuthFailure() {
+	atomic.AddInt64(&m.authFails, 1)
+}
+
+// AuthFailures returns the number of requests rejected for bad credentials
+func (m *Metrics) AuthFailures() int64 {
+	return atomic.LoadInt64(&m.authFails)
+}
+
+// S3Failures returns the number of calls to s3 along with how many of them
+// failed outright or with a 5xx
+func (m *Metrics) S3Failures() (calls, failures uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for call, n := range m.s3Calls {
+		calls += n
+		result := strings.SplitN(call, "|", 2)[1]
+		if result == "error" || strings.HasPrefix(result, "5") {
+			failures += n
+		}
+	}
+	return calls, failures
+}
+
+// ObserveS3 records a call to s3; op is one of get, head, list, put, or delete
+func (m *Metrics) ObserveS3(op, result string, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.s3Calls[op+"|"+result]++
+	h, ok := m.s3Latency[op]
+	if !ok {
+		h = newHistogram()
+		m.s3Latency[op] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// RequestTotals is a point-in-time copy of the request counters
+type RequestTotals struct {
+	Requests uint64
+	Errors   uint64 // responses with a 5xx status
+	Bytes    uint64
+
+	// Latency counts requests per latencyBuckets with a final overflow
+	// bucket; unlike the prometheus histogram the counts are not cumulative
+	Latency    []uint64
+	LatencySum float64
+}
+
+// Totals returns the request counters accumulated since startup
+func (m *Metrics) Totals() RequestTotals {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	totals := RequestTotals{
+		Bytes:      m.bytesServed,
+		Latency:    make([]uint64, len(latencyBuckets)+1),
+		LatencySum: m.latency.sum,
+	}
+	for code, n := range m.requests {
+		totals.Requests += n
+		if code >= 500 {
+			totals.Errors += n
+		}
+	}
+
+	var below uint64
+	for i, n := range m.latency.counts {
+		totals.Latency[i] = n - below
+		below = n
+	}
+	totals.Latency[len(latencyBuckets)] = m.latency.count - below
+	return totals
+}
+
+// Sub returns the change in counters since prev
+func (t RequestTotals) Sub(prev RequestTotals) RequestTotals {
+	delta := RequestTotals{
+		Requests:   t.Requests - prev.Requests,
+		Errors:     t.Errors - prev.Errors,
+		Bytes:      t.Bytes - prev.Bytes,
+		Latency:    make([]uint64, len(t.Latency)),
+		LatencySum: t.LatencySum - prev.LatencySum,
+	}
+	for i := range t.Latency {
+		delta.Latency[i] = t.Latency[i]
+		if i < len(prev.Latency) {
+			delta.Latency[i] -= prev.Latency[i]
+		}
+	}
+	return delta
+}
+
+// Percentile estimates the q (0..1) latency percentile in seconds as the
+// upper bound of the bucket it falls in
+func (t RequestTotals) Percentile(q float64) float64 {
+	var count uint64
+	for _, n := range t.Latency {
+		count += n
+	}
+	if count == 0 {
+		return 0
+	}
+
+	rank := uint64(q*float64(count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range t.Latency {
+		seen += n
+		if seen >= rank && i < len(latencyBuckets) {
+			return latencyBuckets[i]
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+// Instrument wraps h to record the status, size, and latency of each request
+func (m *Metrics) Instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&m.inFlight, 1)
+		defer atomic.AddInt64(&m.inFlight, -1)
+
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, req)
+
+		elapsed := time.Since(started)
+		m.ObserveRequest(recorder.Status(), recorder.bytes, elapsed)
+
+		cache := w.Header().Get("X-Cache")
+		if cache == "" {
+			cache = "NONE"
+		}
+		m.ObserveRoute(routeClass(req.URL.Path), cache, recorder.Status(), elapsed)
+	})
+}
+
+// ConnState tracks open client connections; assign it to http.Server.ConnState
+func (m *Metrics) ConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		atomic.AddInt64(&m.openConns, 1)
+	case http.StateHijacked, http.StateClosed:
+		atomic.AddInt64(&m.openConns, -1)
+	}
+}
+
+// InFlight returns the number of requests currently being served
+func (m *Metrics) InFlight() int64 {
+	return atomic.LoadInt64(&m.inFlight)
+}
+
+// OpenConns returns the number of open client connections
+func (m *Metrics) OpenConns() int64 {
+	return atomic.LoadInt64(&m.openConns)
+}
+
+// Transport wraps rt to record each call made to s3
+func (m *Metrics) Transport(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		started := time.Now()
+		resp, err := rt.RoundTrip(req)
+
+		result := "error"
+		if err == nil {
+			result = fmt.Sprintf("%d", resp.StatusCode)
+			resp.Body = &countingReader{ReadCloser: resp.Body, n: &m.s3Bytes}
+		}
+		m.ObserveS3(s3Op(req), result, time.Since(started))
+		return resp, err
+	})
+}
+
+// s3Op classifies an s3 request by the api call it makes
+func s3Op(req *http.Request) string {
+	switch req.Method {
+	case "HEAD":
+		return "head"
+	case "PUT", "POST":
+		return "put"
+	case "DELETE":
+		return "delete"
+	}
+
+	// a GET of the bucket itself, rather than a key within it, is a listing
+	if _, ok := req.URL.Query()["prefix"]; ok {
+		return "list"
+	}
+	return "get"
+}
+
+// ServeHTTP writes the metrics in the prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeBuildInfo(w, CurrentBuild())
+
+	m.mu.Lock()
+	fmt.Fprintln(w, "# HELP s3site_http_requests_total Requests served by status code.")
+	fmt.Fprintln(w, "# TYPE s3site_http_requests_total counter")
+	codes := make([]int, 0, len(m.requests))
+	for code := range m.requests {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "s3site_http_requests_total{code=\"%d\"} %d\n", code, m.requests[code])
+	}
+
+	fmt.Fprintln(w, "# HELP s3site_http_request_duration_seconds Request latency.")
+	fmt.Fprintln(w, "# TYPE s3site_http_request_duration_seconds histogram")
+	m.latency.write(w, "s3site_http_request_duration_seconds", "")
+
+	fmt.Fprintln(w, "# HELP s3site_http_route_duration_seconds Request latency by route class, cache outcome, and status code.")
+	fmt.Fprintln(w, "# TYPE s3site_http_route_duration_seconds histogram")
+	routes := make([]string, 0, len(m.routes))
+	for route := range m.routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		m.routes[route].write(w, "s3site_http_route_duration_seconds", route)
+	}
+
+	fmt.Fprintln(w, "# HELP s3site_http_response_bytes_total Response body bytes served.")
+	fmt.Fprintln(w, "# TYPE s3site_http_response_bytes_total counter")
+	fmt.Fprintf(w, "s3site_http_response_bytes_total %d\n", m.bytesServed)
+
+	fmt.Fprintln(w, "# HELP s3site_auth_failures_total Requests rejected for bad credentials.")
+	fmt.Fprintln(w, "# TYPE s3site_auth_failures_total counter")
+	fmt.Fprintf(w, "s3site_auth_failures_total %d\n", m.AuthFailures())
+
+	fmt.Fprintln(w, "# HELP s3site_http_requests_in_flight Requests currently being served.")
+	fmt.Fprintln(w, "# TYPE s3site_http_requests_in_flight gauge")
+	fmt.Fprintf(w, "s3site_http_requests_in_flight %d\n", m.InFlight())
+
+	fmt.Fprintln(w, "# HELP s3site_http_open_connections Open client connections.")
+	fmt.Fprintln(w, "# TYPE s3site_http_open_connections gauge")
+	fmt.Fprintf(w, "s3site_http_open_connections %d\n", m.OpenConns())
+
+	fmt.Fprintln(w, "# HELP s3site_s3_requests_total Calls made to s3 by operation and result.")
+	fmt.Fprintln(w, "# TYPE s3site_s3_requests_total counter")
+	calls := make([]string, 0, len(m.s3Calls))
+	for call := range m.s3Calls {
+		calls = append(calls, call)
+	}
+	sort.Strings(calls)
+	for _, call := range calls {
+		parts := strings.SplitN(call, "|", 2)
+		fmt.Fprintf(w, "s3site_s3_requests_total{op=\"%s\",result=\"%s\"} %d\n", parts[0], parts[1], m.s3Calls[call])
+	}
+
+	fmt.Fprintln(w, "# HELP s3site_s3_request_duration_seconds Latency of calls to s3.")
+	fmt.Fprintln(w, "# TYPE s3site_s3_request_duration_seconds histogram")
+	ops := make([]string, 0, len(m.s3Latency))
+	for op := range m.s3Latency {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		m.s3Latency[op].write(w, "s3site_s3_request_duration_seconds", fmt.Sprintf("op=\"%s\"", op))
+	}
+
+	if len(m.variants) > 0 {
+		fmt.Fprintln(w, "# HELP s3site_variant_requests_total Requests served by experiment variant.")
+		fmt.Fprintln(w, "# TYPE s3site_variant_requests_total counter")
+		names := make([]string, 0, len(m.variants))
+		for name := range m.variants {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "s3site_variant_requests_total{variant=\"%s\"} %d\n", name, m.variants[name])
+		}
+	}
+	m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP s3site_s3_response_bytes_total Bytes transferred from s3.")
+	fmt.Fprintln(w, "# TYPE s3site_s3_response_bytes_total counter")
+	fmt.Fprintf(w, "s3site_s3_response_bytes_total %d\n", atomic.LoadInt64(&m.s3Bytes))
+
+	if m.Pricing != nil {
+		estimate := m.Pricing.Estimate(m.S3Usage())
+		fmt.Fprintln(w, "# HELP s3site_s3_estimated_monthly_cost_dollars Projected monthly s3 request and egress cost at the current rate.")
+		fmt.Fprintln(w, "# TYPE s3site_s3_estimated_monthly_cost_dollars gauge")
+		fmt.Fprintf(w, "s3site_s3_estimated_monthly_cost_dollars %g\n", estimate.MonthlyCost)
+		fmt.Fprintln(w, "# HELP s3site_s3_estimated_monthly_savings_dollars Projected monthly s3 cost avoided by the cache.")
+		fmt.Fprintln(w, "# TYPE s3site_s3_estimated_monthly_savings_dollars gauge")
+		fmt.Fprintf(w, "s3site_s3_estimated_monthly_savings_dollars %g\n", estimate.MonthlySavings)
+	}
+
+	if snapshot, ok := m.CacheSnapshot(); ok {
+		writeCacheMetrics(w, snapshot)
+	}
+}
+
+// AddCache reports cache alongside the request metrics; each site's cache
+// is added, and their stats are summed
+func (m *Metrics) AddCache(cache *Cache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.caches = append(m.caches, cache)
+}
+
+// Caches returns the caches added with AddCache
+func (m *Metrics) Caches() []*Cache {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Cache(nil), m.caches...)
+}
+
+// CacheSnapshot sums the snapshots of the caches added, reporting false if
+// there are none
+func (m *Metrics) CacheSnapshot() (CacheSnapshot, bool) {
+	caches := m.Caches()
+	total := CacheSnapshot{}
+	for _, cache := range caches {
+		total = total.add(cache.Snapshot())
+	}
+	return total, len(caches) > 0
+}
+
+func writeCacheMetrics(w io.Writer, snapshot CacheSnapshot) {
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP s3site_cache_%s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE s3site_cache_%s %s\n", name, kind)
+		fmt.Fprintf(w, "s3site_cache_%s %v\n", name, value)
+	}
+
+	metric("entries", "gauge", "Objects held in memory.", snapshot.Entries)
+	metric("bytes", "gauge", "Bytes held in memory.", snapshot.Bytes)
+	metric("max_bytes", "gauge", "Capacity of the memory cache.", snapshot.MaxBytes)
+	metric("hits_total", "counter", "Lookups served fresh from the cache.", snapshot.Hits)
+	metric("stale_total", "counter", "Lookups served stale from the cache.", snapshot.Stale)
+	metric("misses_total", "counter", "Lookups that required a fetch from s3.", snapshot.Misses)
+	metric("evictions_total", "counter", "Entries evicted to make room.", snapshot.Evictions)
+	metric("rejected_total", "counter", "Entries refused by the admission policy.", snapshot.Rejected)
+	metric("fills_total", "counter", "Objects fetched from s3 into the cache.", snapshot.Fills)
+	metric("revalidations_total", "counter", "Conditional requests made to s3.", snapshot.Revalidations)
+	metric("not_modified_total", "counter", "Conditional requests answered with 304.", snapshot.NotModified)
+	metric("bytes_saved_total", "counter", "Bytes served without fetching from s3.", snapshot.BytesSaved)
+}
+
+// countingReader adds the bytes read through it to n
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+// statusRecorder captures the status code and body size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the response status, 200 if none was written explicitly
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
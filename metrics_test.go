@@ -0,0 +1,90 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.AddCache(NewCache(10))
+
+	handler := metrics.Instrument(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	for _, path := range []string{"/", "/", "/missing"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer s3.Close()
+	client := &http.Client{Transport: metrics.Transport(http.DefaultTransport)}
+	client.Get(s3.URL + "/bucket/key")
+	client.Get(s3.URL + "/bucket/?prefix=docs/")
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, nil)
+	body := w.Body.String()
+
+	for _, expected := range []string{
+		`s3site_http_requests_total{code="200"} 2`,
+		`s3site_http_requests_total{code="404"} 1`,
+		`s3site_http_request_duration_seconds_count 3`,
+		`s3site_http_response_bytes_total 10`,
+		`s3site_s3_requests_total{op="get",result="200"} 1`,
+		`s3site_s3_requests_total{op="list",result="200"} 1`,
+		`s3site_s3_request_duration_seconds_bucket{op="get",le="+Inf"} 1`,
+		`s3site_cache_max_bytes 10`,
+		`s3site_http_route_duration_seconds_count{route="listing",cache="NONE",code="200"} 2`,
+		`s3site_http_route_duration_seconds_count{route="asset",cache="NONE",code="404"} 1`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected metrics to contain %s", expected)
+		}
+	}
+}
+
+func TestRouteClass(t *testing.T) {
+	testCases := map[string]string{
+		"/":              "listing",
+		"/docs/":         "listing",
+		"/index.html":    "html",
+		"/old/page.htm":  "html",
+		"/css/site.css":  "asset",
+		"/-/cache":       "admin",
+		"/images/a.jpeg": "asset",
+	}
+	for urlPath, expected := range testCases {
+		if class := routeClass(urlPath); class != expected {
+			t.Errorf("expected %s to be %s, got %s", urlPath, expected, class)
+		}
+	}
+}
+
+func TestMetricsCaches(t *testing.T) {
+	metrics := NewMetrics()
+	if _, ok := metrics.CacheSnapshot(); ok {
+		t.Error("expected no snapshot without a cache")
+	}
+
+	a, b := NewCache(10), NewCache(20)
+	a.Stats.Record(CacheHit, &Entry{Body: []byte("x")})
+	b.Stats.Record(CacheMiss, nil)
+	metrics.AddCache(a)
+	metrics.AddCache(b)
+
+	snapshot, ok := metrics.CacheSnapshot()
+	if !ok || snapshot.MaxBytes != 30 || snapshot.Hits != 1 || snapshot.Misses != 1 || snapshot.HitRatio != .5 {
+		t.Errorf("expected the sites' caches to be summed, got %+v", snapshot)
+	}
+	if len(metrics.Caches()) != 2 {
+		t.Errorf("expected both sites' caches, got %d", len(metrics.Caches()))
+	}
+}
@@ -0,0 +1,177 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// s3DefaultType is stored by s3 for objects uploaded without a type, so
+	// says nothing of the content
+	s3DefaultType = "binary/octet-stream"
+
+	// sniffLen is the most http.DetectContentType reads
+	sniffLen = 512
+)
+
+// modernTypes covers extensions the standard library's table, and many
+// systems' mime.types, are missing or get wrong
+var modernTypes = MimeTypes{
+	".avif":        "image/avif",
+	".geojson":     "application/geo+json",
+	".json":        "application/json",
+	".jsonld":      "application/ld+json",
+	".map":         "application/json",
+	".mjs":         "text/javascript; charset=utf-8",
+	".wasm":        "application/wasm",
+	".webmanifest": "application/manifest+json",
+	".webp":        "image/webp",
+	".woff2":       "font/woff2",
+}
+
+// MimeTypes maps lowercase file extensions, with their leading dot, to
+// content types
+type MimeTypes map[string]string
+
+// TypeByExtension returns the content type for the extension of name; from
+// m, then the modern types, then the standard library
+func (m MimeTypes) TypeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if t, ok := m[ext]; ok {
+		return t
+	}
+	if t, ok := modernTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// contentType returns the content type to serve name with, along with the
+// reader to serve its body from in place of body.  The type is the one for
+// its extension, else the one stored in s3, else, when sniffing, the one
+// detected from the first 512 bytes of body, else the default type; text
+// types are given the charset.
+func (o *Options) contentType(types MimeTypes, name, stored string, body io.Reader) (string, io.Reader) {
+	t := types.TypeByExtension(name)
+	if t == "" && stored != s3DefaultType {
+		t = stored
+	}
+	if t == "" && o.Sniff {
+		head := make([]byte, sniffLen)
+		n, _ := io.ReadFull(body, head)
+		t = http.DetectContentType(head[:n])
+		body = io.MultiReader(bytes.NewReader(head[:n]), body)
+	}
+	if t == "" {
+		t = o.DefaultType
+	}
+	return withCharset(t, o.Charset), body
+}
+
+// withCharset adds charset to text types that don't name one
+func withCharset(contentType, charset string) string {
+	if charset == "" || contentType == "" {
+		return contentType
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["charset"] != "" || !isText(mediaType) {
+		return contentType
+	}
+	return contentType + "; charset=" + charset
+}
+
+// isText returns true for media types holding text e.g. text/css or
+// application/json
+func isText(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// LoadMimeTypes reads file, in the mime.types format of a type followed by
+// its extensions, then applies overrides of the form .ext=type; file may be
+// empty
+func LoadMimeTypes(file string, overrides []string) (MimeTypes, error) {
+	types := MimeTypes{}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text := scanner.Text()
+			if i := strings.Index(text, "#"); i >= 0 {
+				text = text[:i]
+			}
+			fields := strings.Fields(text)
+			if len(fields) == 0 {
+				continue
+			}
+			if _, _, err := mime.ParseMediaType(fields[0]); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid type, %s", file, line, fields[0])
+			}
+			for _, ext := range fields[1:] {
+				types["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = fields[0]
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, value := range overrides {
+		index := strings.Index(value, "=")
+		if index <= 1 || value[0] != '.' {
+			return nil, fmt.Errorf("invalid mime type, %s; expected .ext=type", value)
+		}
+		t := value[index+1:]
+		if _, _, err := mime.ParseMediaType(t); err != nil {
+			return nil, fmt.Errorf("invalid mime type, %s: %s", value, err)
+		}
+		types[strings.ToLower(value[:index])] = t
+	}
+	return types, nil
+}
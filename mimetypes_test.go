@@ -0,0 +1,119 @@
+package s3site
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMimeTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mimetypes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "mime.types")
+	ioutil.WriteFile(file, []byte(`
+# custom types
+model/gltf-binary    glb
+text/x-markdown      md markdown
+`), 0644)
+
+	types, err := LoadMimeTypes(file, []string{".MD=text/markdown; charset=utf-8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]string{
+		"site/models/duck.glb":  "model/gltf-binary",
+		"site/README.md":        "text/markdown; charset=utf-8",
+		"site/notes.markdown":   "text/x-markdown",
+		"site/app.wasm":         "application/wasm",
+		"site/photo.AVIF":       "image/avif",
+		"site/map.geojson":      "application/geo+json",
+		"site/module.mjs":       "text/javascript; charset=utf-8",
+		"site/index.html":       "text/html; charset=utf-8",
+		"site/LICENSE":          "",
+		"site/v1.2/app.unknown": "",
+	}
+	for name, expected := range testCases {
+		if actual := types.TypeByExtension(name); actual != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, actual)
+		}
+	}
+
+	var none MimeTypes
+	if actual := none.TypeByExtension("app.wasm"); actual != "application/wasm" {
+		t.Errorf("expected the modern types without a mapping, got %s", actual)
+	}
+}
+
+func TestLoadMimeTypesErrors(t *testing.T) {
+	for _, override := range []string{"md=text/markdown", ".md", "=text/plain", ".md=not a type"} {
+		if _, err := LoadMimeTypes("", []string{override}); err == nil {
+			t.Errorf("%s: expected an error", override)
+		}
+	}
+	if _, err := LoadMimeTypes("/does/not/exist", nil); err == nil {
+		t.Error("expected a missing file to be an error")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	opts := &Options{DefaultType: "application/octet-stream", Charset: "utf-8"}
+	types := MimeTypes{".csv": "text/csv", ".txt": "text/plain; charset=iso-8859-1"}
+
+	testCases := map[string]string{
+		"site/data.csv":             "text/csv; charset=utf-8",
+		"site/notes.txt":            "text/plain; charset=iso-8859-1",
+		"site/index.html":           "text/html; charset=utf-8",
+		"site/data.json":            "application/json; charset=utf-8",
+		"site/manifest.webmanifest": "application/manifest+json; charset=utf-8",
+		"site/logo.png":             "image/png",
+		"site/LICENSE":              "application/octet-stream",
+	}
+	for name, expected := range testCases {
+		if actual, _ := opts.contentType(types, name, "", nil); actual != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, actual)
+		}
+	}
+
+	opts = &Options{}
+	if actual, _ := opts.contentType(types, "site/data.csv", "", nil); actual != "text/csv" {
+		t.Errorf("expected no charset when disabled, got %s", actual)
+	}
+	if actual, _ := opts.contentType(types, "site/LICENSE", "", nil); actual != "" {
+		t.Errorf("expected no type without a default, got %s", actual)
+	}
+}
+
+func TestContentTypeSniff(t *testing.T) {
+	opts := &Options{DefaultType: "application/octet-stream", Sniff: true}
+	page := "<!DOCTYPE html><html><body>" + strings.Repeat("hello ", 200) + "</body></html>"
+
+	contentType, body := opts.contentType(nil, "site/about", "", strings.NewReader(page))
+	if contentType != "text/html; charset=utf-8" {
+		t.Errorf("expected html to be sniffed, got %s", contentType)
+	}
+	if data, _ := ioutil.ReadAll(body); string(data) != page {
+		t.Error("expected the sniffed bytes to still be served")
+	}
+
+	if contentType, _ := opts.contentType(nil, "site/about", "text/plain", strings.NewReader(page)); contentType != "text/plain" {
+		t.Errorf("expected the type stored in s3 to win over sniffing, got %s", contentType)
+	}
+	if contentType, _ := opts.contentType(nil, "site/about", "binary/octet-stream", strings.NewReader(page)); contentType != "text/html; charset=utf-8" {
+		t.Errorf("expected s3's default type to be sniffed past, got %s", contentType)
+	}
+	if contentType, _ := opts.contentType(nil, "site/about.css", "", strings.NewReader(page)); contentType != "text/css; charset=utf-8" {
+		t.Errorf("expected the extension to win over sniffing, got %s", contentType)
+	}
+
+	opts.Sniff = false
+	if contentType, _ := opts.contentType(nil, "site/about", "", strings.NewReader(page)); contentType != "application/octet-stream" {
+		t.Errorf("expected the default type without sniffing, got %s", contentType)
+	}
+}
@@ -0,0 +1,465 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Minifier strips comments and whitespace from html, css, and javascript
+// as they're served, caching the result by the source's etag
+type Minifier struct {
+	// Types holds the kinds minified; html, css, or js
+	Types map[string]bool
+	Cache *Cache
+}
+
+// NewMinifier returns a minifier for the kinds given, holding cacheSize MB
+// of results.  It returns nil when there are none.
+func NewMinifier(kinds []string, cacheSize int) (*Minifier, error) {
+	if len(kinds) == 0 {
+		return nil, nil
+	}
+	m := &Minifier{Types: map[string]bool{}, Cache: NewCache(int64(cacheSize) << 20)}
+	for _, value := range kinds {
+		for _, kind := range strings.Split(value, ",") {
+			kind = strings.ToLower(strings.TrimSpace(kind))
+			switch kind {
+			case "html", "css", "js":
+				m.Types[kind] = true
+			default:
+				return nil, fmt.Errorf("unknown type to minify, %s", kind)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Kind returns the kind of content served as contentType, or "" when it
+// isn't minified
+func (m *Minifier) Kind(key, contentType string) string {
+	if m == nil || strings.HasSuffix(key, ".min.js") || strings.HasSuffix(key, ".min.css") {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	kind := ""
+	switch mediaType {
+	case "text/html":
+		kind = "html"
+	case "text/css":
+		kind = "css"
+	case "text/javascript", "application/javascript", "application/x-javascript":
+		kind = "js"
+	}
+	if !m.Types[kind] {
+		return ""
+	}
+	return kind
+}
+
+// Minify returns src, of the kind given, minified
+func (m *Minifier) Minify(kind string, src []byte) []byte {
+	switch kind {
+	case "html":
+		return minifyHTML(src)
+	case "css":
+		return minifyCSS(src)
+	case "js":
+		return minifyJS(src)
+	}
+	return src
+}
+
+// Serve writes body, stored at key with etag, minified and tagged with an
+// etag of its own, so the minified variant isn't confused with the object
+func (m *Minifier) Serve(w http.ResponseWriter, req *http.Request, key, etag, lastModified, kind string, body io.Reader) {
+	if setValidators(w, req, VariantETag(etag, "min"), lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	variant := "min&etag=" + etag
+	if entry, ok := m.Cache.Get(CacheKey(key, variant)); ok && etag != "" {
+		w.Write(entry.Body)
+		return
+	}
+
+	src, err := ioutil.ReadAll(io.LimitReader(body, maxRendered+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if len(src) > maxRendered {
+		// too large to minify; serve it as is
+		w.Write(src)
+		copyPooled(w, body)
+		return
+	}
+
+	minified := m.Minify(kind, src)
+	if etag != "" {
+		m.Cache.Set(&Entry{Key: key, Variant: variant, Body: minified, ETag: etag, Fetched: time.Now()})
+	}
+	w.Write(minified)
+}
+
+// isSpace returns true for the whitespace html, css, and javascript share
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// endOfString returns the index just past the string literal starting at
+// src[i], which holds its quote
+func endOfString(src []byte, i int) int {
+	quote := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		}
+	}
+	return len(src)
+}
+
+// rawElements hold text that whitespace matters to, or that isn't html
+var rawElements = []string{"pre", "textarea", "script", "style"}
+
+// minifyHTML drops comments, other than conditional comments, and collapses
+// runs of whitespace between tags to one space or newline.  Attributes, and
+// the contents of pre, textarea, script, and style elements, are left as
+// they are.
+func minifyHTML(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case bytes.HasPrefix(src[i:], []byte("<!--")):
+			end := bytes.Index(src[i+4:], []byte("-->"))
+			if end < 0 {
+				end = len(src) - i - 4
+			} else {
+				end += 3
+			}
+			if bytes.HasPrefix(src[i+4:], []byte("[if")) || bytes.HasPrefix(src[i+4:], []byte("<![endif]")) {
+				out = append(out, src[i:i+4+end]...)
+			}
+			i += 4 + end
+
+		case c == '<' && i+1 < len(src) && (isLetter(src[i+1]) || src[i+1] == '/' || src[i+1] == '!'):
+			j := i + 1
+			for j < len(src) && src[j] != '>' {
+				if src[j] == '"' || src[j] == '\'' {
+					j = endOfString(src, j)
+					continue
+				}
+				j++
+			}
+			if j < len(src) {
+				j++
+			}
+			out = append(out, src[i:j]...)
+			tag := src[i:j]
+			i = j
+			for _, name := range rawElements {
+				if hasTagName(tag, name) {
+					end := indexFold(src[i:], "</"+name)
+					if end < 0 {
+						end = len(src) - i
+					}
+					out = append(out, src[i:i+end]...)
+					i += end
+					break
+				}
+			}
+
+		case isSpace(c):
+			j, newline := i, false
+			for j < len(src) && isSpace(src[j]) {
+				newline = newline || src[j] == '\n'
+				j++
+			}
+			// a dropped comment may leave two runs side by side
+			if len(out) > 0 && isSpace(out[len(out)-1]) {
+				newline = newline || out[len(out)-1] == '\n'
+				out = out[:len(out)-1]
+			}
+			if newline {
+				out = append(out, '\n')
+			} else {
+				out = append(out, ' ')
+			}
+			i = j
+
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+	return bytes.TrimSpace(out)
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// hasTagName returns true when tag is an opening tag named name
+func hasTagName(tag []byte, name string) bool {
+	if len(tag) < len(name)+2 || !strings.EqualFold(string(tag[1:1+len(name)]), name) {
+		return false
+	}
+	next := tag[1+len(name)]
+	return next == '>' || next == '/' || isSpace(next)
+}
+
+// indexFold returns the index of the first instance of s in b, ignoring
+// ascii case, or -1
+func indexFold(b []byte, s string) int {
+	return strings.Index(strings.ToLower(string(b)), s)
+}
+
+// minifyCSS drops comments and the whitespace around braces, semicolons,
+// commas, and child combinators, and the last semicolon of each block.
+// Strings are left as they are.
+func minifyCSS(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	space := false
+	separator := func(c byte) bool { return strings.IndexByte("{};,>", c) >= 0 }
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				i = len(src)
+				break
+			}
+			i += end + 3
+			space = true
+
+		case isSpace(c):
+			space = true
+
+		default:
+			if space && len(out) > 0 && !separator(out[len(out)-1]) && !separator(c) {
+				out = append(out, ' ')
+			}
+			space = false
+			if c == '}' && len(out) > 0 && out[len(out)-1] == ';' {
+				out = out[:len(out)-1]
+			}
+			if c == '"' || c == '\'' {
+				j := endOfString(src, i)
+				out = append(out, src[i:j]...)
+				i = j - 1
+				continue
+			}
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// regexAfter holds the characters after which a / starts a regular
+// expression rather than a division
+const regexAfter = "(,=:[!&|?{};+-*%<>~^"
+
+// regexKeywords are the keywords after which a / starts a regular
+// expression
+var regexKeywords = []string{"return", "typeof", "case", "do", "else", "in", "instanceof", "new", "void", "delete", "throw", "yield", "await"}
+
+// minifyJS drops comments, other than /*! license comments, and collapses
+// runs of whitespace, keeping a newline wherever the source had one so
+// automatic semicolon insertion is unchanged.  Strings, template literals,
+// and regular expressions are left as they are.
+func minifyJS(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	// pending is the whitespace to write before the next token; ' ' or '\n'
+	var pending byte
+	// templates holds the brace depth of each ${ being read within a
+	// template literal
+	var templates []int
+	depth := 0
+
+	emit := func(b []byte) {
+		if len(out) > 0 {
+			switch {
+			case pending == '\n':
+				out = append(out, '\n')
+			case pending == ' ' && needsSpace(out[len(out)-1], b[0]):
+				out = append(out, ' ')
+			}
+		}
+		pending = 0
+		out = append(out, b...)
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case isSpace(c):
+			if c == '\n' {
+				pending = '\n'
+			} else if pending == 0 {
+				pending = ' '
+			}
+
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			i--
+
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(src) - i - 2
+			} else {
+				end += 2
+			}
+			comment := src[i : i+2+end]
+			if bytes.HasPrefix(comment, []byte("/*!")) {
+				emit(comment)
+				pending = '\n'
+			} else if bytes.IndexByte(comment, '\n') >= 0 {
+				pending = '\n'
+			} else if pending == 0 {
+				pending = ' '
+			}
+			i += 1 + end
+
+		case c == '/' && startsRegex(out):
+			j, class, closed := i+1, false, false
+			for ; j < len(src) && src[j] != '\n'; j++ {
+				if src[j] == '\\' {
+					j++
+				} else if src[j] == '[' {
+					class = true
+				} else if src[j] == ']' {
+					class = false
+				} else if src[j] == '/' && !class {
+					closed = true
+					break
+				}
+			}
+			if !closed {
+				// not a regular expression after all
+				emit(src[i : i+1])
+				continue
+			}
+			j++
+			for j < len(src) && isIdentByte(src[j]) {
+				j++
+			}
+			emit(src[i:j])
+			i = j - 1
+
+		case c == '"' || c == '\'':
+			j := endOfString(src, i)
+			emit(src[i:j])
+			i = j - 1
+
+		case c == '`' || (c == '}' && len(templates) > 0 && templates[len(templates)-1] == depth):
+			if c == '}' {
+				templates = templates[:len(templates)-1]
+			}
+			j := i + 1
+			for ; j < len(src); j++ {
+				if src[j] == '\\' {
+					j++
+				} else if src[j] == '`' {
+					j++
+					break
+				} else if src[j] == '$' && j+1 < len(src) && src[j+1] == '{' {
+					j += 2
+					templates = append(templates, depth)
+					break
+				}
+			}
+			if j > len(src) {
+				j = len(src)
+			}
+			emit(src[i:j])
+			i = j - 1
+
+		default:
+			if c == '{' {
+				depth++
+			} else if c == '}' {
+				depth--
+			}
+			emit(src[i : i+1])
+		}
+	}
+	return out
+}
+
+// isIdentByte returns true for bytes of identifiers, numbers, and keywords
+func isIdentByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '$' || c == '\\' || c >= 0x80
+}
+
+// needsSpace returns true when a and b would read as one token, or start a
+// comment, if the space between them were dropped
+func needsSpace(a, b byte) bool {
+	switch {
+	case (isIdentByte(a) || a == '.') && (isIdentByte(b) || b == '.'):
+		return true
+	case a == b && (a == '+' || a == '-' || a == '/'):
+		return true
+	case a == '/' && b == '*':
+		return true
+	}
+	return false
+}
+
+// startsRegex returns true when a / following out starts a regular
+// expression
+func startsRegex(out []byte) bool {
+	i := len(out) - 1
+	for i >= 0 && isSpace(out[i]) {
+		i--
+	}
+	if i < 0 || strings.IndexByte(regexAfter, out[i]) >= 0 {
+		return true
+	}
+	j := i
+	for j >= 0 && isIdentByte(out[j]) {
+		j--
+	}
+	word := string(out[j+1 : i+1])
+	for _, keyword := range regexKeywords {
+		if word == keyword {
+			return j < 0 || out[j] != '.'
+		}
+	}
+	return false
+}
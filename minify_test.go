@@ -0,0 +1,161 @@
+package s3site
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	src := `<!DOCTYPE html>
+<html>
+  <head>
+    <!-- a comment -->
+    <!--[if IE]><p>ie</p><![endif]-->
+    <title>  Hello   World </title>
+    <script>
+      var a = 1;   // kept
+    </script>
+  </head>
+  <body class="a  b">
+    <pre>
+  keep   this
+    </pre>
+    <p>one    two</p>
+  </body>
+</html>
+`
+	expected := `<!DOCTYPE html>
+<html>
+<head>
+<!--[if IE]><p>ie</p><![endif]-->
+<title> Hello World </title>
+<script>
+      var a = 1;   // kept
+    </script>
+</head>
+<body class="a  b">
+<pre>
+  keep   this
+    </pre>
+<p>one two</p>
+</body>
+</html>`
+	if got := string(minifyHTML([]byte(src))); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestMinifyCSS(t *testing.T) {
+	testCases := []struct {
+		In, Out string
+	}{
+		{"a { color : red; }", "a{color : red}"},
+		{"/* c */ ul  >  li,\n  ol li { margin: 0 auto ; }", "ul>li,ol li{margin: 0 auto}"},
+		{`a::after { content: "  ;  } " }`, `a::after{content: "  ;  } "}`},
+		{"@media (min-width: 10px) {\n  a { b: c; }\n}", "@media (min-width: 10px){a{b: c}}"},
+		{"a :hover{}", "a :hover{}"},
+	}
+	for _, tc := range testCases {
+		if got := string(minifyCSS([]byte(tc.In))); got != tc.Out {
+			t.Errorf("%q: expected %q; got %q", tc.In, tc.Out, got)
+		}
+	}
+}
+
+func TestMinifyJS(t *testing.T) {
+	testCases := []struct {
+		In, Out string
+	}{
+		{"var a = 1 ;  // comment\nvar b = a / 2", "var a=1;\nvar b=a/2"},
+		{"/*! license */\nfunction f ( x ) {\n\n  return x\n}", "/*! license */\nfunction f(x){\nreturn x\n}"},
+		{"var s = ' a  // b '; /* gone */ var t", "var s=' a  // b ';var t"},
+		{"x = y.replace( /a  b\\/ [/]/g , '' )", "x=y.replace(/a  b\\/ [/]/g,'')"},
+		{"return /x  y/.test(s)", "return/x  y/.test(s)"},
+		{"a = b + +c - -d", "a=b+ +c- -d"},
+		{"t = `a  ${ b  +  `c  ${d}` }  e`", "t=`a  ${b+`c  ${d}`}  e`"},
+		{"if (a) { b }\nelse { c }", "if(a){b}\nelse{c}"},
+	}
+	for _, tc := range testCases {
+		if got := string(minifyJS([]byte(tc.In))); got != tc.Out {
+			t.Errorf("%q: expected %q; got %q", tc.In, tc.Out, got)
+		}
+	}
+}
+
+func TestMinifierKind(t *testing.T) {
+	m, err := NewMinifier([]string{"html,css"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		Key, ContentType, Kind string
+	}{
+		{"index.html", "text/html; charset=utf-8", "html"},
+		{"site.css", "text/css", "css"},
+		{"site.min.css", "text/css", ""},
+		{"app.js", "text/javascript", ""},
+		{"photo.jpg", "image/jpeg", ""},
+	}
+	for _, tc := range testCases {
+		if kind := m.Kind(tc.Key, tc.ContentType); kind != tc.Kind {
+			t.Errorf("%s: expected %q; got %q", tc.Key, tc.Kind, kind)
+		}
+	}
+
+	if _, err := NewMinifier([]string{"xml"}, 1); err == nil {
+		t.Error("expected an unknown type to fail")
+	}
+	if m, _ := NewMinifier(nil, 1); m.Kind("index.html", "text/html") != "" {
+		t.Error("expected nothing minified without types")
+	}
+}
+
+func TestMinifierServe(t *testing.T) {
+	m, _ := NewMinifier([]string{"css"}, 1)
+	req := httptest.NewRequest("GET", "/site.css", nil)
+
+	w := httptest.NewRecorder()
+	m.Serve(w, req, "site.css", `"v1"`, "", "css", strings.NewReader("a { b: c; }"))
+	if w.Body.String() != "a{b: c}" {
+		t.Errorf("expected minified css; got %q", w.Body.String())
+	}
+	if etag := w.Header().Get("ETag"); etag != `"v1-min"` {
+		t.Errorf("expected an etag of the minified variant; got %s", etag)
+	}
+
+	conditional := httptest.NewRequest("GET", "/site.css", nil)
+	conditional.Header.Set("If-None-Match", `"v1"`)
+	w = httptest.NewRecorder()
+	m.Serve(w, conditional, "site.css", `"v1"`, "", "css", strings.NewReader("a { b: c; }"))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the object's etag not to validate the minified variant; got %d", w.Code)
+	}
+	conditional.Header.Set("If-None-Match", `"v1-min"`)
+	w = httptest.NewRecorder()
+	m.Serve(w, conditional, "site.css", `"v1"`, "", "css", strings.NewReader("a { b: c; }"))
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304; got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	m.Serve(w, req, "site.css", `"v1"`, "", "css", strings.NewReader("changed"))
+	if w.Body.String() != "a{b: c}" {
+		t.Errorf("expected the cached result for the same etag; got %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	m.Serve(w, req, "site.css", `"v2"`, "", "css", strings.NewReader("x { y: z; }"))
+	if w.Body.String() != "x{y: z}" {
+		t.Errorf("expected a new etag to be minified again; got %q", w.Body.String())
+	}
+
+	large := bytes.Repeat([]byte("a  "), maxRendered)
+	w = httptest.NewRecorder()
+	m.Serve(w, req, "large.css", `"v1"`, "", "css", bytes.NewReader(large))
+	if w.Body.Len() != len(large) {
+		t.Errorf("expected large files served as they are; got %d bytes", w.Body.Len())
+	}
+}
@@ -0,0 +1,151 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NotFoundPath serves the top 404s report to admins
+const NotFoundPath = "/-/404s"
+
+const (
+	maxMissingPaths     = 1000
+	maxMissingReferrers = 10
+)
+
+// MissingPath is a path that has been answered with a 404
+type MissingPath struct {
+	Path      string           `json:"path"`
+	Count     int64            `json:"count"`
+	LastSeen  time.Time        `json:"last_seen"`
+	Referrers map[string]int64 `json:"referrers"`
+}
+
+// NotFoundTracker counts 404s by path along with the pages linking to
+// them, to find broken links and misdeployed assets
+type NotFoundTracker struct {
+	mu    sync.Mutex
+	paths map[string]*MissingPath
+}
+
+func NewNotFoundTracker() *NotFoundTracker {
+	return &NotFoundTracker{paths: map[string]*MissingPath{}}
+}
+
+// Record counts a 404 for req.  Once maxMissingPaths distinct paths are
+// tracked, new paths are ignored until the report is reset.
+func (n *NotFoundTracker) Record(req *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	missing, ok := n.paths[req.URL.Path]
+	if !ok {
+		if len(n.paths) >= maxMissingPaths {
+			return
+		}
+		missing = &MissingPath{Path: req.URL.Path, Referrers: map[string]int64{}}
+		n.paths[req.URL.Path] = missing
+	}
+
+	missing.Count++
+	missing.LastSeen = time.Now()
+	if referrer := req.Referer(); referrer != "" {
+		if _, ok := missing.Referrers[referrer]; ok || len(missing.Referrers) < maxMissingReferrers {
+			missing.Referrers[referrer]++
+		}
+	}
+}
+
+// Top returns the limit most frequently missing paths
+func (n *NotFoundTracker) Top(limit int) []MissingPath {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	top := make([]MissingPath, 0, len(n.paths))
+	for _, missing := range n.paths {
+		copied := *missing
+		copied.Referrers = map[string]int64{}
+		for referrer, count := range missing.Referrers {
+			copied.Referrers[referrer] = count
+		}
+		top = append(top, copied)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Path < top[j].Path
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
+
+// Reset clears the report
+func (n *NotFoundTracker) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.paths = map[string]*MissingPath{}
+}
+
+// Wrap records the 404s served by h and serves the report at NotFoundPath
+// to admins; GET /-/404s?n=50 lists the top paths and DELETE resets them
+func (n *NotFoundTracker) Wrap(live *LiveOptions, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == NotFoundPath {
+			if !live.Load().IsAdmin(req) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			switch req.Method {
+			case "GET":
+				limit, err := strconv.Atoi(req.URL.Query().Get("n"))
+				if err != nil || limit <= 0 {
+					limit = 50
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(n.Top(limit))
+			case "DELETE":
+				n.Reset()
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, req)
+		if recorder.Status() == http.StatusNotFound {
+			n.Record(req)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package s3site
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotFoundTracker(t *testing.T) {
+	opts := &Options{AdminToken: "secret"}
+	tracker := NewNotFoundTracker()
+	h := tracker.Wrap(NewLiveOptions(opts, nil), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/index.html" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	for _, path := range []string{"/index.html", "/old.html", "/old.html", "/typo.css"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Referer", "https://example.com/blog/")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", NotFoundPath, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", NotFoundPath+"?n=1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var top []MissingPath
+	if err := json.Unmarshal(w.Body.Bytes(), &top); err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 1 || top[0].Path != "/old.html" || top[0].Count != 2 || top[0].Referrers["https://example.com/blog/"] != 2 {
+		t.Errorf("unexpected report, %+v", top)
+	}
+
+	req = httptest.NewRequest("DELETE", NotFoundPath, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if top := tracker.Top(10); len(top) != 0 {
+		t.Errorf("expected reset to clear the report, got %v", top)
+	}
+}
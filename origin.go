@@ -0,0 +1,284 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+// StaleError is returned along with an expired entry that was served
+// because s3 could not be reached to revalidate it
+type StaleError struct {
+	Err error
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("serving stale content, %s", e.Err)
+}
+
+// Origin fetches objects from s3 through the cache.  Credentials, when set,
+// re-sign Bucket as an assumed role session is renewed.
+type Origin struct {
+	Bucket       *s3.Bucket
+	Credentials  *Credentials
+	Cache        *Cache
+	Client       *http.Client
+	TTL          *TTLPolicy
+	Admission    *Admission
+	MaxStale     time.Duration
+	StaleIfError time.Duration
+}
+
+// Get returns the object at key along with the cache status, one of
+// CacheHit, CacheMiss, or CacheStale.  Cached entries older than their ttl,
+// or restored from a previous run, are served as-is while being
+// revalidated in the background; once older than ttl + MaxStale they are
+// revalidated before being served.
+func (o *Origin) Get(ctx context.Context, key, variant string) (*Entry, string, error) {
+	cacheKey := CacheKey(key, variant)
+	_, lookup := StartSpan(ctx, "cache.lookup", SpanInternal)
+	lookupStarted := time.Now()
+	entry, ok := o.Cache.Get(cacheKey)
+	track(ctx, "cache_lookup", lookupStarted)
+	lookup.SetAttribute("cache.key", cacheKey)
+	lookup.SetAttribute("cache.found", ok)
+	lookup.Finish()
+
+	if ok {
+		ttl := o.TTL.For(entry)
+		age := entry.Age()
+
+		switch {
+		case age <= ttl && !entry.Restored:
+			o.Cache.Stats.Record(CacheHit, entry)
+			return entry, CacheHit, nil
+
+		case o.MaxStale == 0 || age-ttl <= o.MaxStale:
+			if o.Cache.Claim(cacheKey) {
+				// the request's context is canceled once it's answered
+				go o.revalidate(detachedContext(ctx), entry)
+			}
+			o.Cache.Stats.Record(CacheStale, entry)
+			return entry, CacheStale, nil
+		}
+
+		started := time.Now()
+		updated, err := o.refresh(ctx, entry)
+		if err == nil {
+			o.Cache.Stats.RecordFill(time.Since(started))
+			o.Cache.Stats.Record(CacheMiss, nil)
+			return updated, CacheMiss, nil
+		}
+		if !isMissing(err) && age-ttl-o.MaxStale <= o.StaleIfError {
+			o.Cache.Stats.Record(CacheStale, entry)
+			return entry, CacheStale, &StaleError{Err: err}
+		}
+		o.Cache.Stats.Record(CacheMiss, nil)
+		return nil, CacheMiss, err
+	}
+
+	started := time.Now()
+	s3ctx, span := StartSpan(ctx, "s3.GetObject", SpanClient)
+	span.SetAttribute("s3.bucket", o.Bucket.Name)
+	span.SetAttribute("s3.key", key)
+	resp, err := getObject(s3ctx, o.Client, o.Credentials.Sign(o.Bucket), key, nil)
+	track(ctx, "s3_get", started)
+	span.SetError(err)
+	span.Finish()
+	if err != nil {
+		o.Cache.Stats.Record(CacheMiss, nil)
+		return nil, CacheMiss, err
+	}
+	if !o.admits(resp) {
+		o.Cache.Stats.Record(CacheMiss, nil)
+		return streamEntry(key, variant, resp), CacheMiss, nil
+	}
+	defer resp.Body.Close()
+
+	entry, err = newEntry(key, variant, resp)
+	if err != nil {
+		o.Cache.Stats.Record(CacheMiss, nil)
+		return nil, CacheMiss, err
+	}
+	o.Cache.Set(entry)
+	o.Cache.Stats.RecordFill(time.Since(started))
+	o.Cache.Stats.Record(CacheMiss, entry)
+	return entry, CacheMiss, nil
+}
+
+func (o *Origin) revalidate(ctx context.Context, entry *Entry) {
+	defer o.Cache.Release(entry.CacheKey())
+
+	updated, err := o.refresh(ctx, entry)
+	if err != nil && !isMissing(err) {
+		logger.Warn("unable to revalidate", Fields{"key": entry.Key, "error": err})
+	}
+	if updated != nil && updated.Stream != nil {
+		updated.Stream.Close()
+	}
+}
+
+// refresh conditionally refetches entry from s3 and returns the current
+// version of the object; unchanged objects cost a 304 rather than a
+// full download
+func (o *Origin) refresh(ctx context.Context, entry *Entry) (*Entry, error) {
+	header := http.Header{}
+	if entry.ETag != "" {
+		header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		header.Set("If-Modified-Since", entry.LastModified)
+	}
+
+	s3ctx, span := StartSpan(ctx, "s3.GetObject", SpanClient)
+	span.SetAttribute("s3.bucket", o.Bucket.Name)
+	span.SetAttribute("s3.key", entry.Key)
+	span.SetAttribute("s3.conditional", true)
+	started := time.Now()
+	resp, err := getObject(s3ctx, o.Client, o.Credentials.Sign(o.Bucket), entry.Key, header)
+	track(ctx, "s3_revalidate", started)
+	if err == nil {
+		span.SetAttribute("http.status_code", resp.StatusCode)
+	}
+	span.SetError(err)
+	span.Finish()
+	if isMissing(err) {
+		o.Cache.Stats.RecordRevalidation(false)
+		o.Cache.Remove(entry.CacheKey())
+		logger.Debug("revalidated, evicted", Fields{"bucket": o.Bucket.Name, "key": entry.Key, "error": err})
+		return nil, err
+	}
+	if err != nil {
+		if _, ok := err.(*s3.Error); ok {
+			o.Cache.Stats.RecordRevalidation(false)
+		}
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK && !o.admits(resp) {
+		o.Cache.Remove(entry.CacheKey())
+		return streamEntry(entry.Key, entry.Variant, resp), nil
+	}
+	defer resp.Body.Close()
+
+	o.Cache.Stats.RecordRevalidation(resp.StatusCode == http.StatusNotModified)
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		logger.Debug("revalidated, not modified", Fields{"bucket": o.Bucket.Name, "key": entry.Key})
+		if touched := o.Cache.Touch(entry.CacheKey()); touched != nil {
+			return touched, nil
+		}
+		return entry, nil
+
+	case http.StatusOK:
+		updated, err := newEntry(entry.Key, entry.Variant, resp)
+		if err != nil {
+			return nil, err
+		}
+		o.Cache.Set(updated)
+		logger.Debug("revalidated, refreshed content", Fields{"bucket": o.Bucket.Name, "key": entry.Key})
+		return updated, nil
+
+	default:
+		return nil, &s3.Error{StatusCode: resp.StatusCode, Message: resp.Status}
+	}
+}
+
+// getObject fetches key through a presigned url, rather than the s3
+// client, so the request carries ctx and with it any trace to propagate.
+// Responses other than 200 and 304 are returned as an *s3.Error.
+func getObject(ctx context.Context, client *http.Client, bucket *s3.Bucket, key string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("GET", bucket.SignedURL(key, time.Now().Add(time.Minute)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if token := bucket.Auth.Token; token != "" {
+		// the signature covers the session token, which the signed url omits
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, &s3.Error{StatusCode: resp.StatusCode, Message: resp.Status}
+	}
+	return resp, nil
+}
+
+func (o *Origin) admits(resp *http.Response) bool {
+	return o.Admission.Allows(resp.ContentLength, resp.Header.Get("Content-Type"))
+}
+
+// isMissing returns true if s3 reported that the object does not exist
+// or is not accessible, as opposed to being unavailable
+func isMissing(err error) bool {
+	if e, ok := err.(*s3.Error); ok {
+		return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// streamEntry returns an entry that passes the response body through
+// rather than buffering it; the caller must close entry.Stream
+func streamEntry(key, variant string, resp *http.Response) *Entry {
+	return &Entry{
+		Key:          key,
+		Variant:      variant,
+		Stream:       resp.Body,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		CacheControl: resp.Header.Get("Cache-Control"),
+		Fetched:      time.Now(),
+	}
+}
+
+func newEntry(key, variant string, resp *http.Response) (*Entry, error) {
+	body, err := readBody(resp.Body, resp.ContentLength)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{
+		Key:          key,
+		Variant:      variant,
+		Body:         body,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		CacheControl: resp.Header.Get("Cache-Control"),
+		Fetched:      time.Now(),
+	}, nil
+}
@@ -0,0 +1,126 @@
+package s3site
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+func newTestOrigin(handler http.HandlerFunc) (*Origin, func()) {
+	server := httptest.NewServer(handler)
+	region := aws.Region{Name: "test", S3Endpoint: server.URL}
+	bucket := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, region).Bucket("bucket")
+
+	origin := &Origin{
+		Bucket:       bucket,
+		Cache:        NewCache(1 << 20),
+		Client:       http.DefaultClient,
+		TTL:          &TTLPolicy{Default: time.Minute},
+		MaxStale:     time.Minute,
+		StaleIfError: time.Hour,
+	}
+	return origin, server.Close
+}
+
+func TestOriginRevalidatesExpiredEntries(t *testing.T) {
+	origin, done := newTestOrigin(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") == `"v1"` && req.Header.Get("If-Modified-Since") == "Mon, 02 Jan 2006 15:04:05 GMT" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("v2"))
+	})
+	defer done()
+
+	origin.Cache.Set(&Entry{
+		Key:          "a",
+		Body:         []byte("v1"),
+		ETag:         `"v1"`,
+		LastModified: "Mon, 02 Jan 2006 15:04:05 GMT",
+		Fetched:      time.Now().Add(-time.Hour),
+	})
+	entry, status, err := origin.Get(context.Background(), "a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != CacheMiss || string(entry.Body) != "v1" || entry.Age() > time.Minute {
+		t.Errorf("expected unmodified entry to be refreshed, got %s %s %v", status, entry.Body, entry.Age())
+	}
+	if snapshot := origin.Cache.Snapshot(); snapshot.NotModified != 1 {
+		t.Errorf("expected 1 not modified revalidation, got %d", snapshot.NotModified)
+	}
+}
+
+func TestOriginServesStaleOnError(t *testing.T) {
+	origin, done := newTestOrigin(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer done()
+
+	origin.Cache.Set(&Entry{Key: "a", Body: []byte("v1"), Fetched: time.Now().Add(-time.Hour)})
+	entry, status, err := origin.Get(context.Background(), "a", "")
+	if _, ok := err.(*StaleError); !ok {
+		t.Fatalf("expected StaleError, got %v", err)
+	}
+	if status != CacheStale || string(entry.Body) != "v1" {
+		t.Errorf("expected stale entry, got %s %s", status, entry.Body)
+	}
+
+	origin.Cache.Set(&Entry{Key: "b", Body: []byte("v1"), Fetched: time.Now().Add(-3 * time.Hour)})
+	if entry, _, err := origin.Get(context.Background(), "b", ""); err == nil || entry != nil {
+		t.Error("expected entries past stale-if-error to fail")
+	}
+}
+
+func TestOriginEvictsDeletedObjects(t *testing.T) {
+	origin, done := newTestOrigin(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer done()
+
+	origin.Cache.Set(&Entry{Key: "a", Body: []byte("v1"), Fetched: time.Now().Add(-time.Hour)})
+	if _, _, err := origin.Get(context.Background(), "a", ""); !isMissing(err) {
+		t.Errorf("expected missing error, got %v", err)
+	}
+	if _, ok := origin.Cache.Get("a"); ok {
+		t.Error("expected deleted object to be evicted")
+	}
+}
+
+func TestOriginRevalidatesAfterRequestEnds(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	origin, done := newTestOrigin(func(w http.ResponseWriter, req *http.Request) {
+		requested <- struct{}{}
+		<-release
+		w.Write([]byte("v2"))
+	})
+	defer done()
+
+	origin.Cache.Set(&Entry{Key: "a", Body: []byte("v1"), Fetched: time.Now().Add(-90 * time.Second)})
+	ctx, cancel := context.WithCancel(context.Background())
+	entry, status, err := origin.Get(ctx, "a", "")
+	if err != nil || status != CacheStale || string(entry.Body) != "v1" {
+		t.Fatalf("expected stale entry, got %v %s %v", entry, status, err)
+	}
+
+	// the response is sent, and the request's context canceled, while the
+	// revalidation is in flight
+	<-requested
+	cancel()
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if entry, ok := origin.Cache.Get("a"); ok && string(entry.Body) == "v2" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected the entry to be revalidated after the request's context was canceled")
+}
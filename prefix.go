@@ -0,0 +1,196 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefixVar matches a variable in the prefix: {subdomain}, {env},
+// {env:NAME}, {date:2006-01-02}, or {header:X-Tenant}
+var prefixVar = regexp.MustCompile(`\{([a-z]+)(?::([^}]*))?\}`)
+
+// expandEnv replaces the {env:NAME} variables of prefix, which are fixed at
+// startup; {env} is short for {env:ENV}.  Unset variables are left for
+// Validate to report.
+func expandEnv(prefix string) string {
+	return prefixVar.ReplaceAllStringFunc(prefix, func(v string) string {
+		m := prefixVar.FindStringSubmatch(v)
+		if m[1] != "env" {
+			return v
+		}
+		name := m[2]
+		if name == "" {
+			name = "ENV"
+		}
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+		return v
+	})
+}
+
+// prefixProblem returns what's wrong with the variables of prefix, if
+// anything
+func prefixProblem(prefix string) error {
+	for _, m := range prefixVar.FindAllStringSubmatch(prefix, -1) {
+		switch name, arg := m[1], m[2]; {
+		case name == "env":
+			if arg == "" {
+				arg = "ENV"
+			}
+			return fmt.Errorf("environment variable %s, named by %s, is not set", arg, m[0])
+		case name == "subdomain" && arg == "":
+		case name == "date" && arg != "":
+		case name == "header" && arg != "":
+		default:
+			return fmt.Errorf("unknown prefix variable, %s", m[0])
+		}
+	}
+	return nil
+}
+
+// requestScoped reports whether prefix has variables expanded per request
+func requestScoped(prefix string) bool {
+	return prefixVar.MatchString(prefix)
+}
+
+// withPrefix returns a copy of o serving prefix in place of its prefix, or
+// its blue/green prefixes
+func (o *Options) withPrefix(prefix string) *Options {
+	copied := *o
+	copied.Prefix, copied.BluePrefix, copied.GreenPrefix = prefix, "", ""
+	return &copied
+}
+
+// forRequest returns o with the variables of its prefix expanded for req;
+// o is returned as is when its prefix has none
+func (o *Options) forRequest(w http.ResponseWriter, req *http.Request) (*Options, error) {
+	if !requestScoped(o.livePrefix()) {
+		return o, nil
+	}
+	// the site served differs with the headers the prefix reads
+	for _, name := range varHeaders(o.livePrefix()) {
+		w.Header().Add("Vary", name)
+	}
+
+	prefix, err := expandVars(o.livePrefix(), req, true)
+	if err != nil {
+		return nil, err
+	}
+	return o.withPrefix(prefix), nil
+}
+
+// varHeaders returns the request headers the variables of s read
+func varHeaders(s string) []string {
+	names := []string{}
+	for _, m := range prefixVar.FindAllStringSubmatch(s, -1) {
+		if m[1] == "header" {
+			names = append(names, m[2])
+		}
+	}
+	return names
+}
+
+// expandVars replaces the request time variables of s with their values for
+// req; when inKey is set, header values must be safe in an s3 key
+func expandVars(s string, req *http.Request, inKey bool) (string, error) {
+	var err error
+	expanded := prefixVar.ReplaceAllStringFunc(s, func(v string) string {
+		m := prefixVar.FindStringSubmatch(v)
+		switch m[1] {
+		case "subdomain":
+			label, ok := Subdomain(req)
+			if !ok {
+				err = fmt.Errorf("no subdomain for %s", v)
+			}
+			return label
+		case "date":
+			return time.Now().UTC().Format(m[2])
+		case "header":
+			value := req.Header.Get(m[2])
+			if inKey && !validSegment(value) {
+				err = fmt.Errorf("missing or invalid %s header", m[2])
+			}
+			return value
+		}
+		err = prefixProblem(v)
+		return v
+	})
+	return expanded, err
+}
+
+// validSegment reports whether value is safe to use in an s3 key; it may
+// come from a request header
+func validSegment(value string) bool {
+	if value == "" || value == "." || value == ".." || len(value) > 128 {
+		return false
+	}
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+var prefixPatterns sync.Map
+
+// prefixPattern returns a regexp matching the keys under a prefix with
+// variables; each variable matches one path segment, or one per segment of
+// a date layout
+func prefixPattern(prefix string) *regexp.Regexp {
+	if re, ok := prefixPatterns.Load(prefix); ok {
+		return re.(*regexp.Regexp)
+	}
+
+	pattern, last := "^", 0
+	for _, m := range prefixVar.FindAllStringSubmatchIndex(prefix, -1) {
+		pattern += regexp.QuoteMeta(prefix[last:m[0]]) + "[^/]+"
+		if prefix[m[2]:m[3]] == "date" {
+			pattern += strings.Repeat("/[^/]+", strings.Count(prefix[m[4]:m[5]], "/"))
+		}
+		last = m[1]
+	}
+	re := regexp.MustCompile(pattern + regexp.QuoteMeta(prefix[last:]))
+	prefixPatterns.Store(prefix, re)
+	return re
+}
+
+// trimKeyPrefix removes prefix from key, matching any variables in prefix
+// to the values in key
+func trimKeyPrefix(key, prefix string) string {
+	if !requestScoped(prefix) {
+		return strings.TrimPrefix(key, prefix)
+	}
+	if loc := prefixPattern(prefix).FindStringIndex(key); loc != nil {
+		return key[loc[1]:]
+	}
+	return key
+}
@@ -0,0 +1,91 @@
+package s3site
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("S3SITE_TEST_STAGE", "staging")
+	defer os.Unsetenv("S3SITE_TEST_STAGE")
+
+	testCases := map[string]string{
+		"sites/{env:S3SITE_TEST_STAGE}/":       "sites/staging/",
+		"sites/{env:S3SITE_TEST_UNSET}/":       "sites/{env:S3SITE_TEST_UNSET}/",
+		"sites/{header:X-Tenant}/":             "sites/{header:X-Tenant}/",
+		"{env:S3SITE_TEST_STAGE}/{subdomain}/": "staging/{subdomain}/",
+	}
+	for prefix, expected := range testCases {
+		if actual := expandEnv(prefix); actual != expected {
+			t.Errorf("%s: expected %s, got %s", prefix, expected, actual)
+		}
+	}
+}
+
+func TestPrefixProblem(t *testing.T) {
+	testCases := map[string]bool{
+		"site/":                    true,
+		"site/{subdomain}/":        true,
+		"site/{date:2006-01-02}/":  true,
+		"site/{header:X-Tenant}/":  true,
+		"site/{date}/":             false,
+		"site/{header}/":           false,
+		"site/{tenant}/":           false,
+		"site/{env:S3SITE_UNSET}/": false,
+		"site/{subdomain:upper}/":  false,
+	}
+	for prefix, ok := range testCases {
+		if err := prefixProblem(prefix); (err == nil) != ok {
+			t.Errorf("%s: unexpected problem, %v", prefix, err)
+		}
+	}
+}
+
+func TestForRequest(t *testing.T) {
+	opts := &Options{Prefix: "tenants/{header:X-Tenant}/{date:2006/01}/", IndexFile: "index.html"}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Tenant", "acme")
+	w := httptest.NewRecorder()
+	site, err := opts.forRequest(w, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vary := w.Header().Get("Vary"); vary != "X-Tenant" {
+		t.Errorf("expected the response to vary by X-Tenant, got %q", vary)
+	}
+	expected := "tenants/acme/" + time.Now().UTC().Format("2006/01") + "/index.html"
+	if key := site.Key("/"); key != expected {
+		t.Errorf("expected %s, got %s", expected, key)
+	}
+
+	for _, tenant := range []string{"", "..", "a/b"} {
+		req.Header.Set("X-Tenant", tenant)
+		if _, err := opts.forRequest(httptest.NewRecorder(), req); err == nil {
+			t.Errorf("expected tenant %q to be rejected", tenant)
+		}
+	}
+
+	plain := &Options{Prefix: "site/"}
+	if site, _ := plain.forRequest(httptest.NewRecorder(), req); site != plain {
+		t.Error("expected options without variables to be returned as is")
+	}
+}
+
+func TestTrimKeyPrefix(t *testing.T) {
+	testCases := []struct {
+		key, prefix, expected string
+	}{
+		{"site/app.js", "site/", "app.js"},
+		{"tenants/acme/app.js", "tenants/{header:X-Tenant}/", "app.js"},
+		{"daily/2016/05/01/app.js", "daily/{date:2006/01/02}/", "app.js"},
+		{"other/app.js", "tenants/{header:X-Tenant}/", "other/app.js"},
+	}
+	for _, tc := range testCases {
+		if actual := trimKeyPrefix(tc.key, tc.prefix); actual != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.key, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build windows
+
+package s3site
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// upgradeSignal is nil as windows has no SIGUSR2; see UpgradeOnSignal
+var upgradeSignal os.Signal
+
+const (
+	detachedProcess = 0x00000008
+	stillActive     = 259
+)
+
+// detached starts a process without the console so it outlives it
+func detached() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess}
+}
+
+func running(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// terminate kills pid; windows can't signal another process to drain, so a
+// service should be stopped through the service control manager instead
+func terminate(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
+
+// closeOnExec is a no-op as socket activation is systemd's
+func closeOnExec(fd int) {}
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped     = 1
+	serviceStopPending = 3
+	serviceRunning     = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	serviceControlStop     = 1
+	serviceControlShutdown = 5
+
+	errorFailedServiceControllerConnect = syscall.Errno(1063)
+)
+
+// serviceStatus is SERVICE_STATUS
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// serviceTableEntry is SERVICE_TABLE_ENTRYW
+type serviceTableEntry struct {
+	ServiceName *uint16
+	ServiceProc uintptr
+}
+
+// runService connects to the service control manager, then serves until
+// it's asked to stop, which drains the server as SIGTERM does elsewhere
+func runService(name string, serve func()) error {
+	serviceName, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+
+	var handle uintptr
+	setStatus := func(state, accepts uint32) {
+		status := serviceStatus{ServiceType: serviceWin32OwnProcess, CurrentState: state, ControlsAccepted: accepts}
+		procSetServiceStatus.Call(handle, uintptr(unsafe.Pointer(&status)))
+	}
+
+	handler := syscall.NewCallback(func(control, eventType, eventData, context uintptr) uintptr {
+		switch control {
+		case serviceControlStop, serviceControlShutdown:
+			setStatus(serviceStopPending, 0)
+			select {
+			case stopRequests <- os.Interrupt:
+			default:
+			}
+		}
+		return 0
+	})
+
+	serviceMain := syscall.NewCallback(func(argc, argv uintptr) uintptr {
+		h, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(serviceName)), handler, 0)
+		if h == 0 {
+			logger.Error("unable to register with the service control manager", Fields{"error": err})
+			return 0
+		}
+		handle = h
+
+		setStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown)
+		serve()
+		setStatus(serviceStopped, 0)
+		return 0
+	})
+
+	table := []serviceTableEntry{{ServiceName: serviceName, ServiceProc: serviceMain}, {}}
+	if ok, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); ok == 0 {
+		if err == errorFailedServiceControllerConnect {
+			return fmt.Errorf("service run is started by the service control manager; use serve from a console")
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/vaughan0/go-ini"
+)
+
+// Profile is a named profile from the aws shared credentials and config
+// files, as written by aws configure
+type Profile struct {
+	Name    string
+	Auth    aws.Auth
+	Region  string
+	RoleARN string
+}
+
+// LoadProfile reads the named profile from ~/.aws/credentials and
+// ~/.aws/config, or the files named by AWS_SHARED_CREDENTIALS_FILE and
+// AWS_CONFIG_FILE.  A profile with a role_arn takes its keys from its
+// source_profile.
+func LoadProfile(name string) (*Profile, error) {
+	credentials, err := loadAWSFile("AWS_SHARED_CREDENTIALS_FILE", "credentials")
+	if err != nil {
+		return nil, err
+	}
+	config, err := loadAWSFile("AWS_CONFIG_FILE", "config")
+	if err != nil {
+		return nil, err
+	}
+
+	// the config file names all but the default profile "profile name"
+	lookup := func(profile, key string) string {
+		if value, ok := credentials.Get(profile, key); ok {
+			return value
+		}
+		section := "profile " + profile
+		if profile == "default" {
+			section = profile
+		}
+		value, _ := config.Get(section, key)
+		return value
+	}
+	exists := func(profile string) bool {
+		_, inCredentials := credentials[profile]
+		_, inConfig := config["profile "+profile]
+		_, isDefault := config[profile]
+		return inCredentials || inConfig || (profile == "default" && isDefault)
+	}
+	if !exists(name) {
+		return nil, fmt.Errorf("aws profile %s not found", name)
+	}
+
+	profile := &Profile{
+		Name:    name,
+		Region:  lookup(name, "region"),
+		RoleARN: lookup(name, "role_arn"),
+	}
+	keys := name
+	if profile.RoleARN != "" {
+		if source := lookup(name, "credential_source"); source != "" {
+			return nil, fmt.Errorf("aws profile %s: credential_source %s is not supported; use source_profile", name, source)
+		}
+		keys = lookup(name, "source_profile")
+		if keys == "" {
+			return nil, fmt.Errorf("aws profile %s: role_arn requires a source_profile", name)
+		}
+	}
+
+	profile.Auth = aws.Auth{
+		AccessKey: lookup(keys, "aws_access_key_id"),
+		SecretKey: lookup(keys, "aws_secret_access_key"),
+		Token:     lookup(keys, "aws_session_token"),
+	}
+	if profile.Auth.AccessKey == "" || profile.Auth.SecretKey == "" {
+		return nil, fmt.Errorf("aws profile %s has no aws_access_key_id and aws_secret_access_key", keys)
+	}
+	return profile, nil
+}
+
+// loadAWSFile reads the shared aws file named by envVar, defaulting to name
+// in ~/.aws; a missing file is empty
+func loadAWSFile(envVar, name string) (ini.File, error) {
+	path := os.Getenv(envVar)
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ini.File{}, nil
+		}
+		path = filepath.Join(home, ".aws", name)
+	}
+
+	file, err := ini.LoadFile(path)
+	if os.IsNotExist(err) {
+		return ini.File{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return file, nil
+}
@@ -0,0 +1,87 @@
+package s3site
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "credentials"), []byte(`
+[default]
+aws_access_key_id = default-key
+aws_secret_access_key = default-secret
+
+[dev]
+aws_access_key_id = dev-key
+aws_secret_access_key = dev-secret
+aws_session_token = dev-token
+`), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "config"), []byte(`
+[default]
+region = us-west-1
+
+[profile dev]
+region = eu-west-1
+
+[profile docs]
+role_arn = arn:aws:iam::123456789012:role/docs
+source_profile = dev
+region = us-west-2
+
+[profile orphan]
+role_arn = arn:aws:iam::123456789012:role/orphan
+`), 0600)
+
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	os.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	defer os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+	defer os.Unsetenv("AWS_CONFIG_FILE")
+
+	testCases := []struct {
+		Name      string
+		AccessKey string
+		Token     string
+		Region    string
+		RoleARN   string
+	}{
+		{"default", "default-key", "", "us-west-1", ""},
+		{"dev", "dev-key", "dev-token", "eu-west-1", ""},
+		{"docs", "dev-key", "dev-token", "us-west-2", "arn:aws:iam::123456789012:role/docs"},
+	}
+	for _, tc := range testCases {
+		profile, err := LoadProfile(tc.Name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.Name, err)
+			continue
+		}
+		if profile.Auth.AccessKey != tc.AccessKey || profile.Auth.Token != tc.Token || profile.Region != tc.Region || profile.RoleARN != tc.RoleARN {
+			t.Errorf("%s: unexpected profile, %+v", tc.Name, profile)
+		}
+	}
+
+	for _, name := range []string{"orphan", "missing"} {
+		if _, err := LoadProfile(name); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	creds, err := NewCredentials(&Options{AWSProfile: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Base.AccessKey != "dev-key" || creds.Region.Name != "eu-west-1" {
+		t.Errorf("expected the profile's keys and region, got %s in %s", creds.Base.AccessKey, creds.Region.Name)
+	}
+	creds, err = NewCredentials(&Options{AWSProfile: "dev", S3Region: "us-west-2"})
+	if err != nil || creds.Region.Name != "us-west-2" {
+		t.Errorf("expected s3-region to override the profile's region, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// remoteParent returns the span context of an upstream trace carried by
+// req, trying w3c traceparent, then b3 (single and multi header), then
+// x-ray
+func remoteParent(req *http.Request) (traceID [16]byte, spanID [8]byte, ok bool) {
+	if traceID, spanID, ok = parseTraceparent(req.Header.Get("traceparent")); ok {
+		return
+	}
+	if traceID, spanID, ok = parseB3(req.Header.Get("b3")); ok {
+		return
+	}
+	if id := req.Header.Get("X-B3-TraceId"); id != "" {
+		if traceID, spanID, ok = parseB3(id + "-" + req.Header.Get("X-B3-SpanId")); ok {
+			return
+		}
+	}
+	return parseXRayHeader(req.Header.Get(xrayHeader))
+}
+
+// withUpstreamTrace returns ctx joined to any trace req arrived with
+func withUpstreamTrace(ctx context.Context, req *http.Request) context.Context {
+	if traceID, spanID, ok := remoteParent(req); ok {
+		return withRemoteParent(ctx, traceID, spanID)
+	}
+	return ctx
+}
+
+func decodeID(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil && strings.Trim(s, "0") != ""
+}
+
+// parseTraceparent parses a w3c trace context header e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(header string) (traceID [16]byte, spanID [8]byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return traceID, spanID, false
+	}
+	if !decodeID(traceID[:], parts[1]) || !decodeID(spanID[:], parts[2]) {
+		return traceID, spanID, false
+	}
+	return traceID, spanID, true
+}
+
+// parseB3 parses a b3 single header e.g. {trace id}-{span id}-{sampled};
+// 64 bit trace ids are left padded to 128 bits
+func parseB3(header string) (traceID [16]byte, spanID [8]byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 2 {
+		return traceID, spanID, false
+	}
+
+	id := parts[0]
+	if len(id) == 16 {
+		id = strings.Repeat("0", 16) + id
+	}
+	if !decodeID(traceID[:], id) || !decodeID(spanID[:], parts[1]) {
+		return traceID, spanID, false
+	}
+	return traceID, spanID, true
+}
+
+// traceHeaders returns the headers that propagate span to a downstream
+// service
+func traceHeaders(span *Span) http.Header {
+	header := http.Header{}
+	header.Set("traceparent", fmt.Sprintf("00-%x-%x-01", span.TraceID, span.SpanID))
+	header.Set(xrayHeader, fmt.Sprintf("Root=%s;Parent=%x;Sampled=1", xrayTraceID(span.TraceID), span.SpanID))
+	return header
+}
+
+// PropagateTrace wraps rt to forward the trace active in each request's
+// context, e.g. so s3 server access logs and x-ray can join the trace
+func PropagateTrace(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		span := spanFromContext(req.Context())
+		if span == nil {
+			return rt.RoundTrip(req)
+		}
+
+		propagated := req.Clone(req.Context())
+		for name, values := range traceHeaders(span) {
+			if propagated.Header.Get(name) == "" {
+				propagated.Header[name] = values
+			}
+		}
+		return rt.RoundTrip(propagated)
+	})
+}
+
+// traceID returns the id of the trace req is part of, if any, for logging
+func traceID(req *http.Request) string {
+	if span := spanFromContext(req.Context()); span != nil {
+		return fmt.Sprintf("%x", span.TraceID)
+	}
+	return ""
+}
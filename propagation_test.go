@@ -0,0 +1,78 @@
+package s3site
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteParent(t *testing.T) {
+	testCases := []struct {
+		header, value string
+		traceID       string
+		spanID        string
+	}{
+		{"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{"b3", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1", "80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1"},
+		{"b3", "64fe8b2a57d3eff7-e457b5a2e4d86bd1", "000000000000000064fe8b2a57d3eff7", "e457b5a2e4d86bd1"},
+		{xrayHeader, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1", "5759e988bd862e3fe1be46a994272793", "53995c3f42cd8ad8"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(tc.header, tc.value)
+		traceID, spanID, ok := remoteParent(req)
+		if !ok || fmt.Sprintf("%x", traceID) != tc.traceID || fmt.Sprintf("%x", spanID) != tc.spanID {
+			t.Errorf("%s: expected %s/%s, got %x/%x", tc.header, tc.traceID, tc.spanID, traceID, spanID)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-B3-TraceId", "80f198ee56343ba864fe8b2a57d3eff7")
+	req.Header.Set("X-B3-SpanId", "e457b5a2e4d86bd1")
+	if _, _, ok := remoteParent(req); !ok {
+		t.Errorf("expected multi header b3 to parse")
+	}
+
+	for _, value := range []string{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "garbage"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("traceparent", value)
+		if _, _, ok := remoteParent(req); ok {
+			t.Errorf("expected %s to be rejected", value)
+		}
+	}
+}
+
+func TestPropagateTrace(t *testing.T) {
+	var forwarded http.Header
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		forwarded = req.Header
+	}))
+	defer s3.Close()
+
+	client := &http.Client{Transport: PropagateTrace(http.DefaultTransport)}
+	var logged string
+	h := Trace(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		logged = traceID(req)
+		s3req, _ := http.NewRequest("GET", s3.URL, nil)
+		resp, err := client.Do(s3req.WithContext(req.Context()))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}))
+
+	// tracing is disabled, so the upstream span is forwarded as is
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if logged != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected upstream trace id to be available for logging, got %q", logged)
+	}
+	if got := forwarded.Get("traceparent"); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("unexpected traceparent forwarded, %s", got)
+	}
+	if got := forwarded.Get(xrayHeader); got != "Root=1-4bf92f35-77b34da6a3ce929d0e0e4736;Parent=00f067aa0ba902b7;Sampled=1" {
+		t.Errorf("unexpected x-ray header forwarded, %s", got)
+	}
+}
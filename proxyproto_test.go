@@ -0,0 +1,82 @@
+package s3site
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func proxyV2Header(src net.IP, port uint16) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x21, 0x11, 0, 12)
+	header = append(header, src.To4()...)
+	header = append(header, 10, 0, 0, 1)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:2], port)
+	binary.BigEndian.PutUint16(ports[2:4], 80)
+	return append(header, ports...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	testCases := map[string]string{
+		"PROXY TCP4 203.0.113.7 10.0.0.1 52000 80\r\nGET":                "203.0.113.7:52000",
+		"PROXY TCP6 2001:db8::7 2001:db8::1 52000 80\r\nGET":             "[2001:db8::7]:52000",
+		"PROXY UNKNOWN\r\nGET":                                           "",
+		string(proxyV2Header(net.ParseIP("198.51.100.9"), 4000)) + "GET": "198.51.100.9:4000",
+		string(proxyV2Signature) + "\x20\x00\x00\x00GET":                 "",
+	}
+	for input, expected := range testCases {
+		r := bufio.NewReader(strings.NewReader(input))
+		addr, err := readProxyHeader(r)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if got := fmt.Sprint(addr); addr != nil && got != expected || addr == nil && expected != "" {
+			t.Errorf("%q: expected %s, got %v", input, expected, addr)
+		}
+		if rest, _ := ioutil.ReadAll(r); string(rest) != "GET" {
+			t.Errorf("%q: expected the header to be consumed, got %q", input, rest)
+		}
+	}
+
+	for _, input := range []string{"GET / HTTP/1.1\r\n\r\n", "PROXY TCP4 nonsense\r\n", "PROXY " + strings.Repeat("x", 200)} {
+		if _, err := readProxyHeader(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go http.Serve(&ProxyListener{Listener: ln}, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(clientIP(req)))
+	}))
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	request := append(proxyV2Header(net.ParseIP("198.51.100.9"), 4000), "GET / HTTP/1.0\r\n\r\n"...)
+	conn.Write(request)
+
+	resp, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(resp, []byte("198.51.100.9")) {
+		t.Errorf("expected the client ip from the proxy header, got %q", resp)
+	}
+}
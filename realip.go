@@ -0,0 +1,116 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the networks, such as a load balancer's subnet, whose
+// X-Forwarded-For and X-Real-IP headers are believed
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses cidrs e.g. 10.0.0.0/8; a bare ip trusts just
+// that address
+func ParseTrustedProxies(values []string) (TrustedProxies, error) {
+	proxies := TrustedProxies{}
+	for _, value := range values {
+		for _, cidr := range strings.Split(value, ",") {
+			cidr = strings.TrimSpace(cidr)
+			if cidr == "" {
+				continue
+			}
+			if !strings.Contains(cidr, "/") {
+				if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+					cidr = cidr + "/32"
+				} else {
+					cidr = cidr + "/128"
+				}
+			}
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy, %s", cidr)
+			}
+			proxies = append(proxies, network)
+		}
+	}
+	return proxies, nil
+}
+
+// Contains returns true if ip belongs to a trusted network
+func (t TrustedProxies) Contains(ip net.IP) bool {
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that sent req.  Headers are
+// only consulted when the peer is trusted; X-Forwarded-For is read right to
+// left, skipping trusted hops, so a client can't forge its address by
+// sending the header itself.
+func (t TrustedProxies) ClientIP(req *http.Request) string {
+	peer := clientIP(req)
+	if ip := net.ParseIP(peer); ip == nil || !t.Contains(ip) {
+		return peer
+	}
+
+	if len(req.Header["X-Forwarded-For"]) > 0 {
+		hops := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			peer = ip.String()
+			if !t.Contains(ip) {
+				break
+			}
+		}
+		return peer
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer
+}
+
+// Wrap rewrites the RemoteAddr of requests from trusted proxies to the
+// client they forwarded for, so logs, traces, and checks downstream all see
+// the same address
+func (t TrustedProxies) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ip := t.ClientIP(req); ip != clientIP(req) {
+			r := *req
+			r.RemoteAddr = net.JoinHostPort(ip, "0")
+			req = &r
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,59 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8,192.168.1.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Peer     string
+		Headers  map[string]string
+		Expected string
+	}{
+		{"203.0.113.7:4000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.7"},
+		{"10.0.0.5:4000", map[string]string{}, "10.0.0.5"},
+		{"10.0.0.5:4000", map[string]string{"X-Forwarded-For": "198.51.100.9"}, "198.51.100.9"},
+		{"10.0.0.5:4000", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.9, 10.0.0.9"}, "198.51.100.9"},
+		{"10.0.0.5:4000", map[string]string{"X-Forwarded-For": "10.0.0.8, 10.0.0.9"}, "10.0.0.8"},
+		{"192.168.1.1:4000", map[string]string{"X-Real-IP": "198.51.100.9"}, "198.51.100.9"},
+		{"192.168.1.2:4000", map[string]string{"X-Real-IP": "198.51.100.9"}, "192.168.1.2"},
+		{"[2001:db8::1]:4000", map[string]string{"X-Forwarded-For": "2001:db9::7"}, "2001:db9::7"},
+	}
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.Peer
+		for k, v := range tc.Headers {
+			req.Header.Set(k, v)
+		}
+		if ip := proxies.ClientIP(req); ip != tc.Expected {
+			t.Errorf("%s %v: expected %s, got %s", tc.Peer, tc.Headers, tc.Expected, ip)
+		}
+	}
+
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an invalid cidr to be rejected")
+	}
+}
+
+func TestTrustedProxiesWrap(t *testing.T) {
+	proxies, _ := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	var seen string
+	h := proxies.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = clientIP(req)
+	}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.5:4000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if seen != "198.51.100.9" {
+		t.Errorf("expected handlers to see the forwarded client, got %s", seen)
+	}
+}
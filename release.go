@@ -0,0 +1,222 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+const (
+	// ReleasePointer, stored under the prefix, names the release being served
+	ReleasePointer = "current.json"
+
+	// releasesDir holds each release under the prefix, along with its
+	// manifest, e.g. releases/20150102T150405Z/ and releases/20150102T150405Z.json
+	releasesDir = "releases/"
+
+	// maxReleaseHistory bounds how many releases can be rolled back to
+	maxReleaseHistory = 20
+)
+
+// Release is the content of the release pointer
+type Release struct {
+	ID string `json:"release"`
+	// History lists earlier releases, most recent last
+	History []string `json:"history,omitempty"`
+}
+
+// ReleaseManifest records the files making up a release
+type ReleaseManifest struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Files   []string  `json:"files"`
+}
+
+// releasePath returns the path of the release relative to the prefix
+func releasePath(id string) string {
+	return releasesDir + id + "/"
+}
+
+// isReleaseKey returns true for keys, relative to the prefix, written by
+// atomic deploys
+func isReleaseKey(rel string) bool {
+	return rel == ReleasePointer || strings.HasPrefix(rel, releasesDir)
+}
+
+// ReadRelease returns the release pointer under prefix, or nil if there is
+// none
+func ReadRelease(bucket *s3.Bucket, prefix string) (*Release, error) {
+	data, err := bucket.Get(prefix + ReleasePointer)
+	if err != nil {
+		if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	release := &Release{}
+	if err := json.Unmarshal(data, release); err != nil {
+		return nil, fmt.Errorf("invalid release pointer, %s", err)
+	}
+	if release.ID == "" {
+		return nil, fmt.Errorf("invalid release pointer, no release")
+	}
+	return release, nil
+}
+
+func writeJSON(bucket *s3.Bucket, key string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data, "application/json", s3.Private)
+}
+
+// DeployRelease uploads dir as a new release then points the release pointer
+// at it, so servers switch from one complete release to the next.  Files
+// unchanged since the current release are copied within s3.
+func DeployRelease(d *Deployer, dir string, now time.Time) error {
+	prefix := d.Prefix
+	current, err := ReadRelease(d.Bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	id := now.UTC().Format("20060102T150405Z")
+	d.Prefix = prefix + releasePath(id)
+	d.Delete = false
+	if current != nil {
+		d.Base = prefix + releasePath(current.ID)
+	}
+	defer func() { d.Prefix, d.Base = prefix, "" }()
+
+	plan, err := d.Plan(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Apply(plan); err != nil {
+		return err
+	}
+
+	manifest := ReleaseManifest{ID: id, Created: now.UTC()}
+	for _, file := range plan.Upload {
+		manifest.Files = append(manifest.Files, strings.TrimPrefix(file.Key, d.Prefix))
+	}
+	for _, op := range plan.Copy {
+		manifest.Files = append(manifest.Files, strings.TrimPrefix(op.To, d.Prefix))
+	}
+	if err := writeJSON(d.Bucket, prefix+releasesDir+id+".json", manifest); err != nil {
+		return err
+	}
+
+	next := &Release{ID: id}
+	if current != nil {
+		next.History = append(current.History, current.ID)
+		if n := len(next.History); n > maxReleaseHistory {
+			next.History = next.History[n-maxReleaseHistory:]
+		}
+	}
+	if err := writeJSON(d.Bucket, prefix+ReleasePointer, next); err != nil {
+		return err
+	}
+	d.printf("released %s\n", id)
+	return nil
+}
+
+// RollbackRelease points the release pointer under prefix back at the
+// previous release, returning the release rolled back from and to
+func RollbackRelease(bucket *s3.Bucket, prefix string) (string, string, error) {
+	current, err := ReadRelease(bucket, prefix)
+	if err != nil {
+		return "", "", err
+	}
+	if current == nil {
+		return "", "", fmt.Errorf("no release to roll back; deploy with --atomic first")
+	}
+	if len(current.History) == 0 {
+		return "", "", fmt.Errorf("no release before %s", current.ID)
+	}
+
+	n := len(current.History)
+	previous := &Release{ID: current.History[n-1], History: current.History[:n-1]}
+	if err := writeJSON(bucket, prefix+ReleasePointer, previous); err != nil {
+		return "", "", err
+	}
+	return current.ID, previous.ID, nil
+}
+
+// Rollback serves the previous release of an atomic deploy
+func Rollback(c *cli.Context) {
+	opts := Opts(c)
+	if opts.Bucket == "" {
+		check(fmt.Errorf("rollback requires a bucket"))
+	}
+
+	auth, err := aws.EnvAuth()
+	check(err)
+
+	bucket := s3.New(auth, aws.USEast).Bucket(opts.Bucket)
+	from, to, err := RollbackRelease(bucket, opts.keyPrefix("/"))
+	check(err)
+	fmt.Fprintf(os.Stdout, "rolled back from %s to %s\n", from, to)
+}
+
+// WatchRelease polls the release pointer every interval, switching the
+// served release whenever it changes
+func WatchRelease(live *LiveOptions, bucket *s3.Bucket, creds *Credentials, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := resolveRelease(live, creds.Sign(bucket)); err != nil {
+			logger.Warn("unable to read release pointer", Fields{"bucket": bucket.Name, "error": err})
+		}
+	}
+}
+
+// resolveRelease serves the release named by the pointer
+func resolveRelease(live *LiveOptions, bucket *s3.Bucket) error {
+	opts := live.Load()
+	release, err := ReadRelease(bucket, opts.siteRoot())
+	if err != nil {
+		return err
+	}
+	if release == nil {
+		return fmt.Errorf("no release pointer at %s%s", opts.siteRoot(), ReleasePointer)
+	}
+	if release.ID == opts.Release {
+		return nil
+	}
+
+	live.Update(func(o *Options) {
+		o.Release = release.ID
+	})
+	logger.Info("serving release", Fields{"bucket": bucket.Name, "release": release.ID, "previous": opts.Release})
+	return nil
+}
@@ -0,0 +1,173 @@
+package s3site
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+// memS3 is an in-memory s3 bucket supporting get, put, copy, and list
+type memS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	puts    int
+	copies  int
+}
+
+func (m *memS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := strings.TrimPrefix(req.URL.Path, "/bucket/")
+	switch {
+	case req.Method == "GET" && key == "":
+		prefix := req.URL.Query().Get("prefix")
+		keys := []string{}
+		for k := range m.objects {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, `<ListBucketResult><Name>bucket</Name>`)
+		for _, k := range keys {
+			sum := md5.Sum(m.objects[k])
+			fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size><ETag>"%s"</ETag></Contents>`, k, len(m.objects[k]), hex.EncodeToString(sum[:]))
+		}
+		fmt.Fprint(w, `</ListBucketResult>`)
+
+	case req.Method == "GET":
+		data, ok := m.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		w.Write(data)
+
+	case req.Method == "PUT" && req.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.QueryUnescape(req.Header.Get("X-Amz-Copy-Source"))
+		m.objects[key] = m.objects[strings.TrimPrefix(source, "/bucket/")]
+		m.copies++
+
+	case req.Method == "PUT":
+		data, _ := ioutil.ReadAll(req.Body)
+		m.objects[key] = data
+		m.puts++
+	}
+}
+
+func (m *memS3) release(t *testing.T, prefix string) *Release {
+	release := &Release{}
+	if err := json.Unmarshal(m.objects[prefix+ReleasePointer], release); err != nil {
+		t.Fatal(err)
+	}
+	return release
+}
+
+func TestDeployReleaseAndRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("v1"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "logo.png"), []byte("png"), 0644)
+
+	store := &memS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(store)
+	defer server.Close()
+
+	api := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{S3Endpoint: server.URL})
+	bucket := api.Bucket("bucket")
+	deployer := &Deployer{Bucket: bucket, Prefix: "site/", Parallel: 2}
+
+	first := time.Date(2015, 1, 2, 15, 4, 5, 0, time.UTC)
+	if err := DeployRelease(deployer, dir, first); err != nil {
+		t.Fatal(err)
+	}
+	if string(store.objects["site/releases/20150102T150405Z/index.html"]) != "v1" {
+		t.Error("expected the first release to be uploaded under its own prefix")
+	}
+	if release := store.release(t, "site/"); release.ID != "20150102T150405Z" || len(release.History) != 0 {
+		t.Errorf("unexpected release pointer, %+v", release)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("v2"), 0644)
+	store.puts = 0
+	if err := DeployRelease(deployer, dir, first.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if string(store.objects["site/releases/20150102T160405Z/logo.png"]) != "png" || store.copies != 1 {
+		t.Errorf("expected the unchanged file to be copied, %d copies", store.copies)
+	}
+	if store.puts != 3 {
+		t.Errorf("expected the changed file, manifest, and pointer to be put, got %d puts", store.puts)
+	}
+	if deployer.Prefix != "site/" {
+		t.Errorf("expected the deployer prefix to be restored, got %s", deployer.Prefix)
+	}
+
+	manifest := ReleaseManifest{}
+	json.Unmarshal(store.objects["site/releases/20150102T160405Z.json"], &manifest)
+	sort.Strings(manifest.Files)
+	if strings.Join(manifest.Files, ",") != "index.html,logo.png" {
+		t.Errorf("unexpected manifest, %+v", manifest)
+	}
+
+	live := NewLiveOptions(&Options{Bucket: "bucket", Prefix: "site", IndexFile: "index.html", Releases: true}, nil)
+	if err := resolveRelease(live, bucket); err != nil {
+		t.Fatal(err)
+	}
+	if key := live.Load().Key("/"); key != "site/releases/20150102T160405Z/index.html" {
+		t.Errorf("expected the latest release to be served, got %s", key)
+	}
+
+	from, to, err := RollbackRelease(bucket, "site/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "20150102T160405Z" || to != "20150102T150405Z" {
+		t.Errorf("unexpected rollback from %s to %s", from, to)
+	}
+	if err := resolveRelease(live, bucket); err != nil {
+		t.Fatal(err)
+	}
+	if key := live.Load().Key("/"); key != "site/releases/20150102T150405Z/index.html" {
+		t.Errorf("expected the previous release to be served, got %s", key)
+	}
+
+	if _, _, err := RollbackRelease(bucket, "site/"); err == nil {
+		t.Error("expected rollback past the first release to fail")
+	}
+}
+
+func TestReleaseSurvivesReload(t *testing.T) {
+	live := NewLiveOptions(&Options{Prefix: "site"}, func() (*Options, error) {
+		return &Options{Prefix: "site"}, nil
+	})
+	live.Update(func(o *Options) { o.Release = "r1" })
+
+	restart, err := live.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restart) != 0 || live.Load().Release != "r1" {
+		t.Errorf("expected the release to be kept across a reload, got %q %v", live.Load().Release, restart)
+	}
+}
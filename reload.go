@@ -0,0 +1,212 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ReloadPath reloads the config file, credentials, and cache rules
+const ReloadPath = "/-/reload"
+
+var errNoSource = errors.New("options have no source to reload from")
+
+// reloadable lists the Options fields that take effect without a restart;
+// changes to any other field are reported as requiring one
+var reloadable = map[string]bool{
+	"AdminToken":         true,
+	"Username":           true,
+	"Password":           true,
+	"Users":              true,
+	"Realm":              true,
+	"MaxAge":             true,
+	"SurrogateMaxAge":    true,
+	"SurrogateKeyHeader": true,
+	"DeployID":           true,
+	"CacheFresh":         true,
+	"CacheTTL":           true,
+}
+
+// LiveOptions holds the current options.  Requests take a snapshot with Load
+// as they start, so a reload never changes the rules partway through serving
+// a request and in flight requests complete undisturbed.
+type LiveOptions struct {
+	// Source reads fresh options e.g. from the command line, environment,
+	// and config file; options without a source, e.g. those given to New,
+	// can't be reloaded
+	Source func() (*Options, error)
+
+	value    atomic.Value
+	mu       sync.Mutex
+	onReload []func(*Options)
+}
+
+func NewLiveOptions(opts *Options, source func() (*Options, error)) *LiveOptions {
+	live := &LiveOptions{Source: source}
+	live.value.Store(opts)
+	return live
+}
+
+// Load returns the current options, which must not be modified
+func (l *LiveOptions) Load() *Options {
+	return l.value.Load().(*Options)
+}
+
+// OnReload registers fn to be called with the options after each reload
+func (l *LiveOptions) OnReload(fn func(*Options)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onReload = append(l.onReload, fn)
+}
+
+// RunEvery calls fn with the current options now, every interval, and after
+// each reload or update e.g. when a release or slot goes live
+func (l *LiveOptions) RunEvery(interval time.Duration, fn func(*Options)) {
+	l.OnReload(func(opts *Options) { go fn(opts) })
+	fn(l.Load())
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		fn(l.Load())
+	}
+}
+
+// Reload reads fresh options from Source and applies the reloadable ones,
+// returning the names of changed options that require a restart
+func (l *LiveOptions) Reload() ([]string, error) {
+	if l.Source == nil {
+		return nil, errNoSource
+	}
+	fresh, err := l.Source()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ParseTTLRules(fresh.CacheTTL); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current := l.Load()
+	next := *current
+	restart := []string{}
+
+	nextValue := reflect.ValueOf(&next).Elem()
+	freshValue := reflect.ValueOf(fresh).Elem()
+	for i := 0; i < nextValue.NumField(); i++ {
+		name := nextValue.Type().Field(i).Name
+		if name == "Release" || name == "Slot" || name == "CanaryPercent" {
+			// resolved or switched at runtime rather than configured
+			continue
+		}
+		if reflect.DeepEqual(nextValue.Field(i).Interface(), freshValue.Field(i).Interface()) {
+			continue
+		}
+		if !reloadable[name] {
+			restart = append(restart, name)
+			continue
+		}
+		nextValue.Field(i).Set(freshValue.Field(i))
+	}
+
+	l.value.Store(&next)
+	for _, fn := range l.onReload {
+		fn(&next)
+	}
+	return restart, nil
+}
+
+// Update applies fn to a copy of the current options and stores the result
+func (l *LiveOptions) Update(fn func(*Options)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	next := *l.Load()
+	fn(&next)
+	l.value.Store(&next)
+	for _, hook := range l.onReload {
+		hook(&next)
+	}
+}
+
+// reload reloads the options, logging the outcome
+func (l *LiveOptions) reload(source string) ([]string, error) {
+	restart, err := l.Reload()
+	if err != nil {
+		logger.Error("unable to reload configuration", Fields{"source": source, "error": err})
+		return nil, err
+	}
+	logger.Info("reloaded configuration", Fields{"source": source})
+	if len(restart) > 0 {
+		logger.Warn("some changes require a restart", Fields{"options": restart})
+	}
+	return restart, nil
+}
+
+// ReloadOnSignal reloads the options whenever the process receives SIGHUP
+func (l *LiveOptions) ReloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		l.reload("SIGHUP")
+	}
+}
+
+type reloadResponse struct {
+	RequiresRestart []string `json:"requires_restart"`
+}
+
+// ReloadHandler reloads the options on POST /-/reload
+func ReloadHandler(live *LiveOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		opts := live.Load()
+		if !opts.IsAdmin(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		restart, err := live.reload(ReloadPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reloadResponse{RequiresRestart: restart})
+	}
+}
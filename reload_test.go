@@ -0,0 +1,91 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveOptionsReload(t *testing.T) {
+	current := &Options{Bucket: "a", Username: "alice", Password: "old", Realm: "Realm"}
+	fresh := &Options{Bucket: "b", Username: "alice", Password: "new", Realm: "Staff", CacheTTL: []string{"*.png=1h"}}
+	live := NewLiveOptions(current, func() (*Options, error) { return fresh, nil })
+
+	var reloaded *Options
+	live.OnReload(func(opts *Options) { reloaded = opts })
+
+	restart, err := live.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restart, []string{"Bucket"}) {
+		t.Errorf("expected bucket to require a restart, got %v", restart)
+	}
+
+	opts := live.Load()
+	if opts != reloaded {
+		t.Error("expected reload callbacks to receive the new options")
+	}
+	if opts.Bucket != "a" || opts.Password != "new" || opts.Realm != "Staff" || len(opts.CacheTTL) != 1 {
+		t.Errorf("unexpected options after reload, %+v", opts)
+	}
+	if current.Password != "old" {
+		t.Error("expected the previous snapshot to be left unchanged")
+	}
+}
+
+func TestLiveOptionsReloadRejectsInvalidRules(t *testing.T) {
+	current := &Options{Password: "old"}
+	live := NewLiveOptions(current, func() (*Options, error) {
+		return &Options{Password: "new", CacheTTL: []string{"*.png=soon"}}, nil
+	})
+
+	if _, err := live.Reload(); err == nil {
+		t.Error("expected invalid ttl rules to be rejected")
+	}
+	if live.Load() != current {
+		t.Error("expected options to be unchanged after a failed reload")
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	opts := &Options{AdminToken: "secret", Realm: "Realm"}
+	live := NewLiveOptions(opts, func() (*Options, error) {
+		return &Options{AdminToken: "secret", Realm: "Staff"}, nil
+	})
+	handler := ReloadHandler(live)
+
+	req, _ := http.NewRequest("POST", ReloadPath, nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", w.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if body := strings.TrimSpace(w.Body.String()); body != `{"requires_restart":[]}` {
+		t.Errorf("unexpected response %s", body)
+	}
+	if realm := live.Load().Realm; realm != "Staff" {
+		t.Errorf("expected realm to be reloaded, got %s", realm)
+	}
+}
+
+func TestTTLPolicySetRules(t *testing.T) {
+	policy := &TTLPolicy{Default: time.Minute}
+	entry := &Entry{Key: "logo.png", ContentType: "image/png"}
+
+	rules, _ := ParseTTLRules([]string{"*.png=1h"})
+	policy.SetRules(rules, time.Second)
+	if ttl := policy.For(entry); ttl != time.Hour {
+		t.Errorf("expected 1h, got %v", ttl)
+	}
+	if ttl := policy.For(&Entry{Key: "index.html"}); ttl != time.Second {
+		t.Errorf("expected new default of 1s, got %v", ttl)
+	}
+}
@@ -0,0 +1,251 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RewriteCondition tests a request header or cookie; rules apply only to
+// requests meeting each of their conditions
+type RewriteCondition struct {
+	// Cookie is true for conditions on the cookie named Name, rather than
+	// the header
+	Cookie bool
+	Name   string
+	// Value, when set, must equal the header or cookie
+	Value string
+	// Pattern, when set, must match it; with neither, it need only be
+	// present
+	Pattern *regexp.Regexp
+	// Negate inverts the condition
+	Negate bool
+}
+
+// parseRewriteCondition parses conditions of the form [!]header:Name,
+// [!]header:Name=value, or [!]header:Name~pattern, or the same of cookie:
+func parseRewriteCondition(value string) (RewriteCondition, error) {
+	c := RewriteCondition{}
+	s := value
+	if strings.HasPrefix(s, "!") {
+		c.Negate, s = true, s[1:]
+	}
+	switch {
+	case strings.HasPrefix(s, "cookie:"):
+		c.Cookie, s = true, strings.TrimPrefix(s, "cookie:")
+	case strings.HasPrefix(s, "header:"):
+		s = strings.TrimPrefix(s, "header:")
+	default:
+		return c, fmt.Errorf("unknown rewrite flag, %s", value)
+	}
+
+	if i := strings.IndexAny(s, "=~"); i >= 0 {
+		c.Name = s[:i]
+		if s[i] == '=' {
+			c.Value = s[i+1:]
+		} else {
+			pattern, err := regexp.Compile(s[i+1:])
+			if err != nil {
+				return c, fmt.Errorf("invalid rewrite condition pattern, %s: %v", s[i+1:], err)
+			}
+			c.Pattern = pattern
+		}
+	} else {
+		c.Name = s
+	}
+	if c.Name == "" {
+		return c, fmt.Errorf("invalid rewrite condition, %s", value)
+	}
+	if !c.Cookie {
+		c.Name = http.CanonicalHeaderKey(c.Name)
+	}
+	return c, nil
+}
+
+// Meets reports whether req meets the condition
+func (c RewriteCondition) Meets(req *http.Request) bool {
+	value, present := "", false
+	if c.Cookie {
+		if cookie, err := req.Cookie(c.Name); err == nil {
+			value, present = cookie.Value, true
+		}
+	} else if values, ok := req.Header[c.Name]; ok {
+		value, present = strings.Join(values, ","), true
+	}
+
+	met := present
+	switch {
+	case c.Value != "":
+		met = present && value == c.Value
+	case c.Pattern != nil:
+		met = present && c.Pattern.MatchString(value)
+	}
+	return met != c.Negate
+}
+
+// RewriteRule maps request paths matching Pattern to Replacement, in which
+// $1, ${name}, and so on are the pattern's groups
+type RewriteRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+	// Last stops the rules that follow from applying to the result
+	Last bool
+	// Redirect, when set, is the status the client is redirected to the
+	// result with, rather than served it; rules that redirect are last
+	Redirect int
+	// Conditions must each be met by the request for the rule to apply
+	Conditions []RewriteCondition
+}
+
+// Rewrites are applied in order, each to the result of those before it,
+// until one marked last matches
+type Rewrites []RewriteRule
+
+// ParseRewrites parses rules of the form "pattern replacement [flag...]"
+// e.g. "^/blog/(\d+)/(.*)$ /posts/$1-$2 last"; rules continue unless marked
+// last, redirect with redirect or redirect=301, and apply only to requests
+// meeting their header: and cookie: conditions e.g.
+// "^/(.*)$ /beta/$1 cookie:beta=1 last" or
+// "^/(.*)$ /m/$1 header:User-Agent~(?i)mobile redirect"
+func ParseRewrites(values []string) (Rewrites, error) {
+	rules := Rewrites{}
+	for _, value := range values {
+		fields := strings.Fields(value)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid rewrite, %s", value)
+		}
+		pattern, err := regexp.Compile(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite pattern, %s: %v", fields[0], err)
+		}
+		if !strings.HasPrefix(fields[1], "/") || strings.Contains(fields[1], "?") {
+			return nil, fmt.Errorf("invalid rewrite replacement, %s; rewrites replace the path, so begin with / and have no query", fields[1])
+		}
+		rule := RewriteRule{Pattern: pattern, Replacement: fields[1]}
+		for _, flag := range fields[2:] {
+			switch {
+			case flag == "last":
+				rule.Last = true
+			case flag == "continue":
+			case flag == "redirect":
+				rule.Redirect = http.StatusFound
+			case strings.HasPrefix(flag, "redirect="):
+				status, err := strconv.Atoi(strings.TrimPrefix(flag, "redirect="))
+				if err != nil || !redirectStatus(status) {
+					return nil, fmt.Errorf("invalid rewrite redirect, %s; use 301, 302, 303, 307, or 308", flag)
+				}
+				rule.Redirect = status
+			default:
+				condition, err := parseRewriteCondition(flag)
+				if err != nil {
+					return nil, err
+				}
+				rule.Conditions = append(rule.Conditions, condition)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// applies reports whether req meets each of the rule's conditions
+func (r RewriteRule) applies(req *http.Request) bool {
+	for _, c := range r.Conditions {
+		if !c.Meets(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns urlPath rewritten by the rules that match it, and the
+// status to redirect with when a redirecting rule matched.  req may be nil
+// for paths without a request, in which case rules with conditions are
+// skipped.
+func (r Rewrites) Apply(req *http.Request, urlPath string) (string, int) {
+	for _, rule := range r {
+		if len(rule.Conditions) > 0 && (req == nil || !rule.applies(req)) {
+			continue
+		}
+		match := rule.Pattern.FindStringSubmatchIndex(urlPath)
+		if match == nil {
+			continue
+		}
+		rewritten := string(rule.Pattern.ExpandString(nil, rule.Replacement, urlPath, match))
+		// captures may have brought in dot segments
+		cleaned := path.Clean(rewritten)
+		if strings.HasSuffix(rewritten, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		urlPath = cleaned
+		if rule.Redirect != 0 {
+			return urlPath, rule.Redirect
+		}
+		if rule.Last {
+			break
+		}
+	}
+	return urlPath, 0
+}
+
+// vary adds the headers the rules' conditions read to w's Vary, so caches
+// keep the responses to each apart
+func (r Rewrites) vary(w http.ResponseWriter) {
+	seen := map[string]bool{}
+	for _, rule := range r {
+		for _, c := range rule.Conditions {
+			name := c.Name
+			if c.Cookie {
+				name = "Cookie"
+			}
+			if !seen[name] {
+				seen[name] = true
+				w.Header().Add("Vary", name)
+			}
+		}
+	}
+}
+
+// Route returns urlPath rewritten for req, or redirects to it, returning
+// true, when a redirecting rule matched
+func (r Rewrites) Route(w http.ResponseWriter, req *http.Request, urlPath string) (string, bool) {
+	r.vary(w)
+	rewritten, status := r.Apply(req, urlPath)
+	if status == 0 {
+		if rewritten != urlPath {
+			logger.Debug("rewrote", Fields{"request_id": requestID(req), "path": urlPath, "rewritten": rewritten})
+		}
+		return rewritten, false
+	}
+
+	if req.URL.RawQuery != "" {
+		rewritten += "?" + req.URL.RawQuery
+	}
+	http.Redirect(w, req, rewritten, status)
+	return urlPath, true
+}
@@ -0,0 +1,120 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRewrites(t *testing.T) {
+	rules, err := ParseRewrites([]string{`^/blog/(\d+)/(.*)$ /posts/$1-$2 last`, `^/old/ /new/ continue`, `^/a$ /b`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 || !rules[0].Last || rules[1].Last || rules[2].Last {
+		t.Errorf("unexpected rules, %+v", rules)
+	}
+
+	for _, value := range []string{
+		"^/a$",
+		"^/a$ /b last extra",
+		"^/(a$ /b",
+		"^/a$ b",
+		"^/a$ /b?x=1",
+		"^/a$ /b stop",
+		"^/a$ /b redirect=200",
+		"^/a$ /b cookie:",
+		"^/a$ /b header:User-Agent~(",
+	} {
+		if _, err := ParseRewrites([]string{value}); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestRewritesApply(t *testing.T) {
+	rules, err := ParseRewrites([]string{
+		`^/blog/(\d+)/(.*)$ /posts/$1-$2 last`,
+		`^/old/(.*)$ /new/$1`,
+		`^/new/(?P<name>[^/]+)\.htm$ /new/${name}.html`,
+		`^/posts/(.*)$ /never/$1`,
+		`^/up/(.*)$ /base/$1/`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]string{
+		"/blog/2016/hello.html": "/posts/2016-hello.html",
+		"/old/page.htm":         "/new/page.html",
+		"/old/docs/":            "/new/docs/",
+		"/posts/x":              "/never/x",
+		"/up/../../etc":         "/etc/",
+		"/other/":               "/other/",
+	}
+	for urlPath, expected := range testCases {
+		if rewritten, _ := rules.Apply(nil, urlPath); rewritten != expected {
+			t.Errorf("%s: expected %s; got %s", urlPath, expected, rewritten)
+		}
+	}
+
+	var none Rewrites
+	if rewritten, _ := none.Apply(nil, "/a"); rewritten != "/a" {
+		t.Error("expected no rules to leave the path alone")
+	}
+}
+
+func TestRewriteConditions(t *testing.T) {
+	rules, err := ParseRewrites([]string{
+		`^/(.*)$ /beta/$1 cookie:beta=1 last`,
+		`^/(.*)$ /m/$1 header:user-agent~(?i)mobile !cookie:desktop redirect`,
+		`^/admin/(.*)$ /staff/$1 header:X-Staff redirect=307`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := rules[1].Conditions; len(c) != 2 || c[0].Name != "User-Agent" || !c[1].Cookie || !c[1].Negate || rules[1].Redirect != http.StatusFound {
+		t.Errorf("unexpected rule, %+v", rules[1])
+	}
+
+	testCases := []struct {
+		Target   string
+		Header   string
+		Value    string
+		Expected string
+		Status   int
+	}{
+		{"/docs/", "", "", "/docs/", 0},
+		{"/docs/", "Cookie", "beta=1", "/beta/docs/", 0},
+		{"/docs/", "Cookie", "beta=0", "/docs/", 0},
+		{"/docs/", "User-Agent", "Mozilla/5.0 (iPhone) Mobile/15E148", "/m/docs/", http.StatusFound},
+		{"/docs/", "User-Agent", "Mozilla/5.0 (X11; Linux x86_64)", "/docs/", 0},
+		{"/admin/users", "X-Staff", "", "/staff/users", http.StatusTemporaryRedirect},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.Target, nil)
+		if tc.Header != "" {
+			req.Header.Set(tc.Header, tc.Value)
+		}
+		if rewritten, status := rules.Apply(req, tc.Target); rewritten != tc.Expected || status != tc.Status {
+			t.Errorf("%s %s: expected %s %d; got %s %d", tc.Header, tc.Value, tc.Expected, tc.Status, rewritten, status)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/docs/?page=2", nil)
+	req.Header.Set("User-Agent", "Mobile")
+	req.AddCookie(&http.Cookie{Name: "desktop", Value: "1"})
+	if rewritten, status := rules.Apply(req, "/docs/"); rewritten != "/docs/" || status != 0 {
+		t.Errorf("expected the negated cookie to skip the rule; got %s %d", rewritten, status)
+	}
+
+	req = httptest.NewRequest("GET", "/docs/?page=2", nil)
+	req.Header.Set("User-Agent", "Mobile")
+	w := httptest.NewRecorder()
+	if _, redirected := rules.Route(w, req, "/docs/"); !redirected || w.Code != http.StatusFound || w.Header().Get("Location") != "/m/docs/?page=2" {
+		t.Errorf("unexpected redirect, %v %v", w.Code, w.Header())
+	}
+	if vary := w.Header()["Vary"]; len(vary) != 3 || vary[0] != "Cookie" || vary[1] != "User-Agent" || vary[2] != "X-Staff" {
+		t.Errorf("unexpected vary, %v", vary)
+	}
+}
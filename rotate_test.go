@@ -0,0 +1,104 @@
+package s3site
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &RotatingFile{Path: filepath.Join(dir, "access.log"), MaxBytes: 10}
+	defer r.Close()
+
+	r.Write([]byte("0123456789"))
+	r.Write([]byte("abc"))
+
+	data, _ := ioutil.ReadFile(r.Path)
+	if string(data) != "abc" {
+		t.Errorf("expected current file to hold abc, got %q", data)
+	}
+	matches, _ := filepath.Glob(r.Path + ".*")
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 rotated file, got %v", matches)
+	}
+	if data, _ := ioutil.ReadFile(matches[0]); string(data) != "0123456789" {
+		t.Errorf("expected rotated file to hold the first write, got %q", data)
+	}
+}
+
+func TestRotatingFileMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &RotatingFile{Path: filepath.Join(dir, "access.log"), MaxAge: time.Hour}
+	defer r.Close()
+
+	r.Write([]byte("old"))
+	r.opened = time.Now().Add(-2 * time.Hour)
+	r.Write([]byte("new"))
+
+	if data, _ := ioutil.ReadFile(r.Path); string(data) != "new" {
+		t.Errorf("expected rotation after max age, got %q", data)
+	}
+}
+
+func TestCompressFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log.1")
+	ioutil.WriteFile(path, []byte("hello"), 0644)
+	if err := compressFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected uncompressed file to be removed")
+	}
+	f, err := os.Open(path + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(gz); string(data) != "hello" {
+		t.Errorf("expected hello, got %q", data)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &RotatingFile{Path: filepath.Join(dir, "access.log"), MaxBackups: 2}
+	for _, suffix := range []string{"20200101T000000.000", "20200102T000000.000.gz", "20200103T000000.000"} {
+		ioutil.WriteFile(r.Path+"."+suffix, nil, 0644)
+	}
+	r.prune()
+
+	matches, _ := filepath.Glob(r.Path + ".*")
+	if len(matches) != 2 || filepath.Base(matches[0]) != "access.log.20200102T000000.000.gz" {
+		t.Errorf("expected the oldest backup to be pruned, got %v", matches)
+	}
+}
@@ -0,0 +1,71 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package s3site serves a website from an s3 bucket.  The s3site command in
+// cmd/s3site runs it as a server; New mounts the same handler within another
+// service e.g.
+//
+//	opts := s3site.DefaultOptions()
+//	opts.Bucket = "docs"
+//	site, err := s3site.New(opts)
+//	...
+//	mux.Handle("/docs/", http.StripPrefix("/docs", site))
+package s3site
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/codegangsta/cli"
+)
+
+// DefaultOptions returns the options s3site runs with when no flag is given.
+// As with the command, S3SITE_ environment variables override the defaults.
+func DefaultOptions() Options {
+	set := flag.NewFlagSet("s3site", flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	for _, f := range Flags() {
+		f.Apply(set)
+	}
+	return *Opts(cli.NewContext(nil, set, set))
+}
+
+// New returns a handler serving the bucket named by opts with the same auth,
+// caching, and routing as the s3site command, extended by any hooks.  Start
+// from DefaultOptions; credentials are read from the AWS_ environment
+// variables.
+func New(opts Options, extensions ...Hook) (http.Handler, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("s3site requires a bucket")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	handler, err := S3Handler(NewLiveOptions(&opts, nil), NewMetrics(), extensions...)
+	if err != nil {
+		return nil, err
+	}
+	return Recover(handler, nil), nil
+}
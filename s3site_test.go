@@ -0,0 +1,63 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultOptions(t *testing.T) {
+	opts := DefaultOptions()
+	if opts.Port != "8080" || opts.IndexFile != "index.html" || opts.DrainTimeout != 30*time.Second {
+		t.Errorf("expected the flag defaults, got %+v", opts)
+	}
+}
+
+func TestNew(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	opts := DefaultOptions()
+	if _, err := New(opts); err == nil {
+		t.Error("expected a bucket to be required")
+	}
+
+	opts.Bucket = "docs"
+	opts.CacheTTL = []string{"nonsense"}
+	if _, err := New(opts); err == nil {
+		t.Error("expected invalid options to be rejected")
+	}
+
+	opts.CacheTTL = nil
+	if h, err := New(opts); err != nil || h == nil {
+		t.Errorf("expected a handler, got %v", err)
+	}
+}
+
+func TestNewReload(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	opts := DefaultOptions()
+	opts.Bucket = "docs"
+	opts.AdminToken = "token"
+	h, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", ReloadPath, nil)
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "no source") {
+		t.Errorf("expected the reload to be refused, got %d %s", w.Code, w.Body.String())
+	}
+}
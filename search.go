@@ -0,0 +1,403 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/json"
+	"html"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+const (
+	// SearchPath answers queries e.g. /-/search?q=install
+	SearchPath = "/-/search"
+
+	// maxSearchText bounds the text indexed, and kept for snippets, of each
+	// page
+	maxSearchText = 32 << 10
+
+	// maxSearchResults bounds the results of one query
+	maxSearchResults = 50
+
+	// bm25 parameters
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	// titleWeight counts each word of a title as this many in the text
+	titleWeight = 3
+)
+
+var (
+	htmlHidden = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>`)
+	htmlTag    = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaces     = regexp.MustCompile(`\s+`)
+)
+
+// SearchDoc is an indexed page
+type SearchDoc struct {
+	Key   string         `json:"key"`
+	ETag  string         `json:"etag"`
+	Path  string         `json:"path"`
+	Title string         `json:"title"`
+	Text  string         `json:"text"`
+	Terms map[string]int `json:"terms"`
+	// Length counts the words of the page
+	Length int `json:"length"`
+}
+
+// SearchResult is a page matching a query
+type SearchResult struct {
+	Path    string  `json:"path"`
+	Title   string  `json:"title"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+type posting struct {
+	doc   int
+	count int
+}
+
+// Search is an inverted index of the site's html, markdown, and text pages,
+// ranked by bm25
+type Search struct {
+	// Exclude holds the patterns of pages left out
+	Exclude []string
+	// IndexKey, relative to the site's prefix, persists the index to the
+	// bucket, so it's loaded rather than rebuilt on start; in memory only
+	// when empty
+	IndexKey  string
+	IndexFile string
+
+	mu       sync.RWMutex
+	docs     []*SearchDoc
+	postings map[string][]posting
+	// avgLength is the mean length of docs
+	avgLength float64
+}
+
+// isSearchable returns true for the keys of pages indexed
+func isSearchable(key string) bool {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".html", ".htm", ".md", ".markdown", ".txt":
+		return true
+	}
+	return false
+}
+
+// tokenize returns the lowercase words of s
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// pageText returns the title and visible text of the page at key
+func pageText(key string, src []byte) (string, string) {
+	title := ""
+	text := string(src)
+	switch strings.ToLower(path.Ext(key)) {
+	case ".html", ".htm":
+		if m := htmlTitle.FindStringSubmatch(text); m != nil {
+			title = html.UnescapeString(strings.TrimSpace(m[1]))
+		}
+		text = html.UnescapeString(htmlTag.ReplaceAllString(htmlHidden.ReplaceAllString(text, " "), " "))
+	case ".md", ".markdown":
+		fields, rest := parseFrontMatter(src)
+		text = string(rest)
+		if title = fields["title"]; title == "" {
+			if m := firstHeading.FindStringSubmatch(text); m != nil {
+				title = m[1]
+			}
+		}
+	}
+	text = strings.TrimSpace(spaces.ReplaceAllString(text, " "))
+	if len(text) > maxSearchText {
+		text = text[:maxSearchText]
+		for len(text) > 0 && !utf8.RuneStart(text[len(text)-1]) {
+			text = text[:len(text)-1]
+		}
+	}
+	if title == "" {
+		title = path.Base(key)
+	}
+	return title, text
+}
+
+// newSearchDoc indexes the page stored at key
+func newSearchDoc(key, etag, urlPath string, src []byte) *SearchDoc {
+	title, text := pageText(key, src)
+	doc := &SearchDoc{Key: key, ETag: etag, Path: urlPath, Title: title, Text: text, Terms: map[string]int{}}
+	for _, term := range tokenize(text) {
+		doc.Terms[term]++
+		doc.Length++
+	}
+	for _, term := range tokenize(title) {
+		doc.Terms[term] += titleWeight
+		doc.Length += titleWeight
+	}
+	return doc
+}
+
+// excluded returns true for pages left out of the index
+func (s *Search) excluded(urlPath string) bool {
+	for _, pattern := range s.Exclude {
+		if (TTLRule{Pattern: pattern}).Matches(urlPath, "") {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh indexes the pages under prefix, reading only those whose etag
+// changed, and persists the index when it changed
+func (s *Search) Refresh(bucket *s3.Bucket, prefix string) error {
+	s.mu.RLock()
+	known := map[string]*SearchDoc{}
+	for _, doc := range s.docs {
+		known[doc.Key] = doc
+	}
+	s.mu.RUnlock()
+
+	docs := []*SearchDoc{}
+	changed := false
+	marker := ""
+	for {
+		list, err := bucket.List(prefix, "", marker, 1000)
+		if err != nil {
+			return err
+		}
+		for _, key := range list.Contents {
+			urlPath := "/" + strings.TrimPrefix(key.Key, prefix)
+			if !isSearchable(key.Key) || s.excluded(urlPath) {
+				continue
+			}
+			if doc, ok := known[key.Key]; ok && doc.ETag == key.ETag {
+				docs = append(docs, doc)
+				continue
+			}
+			if path.Base(urlPath) == s.IndexFile {
+				urlPath = strings.TrimSuffix(urlPath, s.IndexFile)
+			}
+
+			rc, err := bucket.GetReader(key.Key)
+			if err != nil {
+				logger.Warn("unable to index page", Fields{"bucket": bucket.Name, "key": key.Key, "error": err})
+				continue
+			}
+			src, err := ioutil.ReadAll(io.LimitReader(rc, maxRendered))
+			rc.Close()
+			if err != nil {
+				logger.Warn("unable to index page", Fields{"bucket": bucket.Name, "key": key.Key, "error": err})
+				continue
+			}
+			docs = append(docs, newSearchDoc(key.Key, key.ETag, urlPath, src))
+			changed = true
+		}
+		if !list.IsTruncated || len(list.Contents) == 0 {
+			break
+		}
+		marker = list.NextMarker
+		if marker == "" {
+			marker = list.Contents[len(list.Contents)-1].Key
+		}
+	}
+	changed = changed || len(docs) != len(known)
+
+	s.load(docs)
+	if changed && s.IndexKey != "" {
+		return writeJSON(bucket, prefix+s.IndexKey, docs)
+	}
+	return nil
+}
+
+// Load reads the index persisted under prefix, if any
+func (s *Search) Load(bucket *s3.Bucket, prefix string) error {
+	if s.IndexKey == "" {
+		return nil
+	}
+	data, err := bucket.Get(prefix + s.IndexKey)
+	if err != nil {
+		if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	docs := []*SearchDoc{}
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return err
+	}
+	s.load(docs)
+	return nil
+}
+
+// load replaces the index with one of docs
+func (s *Search) load(docs []*SearchDoc) {
+	postings := map[string][]posting{}
+	total := 0
+	for i, doc := range docs {
+		for term, count := range doc.Terms {
+			postings[term] = append(postings[term], posting{doc: i, count: count})
+		}
+		total += doc.Length
+	}
+	avgLength := 0.0
+	if len(docs) > 0 {
+		avgLength = float64(total) / float64(len(docs))
+	}
+
+	s.mu.Lock()
+	s.docs, s.postings, s.avgLength = docs, postings, avgLength
+	s.mu.Unlock()
+}
+
+// Run loads the persisted index of the site live serves, then refreshes it
+// every interval and whenever its options change
+func (s *Search) Run(live *LiveOptions, bucket *s3.Bucket, creds *Credentials, interval time.Duration) {
+	prefix := live.Load().keyPrefix("/")
+	if err := s.Load(creds.Sign(bucket), prefix); err != nil {
+		logger.Warn("unable to load search index", Fields{"bucket": bucket.Name, "key": prefix + s.IndexKey, "error": err})
+	}
+	live.RunEvery(interval, func(opts *Options) {
+		prefix := opts.keyPrefix("/")
+		if err := s.Refresh(creds.Sign(bucket), prefix); err != nil {
+			logger.Warn("unable to refresh search index", Fields{"bucket": bucket.Name, "prefix": prefix, "error": err})
+		}
+	})
+}
+
+// Query returns the pages best matching q, at most limit of them
+func (s *Search) Query(q string, limit int) []SearchResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := tokenize(q)
+	scores := map[int]float64{}
+	seen := map[string]bool{}
+	n := float64(len(s.docs))
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := s.postings[term]
+		idf := math.Log(1 + (n-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for _, p := range postings {
+			tf := float64(p.count)
+			length := float64(s.docs[p.doc].Length)
+			scores[p.doc] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/s.avgLength))
+		}
+	}
+
+	results := []SearchResult{}
+	for i, score := range scores {
+		doc := s.docs[i]
+		results = append(results, SearchResult{Path: doc.Path, Title: doc.Title, Score: score, Snippet: snippet(doc.Text, terms)})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// snippet returns the text around the first of terms in text
+func snippet(text string, terms []string) string {
+	const width = 160
+	start := 0
+	for _, term := range terms {
+		if loc := regexp.MustCompile(`(?i)` + regexp.QuoteMeta(term)).FindStringIndex(text); loc != nil {
+			start = loc[0] - width/4
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + width
+	if end > len(text) {
+		end = len(text)
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	out := text[start:end]
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(text) {
+		out += "…"
+	}
+	return out
+}
+
+// ServeHTTP answers ?q= with the best matching pages as json; ?limit=
+// bounds them, 10 by default
+func (s *Search) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s == nil {
+		http.NotFound(w, req)
+		return
+	}
+	q := strings.TrimSpace(req.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "missing q", http.StatusBadRequest)
+		return
+	}
+	limit := 10
+	if value := req.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxSearchResults)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Query   string         `json:"query"`
+		Results []SearchResult `json:"results"`
+	}{Query: q, Results: s.Query(q, limit)})
+}
@@ -0,0 +1,114 @@
+package s3site
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+func TestPageText(t *testing.T) {
+	title, text := pageText("site/a.html", []byte("<html><head><title>Fish &amp; Chips</title><style>p{}</style></head><body><h1>Menu</h1>\n<p>Cod,  chips</p><script>var x</script></body></html>"))
+	if title != "Fish & Chips" || text != "Menu Cod, chips" {
+		t.Errorf("unexpected html text, %q %q", title, text)
+	}
+	title, text = pageText("site/b.md", []byte("---\ntitle: Guide\n---\n# Install\n\nRun   it.\n"))
+	if title != "Guide" || text != "# Install Run it." {
+		t.Errorf("unexpected markdown text, %q %q", title, text)
+	}
+	if title, _ = pageText("site/c.txt", []byte("plain")); title != "c.txt" {
+		t.Errorf("expected the file name as title; got %q", title)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := strings.Repeat("filler ", 40) + "the Needle is here " + strings.Repeat("more ", 40)
+	s := snippet(text, []string{"needle"})
+	if !strings.HasPrefix(s, "…") || !strings.HasSuffix(s, "…") || !strings.Contains(s, "the Needle is here") {
+		t.Errorf("unexpected snippet, %q", s)
+	}
+	if s := snippet("short text", []string{"missing"}); s != "short text" {
+		t.Errorf("expected the whole short text; got %q", s)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	mem := &memS3{objects: map[string][]byte{
+		"site/index.html":        []byte("<title>Home</title><p>Welcome to the example site.</p>"),
+		"site/docs/install.md":   []byte("# Install\n\nDownload the binary and install it. Install takes a minute.\n"),
+		"site/docs/config.md":    []byte("# Configuration\n\nSet the bucket to install from.\n"),
+		"site/notes.txt":         []byte("notes about caching"),
+		"site/drafts/install.md": []byte("# Install draft\n"),
+		"site/logo.png":          []byte("png"),
+	}}
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" && strings.Contains(req.URL.Path, ".") {
+			atomic.AddInt32(&gets, 1)
+		}
+		mem.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+	bucket := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{Name: "us-east-1", S3Endpoint: server.URL}).Bucket("bucket")
+
+	search := &Search{Exclude: []string{"/drafts/"}, IndexKey: "_search/index.json", IndexFile: "index.html"}
+	if err := search.Refresh(bucket, "site/"); err != nil {
+		t.Fatal(err)
+	}
+	if len(search.docs) != 4 {
+		t.Fatalf("expected 4 pages indexed; got %d", len(search.docs))
+	}
+
+	results := search.Query("install", 10)
+	if len(results) != 2 || results[0].Path != "/docs/install.md" || results[1].Path != "/docs/config.md" {
+		t.Errorf("expected install ranked above config; got %+v", results)
+	}
+	if results := search.Query("welcome", 10); len(results) != 1 || results[0].Path != "/" || results[0].Title != "Home" {
+		t.Errorf("expected the home page by its directory; got %+v", results)
+	}
+	if results := search.Query("nothing matches", 10); len(results) != 0 {
+		t.Errorf("expected no results; got %+v", results)
+	}
+
+	before := atomic.LoadInt32(&gets)
+	if err := search.Refresh(bucket, "site/"); err != nil {
+		t.Fatal(err)
+	}
+	if after := atomic.LoadInt32(&gets); after != before {
+		t.Errorf("expected unchanged pages not to be read again; got %d more reads", after-before)
+	}
+
+	loaded := &Search{IndexKey: "_search/index.json"}
+	if err := loaded.Load(bucket, "site/"); err != nil {
+		t.Fatal(err)
+	}
+	if results := loaded.Query("caching", 10); len(results) != 1 || results[0].Path != "/notes.txt" {
+		t.Errorf("expected the persisted index to answer queries; got %+v", results)
+	}
+
+	w := httptest.NewRecorder()
+	search.ServeHTTP(w, httptest.NewRequest("GET", "/-/search?q=install&limit=1", nil))
+	var body struct {
+		Query   string
+		Results []SearchResult
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Query != "install" || len(body.Results) != 1 || body.Results[0].Title != "Install" {
+		t.Errorf("unexpected response, %s", w.Body.String())
+	}
+
+	for _, target := range []string{"/-/search", "/-/search?q=x&limit=0"} {
+		w = httptest.NewRecorder()
+		search.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400; got %d", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Sentry reports panics and server errors to a sentry project
+type Sentry struct {
+	Endpoint  string // store api e.g. https://sentry.io/api/42/store/
+	PublicKey string
+	Client    *http.Client
+	Tags      map[string]string
+}
+
+// NewSentry parses a dsn of the form https://<key>@<host>/<project>
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("invalid sentry dsn, %s", dsn)
+	}
+
+	return &Sentry{
+		Endpoint:  fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		PublicKey: u.User.Username(),
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		Tags:      map[string]string{},
+	}, nil
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryRequest struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+}
+
+type sentryEvent struct {
+	EventID   string                 `json:"event_id"`
+	Timestamp string                 `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Platform  string                 `json:"platform"`
+	Message   string                 `json:"message,omitempty"`
+	Exception map[string]interface{} `json:"exception,omitempty"`
+	Request   *sentryRequest         `json:"request,omitempty"`
+	Tags      map[string]string      `json:"tags,omitempty"`
+}
+
+// stacktrace returns the calling goroutine's frames, oldest first as
+// sentry expects, skipping skip frames
+func stacktrace(skip int) []sentryFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []sentryFrame
+	for {
+		frame, more := frames.Next()
+		stack = append([]sentryFrame{{Function: frame.Function, Filename: frame.File, Lineno: frame.Line}}, stack...)
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+func (s *Sentry) event(level, message string, req *http.Request) *sentryEvent {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	event := &sentryEvent{
+		EventID:   hex.EncodeToString(id),
+		Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Level:     level,
+		Platform:  "go",
+		Message:   message,
+		Tags:      map[string]string{},
+	}
+	for key, value := range s.Tags {
+		event.Tags[key] = value
+	}
+
+	if req != nil {
+		headers := map[string]string{}
+		for name := range req.Header {
+			if name == "Authorization" || name == "Cookie" {
+				continue
+			}
+			headers[name] = req.Header.Get(name)
+		}
+		event.Request = &sentryRequest{URL: req.URL.String(), Method: req.Method, Headers: headers}
+		if id := requestID(req); id != "" {
+			event.Tags["request_id"] = id
+		}
+	}
+	return event
+}
+
+// CapturePanic reports a recovered panic with the stack of the panicking
+// goroutine; safe to call on a nil Sentry
+func (s *Sentry) CapturePanic(recovered interface{}, req *http.Request) {
+	if s == nil {
+		return
+	}
+
+	event := s.event("fatal", "", req)
+	exception := sentryException{
+		Type:       "panic",
+		Value:      fmt.Sprint(recovered),
+		Stacktrace: &sentryStacktrace{Frames: stacktrace(2)},
+	}
+	event.Exception = map[string]interface{}{"values": []sentryException{exception}}
+
+	go s.report(event)
+}
+
+// CaptureMessage reports an error without a stack; safe to call on a nil
+// Sentry
+func (s *Sentry) CaptureMessage(message string, req *http.Request) {
+	if s == nil {
+		return
+	}
+	go s.report(s.event("error", message, req))
+}
+
+func (s *Sentry) report(event *sentryEvent) {
+	if err := s.send(event); err != nil {
+		logger.Warn("unable to report to sentry", Fields{"event_id": event.EventID, "error": err})
+	}
+}
+
+func (s *Sentry) send(event *sentryEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=s3site/1.0, sentry_key=%s", s.PublicKey))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sentry responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Recover wraps h so a panic fails only the request that caused it.  The
+// panic is logged and, along with any 5xx response, reported to reporter
+// which may be nil.
+func Recover(h http.Handler, reporter *Sentry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+
+				stack := make([]byte, 8<<10)
+				stack = stack[:runtime.Stack(stack, false)]
+				logger.Error("panic serving request", Fields{"request_id": requestID(req), "path": req.URL.Path, "panic": fmt.Sprint(recovered), "stack": string(stack)})
+				reporter.CapturePanic(recovered, req)
+
+				if recorder.status == 0 {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+				return
+			}
+
+			if recorder.Status() >= 500 {
+				reporter.CaptureMessage(fmt.Sprintf("%d %s %s", recorder.Status(), req.Method, req.URL.Path), req)
+			}
+		}()
+
+		h.ServeHTTP(recorder, req)
+	})
+}
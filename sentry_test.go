@@ -0,0 +1,78 @@
+package s3site
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNewSentry(t *testing.T) {
+	s, err := NewSentry("https://abc123@o1.ingest.sentry.io/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Endpoint != "https://o1.ingest.sentry.io/api/42/store/" || s.PublicKey != "abc123" {
+		t.Errorf("unexpected sentry %#v", s)
+	}
+
+	if _, err := NewSentry("https://o1.ingest.sentry.io/42"); err == nil {
+		t.Errorf("expected an error for a dsn without a key")
+	}
+}
+
+func TestSentrySend(t *testing.T) {
+	var auth string
+	var event map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("X-Sentry-Auth")
+		data, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(data, &event)
+	}))
+	defer server.Close()
+
+	s, _ := NewSentry(strings.Replace(server.URL, "://", "://key@", 1) + "/1")
+	req := httptest.NewRequest("GET", "/index.html", nil)
+	req.Header.Set("Authorization", "Basic c2VjcmV0")
+
+	if err := s.send(s.event("error", "boom", req)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(auth, "sentry_key=key") {
+		t.Errorf("expected sentry auth header, got %s", auth)
+	}
+	headers := event["request"].(map[string]interface{})["headers"].(map[string]interface{})
+	if event["message"] != "boom" || headers["Authorization"] != nil {
+		t.Errorf("unexpected event %v", event)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger.Out = out
+	defer func() { logger.Out = os.Stderr }()
+
+	h := Recover(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	}), nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/index.html", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", w.Code)
+	}
+	if !strings.Contains(out.String(), "panic serving request") {
+		t.Errorf("expected the panic to be logged, got %s", out.String())
+	}
+}
+
+func TestStacktrace(t *testing.T) {
+	frames := stacktrace(0)
+	if last := frames[len(frames)-1]; !strings.HasSuffix(last.Function, "TestStacktrace") {
+		t.Errorf("expected innermost frame to be the caller, got %s", last.Function)
+	}
+}
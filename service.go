@@ -0,0 +1,315 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/codegangsta/cli"
+)
+
+// Service describes s3site installed as a service managed by the host
+type Service struct {
+	Name string
+	Exec string   // absolute path of the binary
+	Args []string // serve flags
+}
+
+// ServiceManager installs services for one init system
+type ServiceManager struct {
+	Kind string // systemd, launchd, or scm
+	Dir  string // where unit files are written; the registry key for scm
+
+	// Command runs the init system's tools; exec.Command unless set
+	Command func(name string, args ...string) error
+}
+
+// NewServiceManager returns the manager for goos
+func NewServiceManager(goos string) (*ServiceManager, error) {
+	switch goos {
+	case "linux":
+		return &ServiceManager{Kind: "systemd", Dir: "/etc/systemd/system"}, nil
+	case "darwin":
+		return &ServiceManager{Kind: "launchd", Dir: "/Library/LaunchDaemons"}, nil
+	case "windows":
+		return &ServiceManager{Kind: "scm", Dir: `HKLM\SYSTEM\CurrentControlSet\Services`}, nil
+	default:
+		return nil, fmt.Errorf("services aren't supported on %s; run s3site under the platform's service wrapper", goos)
+	}
+}
+
+var systemdUnit = template.Must(template.New("systemd").Parse(`[Unit]
+Description=s3site {{.Name}}
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+ExecStart={{.Command}}
+ExecReload=/bin/kill -HUP $MAINPID
+KillSignal=SIGTERM
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+var launchdPlist = template.Must(template.New("launchd").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{range .Argv}}		<string>{{.}}</string>
+{{end}}	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+</dict>
+</plist>
+`))
+
+// Path returns the unit file the service is written to, or for scm the
+// service's registry key
+func (m *ServiceManager) Path(s *Service) string {
+	switch m.Kind {
+	case "launchd":
+		return filepath.Join(m.Dir, launchdLabel(s.Name)+".plist")
+	case "scm":
+		return m.Dir + `\` + s.Name
+	}
+	return filepath.Join(m.Dir, s.Name+".service")
+}
+
+// Unit renders the systemd unit or launchd plist for s, or for scm the
+// command line the service control manager starts
+func (m *ServiceManager) Unit(s *Service) (string, error) {
+	if m.Kind == "scm" {
+		argv := append([]string{s.Exec, "service", "run", "--name", s.Name}, s.Args...)
+		quoted := []string{}
+		for _, arg := range argv {
+			quoted = append(quoted, windowsQuote(arg))
+		}
+		return strings.Join(quoted, " "), nil
+	}
+
+	argv := append([]string{s.Exec, "serve"}, s.Args...)
+	buf := &bytes.Buffer{}
+	var err error
+	if m.Kind == "launchd" {
+		err = launchdPlist.Execute(buf, map[string]interface{}{
+			"Label": launchdLabel(s.Name),
+			"Argv":  argv,
+		})
+	} else {
+		quoted := []string{}
+		for _, arg := range argv {
+			quoted = append(quoted, systemdQuote(arg))
+		}
+		err = systemdUnit.Execute(buf, map[string]interface{}{
+			"Name":    s.Name,
+			"Command": strings.Join(quoted, " "),
+		})
+	}
+	return buf.String(), err
+}
+
+// Install writes the unit then enables and starts the service
+func (m *ServiceManager) Install(s *Service) error {
+	unit, err := m.Unit(s)
+	if err != nil {
+		return err
+	}
+	if m.Kind == "scm" {
+		if err := m.run("sc.exe", "create", s.Name, "binPath=", unit, "start=", "auto", "DisplayName=", "s3site "+s.Name); err != nil {
+			return err
+		}
+		// restart on failure after 5s as the systemd unit does
+		if err := m.run("sc.exe", "failure", s.Name, "reset=", "86400", "actions=", "restart/5000"); err != nil {
+			return err
+		}
+		return m.run("sc.exe", "start", s.Name)
+	}
+	if err := ioutil.WriteFile(m.Path(s), []byte(unit), 0644); err != nil {
+		return err
+	}
+
+	if m.Kind == "launchd" {
+		return m.run("launchctl", "load", "-w", m.Path(s))
+	}
+	if err := m.run("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	return m.run("systemctl", "enable", "--now", s.Name)
+}
+
+// Uninstall stops and disables the service then removes its unit
+func (m *ServiceManager) Uninstall(s *Service) error {
+	if m.Kind == "scm" {
+		// stop fails when the service isn't running, which needn't stop
+		// its removal
+		m.run("sc.exe", "stop", s.Name)
+		return m.run("sc.exe", "delete", s.Name)
+	}
+	if m.Kind == "launchd" {
+		if err := m.run("launchctl", "unload", "-w", m.Path(s)); err != nil {
+			return err
+		}
+		return os.Remove(m.Path(s))
+	}
+
+	if err := m.run("systemctl", "disable", "--now", s.Name); err != nil {
+		return err
+	}
+	if err := os.Remove(m.Path(s)); err != nil {
+		return err
+	}
+	return m.run("systemctl", "daemon-reload")
+}
+
+func (m *ServiceManager) run(name string, args ...string) error {
+	if m.Command != nil {
+		return m.Command(name, args...)
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+func launchdLabel(name string) string {
+	return "com.github.savaki." + name
+}
+
+// systemdQuote escapes arg for ExecStart, which expands % specifiers and $
+// variables, quoting it when it holds spaces or quotes
+func systemdQuote(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// windowsQuote quotes arg for a command line split as CommandLineToArgvW
+// does, escaping the backslashes that precede quotes
+func windowsQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte('"')
+	slashes := 0
+	for i := 0; i < len(arg); i++ {
+		switch arg[i] {
+		case '\\':
+			slashes++
+		case '"':
+			buf.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		buf.WriteByte(arg[i])
+	}
+	buf.WriteString(strings.Repeat(`\`, slashes))
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// serviceFlags are the serve flags, which the unit runs with, and the name
+// of the service
+func serviceFlags() []cli.Flag {
+	return append(Flags(), cli.StringFlag{"name", "s3site", "name of the service", "S3SITE_SERVICE_NAME"})
+}
+
+// serviceArgs returns the arguments following action less --name, which
+// names the service rather than configuring serve
+func serviceArgs(args []string, action string) []string {
+	for i, arg := range args {
+		if arg == action {
+			args = args[i+1:]
+			break
+		}
+	}
+
+	serve := []string{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--name" || args[i] == "-name":
+			i++
+		case strings.HasPrefix(args[i], "--name=") || strings.HasPrefix(args[i], "-name="):
+		default:
+			serve = append(serve, args[i])
+		}
+	}
+	return serve
+}
+
+// ServiceRun serves as the service installed by ServiceCommand.  The scm
+// service runs it so s3site can report to windows' service control manager,
+// which stops it by asking it to drain; elsewhere it serves as serve does.
+func ServiceRun(c *cli.Context) {
+	os.Args = append([]string{os.Args[0], "serve"}, serviceArgs(os.Args[1:], "run")...)
+	check(runService(c.String("name"), func() { Run(c) }))
+}
+
+// ServiceCommand installs, uninstalls, or prints the unit for s3site as a
+// systemd, launchd, or windows service; the serve flags following the action are
+// baked into the unit as given e.g. service install --name docs --bucket docs
+func ServiceCommand(action string) func(c *cli.Context) {
+	return func(c *cli.Context) {
+		manager, err := NewServiceManager(runtime.GOOS)
+		check(err)
+		exe, err := os.Executable()
+		check(err)
+		exe, err = filepath.EvalSymlinks(exe)
+		check(err)
+
+		s := &Service{Name: c.String("name"), Exec: exe, Args: serviceArgs(os.Args[1:], action)}
+		switch action {
+		case "install":
+			check(manager.Install(s))
+			fmt.Printf("installed %s\n", manager.Path(s))
+		case "uninstall":
+			check(manager.Uninstall(s))
+			fmt.Printf("uninstalled %s\n", manager.Path(s))
+		default:
+			unit, err := manager.Unit(s)
+			check(err)
+			fmt.Print(unit)
+		}
+	}
+}
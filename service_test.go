@@ -0,0 +1,132 @@
+package s3site
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServiceUnits(t *testing.T) {
+	s := &Service{Name: "docs", Exec: "/usr/local/bin/s3site", Args: []string{"--bucket", "my bucket", "--prefix", "50%"}}
+
+	systemd, _ := NewServiceManager("linux")
+	unit, err := systemd.Unit(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(unit, `ExecStart=/usr/local/bin/s3site serve --bucket "my bucket" --prefix 50%%`+"\n") {
+		t.Errorf("unexpected systemd unit, %s", unit)
+	}
+	if systemd.Path(s) != "/etc/systemd/system/docs.service" {
+		t.Errorf("unexpected unit path, %s", systemd.Path(s))
+	}
+
+	launchd, _ := NewServiceManager("darwin")
+	plist, err := launchd.Unit(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(plist, "<string>com.github.savaki.docs</string>") || !strings.Contains(plist, "<string>my bucket</string>") {
+		t.Errorf("unexpected launchd plist, %s", plist)
+	}
+
+	if _, err := NewServiceManager("plan9"); err == nil {
+		t.Error("expected an unsupported platform to fail")
+	}
+}
+
+func TestServiceInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	commands := []string{}
+	manager := &ServiceManager{Kind: "systemd", Dir: dir, Command: func(name string, args ...string) error {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return nil
+	}}
+	s := &Service{Name: "docs", Exec: "/usr/local/bin/s3site"}
+
+	if err := manager.Install(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "docs.service")); err != nil {
+		t.Errorf("expected the unit to be written, %v", err)
+	}
+	if err := manager.Uninstall(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "docs.service")); !os.IsNotExist(err) {
+		t.Error("expected the unit to be removed")
+	}
+
+	expected := "systemctl daemon-reload,systemctl enable --now docs,systemctl disable --now docs,systemctl daemon-reload"
+	if got := strings.Join(commands, ","); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestServiceArgs(t *testing.T) {
+	args := serviceArgs([]string{"service", "install", "--name", "docs", "--bucket", "b", "--name=x", "--port", "80"}, "install")
+	if strings.Join(args, " ") != "--bucket b --port 80" {
+		t.Errorf("unexpected serve args, %v", args)
+	}
+}
+
+func TestServiceWindows(t *testing.T) {
+	commands := []string{}
+	manager, _ := NewServiceManager("windows")
+	manager.Command = func(name string, args ...string) error {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return nil
+	}
+	s := &Service{Name: "docs", Exec: `C:\Program Files\s3site\s3site.exe`, Args: []string{"--bucket", "docs"}}
+
+	command, err := manager.Unit(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `"C:\Program Files\s3site\s3site.exe" service run --name docs --bucket docs`; command != expected {
+		t.Errorf("expected %s, got %s", expected, command)
+	}
+	if manager.Path(s) != `HKLM\SYSTEM\CurrentControlSet\Services\docs` {
+		t.Errorf("unexpected registry key, %s", manager.Path(s))
+	}
+
+	if err := manager.Install(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Uninstall(s); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"sc.exe create docs binPath= " + command + " start= auto DisplayName= s3site docs",
+		"sc.exe failure docs reset= 86400 actions= restart/5000",
+		"sc.exe start docs",
+		"sc.exe stop docs",
+		"sc.exe delete docs",
+	}
+	if got := strings.Join(commands, ","); got != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, commands)
+	}
+}
+
+func TestWindowsQuote(t *testing.T) {
+	testCases := map[string]string{
+		"docs":         "docs",
+		"":             `""`,
+		"my bucket":    `"my bucket"`,
+		`say "hi"`:     `"say \"hi\""`,
+		`C:\dir with\`: `"C:\dir with\\"`,
+		`a\"b c`:       `"a\\\"b c"`,
+	}
+	for arg, expected := range testCases {
+		if got := windowsQuote(arg); got != expected {
+			t.Errorf("%s: expected %s, got %s", arg, expected, got)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// stopRequests drains the server as SIGTERM does, for service managers that
+// don't signal e.g. windows' service control manager
+var stopRequests = make(chan os.Signal, 1)
+
+// Serve runs server on each listener until SIGTERM or SIGINT, then drains
+// it; see Drain
+func Serve(server *http.Server, listeners []net.Listener, timeout time.Duration) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stop)
+
+	return Drain(server, func() error {
+		errs := make(chan error, len(listeners))
+		for _, ln := range listeners {
+			go func(ln net.Listener) {
+				errs <- server.Serve(ln)
+			}(ln)
+		}
+		return <-errs
+	}, stop, timeout)
+}
+
+// Drain runs serve until a signal arrives on stop or stopRequests, then stops accepting
+// connections and waits up to timeout for in flight requests to complete
+// before closing those that remain.  A request still streaming a download
+// when the timeout expires is cut off.
+func Drain(server *http.Server, serve func() error, stop <-chan os.Signal, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serve()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		logger.Info("draining connections", Fields{"signal": sig.String(), "timeout": timeout})
+	case sig := <-stopRequests:
+		logger.Info("draining connections", Fields{"signal": sig.String(), "timeout": timeout})
+	}
+
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Warn("drain timed out; closing remaining connections", Fields{"timeout": timeout})
+		server.Close()
+		return err
+	}
+	logger.Info("drained connections", Fields{"elapsed": time.Since(started).String()})
+	return nil
+}
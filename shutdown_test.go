@@ -0,0 +1,85 @@
+package s3site
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDrainFinishesInFlightRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+
+	stop := make(chan os.Signal, 1)
+	drained := make(chan error, 1)
+	go func() {
+		drained <- Drain(server, func() error { return server.Serve(ln) }, stop, time.Second)
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		body <- string(data)
+	}()
+
+	<-started
+	stop <- syscall.SIGTERM
+
+	if err := <-drained; err != nil {
+		t.Errorf("expected a clean drain, got %v", err)
+	}
+	if v := <-body; v != "done" {
+		t.Errorf("expected in flight request to complete, got %s", v)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("expected new connections to be refused after draining")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	stop := make(chan os.Signal, 1)
+	drained := make(chan error, 1)
+	go func() {
+		drained <- Drain(server, func() error { return server.Serve(ln) }, stop, 50*time.Millisecond)
+	}()
+	go http.Get("http://" + ln.Addr().String() + "/")
+
+	<-started
+	stop <- syscall.SIGTERM
+	if err := <-drained; err != context.DeadlineExceeded {
+		t.Errorf("expected drain to time out, got %v", err)
+	}
+}
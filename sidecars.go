@@ -0,0 +1,134 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSidecars bounds the Exists results remembered
+const maxSidecars = 10000
+
+// sidecarFormats are the formats sidecars may be stored in, smallest first
+var sidecarFormats = []struct {
+	Ext       string
+	MediaType string
+}{
+	{".avif", "image/avif"},
+	{".webp", "image/webp"},
+}
+
+// Sidecars serves avif and webp versions of images, stored beside the
+// original e.g. photo.jpg.avif, to clients whose Accept header allows them
+type Sidecars struct {
+	// Exists reports whether key is in the bucket
+	Exists func(key string) (bool, error)
+	// TTL is how long Exists results are remembered
+	TTL time.Duration
+
+	mu    sync.Mutex
+	known map[string]sidecar
+}
+
+type sidecar struct {
+	exists  bool
+	checked time.Time
+}
+
+// accepted returns the quality req's Accept header gives mediaType, 0 when
+// it isn't accepted; wildcards don't count, since browsers send */* for
+// images they can't decode
+func accepted(req *http.Request, mediaType string) float64 {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		params := strings.Split(accept, ";")
+		if strings.TrimSpace(params[0]) != mediaType {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		return q
+	}
+	return 0
+}
+
+// Negotiate returns the key of the sidecar of the image at key that req
+// prefers, or key itself when there is none
+func (s *Sidecars) Negotiate(req *http.Request, key string) string {
+	type candidate struct {
+		key string
+		q   float64
+	}
+	var candidates []candidate
+	for _, format := range sidecarFormats {
+		if q := accepted(req, format.MediaType); q > 0 {
+			candidates = append(candidates, candidate{key: key + format.Ext, q: q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	for _, c := range candidates {
+		if s.exists(c.key) {
+			return c.key
+		}
+	}
+	return key
+}
+
+// exists returns whether key is in the bucket, remembering the answer for
+// TTL; errors count as missing, so the original is served instead
+func (s *Sidecars) exists(key string) bool {
+	s.mu.Lock()
+	if s.known == nil {
+		s.known = map[string]sidecar{}
+	}
+	if known, ok := s.known[key]; ok && time.Since(known.checked) < s.TTL {
+		s.mu.Unlock()
+		return known.exists
+	}
+	s.mu.Unlock()
+
+	exists, err := s.Exists(key)
+	if err != nil {
+		logger.Warn("unable to check for sidecar", Fields{"key": key, "error": err})
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.known) >= maxSidecars {
+		s.known = map[string]sidecar{}
+	}
+	s.known[key] = sidecar{exists: exists, checked: time.Now()}
+	return exists
+}
@@ -0,0 +1,72 @@
+package s3site
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccepted(t *testing.T) {
+	testCases := []struct {
+		Accept string
+		Q      float64
+	}{
+		{"image/avif,image/webp,*/*", 1},
+		{"image/webp;q=0.8, image/avif;q=0.5", 0.5},
+		{"image/avif;q=0", 0},
+		{"*/*", 0},
+		{"", 0},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/photo.jpg", nil)
+		req.Header.Set("Accept", tc.Accept)
+		if q := accepted(req, "image/avif"); q != tc.Q {
+			t.Errorf("%q: expected %v; got %v", tc.Accept, tc.Q, q)
+		}
+	}
+}
+
+func TestSidecarsNegotiate(t *testing.T) {
+	stored := map[string]bool{"img/a.jpg.avif": true, "img/a.jpg.webp": true, "img/b.png.webp": true}
+	checks := 0
+	sidecars := &Sidecars{
+		Exists: func(key string) (bool, error) {
+			checks++
+			if key == "img/broken.jpg.webp" {
+				return false, errors.New("boom")
+			}
+			return stored[key], nil
+		},
+		TTL: time.Minute,
+	}
+
+	testCases := []struct {
+		Key    string
+		Accept string
+		Served string
+	}{
+		{"img/a.jpg", "image/avif,image/webp,*/*", "img/a.jpg.avif"},
+		{"img/a.jpg", "image/avif;q=0.5,image/webp", "img/a.jpg.webp"},
+		{"img/a.jpg", "image/webp,*/*", "img/a.jpg.webp"},
+		{"img/a.jpg", "*/*", "img/a.jpg"},
+		{"img/b.png", "image/avif,image/webp", "img/b.png.webp"},
+		{"img/c.gif", "image/avif,image/webp", "img/c.gif"},
+		{"img/broken.jpg", "image/webp", "img/broken.jpg"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/"+tc.Key, nil)
+		req.Header.Set("Accept", tc.Accept)
+		if served := sidecars.Negotiate(req, tc.Key); served != tc.Served {
+			t.Errorf("%s, %q: expected %s; got %s", tc.Key, tc.Accept, tc.Served, served)
+		}
+	}
+
+	before := checks
+	req := httptest.NewRequest("GET", "/img/c.gif", nil)
+	req.Header.Set("Accept", "image/avif,image/webp")
+	sidecars.Negotiate(req, "img/c.gif")
+	if checks != before {
+		t.Errorf("expected missing sidecars to be remembered; checked %d more", checks-before)
+	}
+}
@@ -0,0 +1,23 @@
+package s3site
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+// get-vanilla from the AWS signature version 4 test suite
+func TestSignV4(t *testing.T) {
+	auth := aws.Auth{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	SignV4(req, auth, "us-east-1", "service", nil, now)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
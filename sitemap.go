@@ -0,0 +1,251 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+const (
+	// SitemapPath serves the sitemap, or the sitemap index of large sites
+	SitemapPath = "/sitemap.xml"
+
+	// maxSitemapURLs is the most urls the protocol allows in one sitemap;
+	// larger sites are split into /sitemap-1.xml, /sitemap-2.xml, ...
+	maxSitemapURLs = 50000
+)
+
+// SitemapURL is a page listed in the sitemap
+type SitemapURL struct {
+	Path     string
+	Modified time.Time
+}
+
+// Sitemap lists the site's pages, built from the bucket listing
+type Sitemap struct {
+	// Include holds the patterns of pages listed, *.html when empty
+	Include []string
+	// Exclude holds the patterns of pages left out
+	Exclude []string
+	// BaseURL e.g. https://www.example.com precedes each path; the
+	// request's scheme and host are used when empty
+	BaseURL   string
+	IndexFile string
+
+	mu   sync.RWMutex
+	urls []SitemapURL
+}
+
+// Matches returns true when the page at urlPath belongs in the sitemap
+func (s *Sitemap) Matches(urlPath string) bool {
+	include := s.Include
+	if len(include) == 0 {
+		include = []string{"*.html"}
+	}
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if (TTLRule{Pattern: pattern}).Matches(urlPath, "") {
+				return true
+			}
+		}
+		return false
+	}
+	return matches(include) && !matches(s.Exclude)
+}
+
+// Refresh lists every object under prefix, replacing the urls served
+func (s *Sitemap) Refresh(bucket *s3.Bucket, prefix string) error {
+	urls := []SitemapURL{}
+	marker := ""
+	for {
+		list, err := bucket.List(prefix, "", marker, 1000)
+		if err != nil {
+			return err
+		}
+		for _, key := range list.Contents {
+			urlPath := "/" + strings.TrimPrefix(key.Key, prefix)
+			if strings.HasSuffix(urlPath, "/") || !s.Matches(urlPath) {
+				continue
+			}
+			if path.Base(urlPath) == s.IndexFile {
+				urlPath = strings.TrimSuffix(urlPath, s.IndexFile)
+			}
+			modified, _ := time.Parse(time.RFC3339, key.LastModified)
+			urls = append(urls, SitemapURL{Path: urlPath, Modified: modified})
+		}
+		if !list.IsTruncated || len(list.Contents) == 0 {
+			break
+		}
+		marker = list.NextMarker
+		if marker == "" {
+			marker = list.Contents[len(list.Contents)-1].Key
+		}
+	}
+
+	s.mu.Lock()
+	s.urls = urls
+	s.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the sitemap of the site live serves every interval, and
+// whenever its options change e.g. when a release or slot is deployed
+func (s *Sitemap) Run(live *LiveOptions, bucket *s3.Bucket, creds *Credentials, interval time.Duration) {
+	refresh := func(opts *Options) {
+		prefix := opts.keyPrefix("/")
+		if err := s.Refresh(creds.Sign(bucket), prefix); err != nil {
+			logger.Warn("unable to refresh sitemap", Fields{"bucket": bucket.Name, "prefix": prefix, "error": err})
+		}
+	}
+	live.RunEvery(interval, refresh)
+}
+
+// Serves returns true for the paths of the sitemap and its pages
+func (s *Sitemap) Serves(urlPath string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := sitemapPage(urlPath)
+	return ok
+}
+
+// sitemapPage returns the page of the sitemap at urlPath, 0 for the
+// sitemap itself
+func sitemapPage(urlPath string) (int, bool) {
+	if urlPath == SitemapPath {
+		return 0, true
+	}
+	if !strings.HasPrefix(urlPath, "/sitemap-") || !strings.HasSuffix(urlPath, ".xml") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(urlPath, "/sitemap-"), ".xml"))
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type urlSet struct {
+	XMLName xml.Name       `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapEntry `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name       `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+// siteURL returns the scheme and host generated urls begin with; base
+// when set, otherwise the request's
+func siteURL(base string, req *http.Request) string {
+	if base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host
+}
+
+// ServeHTTP writes the sitemap; once a site outgrows one sitemap,
+// /sitemap.xml is an index of /sitemap-1.xml, /sitemap-2.xml, ...
+func (s *Sitemap) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	page, ok := sitemapPage(req.URL.Path)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	s.mu.RLock()
+	urls := s.urls
+	s.mu.RUnlock()
+
+	base := siteURL(s.BaseURL, req)
+	pages := (len(urls) + maxSitemapURLs - 1) / maxSitemapURLs
+
+	var v interface{}
+	switch {
+	case page == 0 && pages > 1:
+		index := sitemapIndex{}
+		for i := 1; i <= pages; i++ {
+			index.Sitemaps = append(index.Sitemaps, sitemapEntry{Loc: fmt.Sprintf("%s/sitemap-%d.xml", base, i), LastMod: lastMod(urls[(i-1)*maxSitemapURLs : min(i*maxSitemapURLs, len(urls))])})
+		}
+		v = index
+
+	case page == 0 || page <= pages && pages > 1:
+		if page > 0 {
+			urls = urls[(page-1)*maxSitemapURLs : min(page*maxSitemapURLs, len(urls))]
+		}
+		set := urlSet{URLs: []sitemapEntry{}}
+		for _, u := range urls {
+			entry := sitemapEntry{Loc: base + u.Path}
+			if !u.Modified.IsZero() {
+				entry.LastMod = u.Modified.UTC().Format(time.RFC3339)
+			}
+			set.URLs = append(set.URLs, entry)
+		}
+		v = set
+
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	data, err := xml.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+// lastMod returns the latest modification of urls
+func lastMod(urls []SitemapURL) string {
+	var latest time.Time
+	for _, u := range urls {
+		if u.Modified.After(latest) {
+			latest = u.Modified
+		}
+	}
+	if latest.IsZero() {
+		return ""
+	}
+	return latest.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,116 @@
+package s3site
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+func TestSitemapRefresh(t *testing.T) {
+	objects := map[string]int64{
+		"site/index.html":         1,
+		"site/about.html":         1,
+		"site/docs/":              0,
+		"site/docs/index.html":    1,
+		"site/docs/guide.html":    1,
+		"site/drafts/next.html":   1,
+		"site/css/site.css":       1,
+		"other/index.html":        1,
+		"site/docs/images/x.png":  1,
+		"site/docs/notes/y.html":  1,
+		"site/docs/notes/z.html":  1,
+		"site/docs/notes/zz.html": 1,
+	}
+	for i := 0; i < 1200; i++ {
+		objects[fmt.Sprintf("site/posts/%04d.html", i)] = 1
+	}
+	server := fakeListing(objects)
+	defer server.Close()
+	bucket := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{Name: "us-east-1", S3Endpoint: server.URL}).Bucket("bucket")
+
+	sitemap := &Sitemap{Exclude: []string{"/drafts/"}, IndexFile: "index.html"}
+	if err := sitemap.Refresh(bucket, "site/"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sitemap.urls) != 1207 {
+		t.Fatalf("expected 1207 urls; got %d", len(sitemap.urls))
+	}
+
+	req := httptest.NewRequest("GET", "http://www.example.com/sitemap.xml", nil)
+	w := httptest.NewRecorder()
+	sitemap.ServeHTTP(w, req)
+	body := w.Body.String()
+	for _, expected := range []string{
+		`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`,
+		`<url><loc>http://www.example.com/</loc><lastmod>`,
+		`<loc>http://www.example.com/docs/</loc>`,
+		`<loc>http://www.example.com/docs/guide.html</loc>`,
+		`<loc>http://www.example.com/posts/1199.html</loc>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected sitemap to contain %s", expected)
+		}
+	}
+	for _, unexpected := range []string{"drafts", "site.css", "x.png", "index.html"} {
+		if strings.Contains(body, unexpected) {
+			t.Errorf("expected sitemap to leave out %s", unexpected)
+		}
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/xml; charset=utf-8" {
+		t.Errorf("expected xml; got %s", ct)
+	}
+}
+
+func TestSitemapIndex(t *testing.T) {
+	sitemap := &Sitemap{BaseURL: "https://www.example.com/"}
+	modified := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < maxSitemapURLs+1; i++ {
+		sitemap.urls = append(sitemap.urls, SitemapURL{Path: fmt.Sprintf("/%d.html", i), Modified: modified.Add(time.Duration(i) * time.Second)})
+	}
+
+	get := func(urlPath string) (int, string) {
+		w := httptest.NewRecorder()
+		sitemap.ServeHTTP(w, httptest.NewRequest("GET", urlPath, nil))
+		return w.Code, w.Body.String()
+	}
+
+	_, index := get("/sitemap.xml")
+	if !strings.Contains(index, "<sitemapindex") || !strings.Contains(index, "<loc>https://www.example.com/sitemap-2.xml</loc>") {
+		t.Errorf("expected a sitemap index; got %s", index)
+	}
+	if !strings.Contains(index, "<lastmod>2016-01-02T16:57:25Z</lastmod>") {
+		t.Errorf("expected the index to carry each page's latest modification; got %s", index)
+	}
+
+	_, second := get("/sitemap-2.xml")
+	if strings.Count(second, "<url>") != 1 || !strings.Contains(second, fmt.Sprintf("/%d.html", maxSitemapURLs)) {
+		t.Errorf("expected the last url on the second page; got %s", second)
+	}
+	if code, _ := get("/sitemap-3.xml"); code != 404 {
+		t.Errorf("expected 404 past the last page; got %d", code)
+	}
+}
+
+func TestSitemapServes(t *testing.T) {
+	var none *Sitemap
+	if none.Serves(SitemapPath) {
+		t.Error("expected no sitemap without one configured")
+	}
+	sitemap := &Sitemap{}
+	for urlPath, expected := range map[string]bool{
+		"/sitemap.xml":      true,
+		"/sitemap-12.xml":   true,
+		"/sitemap-0.xml":    false,
+		"/sitemap-a.xml":    false,
+		"/docs/sitemap.xml": false,
+	} {
+		if sitemap.Serves(urlPath) != expected {
+			t.Errorf("%s: expected %v", urlPath, expected)
+		}
+	}
+}
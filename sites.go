@@ -0,0 +1,336 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+)
+
+// SubdomainVar in the prefix of a wildcard site e.g. *.preview.example.com is
+// replaced by the subdomain each request was made to
+const SubdomainVar = "{subdomain}"
+
+type subdomainKey struct{}
+
+// Subdomain returns the subdomain matched by a wildcard site, if any
+func Subdomain(req *http.Request) (string, bool) {
+	label, ok := req.Context().Value(subdomainKey{}).(string)
+	return label, ok
+}
+
+// siteOptions are the options a site in the config file may set for itself;
+// the rest apply to the whole process
+var siteOptions = map[string]bool{
+	"bucket":                  true,
+	"prefix":                  true,
+	"blue-prefix":             true,
+	"green-prefix":            true,
+	"live-slot":               true,
+	"canary-prefix":           true,
+	"canary-percent":          true,
+	"canary-sticky":           true,
+	"variant":                 true,
+	"mime-types":              true,
+	"autoindex":               true,
+	"autoindex-template":      true,
+	"markdown":                true,
+	"markdown-layout":         true,
+	"template":                true,
+	"ssi":                     true,
+	"inject-head":             true,
+	"inject-body":             true,
+	"images":                  true,
+	"image-max-size":          true,
+	"image-quality":           true,
+	"image-cache-size":        true,
+	"image-sidecars":          true,
+	"minify":                  true,
+	"minify-cache-size":       true,
+	"sitemap":                 true,
+	"sitemap-url":             true,
+	"sitemap-include":         true,
+	"sitemap-exclude":         true,
+	"sitemap-refresh":         true,
+	"feed":                    true,
+	"feed-url":                true,
+	"feed-limit":              true,
+	"feed-refresh":            true,
+	"search":                  true,
+	"search-exclude":          true,
+	"search-index":            true,
+	"search-refresh":          true,
+	"media":                   true,
+	"media-path":              true,
+	"fallback-origin":         true,
+	"form":                    true,
+	"form-region":             true,
+	"form-secret":             true,
+	"function":                true,
+	"zip":                     true,
+	"zip-max-size":            true,
+	"function-region":         true,
+	"function-timeout":        true,
+	"upstream":                true,
+	"upstream-timeout":        true,
+	"alias":                   true,
+	"alias-object":            true,
+	"alias-refresh":           true,
+	"rewrite":                 true,
+	"locale":                  true,
+	"locale-cookie":           true,
+	"locale-mode":             true,
+	"template-var":            true,
+	"mime-type":               true,
+	"default-type":            true,
+	"sniff":                   true,
+	"charset":                 true,
+	"variant-cookie":          true,
+	"s3-region":               true,
+	"s3-access-key":           true,
+	"s3-secret-key":           true,
+	"s3-role-arn":             true,
+	"aws-profile":             true,
+	"releases":                true,
+	"release-poll":            true,
+	"index-file":              true,
+	"username":                true,
+	"password":                true,
+	"realm":                   true,
+	"max-age":                 true,
+	"s3-timeout":              true,
+	"cache-size":              true,
+	"cache-dir":               true,
+	"cache-dir-size":          true,
+	"cache-max-object":        true,
+	"cache-content-type":      true,
+	"cache-admission":         true,
+	"cache-fresh":             true,
+	"cache-ttl":               true,
+	"cache-max-stale":         true,
+	"cache-stale-if-error":    true,
+	"cache-key-lowercase":     true,
+	"cache-key-query":         true,
+	"invalidation-queue":      true,
+	"warm":                    true,
+	"warm-manifest":           true,
+	"surrogate-max-age":       true,
+	"surrogate-key-header":    true,
+	"deploy-id":               true,
+	"diagnostics":             true,
+	"cloudfront-distribution": true,
+}
+
+// LoadSites returns the options of each site in the config file named by
+// args, keyed by host name.  A site starts from the top level options and
+// replaces those it sets; setting any of username, password, or users
+// replaces all three, so a site never accepts the top level credentials
+// alongside its own.
+func LoadSites(args []string) (map[string]*Options, error) {
+	top, config, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+
+	sites := map[string]*Options{}
+	problems := ConfigError{}
+	for host, values := range config.Sites {
+		set, _, err := parseFlags(args)
+		if err != nil {
+			return nil, err
+		}
+
+		users := config.Users
+		_, hasUsername := values["username"]
+		_, hasPassword := values["password"]
+		_, hasUsers := values["users"]
+		if hasUsername || hasPassword || hasUsers {
+			set.Set("username", "")
+			set.Set("password", "")
+			users = map[string]string{}
+		}
+
+		for name, value := range values {
+			fix := "see s3site --help for its format"
+			switch {
+			case name == "users":
+				fix = "list users as name=password"
+				users, err = parseSiteUsers(value)
+			case !siteOptions[name]:
+				fix = "set it at the top level"
+				err = fmt.Errorf("%s applies to every site", name)
+			default:
+				err = setFlag(set, name, value)
+			}
+			if err != nil {
+				problems = append(problems, ConfigProblem{Field: "sites." + host + "." + name, Problem: err.Error(), Fix: fix})
+			}
+		}
+
+		opts := Opts(cli.NewContext(nil, set, set))
+		opts.Users = users
+		if strings.Contains(opts.Prefix+opts.BluePrefix+opts.GreenPrefix, SubdomainVar) && !strings.HasPrefix(host, "*.") {
+			problems = append(problems, ConfigProblem{
+				Field:   "sites." + host + ".prefix",
+				Problem: SubdomainVar + " requires a wildcard site",
+				Fix:     "name the site *.domain e.g. *.preview.example.com",
+			})
+		}
+		if err := opts.Validate(); err != nil {
+			for _, problem := range err.(ConfigError) {
+				problem.Field = "sites." + host + "." + problem.Field
+				problems = append(problems, problem)
+			}
+		}
+		sites[host] = opts
+	}
+
+	dirs := map[string]string{}
+	if dir := top.Lookup("cache-dir").Value.String(); dir != "" && top.Lookup("bucket").Value.String() != "" {
+		dirs[dir] = "the top level site"
+	}
+	for _, host := range sortedHosts(sites) {
+		dir := sites[host].CacheDir
+		if other, ok := dirs[dir]; ok && dir != "" {
+			problems = append(problems, ConfigProblem{
+				Field:   "sites." + host + ".cache-dir",
+				Problem: fmt.Sprintf("cache-dir %s is also used by %s", dir, other),
+				Fix:     "give each site its own cache-dir",
+			})
+		}
+		dirs[dir] = host
+	}
+
+	if len(problems) > 0 {
+		sort.SliceStable(problems, func(i, j int) bool { return problems[i].Field < problems[j].Field })
+		return nil, problems
+	}
+	return sites, nil
+}
+
+// setFlag replaces the value of the named flag in set; list values replace
+// the whole list, and a single value may hold a comma separated list
+func setFlag(set *flag.FlagSet, name string, value interface{}) error {
+	f := set.Lookup(name)
+	if slice, ok := f.Value.(*cli.StringSlice); ok {
+		*slice = cli.StringSlice{}
+		items, ok := value.([]string)
+		if !ok {
+			items = strings.Split(value.(string), ",")
+		}
+		for _, item := range items {
+			slice.Set(strings.TrimSpace(item))
+		}
+		return nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("expected a single value")
+	}
+	if err := set.Set(name, s); err != nil {
+		return fmt.Errorf("invalid value, %s", s)
+	}
+	return nil
+}
+
+// parseSiteUsers parses a site's users, a list of name=password pairs
+func parseSiteUsers(value interface{}) (map[string]string, error) {
+	items, ok := value.([]string)
+	if !ok {
+		items = strings.Split(value.(string), ",")
+	}
+
+	users := map[string]string{}
+	for _, item := range items {
+		index := strings.Index(item, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid user, %s; expected name=password", strings.TrimSpace(item))
+		}
+		users[strings.TrimSpace(item[:index])] = item[index+1:]
+	}
+	return users, nil
+}
+
+func sortedHosts(sites map[string]*Options) []string {
+	hosts := make([]string, 0, len(sites))
+	for host := range sites {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// SiteRouter serves each request with the handler of the site named by its
+// Host header, or with Default when no site matches.  A wildcard site
+// e.g. *.preview.example.com matches one more label, which Subdomain returns.
+type SiteRouter struct {
+	Sites   map[string]http.Handler
+	Default http.Handler
+}
+
+func (s *SiteRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	host := siteHost(req.Host)
+	if h, ok := s.Sites[host]; ok {
+		h.ServeHTTP(w, req)
+		return
+	}
+	if i := strings.Index(host, "."); i > 0 && validLabel(host[:i]) {
+		if h, ok := s.Sites["*"+host[i:]]; ok {
+			h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), subdomainKey{}, host[:i])))
+			return
+		}
+	}
+	if s.Default == nil {
+		logger.Debug("unknown site", Fields{"request_id": requestID(req), "host": req.Host})
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.Default.ServeHTTP(w, req)
+}
+
+// validLabel reports whether label is a dns label, so it is safe to use in
+// an s3 key
+func validLabel(label string) bool {
+	for _, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return len(label) <= 63
+}
+
+// siteHost returns the host name of a Host header, without its port
+func siteHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
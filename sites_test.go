@@ -0,0 +1,217 @@
+package s3site
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "sites")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadSites(t *testing.T) {
+	configs := map[string]string{
+		"sites.yaml": `
+bucket: main
+max-age: 300
+users:
+  alice: a
+sites:
+  docs.example.com:
+    bucket: docs
+    cache-ttl: [text/html=30s, /assets/=forever]
+  Blog.Example.com:
+    prefix: blog
+    username: bob
+    password: b
+`,
+		"sites.toml": `
+bucket = "main"
+max_age = 300
+
+[users]
+alice = "a"
+
+[sites."docs.example.com"]
+bucket = "docs"
+cache_ttl = ["text/html=30s", "/assets/=forever"]
+
+[sites."Blog.Example.com"]
+prefix = "blog"
+username = "bob"
+password = "b"
+`,
+	}
+
+	for name, content := range configs {
+		path, cleanup := writeConfig(t, name, content)
+		defer cleanup()
+
+		sites, err := LoadSites([]string{"--config", path, "--cache-size", "8"})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if hosts := sortedHosts(sites); !reflect.DeepEqual(hosts, []string{"blog.example.com", "docs.example.com"}) {
+			t.Fatalf("%s: unexpected sites, %v", name, hosts)
+		}
+
+		docs := sites["docs.example.com"]
+		if docs.Bucket != "docs" || docs.MaxAge != 300 || docs.CacheSize != 8 {
+			t.Errorf("%s: expected docs to inherit the top level options, got %+v", name, docs)
+		}
+		if !reflect.DeepEqual(docs.CacheTTL, []string{"text/html=30s", "/assets/=forever"}) {
+			t.Errorf("%s: unexpected cache-ttl, %v", name, docs.CacheTTL)
+		}
+		if !docs.Authorized("alice", "a") {
+			t.Errorf("%s: expected docs to inherit the top level users", name)
+		}
+
+		blog := sites["blog.example.com"]
+		if blog.Bucket != "main" || blog.Prefix != "blog" {
+			t.Errorf("%s: unexpected blog origin, %s/%s", name, blog.Bucket, blog.Prefix)
+		}
+		if !blog.Authorized("bob", "b") || blog.Authorized("alice", "a") {
+			t.Errorf("%s: expected blog's credentials to replace the top level users", name)
+		}
+	}
+}
+
+func TestLoadSitesProblems(t *testing.T) {
+	path, cleanup := writeConfig(t, "sites.yaml", `
+bucket: main
+cache-size: 8
+cache-dir: /var/cache/s3site
+sites:
+  a.example.com:
+    port: 9000
+    max-age: soon
+  b.example.com:
+    users: [carol]
+`)
+	defer cleanup()
+
+	_, err := LoadSites([]string{"--config", path})
+	problems, ok := err.(ConfigError)
+	if !ok {
+		t.Fatalf("expected a ConfigError, got %v", err)
+	}
+	fields := []string{}
+	for _, problem := range problems {
+		fields = append(fields, problem.Field)
+	}
+	expected := "sites.a.example.com.cache-dir,sites.a.example.com.max-age,sites.a.example.com.port,sites.b.example.com.cache-dir,sites.b.example.com.users"
+	if got := strings.Join(fields, ","); got != expected {
+		t.Errorf("unexpected problems, %s\n%v", got, err)
+	}
+}
+
+func TestWildcardSite(t *testing.T) {
+	path, cleanup := writeConfig(t, "sites.yaml", `
+bucket: main
+sites:
+  "*.preview.example.com":
+    prefix: previews/{subdomain}
+  docs.example.com:
+    prefix: docs/{subdomain}
+`)
+	defer cleanup()
+
+	_, err := LoadSites([]string{"--config", path})
+	problems, ok := err.(ConfigError)
+	if !ok || len(problems) != 1 || problems[0].Field != "sites.docs.example.com.prefix" {
+		t.Fatalf("expected {subdomain} to be rejected outside a wildcard site, got %v", err)
+	}
+
+	opts := &Options{Prefix: "previews/{subdomain}", IndexFile: "index.html"}
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), subdomainKey{}, "feature-x"))
+	site, err := opts.forRequest(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := site.Key("/"); key != "previews/feature-x/index.html" {
+		t.Errorf("unexpected key, %s", key)
+	}
+	if opts.Prefix != "previews/{subdomain}" {
+		t.Error("expected forRequest to leave the site's options unchanged")
+	}
+}
+
+func TestSiteRouter(t *testing.T) {
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(name))
+		})
+	}
+	router := &SiteRouter{Sites: map[string]http.Handler{
+		"docs.example.com": named("docs"),
+		"::1":              named("ipv6"),
+		"*.preview.example.com": http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			label, _ := Subdomain(req)
+			w.Write([]byte("preview " + label))
+		}),
+	}}
+
+	testCases := []struct {
+		Host     string
+		Expected string
+		Status   int
+	}{
+		{"docs.example.com", "docs", http.StatusOK},
+		{"DOCS.example.com:8080", "docs", http.StatusOK},
+		{"docs.example.com.", "docs", http.StatusOK},
+		{"[::1]:8080", "ipv6", http.StatusOK},
+		{"other.example.com", "", http.StatusNotFound},
+		{"Feature-X.preview.example.com", "preview feature-x", http.StatusOK},
+		{"preview.example.com", "", http.StatusNotFound},
+		{"a.b.preview.example.com", "", http.StatusNotFound},
+		{"a_b.preview.example.com", "", http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = tc.Host
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tc.Status || w.Body.String() != tc.Expected {
+			t.Errorf("%s: expected %d %q, got %d %q", tc.Host, tc.Status, tc.Expected, w.Code, w.Body.String())
+		}
+	}
+
+	router.Default = named("default")
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "other.example.com"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Body.String() != "default" {
+		t.Errorf("expected unknown hosts to be served by the default site, got %q", w.Body.String())
+	}
+}
+
+func TestSiteOptionsAreFlags(t *testing.T) {
+	names := map[string]bool{}
+	for _, flag := range Flags() {
+		for _, name := range flagNames(flag) {
+			names[name] = true
+		}
+	}
+	for name := range siteOptions {
+		if !names[name] {
+			t.Errorf("site option %s is not a flag", name)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Timings accumulates how long a request spent in each phase e.g. s3_get
+// so slow requests can be broken down
+type Timings struct {
+	mu     sync.Mutex
+	phases map[string]time.Duration
+	calls  map[string]int
+}
+
+type timingsKey struct{}
+
+// timingsFromContext returns the timings attached by SlowLog, if any
+func timingsFromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
+
+// track records the time since started against phase on the request's
+// timings, if any
+func track(ctx context.Context, phase string, started time.Time) {
+	timingsFromContext(ctx).Add(phase, time.Since(started))
+}
+
+// Add records elapsed against phase; safe to call on nil Timings
+func (t *Timings) Add(phase string, elapsed time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.phases[phase] += elapsed
+	t.calls[phase]++
+}
+
+// SlowLog wraps h to log a warning with a breakdown of where the time went
+// for any request taking longer than threshold
+func SlowLog(h http.Handler, threshold time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started := time.Now()
+		timings := &Timings{phases: map[string]time.Duration{}, calls: map[string]int{}}
+		req = req.WithContext(context.WithValue(req.Context(), timingsKey{}, timings))
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, req)
+
+		elapsed := time.Since(started)
+		if elapsed < threshold {
+			return
+		}
+
+		fields := Fields{
+			"request_id": requestID(req),
+			"method":     req.Method,
+			"path":       req.URL.Path,
+			"status":     recorder.Status(),
+			"duration":   elapsed.Seconds(),
+			"bytes":      recorder.bytes,
+			"cache":      w.Header().Get("X-Cache"),
+		}
+		timings.mu.Lock()
+		for phase, d := range timings.phases {
+			fields[phase] = d.Seconds()
+			fields[phase+"_calls"] = timings.calls[phase]
+		}
+		timings.mu.Unlock()
+
+		logger.Warn("slow request", fields)
+	})
+}
@@ -0,0 +1,44 @@
+package s3site
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowLog(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger.Out = out
+	defer func() { logger.Out = os.Stderr }()
+
+	h := SlowLog(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		timingsFromContext(req.Context()).Add("s3_get", 40*time.Millisecond)
+		if req.URL.Path == "/slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		w.Header().Set("X-Cache", CacheMiss)
+		w.Write([]byte("hello"))
+	}), 10*time.Millisecond)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast", nil))
+	if out.Len() != 0 {
+		t.Errorf("expected fast requests not to be logged, got %s", out.String())
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	line := out.String()
+	for _, expected := range []string{"slow request", "path=/slow", "cache=MISS", "bytes=5", "s3_get=0.04", "s3_get_calls=1"} {
+		if !strings.Contains(line, expected) {
+			t.Errorf("expected %q in %s", expected, line)
+		}
+	}
+}
+
+func TestTrackWithoutTimings(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	track(req.Context(), "s3_get", time.Now())
+}
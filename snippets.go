@@ -0,0 +1,161 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Snippets are injected into html pages, before </head> or </body>, e.g. an
+// analytics tag or an environment banner
+type Snippets struct {
+	Head string
+	Body string
+}
+
+// LoadSnippets joins the head and body snippets; a snippet starting with @
+// is read from the named file, and {env:NAME} is expanded.  It returns nil
+// when there are none.
+func LoadSnippets(head, body []string) (*Snippets, error) {
+	if len(head) == 0 && len(body) == 0 {
+		return nil, nil
+	}
+
+	join := func(values []string) (string, error) {
+		parts := []string{}
+		for _, value := range values {
+			if strings.HasPrefix(value, "@") {
+				data, err := ioutil.ReadFile(value[1:])
+				if err != nil {
+					return "", err
+				}
+				value = string(data)
+			}
+			parts = append(parts, expandEnv(value))
+		}
+		return strings.Join(parts, "\n"), nil
+	}
+
+	s := &Snippets{}
+	var err error
+	if s.Head, err = join(head); err != nil {
+		return nil, err
+	}
+	if s.Body, err = join(body); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Variant distinguishes the etags of pages injected with the snippets from
+// those of the objects, and of pages injected with other snippets
+func (s *Snippets) Variant() string {
+	if s == nil {
+		return ""
+	}
+	sum := sha1.Sum([]byte(s.Head + "\x00" + s.Body))
+	return "inject" + hex.EncodeToString(sum[:4])
+}
+
+// Inject returns page with the snippets inserted before its closing head and
+// body tags; the body snippet is appended to pages without a closing body
+// tag
+func (s *Snippets) Inject(page []byte) []byte {
+	if s.Head != "" {
+		if i := lastIndexFold(page, "</head>"); i >= 0 {
+			page = insertAt(page, i, s.Head)
+		}
+	}
+	if s.Body != "" {
+		if i := lastIndexFold(page, "</body>"); i >= 0 {
+			page = insertAt(page, i, s.Body)
+		} else {
+			page = append(page, s.Body...)
+		}
+	}
+	return page
+}
+
+func lastIndexFold(page []byte, tag string) int {
+	return bytes.LastIndex(bytes.ToLower(page), []byte(tag))
+}
+
+func insertAt(page []byte, i int, snippet string) []byte {
+	out := make([]byte, 0, len(page)+len(snippet))
+	out = append(out, page[:i]...)
+	out = append(out, snippet...)
+	return append(out, page[i:]...)
+}
+
+// Wrap returns a writer injecting the snippets into html responses written
+// to w; Close must be called once the response is written
+func (s *Snippets) Wrap(w http.ResponseWriter) *injectWriter {
+	return &injectWriter{ResponseWriter: w, snippets: s}
+}
+
+// injectWriter holds back successful html responses so the snippets can be
+// injected, passing anything else straight through
+type injectWriter struct {
+	http.ResponseWriter
+	snippets *Snippets
+	status   int
+	buf      *bytes.Buffer
+}
+
+func (w *injectWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if w.snippets != nil && status == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		w.buf = &bytes.Buffer{}
+		w.Header().Del("Content-Length")
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *injectWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buf != nil {
+		return w.buf.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Close writes the held back response, with the snippets injected
+func (w *injectWriter) Close() error {
+	if w.buf == nil {
+		return nil
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.snippets.Inject(w.buf.Bytes()))
+	return err
+}
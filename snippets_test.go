@@ -0,0 +1,87 @@
+package s3site
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnippetsInject(t *testing.T) {
+	s := &Snippets{Head: `<script src="/a.js"></script>`, Body: `<div>staging</div>`}
+
+	testCases := map[string]string{
+		"<html><head></head><body><p>hi</p></body></html>": `<html><head><script src="/a.js"></script></head><body><p>hi</p><div>staging</div></body></html>`,
+		"<HTML><HEAD></HEAD><BODY></BODY></HTML>":          `<HTML><HEAD><script src="/a.js"></script></HEAD><BODY><div>staging</div></BODY></HTML>`,
+		"<p>fragment</p>": `<p>fragment</p><div>staging</div>`,
+	}
+	for page, expected := range testCases {
+		if actual := string(s.Inject([]byte(page))); actual != expected {
+			t.Errorf("expected %s, got %s", expected, actual)
+		}
+	}
+}
+
+func TestSnippetsVariant(t *testing.T) {
+	a := &Snippets{Head: "<script>a</script>"}
+	b := &Snippets{Head: "<script>b</script>"}
+	if a.Variant() == "" || a.Variant() == b.Variant() {
+		t.Errorf("expected variants to identify the snippets, got %s and %s", a.Variant(), b.Variant())
+	}
+	var none *Snippets
+	if none.Variant() != "" {
+		t.Error("expected no variant without snippets")
+	}
+}
+
+func TestLoadSnippets(t *testing.T) {
+	if s, err := LoadSnippets(nil, nil); s != nil || err != nil {
+		t.Errorf("expected no snippets, got %v %v", s, err)
+	}
+
+	dir, _ := ioutil.TempDir("", "snippets")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "analytics.html")
+	ioutil.WriteFile(file, []byte("<script>track()</script>"), 0644)
+	os.Setenv("S3SITE_TEST_STAGE", "staging")
+	defer os.Unsetenv("S3SITE_TEST_STAGE")
+
+	s, err := LoadSnippets([]string{"@" + file}, []string{"<div>{env:S3SITE_TEST_STAGE}</div>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Head != "<script>track()</script>" || s.Body != "<div>staging</div>" {
+		t.Errorf("unexpected snippets, %+v", s)
+	}
+	if _, err := LoadSnippets([]string{"@/does/not/exist"}, nil); err == nil {
+		t.Error("expected a missing file to be an error")
+	}
+}
+
+func TestSnippetsWrap(t *testing.T) {
+	s := &Snippets{Body: "<b>!</b>"}
+
+	serve := func(contentType string, status int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		w := s.Wrap(rec)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", "25")
+		w.WriteHeader(status)
+		w.Write([]byte("<body>hello</body>"))
+		w.Close()
+		return rec
+	}
+
+	rec := serve("text/html; charset=utf-8", http.StatusOK)
+	if body := rec.Body.String(); body != "<body>hello<b>!</b></body>" || rec.Header().Get("Content-Length") != "" {
+		t.Errorf("expected the snippet in html, got %s %v", body, rec.Header())
+	}
+	if body := serve("text/css", http.StatusOK).Body.String(); body != "<body>hello</body>" {
+		t.Errorf("expected other types to pass through, got %s", body)
+	}
+	if rec := serve("text/html", http.StatusNotFound); rec.Code != http.StatusNotFound || rec.Body.String() != "<body>hello</body>" {
+		t.Errorf("expected errors to pass through, got %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,229 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FormCookie records when the visitor first loaded a page, so forms with
+	// a minimum time can tell how long they took to submit
+	FormCookie = "s3site_form"
+
+	formCookieAge = 24 * 60 * 60
+
+	// maxFormWindows bounds the submission counts kept for rate limits
+	maxFormWindows = 10000
+)
+
+// captchaVerifiers holds the verification url of each captcha service
+var captchaVerifiers = map[string]string{
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+}
+
+// captchaFields holds the field each captcha service's widget submits its
+// token in
+var captchaFields = map[string]string{
+	"recaptcha": "g-recaptcha-response",
+	"hcaptcha":  "h-captcha-response",
+}
+
+// errSpam rejects submissions quietly; the sender is told they succeeded
+var errSpam = fmt.Errorf("spam")
+
+// parseRate parses rates of the form count/duration e.g. 5/1h
+func parseRate(value string) (int, time.Duration, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid form rate, %s; use count/duration e.g. 5/1h", value)
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("invalid form rate, %s; use count/duration e.g. 5/1h", value)
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return 0, 0, fmt.Errorf("invalid form rate, %s; use count/duration e.g. 5/1h", value)
+	}
+	return count, period, nil
+}
+
+// formWindow counts the submissions made since start
+type formWindow struct {
+	start time.Time
+	count int
+}
+
+// stamp returns the signed value of the form cookie for t
+func (f *Forms) stamp(t time.Time) string {
+	value := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, f.Secret)
+	mac.Write([]byte(value))
+	return value + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// stamped returns when the visitor was stamped, according to req's form
+// cookie, and false when the cookie is missing or its signature invalid
+func (f *Forms) stamped(req *http.Request) (time.Time, bool) {
+	c, err := req.Cookie(FormCookie)
+	if err != nil {
+		return time.Time{}, false
+	}
+	parts := strings.SplitN(c.Value, ".", 2)
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t := time.Unix(seconds, 0)
+	if !hmac.Equal([]byte(c.Value), []byte(f.stamp(t))) {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Stamp sets the form cookie on pages fetched by visitors without one, when
+// any form has a minimum time
+func (f *Forms) Stamp(w http.ResponseWriter, req *http.Request) {
+	if f == nil || (req.Method != "GET" && req.Method != "HEAD") {
+		return
+	}
+	timed := false
+	for _, form := range f.List {
+		timed = timed || form.MinTime > 0
+	}
+	if !timed {
+		return
+	}
+	if _, ok := f.stamped(req); !ok {
+		f.setStamp(w)
+	}
+}
+
+// setStamp sets the form cookie to now
+func (f *Forms) setStamp(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     FormCookie,
+		Value:    f.stamp(time.Now()),
+		Path:     "/",
+		MaxAge:   formCookieAge,
+		HttpOnly: true,
+	})
+}
+
+// allow counts a submission to form from ip, returning false once the form's
+// rate is exceeded
+func (f *Forms) allow(form *Form, ip string, now time.Time) bool {
+	if form.Rate <= 0 {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.windows == nil {
+		f.windows = map[string]*formWindow{}
+	}
+	key := form.Path + " " + ip
+	window, ok := f.windows[key]
+	if !ok || now.Sub(window.start) >= form.Period {
+		if len(f.windows) >= maxFormWindows {
+			for k, w := range f.windows {
+				if now.Sub(w.start) >= form.Period {
+					delete(f.windows, k)
+				}
+			}
+			if len(f.windows) >= maxFormWindows {
+				f.windows = map[string]*formWindow{}
+			}
+		}
+		window = &formWindow{start: now}
+		f.windows[key] = window
+	}
+	window.count++
+	return window.count <= form.Rate
+}
+
+// verifyCaptcha checks the captcha token submitted in fields with the
+// form's captcha service
+func (f *Forms) verifyCaptcha(form *Form, fields url.Values, ip string) error {
+	token := fields.Get(captchaFields[form.Captcha])
+	if token == "" {
+		return fmt.Errorf("please complete the captcha")
+	}
+	verifier := captchaVerifiers[form.Captcha]
+	if v, ok := f.Verifiers[form.Captcha]; ok {
+		verifier = v
+	}
+
+	resp, err := f.Client.PostForm(verifier, url.Values{"secret": {form.CaptchaSecret}, "response": {token}, "remoteip": {ip}})
+	if err != nil {
+		logger.Warn("unable to verify captcha", Fields{"form": form.Path, "captcha": form.Captcha, "error": err})
+		return fmt.Errorf("unable to verify the captcha")
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool     `json:"success"`
+		Errors  []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		logger.Warn("unable to verify captcha", Fields{"form": form.Path, "captcha": form.Captcha, "error": err})
+		return fmt.Errorf("unable to verify the captcha")
+	}
+	if !result.Success {
+		logger.Debug("captcha failed", Fields{"form": form.Path, "captcha": form.Captcha, "errors": strings.Join(result.Errors, ",")})
+		return fmt.Errorf("captcha failed")
+	}
+	return nil
+}
+
+// Screen returns errSpam for submissions a bot filled in, or an error the
+// sender may correct e.g. a missing captcha.  Senders that submit sooner
+// than the form's minimum time after loading a page, or without having
+// loaded one, are stamped afresh so they may submit again shortly.
+func (f *Forms) Screen(w http.ResponseWriter, req *http.Request, form *Form, fields url.Values) error {
+	if form.Honeypot != "" && fields.Get(form.Honeypot) != "" {
+		return errSpam
+	}
+	if form.MinTime > 0 {
+		if t, ok := f.stamped(req); !ok || time.Since(t) < form.MinTime {
+			f.setStamp(w)
+			return fmt.Errorf("submitted too quickly; please wait a moment and submit again")
+		}
+	}
+	if form.Captcha != "" {
+		if err := f.verifyCaptcha(form, fields, clientIP(req)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+func TestParseFormSpamOptions(t *testing.T) {
+	form, err := ParseForm("/contact=ses:team@example.com;honeypot=website;min-time=3s;rate=5/1h;captcha=hcaptcha:key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Honeypot != "website" || form.MinTime != 3*time.Second || form.Rate != 5 || form.Period != time.Hour ||
+		form.Captcha != "hcaptcha" || form.CaptchaSecret != "key" {
+		t.Errorf("unexpected form, %+v", form)
+	}
+
+	for _, value := range []string{
+		"/contact=ses:team@example.com;min-time=soon",
+		"/contact=ses:team@example.com;rate=5",
+		"/contact=ses:team@example.com;rate=0/1h",
+		"/contact=ses:team@example.com;rate=5/hour",
+		"/contact=ses:team@example.com;captcha=turnstile:key",
+		"/contact=ses:team@example.com;captcha=recaptcha",
+	} {
+		if _, err := ParseForm(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestFormsAllow(t *testing.T) {
+	forms := &Forms{}
+	form := &Form{Path: "/contact", Rate: 2, Period: time.Minute}
+	now := time.Now()
+	for i, expected := range []bool{true, true, false} {
+		if allowed := forms.allow(form, "10.0.0.1", now); allowed != expected {
+			t.Errorf("submission %d: expected %v; got %v", i, expected, allowed)
+		}
+	}
+	if !forms.allow(form, "10.0.0.2", now) {
+		t.Error("expected other addresses to be counted apart")
+	}
+	if !forms.allow(form, "10.0.0.1", now.Add(time.Minute)) {
+		t.Error("expected the limit to reset after the period")
+	}
+}
+
+func TestFormsStamp(t *testing.T) {
+	forms := &Forms{List: []*Form{{Path: "/contact", MinTime: time.Second}}, Secret: []byte("key")}
+
+	w := httptest.NewRecorder()
+	forms.Stamp(w, httptest.NewRequest("GET", "/", nil))
+	cookie := w.Result().Cookies()
+	if len(cookie) != 1 || cookie[0].Name != FormCookie {
+		t.Fatalf("expected the form cookie; got %v", cookie)
+	}
+
+	req := httptest.NewRequest("POST", "/contact", nil)
+	req.AddCookie(cookie[0])
+	if _, ok := forms.stamped(req); !ok {
+		t.Error("expected the cookie to be accepted")
+	}
+
+	w = httptest.NewRecorder()
+	forms.Stamp(w, req)
+	if w.Header().Get("Set-Cookie") != "" {
+		t.Error("expected no cookie for POSTs")
+	}
+
+	forged := httptest.NewRequest("POST", "/contact", nil)
+	forged.AddCookie(&http.Cookie{Name: FormCookie, Value: "1.abc"})
+	if _, ok := forms.stamped(forged); ok {
+		t.Error("expected a forged cookie to be rejected")
+	}
+}
+
+func TestFormsScreen(t *testing.T) {
+	delivered := 0
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		if req.URL.Path == "/siteverify" {
+			if req.PostForm.Get("secret") != "key" || req.PostForm.Get("response") != "human" {
+				w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+				return
+			}
+			w.Write([]byte(`{"success":true}`))
+			return
+		}
+		if strings.Contains(req.PostForm.Get("Message"), "website") || strings.Contains(req.PostForm.Get("Message"), "h-captcha-response") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delivered++
+	}))
+	defer endpoint.Close()
+
+	forms, err := NewForms([]string{
+		"/contact=sns:arn:aws:sns:us-west-2:123456789012:contact;honeypot=website;min-time=1h;rate=3/1h",
+		"/signup=sns:arn:aws:sns:us-west-2:123456789012:signups;captcha=hcaptcha:key",
+	}, "us-east-1", func() aws.Auth { return aws.Auth{AccessKey: "key", SecretKey: "secret"} }, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forms.Endpoint = endpoint.URL
+	forms.Verifiers = map[string]string{"hcaptcha": endpoint.URL + "/siteverify"}
+
+	submit := func(target string, fields url.Values, stamped time.Time) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(fields.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if !stamped.IsZero() {
+			req.AddCookie(&http.Cookie{Name: FormCookie, Value: forms.stamp(stamped)})
+		}
+		w := httptest.NewRecorder()
+		forms.Serve(w, req, forms.Match(req))
+		return w
+	}
+
+	earlier := time.Now().Add(-2 * time.Hour)
+	if w := submit("/contact", url.Values{"name": {"Matt"}}, earlier); w.Code != http.StatusSeeOther || delivered != 1 {
+		t.Errorf("expected delivery; got %v, %d delivered", w.Code, delivered)
+	}
+	if w := submit("/contact", url.Values{"name": {"Bot"}, "website": {"http://spam.example.com"}}, earlier); w.Code != http.StatusSeeOther || delivered != 1 {
+		t.Errorf("expected the honeypot to be discarded quietly; got %v, %d delivered", w.Code, delivered)
+	}
+	w := submit("/contact", url.Values{"name": {"Bot"}}, time.Now())
+	if w.Code != http.StatusBadRequest || delivered != 1 || w.Header().Get("Set-Cookie") == "" {
+		t.Errorf("expected hasty submissions to be rejected and stamped; got %v, %d delivered", w.Code, delivered)
+	}
+	if w := submit("/contact", url.Values{"name": {"Matt"}}, earlier); w.Code != http.StatusTooManyRequests || delivered != 1 {
+		t.Errorf("expected the rate limit; got %v, %d delivered", w.Code, delivered)
+	}
+
+	if w := submit("/signup", url.Values{"email": {"matt@example.com"}}, time.Time{}); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "captcha") {
+		t.Errorf("expected a missing captcha to be rejected; got %v %q", w.Code, w.Body.String())
+	}
+	if w := submit("/signup", url.Values{"email": {"matt@example.com"}, "h-captcha-response": {"bot"}}, time.Time{}); w.Code != http.StatusBadRequest || delivered != 1 {
+		t.Errorf("expected a failed captcha to be rejected; got %v, %d delivered", w.Code, delivered)
+	}
+	if w := submit("/signup", url.Values{"email": {"matt@example.com"}, "h-captcha-response": {"human"}}, time.Time{}); w.Code != http.StatusSeeOther || delivered != 2 {
+		t.Errorf("expected delivery; got %v, %d delivered", w.Code, delivered)
+	}
+}
@@ -0,0 +1,194 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+// Invalidator consumes s3 event notifications from an sqs queue, either
+// delivered directly or via sns, and evicts the affected keys from the cache
+type Invalidator struct {
+	QueueURL string
+	Region   string
+	Auth     aws.Auth
+	Bucket   string
+	Cache    *Cache
+	Client   *http.Client
+}
+
+type sqsMessage struct {
+	ReceiptHandle string
+	Body          string
+}
+
+type receiveMessageResponse struct {
+	Messages []sqsMessage `xml:"ReceiveMessageResult>Message"`
+}
+
+type snsEnvelope struct {
+	Type    string
+	Message string
+}
+
+type s3Event struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	}
+}
+
+// sqsWait is how long each receive waits for messages to arrive; requests
+// time out a little after, so a lost connection doesn't hang the poller
+const sqsWait = 20 * time.Second
+
+func NewInvalidator(queueURL string, auth aws.Auth, bucket string, cache *Cache) (*Invalidator, error) {
+	region, err := sqsRegion(queueURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Invalidator{
+		QueueURL: queueURL,
+		Region:   region,
+		Auth:     auth,
+		Bucket:   bucket,
+		Cache:    cache,
+		Client:   &http.Client{Timeout: sqsWait + 10*time.Second},
+	}, nil
+}
+
+// Run polls the queue forever
+func (i *Invalidator) Run() {
+	delay := time.Second
+	for {
+		if err := i.poll(); err != nil {
+			logger.Error("unable to receive invalidations", Fields{"queue": i.QueueURL, "error": err})
+			time.Sleep(delay)
+			if delay < time.Minute {
+				delay = delay * 2
+			}
+			continue
+		}
+		delay = time.Second
+	}
+}
+
+func (i *Invalidator) poll() error {
+	resp := receiveMessageResponse{}
+	err := i.call(url.Values{
+		"Action":              {"ReceiveMessage"},
+		"MaxNumberOfMessages": {"10"},
+		"WaitTimeSeconds":     {strconv.Itoa(int(sqsWait / time.Second))},
+	}, &resp)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range resp.Messages {
+		i.invalidate(message.Body)
+
+		err := i.call(url.Values{
+			"Action":        {"DeleteMessage"},
+			"ReceiptHandle": {message.ReceiptHandle},
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Invalidator) invalidate(body string) {
+	envelope := snsEnvelope{}
+	if err := json.Unmarshal([]byte(body), &envelope); err == nil && envelope.Type == "Notification" {
+		body = envelope.Message
+	}
+
+	event := s3Event{}
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		logger.Warn("ignoring unrecognized invalidation message", Fields{"error": err})
+		return
+	}
+
+	for _, record := range event.Records {
+		if record.S3.Bucket.Name != i.Bucket {
+			continue
+		}
+
+		// object keys in s3 event notifications are url encoded
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			key = record.S3.Object.Key
+		}
+
+		if i.Cache.Delete(key) > 0 {
+			logger.Debug("invalidated", Fields{"bucket": i.Bucket, "key": key, "event": record.EventName})
+		}
+	}
+}
+
+func (i *Invalidator) call(params url.Values, v interface{}) error {
+	params.Set("Version", "2012-11-05")
+	payload := []byte(params.Encode())
+
+	req, err := http.NewRequest("POST", i.QueueURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	SignV4(req, i.Auth, i.Region, "sqs", payload, time.Now())
+
+	resp, err := i.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,41 @@
+package s3site
+
+import (
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+func TestInvalidatorRegion(t *testing.T) {
+	invalidator, err := NewInvalidator("https://sqs.eu-west-1.amazonaws.com/123456789012/site", aws.Auth{}, "bucket", NewCache(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalidator.Region != "eu-west-1" {
+		t.Errorf("expected eu-west-1, got %s", invalidator.Region)
+	}
+	if timeout := invalidator.Client.Timeout; timeout <= sqsWait {
+		t.Errorf("expected requests to time out after the %s long poll, got %s", sqsWait, timeout)
+	}
+
+	if _, err := NewInvalidator("https://example.com/queue", aws.Auth{}, "bucket", NewCache(10)); err == nil {
+		t.Error("expected an error for a non-sqs url")
+	}
+}
+
+func TestInvalidatorInvalidate(t *testing.T) {
+	cache := NewCache(100)
+	cache.Set(&Entry{Key: "docs/a b.html", Body: []byte("1")})
+	cache.Set(&Entry{Key: "docs/c.html", Body: []byte("1")})
+
+	invalidator := &Invalidator{Bucket: "bucket", Cache: cache}
+	invalidator.invalidate(`{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"docs/a+b.html"}}}]}`)
+	if _, ok := cache.Get("docs/a b.html"); ok {
+		t.Error("expected direct s3 notification to invalidate key")
+	}
+
+	invalidator.invalidate(`{"Type":"Notification","Message":"{\"Records\":[{\"eventName\":\"ObjectRemoved:Delete\",\"s3\":{\"bucket\":{\"name\":\"bucket\"},\"object\":{\"key\":\"docs/c.html\"}}}]}"}`)
+	if _, ok := cache.Get("docs/c.html"); ok {
+		t.Error("expected sns wrapped notification to invalidate key")
+	}
+}
@@ -0,0 +1,85 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxIncludeDepth bounds how deeply included files may include others
+const maxIncludeDepth = 3
+
+var includeDirective = regexp.MustCompile(`<!--#include\s+(virtual|file)="([^"]+)"\s*-->`)
+
+// Includes expands server side include directives in html pages e.g.
+// <!--#include virtual="/partials/nav.html" -->.  Paths are relative to the
+// including page unless they start with a /.
+type Includes struct {
+	// Fetch returns the content of the object at key
+	Fetch func(ctx context.Context, key string) ([]byte, error)
+}
+
+// isHTMLPath returns true for paths served as html pages, whose includes are
+// expanded
+func isHTMLPath(urlPath string) bool {
+	switch strings.ToLower(path.Ext(urlPath)) {
+	case ".html", ".htm", ".shtml":
+		return true
+	}
+	return false
+}
+
+// Expand returns page, served at urlPath, with its includes replaced by the
+// objects they name.  Includes that can't be read are replaced by a comment.
+func (in *Includes) Expand(ctx context.Context, opts *Options, page []byte, urlPath string) []byte {
+	return in.expand(ctx, opts, page, urlPath, 0)
+}
+
+func (in *Includes) expand(ctx context.Context, opts *Options, page []byte, urlPath string, depth int) []byte {
+	return includeDirective.ReplaceAllFunc(page, func(directive []byte) []byte {
+		m := includeDirective.FindSubmatch(directive)
+		target := string(m[2])
+		if !strings.HasPrefix(target, "/") {
+			target = path.Join(path.Dir(urlPath), target)
+		}
+		target = path.Clean(target)
+
+		if depth >= maxIncludeDepth {
+			logger.Warn("includes nested too deeply", Fields{"path": urlPath, "include": target})
+			return []byte("<!-- include of " + target + " nested too deeply -->")
+		}
+		content, err := in.Fetch(ctx, opts.Key(target))
+		if err != nil {
+			logger.Warn("unable to include", Fields{"path": urlPath, "include": target, "error": err})
+			return []byte("<!-- unable to include " + target + " -->")
+		}
+		if bytes.Contains(content, []byte("<!--#include")) {
+			content = in.expand(ctx, opts, content, target, depth+1)
+		}
+		return content
+	})
+}
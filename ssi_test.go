@@ -0,0 +1,54 @@
+package s3site
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestIncludesExpand(t *testing.T) {
+	objects := map[string]string{
+		"site/partials/nav.html":   `<nav><!--#include file="links.html" --></nav>`,
+		"site/partials/links.html": `<a href="/">home</a>`,
+		"site/docs/footer.html":    `<footer>docs</footer>`,
+		"site/partials/loop.html":  `<!--#include virtual="/partials/loop.html" -->`,
+	}
+	includes := &Includes{
+		Fetch: func(ctx context.Context, key string) ([]byte, error) {
+			if content, ok := objects[key]; ok {
+				return []byte(content), nil
+			}
+			return nil, fmt.Errorf("no such key, %s", key)
+		},
+	}
+	opts := &Options{Prefix: "site", IndexFile: "index.html"}
+
+	testCases := map[string]string{
+		`<!--#include virtual="/partials/nav.html" --><main>`: `<nav><a href="/">home</a></nav><main>`,
+		`<!--#include file="footer.html"-->`:                  `<footer>docs</footer>`,
+		`<!--#include virtual="../docs/footer.html" -->`:      `<footer>docs</footer>`,
+		`<!--#include virtual="/missing.html" -->`:            `<!-- unable to include /missing.html -->`,
+		`<!--#include virtual="/partials/loop.html" -->`:      `<!-- include of /partials/loop.html nested too deeply -->`,
+		`<p>no includes</p>`:                                  `<p>no includes</p>`,
+	}
+	for page, expected := range testCases {
+		if actual := string(includes.Expand(context.Background(), opts, []byte(page), "/docs/")); actual != expected {
+			t.Errorf("%s: expected %s, got %s", page, expected, actual)
+		}
+	}
+}
+
+func TestIsHTMLPath(t *testing.T) {
+	testCases := map[string]bool{
+		"site/index.html": true,
+		"site/page.HTM":   true,
+		"site/page.shtml": true,
+		"site/app.js":     false,
+		"site/about":      false,
+	}
+	for key, expected := range testCases {
+		if actual := isHTMLPath(key); actual != expected {
+			t.Errorf("%s: expected %v, got %v", key, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// StatsD periodically emits the request and cache metrics over udp in
+// the statsd line format.  With DogStatsD set, Tags e.g. env:prod are
+// appended to each metric; plain statsd has no notion of tags.
+type StatsD struct {
+	Addr      string
+	Prefix    string
+	Tags      []string
+	DogStatsD bool
+	Metrics   *Metrics
+
+	conn      net.Conn
+	last      RequestTotals
+	lastCache CacheSnapshot
+}
+
+// maxStatsDPacket keeps datagrams under a typical ethernet mtu
+const maxStatsDPacket = 1432
+
+// Run emits every interval
+func (s *StatsD) Run(interval time.Duration) {
+	s.last = s.Metrics.Totals()
+	for range time.Tick(interval) {
+		if err := s.Publish(); err != nil {
+			logger.Warn("unable to emit statsd metrics", Fields{"addr": s.Addr, "error": err})
+		}
+	}
+}
+
+// Publish emits the change in counters since the previous call along with
+// the current gauges
+func (s *StatsD) Publish() error {
+	if s.conn == nil {
+		conn, err := net.Dial("udp", s.Addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	totals := s.Metrics.Totals()
+	delta := totals.Sub(s.last)
+	s.last = totals
+
+	lines := []string{
+		s.line("requests", delta.Requests, "c"),
+		s.line("errors", delta.Errors, "c"),
+		s.line("bytes_sent", delta.Bytes, "c"),
+		s.line("in_flight", s.Metrics.InFlight(), "g"),
+		s.line("open_connections", s.Metrics.OpenConns(), "g"),
+	}
+	if delta.Requests > 0 {
+		lines = append(lines,
+			s.line("latency.p50", delta.Percentile(.5)*1000, "g"),
+			s.line("latency.p90", delta.Percentile(.9)*1000, "g"),
+			s.line("latency.p99", delta.Percentile(.99)*1000, "g"),
+		)
+	}
+
+	if snapshot, ok := s.Metrics.CacheSnapshot(); ok {
+		lines = append(lines,
+			s.line("cache.hits", snapshot.Hits-s.lastCache.Hits, "c"),
+			s.line("cache.misses", snapshot.Misses-s.lastCache.Misses, "c"),
+			s.line("cache.stale", snapshot.Stale-s.lastCache.Stale, "c"),
+			s.line("cache.evictions", snapshot.Evictions-s.lastCache.Evictions, "c"),
+			s.line("cache.entries", snapshot.Entries, "g"),
+			s.line("cache.bytes", snapshot.Bytes, "g"),
+		)
+		s.lastCache = snapshot
+	}
+
+	return s.send(lines)
+}
+
+func (s *StatsD) line(name string, value interface{}, kind string) string {
+	line := fmt.Sprintf("%s%s:%v|%s", s.Prefix, name, value, kind)
+	if s.DogStatsD && len(s.Tags) > 0 {
+		line += "|#" + strings.Join(s.Tags, ",")
+	}
+	return line
+}
+
+// send packs lines into as few datagrams as possible
+func (s *StatsD) send(lines []string) error {
+	packet := &bytes.Buffer{}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsDPacket {
+			if _, err := s.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(packet.Bytes())
+	return err
+}
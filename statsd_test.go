@@ -0,0 +1,62 @@
+package s3site
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDPublish(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	metrics := NewMetrics()
+	statsd := &StatsD{
+		Addr:      conn.LocalAddr().String(),
+		Prefix:    "s3site.",
+		Tags:      []string{"site:docs", "env:prod"},
+		DogStatsD: true,
+		Metrics:   metrics,
+	}
+	metrics.ObserveRequest(200, 100, 20*time.Millisecond)
+	metrics.ObserveRequest(500, 10, 20*time.Millisecond)
+
+	if err := statsd.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, maxStatsDPacket)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(buf[:n]), "\n")
+
+	expected := []string{
+		"s3site.requests:2|c|#site:docs,env:prod",
+		"s3site.errors:1|c|#site:docs,env:prod",
+		"s3site.bytes_sent:110|c|#site:docs,env:prod",
+		"s3site.latency.p99:25|g|#site:docs,env:prod",
+	}
+	for _, line := range expected {
+		found := false
+		for _, got := range lines {
+			found = found || got == line
+		}
+		if !found {
+			t.Errorf("expected %q in %q", line, lines)
+		}
+	}
+}
+
+func TestStatsDLineWithoutTags(t *testing.T) {
+	statsd := &StatsD{Prefix: "s3site.", Tags: []string{"env:prod"}}
+	if line := statsd.line("requests", 3, "c"); line != "s3site.requests:3|c" {
+		t.Errorf("expected plain statsd to omit tags, got %s", line)
+	}
+}
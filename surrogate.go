@@ -0,0 +1,73 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SurrogateKeys returns the tags a CDN can purge by for the request path:
+// the path itself, each enclosing directory, and the deploy id if any
+func SurrogateKeys(urlPath, deployID string) []string {
+	keys := []string{}
+	if deployID != "" {
+		keys = append(keys, "deploy-"+deployID)
+	}
+
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	dir := "/"
+	keys = append(keys, dir)
+	for _, segment := range segments[:len(segments)-1] {
+		dir = dir + segment + "/"
+		keys = append(keys, dir)
+	}
+	if urlPath != dir {
+		keys = append(keys, urlPath)
+	}
+
+	for i, key := range keys {
+		keys[i] = strings.Replace(key, " ", "%20", -1)
+	}
+	return keys
+}
+
+// setCacheHeaders writes the browser facing Cache-Control along with the
+// cdn facing Surrogate-Control and surrogate key headers
+func setCacheHeaders(w http.ResponseWriter, opts *Options, urlPath string) {
+	if opts.MaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", opts.MaxAge))
+	}
+	if opts.SurrogateMaxAge > 0 {
+		w.Header().Set("Surrogate-Control", fmt.Sprintf("max-age=%d", opts.SurrogateMaxAge))
+	}
+	if opts.SurrogateKeyHeader != "" {
+		separator := " "
+		if strings.EqualFold(opts.SurrogateKeyHeader, "Cache-Tag") {
+			separator = ","
+		}
+		keys := SurrogateKeys(urlPath, opts.DeployID)
+		w.Header().Set(opts.SurrogateKeyHeader, strings.Join(keys, separator))
+	}
+}
@@ -0,0 +1,43 @@
+package s3site
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSurrogateKeys(t *testing.T) {
+	testCases := map[string][]string{
+		"/":                  {"/"},
+		"/about.html":        {"/", "/about.html"},
+		"/docs/guide/":       {"/", "/docs/", "/docs/guide/"},
+		"/docs/guide/a.html": {"/", "/docs/", "/docs/guide/", "/docs/guide/a.html"},
+		"/my docs/a b.html":  {"/", "/my%20docs/", "/my%20docs/a%20b.html"},
+	}
+	for urlPath, expected := range testCases {
+		if keys := SurrogateKeys(urlPath, ""); !reflect.DeepEqual(keys, expected) {
+			t.Errorf("%s: expected %v, got %v", urlPath, expected, keys)
+		}
+	}
+
+	if keys := SurrogateKeys("/", "42"); !reflect.DeepEqual(keys, []string{"deploy-42", "/"}) {
+		t.Errorf("expected deploy key, got %v", keys)
+	}
+}
+
+func TestSetCacheHeaders(t *testing.T) {
+	opts := &Options{MaxAge: 90, SurrogateMaxAge: 3600, SurrogateKeyHeader: "Cache-Tag"}
+	w := httptest.NewRecorder()
+	setCacheHeaders(w, opts, "/docs/a.html")
+
+	expected := map[string]string{
+		"Cache-Control":     "max-age=90",
+		"Surrogate-Control": "max-age=3600",
+		"Cache-Tag":         "/,/docs/,/docs/a.html",
+	}
+	for name, value := range expected {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("%s: expected %s, got %s", name, value, got)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslog severities per rfc 5424
+const (
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+	severityDebug   = 7
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Syslog writes log lines as rfc 5424 messages to the local syslog daemon
+// or a remote collector over udp or tcp
+type Syslog struct {
+	Network  string // unixgram, udp, or tcp
+	Addr     string
+	Facility int
+	Severity int // used for lines written without a level
+	Tag      string
+	Hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslog returns a syslog writer for addr, one of local,
+// udp://host:port, or tcp://host:port
+func NewSyslog(addr, facility, tag string) (*Syslog, error) {
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility, %s", facility)
+	}
+	hostname, _ := os.Hostname()
+	s := &Syslog{Facility: code, Severity: severityInfo, Tag: tag, Hostname: hostname}
+
+	if addr == "local" {
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			if _, err := os.Stat(path); err == nil {
+				s.Network, s.Addr = "unixgram", path
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("unable to find a local syslog socket")
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("unknown syslog address, %s", addr)
+	}
+	s.Network, s.Addr = u.Scheme, u.Host
+	return s, nil
+}
+
+// WithSeverity returns a copy of s that shares its connection settings but
+// writes unleveled lines at severity
+func (s *Syslog) WithSeverity(severity int) *Syslog {
+	return &Syslog{
+		Network:  s.Network,
+		Addr:     s.Addr,
+		Facility: s.Facility,
+		Severity: severity,
+		Tag:      s.Tag,
+		Hostname: s.Hostname,
+	}
+}
+
+func (s *Syslog) Write(p []byte) (int, error) {
+	return s.write(s.Severity, p)
+}
+
+// WriteLevel writes p at the severity corresponding to level
+func (s *Syslog) WriteLevel(level Level, p []byte) (int, error) {
+	severity := severityInfo
+	switch level {
+	case LevelDebug:
+		severity = severityDebug
+	case LevelWarn:
+		severity = severityWarning
+	case LevelError:
+		severity = severityError
+	}
+	return s.write(severity, p)
+}
+
+// Format renders msg as an rfc 5424 message
+func (s *Syslog) Format(severity int, msg string, now time.Time) string {
+	hostname := s.Hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		s.Facility*8+severity,
+		now.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		hostname,
+		s.Tag,
+		os.Getpid(),
+		msg,
+	)
+}
+
+func (s *Syslog) write(severity int, p []byte) (int, error) {
+	msg := s.Format(severity, strings.TrimRight(string(p), "\n"), time.Now())
+	if s.Network == "tcp" {
+		// octet counting framing per rfc 6587
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// reconnect once, e.g. after the syslog daemon restarts
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			conn, err := net.Dial(s.Network, s.Addr)
+			if err != nil {
+				return 0, err
+			}
+			s.conn = conn
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			continue
+		}
+		return len(p), nil
+	}
+	return 0, fmt.Errorf("unable to write to syslog at %s", s.Addr)
+}
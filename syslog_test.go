@@ -0,0 +1,58 @@
+package s3site
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogFormat(t *testing.T) {
+	s := &Syslog{Facility: 16, Tag: "s3site", Hostname: "web-1"}
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	msg := s.Format(severityError, "boom", now)
+	if !strings.HasPrefix(msg, "<131>1 2006-01-02T15:04:05.000000Z web-1 s3site ") || !strings.HasSuffix(msg, " - - boom") {
+		t.Errorf("unexpected rfc 5424 message, %s", msg)
+	}
+}
+
+func TestNewSyslog(t *testing.T) {
+	s, err := NewSyslog("udp://127.0.0.1:514", "daemon", "s3site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Network != "udp" || s.Addr != "127.0.0.1:514" || s.Facility != 3 {
+		t.Errorf("unexpected syslog %#v", s)
+	}
+
+	if _, err := NewSyslog("udp://127.0.0.1:514", "bogus", "s3site"); err == nil {
+		t.Errorf("expected an error for an unknown facility")
+	}
+	if _, err := NewSyslog("http://127.0.0.1:514", "daemon", "s3site"); err == nil {
+		t.Errorf("expected an error for an unknown scheme")
+	}
+}
+
+func TestSyslogWriteLevel(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, _ := NewSyslog("udp://"+conn.LocalAddr().String(), "local0", "s3site")
+	l := &Logger{Out: s, Level: LevelInfo}
+	l.Warn("slow", Fields{"path": "/index.html"})
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<132>1 ") || !strings.HasSuffix(msg, "[warn] slow path=/index.html") {
+		t.Errorf("unexpected message, %q", msg)
+	}
+}
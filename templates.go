@@ -0,0 +1,170 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxTemplates bounds the parsed templates kept; the cache is emptied when
+// it fills, which happens only across many deploys
+const maxTemplates = 512
+
+// TemplateVar is a variable injected into templates; its value may use the
+// prefix variables e.g. {env:STAGE} or {header:X-Tenant}
+type TemplateVar struct {
+	Name  string
+	Value string
+}
+
+// Templates renders the files matching its patterns as Go html templates,
+// with its variables available as .Vars.  Parsed templates are cached by
+// key and etag, so each deploy is parsed once.
+type Templates struct {
+	Patterns []string
+	Vars     []TemplateVar
+
+	mu     sync.Mutex
+	parsed map[string]*template.Template
+}
+
+// templateData is the data templates are executed with
+type templateData struct {
+	Path  string
+	Host  string
+	Query map[string][]string
+	Vars  map[string]string
+}
+
+// NewTemplates returns the templates matching patterns, with vars of the
+// form name=value; {env:NAME} in values is expanded once, here
+func NewTemplates(patterns, vars []string) (*Templates, error) {
+	t := &Templates{Patterns: patterns, parsed: map[string]*template.Template{}}
+	for _, value := range vars {
+		index := strings.Index(value, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid template var, %s; expected name=value", value)
+		}
+		v := TemplateVar{Name: value[:index], Value: expandEnv(value[index+1:])}
+		if err := prefixProblem(v.Value); err != nil {
+			return nil, fmt.Errorf("invalid template var, %s: %s", value, err)
+		}
+		t.Vars = append(t.Vars, v)
+	}
+	return t, nil
+}
+
+// Matches returns true when urlPath, or its index file for a directory, is
+// rendered as a template; patterns match as cache-ttl patterns do, by path,
+// directory, or file name
+func (t *Templates) Matches(urlPath, indexFile string) bool {
+	if t == nil {
+		return false
+	}
+	if strings.HasSuffix(urlPath, "/") {
+		urlPath += indexFile
+	}
+	for _, pattern := range t.Patterns {
+		if (TTLRule{Pattern: pattern}).Matches(urlPath, "") {
+			return true
+		}
+	}
+	return false
+}
+
+// headers returns the request headers the variables read
+func (t *Templates) headers() []string {
+	names := []string{}
+	for _, v := range t.Vars {
+		names = append(names, varHeaders(v.Value)...)
+	}
+	return names
+}
+
+// parse returns the template read from body, parsing it only the first time
+// key is seen with etag
+func (t *Templates) parse(key, etag string, body io.Reader) (*template.Template, error) {
+	id := key + "\x00" + etag
+	t.mu.Lock()
+	tmpl, ok := t.parsed[id]
+	t.mu.Unlock()
+	if ok && etag != "" {
+		return tmpl, nil
+	}
+
+	src, err := ioutil.ReadAll(io.LimitReader(body, maxRendered))
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err = template.New(key).Option("missingkey=zero").Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if len(t.parsed) >= maxTemplates {
+		t.parsed = map[string]*template.Template{}
+	}
+	t.parsed[id] = tmpl
+	t.mu.Unlock()
+	return tmpl, nil
+}
+
+// Serve renders the template read from body, stored at key with etag
+func (t *Templates) Serve(w http.ResponseWriter, req *http.Request, body io.Reader, key, etag, urlPath, variant string) {
+	tmpl, err := t.parse(key, etag, body)
+	if err != nil {
+		logger.Error("unable to parse template", Fields{"request_id": requestID(req), "key": key, "error": err})
+		http.Error(w, "unable to parse template", http.StatusInternalServerError)
+		return
+	}
+
+	data := templateData{Path: urlPath, Host: req.Host, Query: req.URL.Query(), Vars: map[string]string{}}
+	for _, v := range t.Vars {
+		data.Vars[v.Name], _ = expandVars(v.Value, req, false)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		logger.Error("unable to render template", Fields{"request_id": requestID(req), "key": key, "error": err})
+		http.Error(w, "unable to render template", http.StatusInternalServerError)
+		return
+	}
+	for _, name := range t.headers() {
+		w.Header().Add("Vary", name)
+	}
+	// pages may render differently per request, so are tagged by content
+	if setValidators(w, req, VariantETag(StrongETag(buf.Bytes()), variant), "") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
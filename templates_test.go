@@ -0,0 +1,88 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	os.Setenv("S3SITE_TEST_STAGE", "staging")
+	defer os.Unsetenv("S3SITE_TEST_STAGE")
+
+	templates, err := NewTemplates([]string{"/index.html", "*.tmpl.html"}, []string{"stage={env:S3SITE_TEST_STAGE}", "tenant={header:X-Tenant}", "beta=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]bool{
+		"/":                    true,
+		"/index.html":          true,
+		"/docs/page.tmpl.html": true,
+		"/docs/":               false,
+		"/app.js":              false,
+	}
+	for urlPath, expected := range testCases {
+		if actual := templates.Matches(urlPath, "index.html"); actual != expected {
+			t.Errorf("%s: expected %v, got %v", urlPath, expected, actual)
+		}
+	}
+
+	src := `<p>{{.Vars.stage}} {{.Vars.tenant}} {{if eq .Vars.beta "true"}}beta{{end}} {{.Path}}{{.Vars.missing}}</p>`
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Tenant", "<acme>")
+	w := httptest.NewRecorder()
+	templates.Serve(w, req, strings.NewReader(src), "site/index.html", `"v1"`, "/", "")
+	if body := w.Body.String(); body != "<p>staging &lt;acme&gt; beta /</p>" {
+		t.Errorf("unexpected render, %s", body)
+	}
+	if vary := w.Header().Get("Vary"); vary != "X-Tenant" {
+		t.Errorf("expected responses to vary by the header read, got %s", vary)
+	}
+	etag := w.Header().Get("ETag")
+	if etag != StrongETag(w.Body.Bytes()) {
+		t.Errorf("expected an etag of the rendered page, got %s", etag)
+	}
+
+	conditional := httptest.NewRequest("GET", "/", nil)
+	conditional.Header.Set("X-Tenant", "<acme>")
+	conditional.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	templates.Serve(w, conditional, strings.NewReader(src), "site/index.html", `"v1"`, "/", "")
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", w.Code)
+	}
+	conditional.Header.Set("X-Tenant", "other")
+	w = httptest.NewRecorder()
+	templates.Serve(w, conditional, strings.NewReader(src), "site/index.html", `"v1"`, "/", "")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("expected pages rendered differently to be tagged differently, got %d %s", w.Code, w.Header().Get("ETag"))
+	}
+
+	w = httptest.NewRecorder()
+	templates.Serve(w, req, strings.NewReader("changed"), "site/index.html", `"v1"`, "/", "")
+	if !strings.HasPrefix(w.Body.String(), "<p>staging") {
+		t.Errorf("expected the template to be parsed once per etag, got %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	templates.Serve(w, req, strings.NewReader("changed"), "site/index.html", `"v2"`, "/", "")
+	if w.Body.String() != "changed" {
+		t.Errorf("expected a new deploy to be parsed again, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	templates.Serve(w, req, strings.NewReader("{{.Broken"), "site/broken.html", `"v1"`, "/broken.html", "")
+	if w.Code != 500 {
+		t.Errorf("expected 500 for a broken template, got %d", w.Code)
+	}
+}
+
+func TestNewTemplatesErrors(t *testing.T) {
+	for _, value := range []string{"novalue", "=x", "stage={env:S3SITE_TEST_UNSET}", "x={bogus}"} {
+		if _, err := NewTemplates(nil, []string{value}); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOptionsServer(t *testing.T) {
+	opts := &Options{ReadHeaderTimeout: time.Second, ReadTimeout: 2 * time.Second, IdleTimeout: time.Minute}
+	server := opts.Server(http.NotFoundHandler())
+	if server.ReadHeaderTimeout != time.Second || server.ReadTimeout != 2*time.Second || server.WriteTimeout != 0 || server.IdleTimeout != time.Minute {
+		t.Errorf("unexpected timeouts, %+v", server)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	h := RequestTimeout(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+			w.WriteHeader(http.StatusGatewayTimeout)
+		case <-time.After(time.Second):
+			w.WriteHeader(http.StatusOK)
+		}
+	}), 20*time.Millisecond)
+
+	// under a real server the write deadline is set too
+	server := httptest.NewServer(h)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("expected the request context to be cancelled, got %d", resp.StatusCode)
+		}
+	}
+
+	// writers without deadlines still get the cancelled context
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected the request context to be cancelled, got %d", w.Code)
+	}
+}
@@ -0,0 +1,333 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// span kinds as defined by opentelemetry
+const (
+	SpanInternal = 1
+	SpanServer   = 2
+	SpanClient   = 3
+)
+
+// Span is a single timed operation within a trace
+type Span struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	ParentID   [8]byte
+	Name       string
+	Kind       int
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Err        error
+
+	tracer *Tracer
+}
+
+// SetAttribute annotates the span; safe to call on a nil span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed; safe to call on a nil span
+func (s *Span) SetError(err error) {
+	if s == nil {
+		return
+	}
+	s.Err = err
+}
+
+// Finish ends the span and queues it for export; safe to call on a nil span
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	s.tracer.enqueue(s)
+}
+
+type spanKey struct{}
+
+// withRemoteParent returns ctx carrying a span received from upstream so
+// spans started from it join the upstream trace
+func withRemoteParent(ctx context.Context, traceID [16]byte, spanID [8]byte) context.Context {
+	return context.WithValue(ctx, spanKey{}, &Span{TraceID: traceID, SpanID: spanID})
+}
+
+// spanFromContext returns the active span, if any
+func spanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// detachedContext returns a context free of ctx's cancelation and request
+// scoped values, carrying only its span, for work that outlives the request
+// e.g. background revalidation
+func detachedContext(ctx context.Context) context.Context {
+	if span := spanFromContext(ctx); span != nil {
+		return context.WithValue(context.Background(), spanKey{}, span)
+	}
+	return context.Background()
+}
+
+// SpanExporter ships finished spans to a tracing backend
+type SpanExporter interface {
+	Export(spans []*Span) error
+}
+
+// Tracer records spans and exports them in batches
+type Tracer struct {
+	Exporters []SpanExporter
+
+	mu      sync.Mutex
+	pending []*Span
+}
+
+// tracer is nil unless tracing has been configured
+var tracer *Tracer
+
+func NewTracer(exporters ...SpanExporter) *Tracer {
+	return &Tracer{Exporters: exporters}
+}
+
+// newTraceID returns a random trace id whose first four bytes are the
+// current epoch seconds, making it valid for both opentelemetry and x-ray
+func newTraceID() [16]byte {
+	var id [16]byte
+	binary.BigEndian.PutUint32(id[:4], uint32(time.Now().Unix()))
+	rand.Read(id[4:])
+	return id
+}
+
+// StartSpan begins a span as a child of the span in ctx, if any.  When
+// tracing is disabled, ctx is returned unchanged along with a nil span.
+func StartSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+		tracer:     tracer,
+	}
+	if parent := spanFromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		span.TraceID = newTraceID()
+	}
+	rand.Read(span.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+const maxPendingSpans = 4096
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.pending) < maxPendingSpans {
+		t.pending = append(t.pending, span)
+	}
+}
+
+// Run exports pending spans every interval
+func (t *Tracer) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := t.Flush(); err != nil {
+			logger.Warn("unable to export spans", Fields{"error": err})
+		}
+	}
+}
+
+// Flush exports all pending spans
+func (t *Tracer) Flush() error {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	var failed error
+	for _, exporter := range t.Exporters {
+		if err := exporter.Export(spans); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// OTLPExporter posts spans to an otlp/http collector e.g.
+// http://localhost:4318/v1/traces
+type OTLPExporter struct {
+	Endpoint string
+	Service  string
+	Client   *http.Client
+}
+
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint: endpoint,
+		Service:  service,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Export sends spans as a single ExportTraceServiceRequest
+func (e *OTLPExporter) Export(spans []*Span) error {
+	payload, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.Client.Post(e.Endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded %s", resp.Status)
+	}
+	return nil
+}
+
+type otlpValue map[string]interface{}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	encoded := []otlpAttribute{}
+	for key, value := range attributes {
+		var v otlpValue
+		switch typed := value.(type) {
+		case string:
+			v = otlpValue{"stringValue": typed}
+		case bool:
+			v = otlpValue{"boolValue": typed}
+		case int:
+			v = otlpValue{"intValue": strconv.Itoa(typed)}
+		case int64:
+			v = otlpValue{"intValue": strconv.FormatInt(typed, 10)}
+		default:
+			v = otlpValue{"stringValue": fmt.Sprint(typed)}
+		}
+		encoded = append(encoded, otlpAttribute{Key: key, Value: v})
+	}
+	return encoded
+}
+
+// encode renders spans as an otlp ExportTraceServiceRequest in its json form
+func (e *OTLPExporter) encode(spans []*Span) interface{} {
+	encoded := []interface{}{}
+	for _, span := range spans {
+		s := map[string]interface{}{
+			"traceId":           hex.EncodeToString(span.TraceID[:]),
+			"spanId":            hex.EncodeToString(span.SpanID[:]),
+			"name":              span.Name,
+			"kind":              span.Kind,
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes),
+		}
+		if span.ParentID != [8]byte{} {
+			s["parentSpanId"] = hex.EncodeToString(span.ParentID[:])
+		}
+		if span.Err != nil {
+			s["status"] = map[string]interface{}{"code": 2, "message": span.Err.Error()}
+		}
+		encoded = append(encoded, s)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": e.Service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "s3site"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+// Trace wraps h in a server span per request, joining any trace the
+// request arrived with.  With tracing disabled, an upstream trace is still
+// carried through so it can be logged and forwarded to s3.
+func Trace(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := withUpstreamTrace(req.Context(), req)
+
+		ctx, span := StartSpan(ctx, req.Method+" "+req.URL.Path, SpanServer)
+		if span == nil {
+			h.ServeHTTP(w, req.WithContext(ctx))
+			return
+		}
+		defer span.Finish()
+
+		w.Header().Set(xrayHeader, "Root="+xrayTraceID(span.TraceID))
+
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.target", req.URL.RequestURI())
+		span.SetAttribute("http.client_ip", clientIP(req))
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, req.WithContext(ctx))
+		span.SetAttribute("http.status_code", recorder.Status())
+		if recorder.Status() >= 500 {
+			span.SetError(fmt.Errorf("%d %s", recorder.Status(), http.StatusText(recorder.Status())))
+		}
+	})
+}
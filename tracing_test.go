@@ -0,0 +1,66 @@
+package s3site
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStartSpanDisabled(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "noop", SpanInternal)
+	if span != nil || spanFromContext(ctx) != nil {
+		t.Fatal("expected no span when tracing is disabled")
+	}
+	span.SetAttribute("a", 1)
+	span.SetError(errors.New("boom"))
+	span.Finish()
+}
+
+func TestTraceExport(t *testing.T) {
+	var payload map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(data, &payload)
+	}))
+	defer collector.Close()
+
+	tracer = NewTracer(NewOTLPExporter(collector.URL, "s3site"))
+	defer func() { tracer = nil }()
+
+	h := Trace(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, span := StartSpan(req.Context(), "s3.GetObject", SpanClient)
+		span.SetError(errors.New("boom"))
+		span.Finish()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/index.html", nil))
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	resourceSpans := payload["resourceSpans"].([]interface{})
+	scopeSpans := resourceSpans[0].(map[string]interface{})["scopeSpans"].([]interface{})
+	spans := scopeSpans[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	child, server := spans[0].(map[string]interface{}), spans[1].(map[string]interface{})
+	if server["name"] != "GET /index.html" || server["kind"] != float64(SpanServer) {
+		t.Errorf("unexpected server span, %v", server)
+	}
+	if child["traceId"] != server["traceId"] || child["parentSpanId"] != server["spanId"] {
+		t.Errorf("expected s3 span to be a child of the server span")
+	}
+	if _, ok := child["status"]; !ok {
+		t.Errorf("expected error status on s3 span")
+	}
+	if _, ok := server["parentSpanId"]; ok {
+		t.Errorf("expected server span to be a root span")
+	}
+}
@@ -0,0 +1,130 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Forever is the ttl for objects that never need revalidation e.g. hashed assets
+const Forever = time.Duration(math.MaxInt64)
+
+// TTLRule assigns a cache freshness to objects matching Pattern, which is
+// either a path glob (/docs/*, *.png) or a content type (text/html, image/*)
+type TTLRule struct {
+	Pattern string
+	TTL     time.Duration
+}
+
+// Matches reports whether the rule applies to the request path or content type
+func (r TTLRule) Matches(urlPath, contentType string) bool {
+	switch {
+	case strings.HasPrefix(r.Pattern, "/"):
+		if strings.HasSuffix(r.Pattern, "/") {
+			return strings.HasPrefix(urlPath, r.Pattern)
+		}
+		ok, _ := path.Match(r.Pattern, urlPath)
+		return ok
+
+	case !strings.Contains(r.Pattern, "/"):
+		ok, _ := path.Match(r.Pattern, path.Base(urlPath))
+		return ok
+
+	default:
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return false
+		}
+		ok, _ := path.Match(r.Pattern, mediaType)
+		return ok
+	}
+}
+
+// ParseTTLRules parses rules of the form pattern=duration e.g. text/html=30s;
+// the duration may also be "forever"
+func ParseTTLRules(values []string) ([]TTLRule, error) {
+	rules := []TTLRule{}
+	for _, value := range values {
+		index := strings.LastIndex(value, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid cache ttl, %s; expected pattern=duration", value)
+		}
+
+		rule := TTLRule{Pattern: value[:index]}
+		if ttl := value[index+1:]; ttl == "forever" {
+			rule.TTL = Forever
+		} else {
+			d, err := time.ParseDuration(ttl)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cache ttl, %s: %s", value, err)
+			}
+			rule.TTL = d
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// TTLPolicy determines how long cached objects are served before revalidation
+type TTLPolicy struct {
+	Prefix  string
+	Rules   []TTLRule
+	Default time.Duration
+
+	mu sync.RWMutex
+}
+
+// SetRules replaces the rules and default ttl e.g. on a configuration reload
+func (p *TTLPolicy) SetRules(rules []TTLRule, ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Rules = rules
+	p.Default = ttl
+}
+
+// SetPrefix replaces the prefix e.g. when a new release is served
+func (p *TTLPolicy) SetPrefix(prefix string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Prefix = prefix
+}
+
+// For returns the freshness of the cached entry; the first matching rule wins
+func (p *TTLPolicy) For(entry *Entry) time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	urlPath := "/" + strings.TrimPrefix(trimKeyPrefix(entry.Key, strings.Trim(p.Prefix, "/")), "/")
+	for _, rule := range p.Rules {
+		if rule.Matches(urlPath, entry.ContentType) {
+			return rule.TTL
+		}
+	}
+	return p.Default
+}
@@ -0,0 +1,52 @@
+package s3site
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTTLPolicy(t *testing.T) {
+	rules, err := ParseTTLRules([]string{"/assets/=forever", "*.png=1h", "text/html=30s", "image/*=10m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &TTLPolicy{Prefix: "/site/", Rules: rules, Default: time.Minute}
+
+	testCases := []struct {
+		Key         string
+		ContentType string
+		Expected    time.Duration
+	}{
+		{"site/assets/app.js", "application/javascript", Forever},
+		{"site/img/logo.png", "image/png", time.Hour},
+		{"site/img/logo.gif", "image/gif", 10 * time.Minute},
+		{"site/index.html", "text/html; charset=utf-8", 30 * time.Second},
+		{"site/data.json", "application/json", time.Minute},
+	}
+
+	for _, tc := range testCases {
+		if ttl := policy.For(&Entry{Key: tc.Key, ContentType: tc.ContentType}); ttl != tc.Expected {
+			t.Errorf("%s: expected %v, got %v", tc.Key, tc.Expected, ttl)
+		}
+	}
+}
+
+func TestTTLPolicyWildcardPrefix(t *testing.T) {
+	rules, _ := ParseTTLRules([]string{"/assets/=forever"})
+	for _, prefix := range []string{"previews/{subdomain}/", "previews/{subdomain}-build/"} {
+		policy := &TTLPolicy{Prefix: prefix, Rules: rules, Default: time.Minute}
+		key := strings.Replace(prefix, SubdomainVar, "feature-x", 1) + "assets/app.js"
+		if ttl := policy.For(&Entry{Key: key}); ttl != Forever {
+			t.Errorf("%s: expected the subdomain to be trimmed with the prefix, got %v", key, ttl)
+		}
+	}
+}
+
+func TestParseTTLRulesRejectsInvalid(t *testing.T) {
+	for _, value := range []string{"text/html", "=30s", "*.png=soon"} {
+		if _, err := ParseTTLRules([]string{value}); err == nil {
+			t.Errorf("expected %s to be rejected", value)
+		}
+	}
+}
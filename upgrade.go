@@ -0,0 +1,130 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+)
+
+// upgradeFDsEnv names, in order, the sockets passed to a process started by
+// Upgrade
+const upgradeFDsEnv = "S3SITE_UPGRADE_FDS"
+
+// UpgradeListeners returns the sockets handed over by the process that
+// started this one to upgrade, if any.  The variable is cleared so a later
+// upgrade starts afresh.
+func UpgradeListeners() ([]InheritedListener, error) {
+	value := os.Getenv(upgradeFDsEnv)
+	if value == "" {
+		return nil, nil
+	}
+	os.Unsetenv(upgradeFDsEnv)
+
+	names := strings.Split(value, ",")
+	return inheritedListeners(listenFDsStart, len(names), names)
+}
+
+// Upgrade starts the binary now installed at this one's path, with the same
+// arguments, and hands it sockets so no connection is refused in between.
+// Once serving, the new process signals this one with SIGTERM, which drains
+// as usual; should it fail to start, this process keeps serving.
+func Upgrade(sockets []InheritedListener) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return handover(exe, os.Args[1:], sockets)
+}
+
+// handover starts exe with args and the sockets
+func handover(exe string, args []string, sockets []InheritedListener) (*os.Process, error) {
+	files := []*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	names := []string{}
+	for _, socket := range sockets {
+		filer, ok := socket.Listener.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			return nil, fmt.Errorf("unable to pass listener %s", socket.Name)
+		}
+		// the new process serves the socket file once this one exits
+		if ln, ok := socket.Listener.(*net.UnixListener); ok {
+			ln.SetUnlinkOnClose(false)
+		}
+
+		f, err := filer.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		names = append(names, socket.Name)
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), upgradeFDsEnv+"="+strings.Join(names, ","))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// UpgradeOnSignal starts an upgrade whenever the process receives SIGUSR2;
+// windows has no such signal, so there it returns at once
+func UpgradeOnSignal(sockets []InheritedListener) {
+	if upgradeSignal == nil {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, upgradeSignal)
+	for range signals {
+		process, err := Upgrade(sockets)
+		if err != nil {
+			logger.Error("unable to upgrade", Fields{"error": err})
+			continue
+		}
+		logger.Info("upgrading", Fields{"pid": process.Pid})
+		go func() {
+			// only returns early if the new process failed
+			state, _ := process.Wait()
+			logger.Warn("upgrade exited", Fields{"pid": process.Pid, "state": fmt.Sprint(state)})
+		}()
+	}
+}
+
+// upgraded tells the process that started this one to drain and exit
+func upgraded() error {
+	return terminate(os.Getppid())
+}
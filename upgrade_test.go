@@ -0,0 +1,85 @@
+package s3site
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"testing"
+)
+
+// TestUpgradeHelper is the process started by TestHandover; it answers one
+// connection on each socket it was handed
+func TestUpgradeHelper(t *testing.T) {
+	if os.Getenv("S3SITE_TEST_UPGRADE_HELPER") == "" {
+		t.Skip("run by TestHandover")
+	}
+	sockets, err := UpgradeListeners()
+	if err != nil || len(sockets) != 1 {
+		t.Fatalf("expected a socket, got %v %v", sockets, err)
+	}
+	conn, err := sockets[0].Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte(sockets[0].Name + "\n"))
+	conn.Close()
+}
+
+func TestHandover(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	os.Setenv("S3SITE_TEST_UPGRADE_HELPER", "1")
+	defer os.Unsetenv("S3SITE_TEST_UPGRADE_HELPER")
+	process, err := handover(os.Args[0], []string{"-test.run=^TestUpgradeHelper$"}, []InheritedListener{{Listener: ln, Name: "127.0.0.1:8080"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stop accepting here so the connection goes to the new process
+	ln.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "127.0.0.1:8080\n" {
+		t.Errorf("expected the new process to answer on the handed over socket, got %q %v", line, err)
+	}
+
+	if state, err := process.Wait(); err != nil || !state.Success() {
+		t.Errorf("helper failed, %v %v", state, err)
+	}
+}
+
+func TestOpenListenersReusesHandedOverSockets(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{Listen: []string{"127.0.0.1:8080"}, SocketMode: "0660"}
+	listeners, err := OpenListeners(opts, []InheritedListener{
+		{Listener: ln, Name: "127.0.0.1:8080"},
+		{Listener: stale, Name: "127.0.0.1:9999"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners.Close()
+
+	if len(listeners.Site) != 1 || listeners.Site[0] != ln {
+		t.Errorf("expected the handed over socket to be reused, got %v", listeners.Site)
+	}
+	if _, err := stale.Accept(); err == nil {
+		t.Error("expected a socket no longer listened on to be closed")
+	}
+}
@@ -0,0 +1,124 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Upstream proxies requests beneath Prefix to a backend, so the site and
+// e.g. its api share a host name
+type Upstream struct {
+	// Prefix e.g. /api/ holds the paths proxied
+	Prefix string
+	// Target is the backend; when it has a path, that path takes the place
+	// of Prefix
+	Target *url.URL
+	Proxy  *httputil.ReverseProxy
+}
+
+// Upstreams are matched by their longest prefix
+type Upstreams []*Upstream
+
+// ParseUpstreams parses routes of the form prefix=url e.g.
+// /api/=http://10.0.0.5:8080, keeping the path, or
+// /api/=http://10.0.0.5:8080/v1/, replacing /api/ with /v1/.  A trailing **
+// on the prefix is dropped, so /api/** reads as /api/.
+func ParseUpstreams(values []string, timeout time.Duration) (Upstreams, error) {
+	upstreams := Upstreams{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid upstream, %s", value)
+		}
+		prefix := strings.TrimSuffix(parts[0], "**")
+		if !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") {
+			return nil, fmt.Errorf("invalid upstream prefix, %s; use a path ending / e.g. /api/", parts[0])
+		}
+		target, err := url.Parse(parts[1])
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return nil, fmt.Errorf("invalid upstream url, %s", parts[1])
+		}
+		if target.Path != "" && !strings.HasSuffix(target.Path, "/") {
+			target.Path += "/"
+		}
+		upstreams = append(upstreams, newUpstream(prefix, target, timeout))
+	}
+	return upstreams, nil
+}
+
+func newUpstream(prefix string, target *url.URL, timeout time.Duration) *Upstream {
+	u := &Upstream{Prefix: prefix, Target: target}
+	u.Proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = target.Scheme
+			r.Out.URL.Host = target.Host
+			if target.Path != "" {
+				rest := strings.TrimPrefix(r.In.URL.Path, prefix)
+				if r.In.URL.Path+"/" == prefix {
+					rest = ""
+				}
+				r.Out.URL.Path = target.Path + rest
+				r.Out.URL.RawPath = ""
+			}
+			// the backend sees the site's host name, as it would were it
+			// serving the site itself
+			r.Out.Host = r.In.Host
+			r.SetXForwarded()
+			dropBasicAuth(r)
+		},
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+			ResponseHeaderTimeout: timeout,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.Warn("unable to reach upstream", Fields{"request_id": requestID(req), "upstream": target.String(), "path": req.URL.Path, "error": err})
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return u
+}
+
+// Match returns the upstream whose prefix is the longest to match urlPath,
+// or nil
+func (u Upstreams) Match(urlPath string) *Upstream {
+	var match *Upstream
+	for _, upstream := range u {
+		if (strings.HasPrefix(urlPath, upstream.Prefix) || urlPath+"/" == upstream.Prefix) && (match == nil || len(upstream.Prefix) > len(match.Prefix)) {
+			match = upstream
+		}
+	}
+	return match
+}
@@ -0,0 +1,107 @@
+package s3site
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUpstreams(t *testing.T) {
+	upstreams, err := ParseUpstreams([]string{"/api/**=http://10.0.0.5:8080", "/api/v2/=https://v2.internal/v2"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstreams[0].Prefix != "/api/" || upstreams[1].Target.Path != "/v2/" {
+		t.Errorf("unexpected upstreams, %+v %+v", upstreams[0], upstreams[1])
+	}
+
+	testCases := map[string]string{
+		"/api/users":    "/api/",
+		"/api":          "/api/",
+		"/api/v2/users": "/api/v2/",
+		"/apis":         "",
+		"/index.html":   "",
+	}
+	for urlPath, prefix := range testCases {
+		match := upstreams.Match(urlPath)
+		if (match == nil && prefix != "") || (match != nil && match.Prefix != prefix) {
+			t.Errorf("%s: expected %q; got %+v", urlPath, prefix, match)
+		}
+	}
+
+	for _, value := range []string{"/api/", "api/=http://x", "/api=http://x", "/api/=x", "/api/=ftp://x"} {
+		if _, err := ParseUpstreams([]string{value}, time.Second); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/slow") {
+			time.Sleep(200 * time.Millisecond)
+		}
+		fmt.Fprintf(w, "%s %s %s %s", req.Host, req.URL.RequestURI(), req.Header.Get("X-Forwarded-Host"), req.Header.Get("Authorization"))
+	}))
+	defer backend.Close()
+
+	upstreams, err := ParseUpstreams([]string{"/api/=" + backend.URL, "/v1/=" + backend.URL + "/internal", "/slow/=" + backend.URL + "/slow"}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Target   string
+		Expected string
+	}{
+		{"http://www.example.com/api/users?page=2", "www.example.com /api/users?page=2 www.example.com Bearer token"},
+		{"http://www.example.com/v1/users", "www.example.com /internal/users www.example.com Bearer token"},
+		{"http://www.example.com/v1", "www.example.com /internal/ www.example.com Bearer token"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.Target, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		upstreams.Match(req.URL.Path).Proxy.ServeHTTP(w, req)
+		if w.Body.String() != tc.Expected {
+			t.Errorf("%s: expected %q; got %q", tc.Target, tc.Expected, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://www.example.com/slow/", nil)
+	upstreams.Match(req.URL.Path).Proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504 from a slow upstream; got %d", w.Code)
+	}
+}
+
+func TestUpstreamDropsBasicAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, req.Header.Get("Authorization"))
+	}))
+	defer backend.Close()
+
+	upstreams, err := ParseUpstreams([]string{"/api/=" + backend.URL}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.SetBasicAuth("user", "secret")
+	w := httptest.NewRecorder()
+	upstreams[0].Proxy.ServeHTTP(w, withBasicAuth(req))
+	if w.Body.String() != "" {
+		t.Errorf("expected the site's credentials to be withheld, got %q", w.Body.String())
+	}
+
+	req.Header.Set("Authorization", "Bearer backend")
+	w = httptest.NewRecorder()
+	upstreams[0].Proxy.ServeHTTP(w, req)
+	if w.Body.String() != "Bearer backend" {
+		t.Errorf("expected other credentials to be passed on, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,455 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+// CheckResult is the outcome of one preflight check
+type CheckResult struct {
+	Name    string
+	Detail  string
+	Err     error
+	Skipped bool
+}
+
+// Preflight checks that the bucket can be served with the given options
+type Preflight struct {
+	Options *Options
+	Auth    aws.Auth
+	Region  aws.Region
+	Client  *http.Client
+}
+
+// Validate runs the preflight checks and prints a report, exiting non-zero
+// if any fail
+func Validate(c *cli.Context) {
+	results := []CheckResult{}
+	opts, err := LoadOptions(os.Args[1:])
+	if err != nil {
+		results = append(results, CheckResult{Name: "config", Err: err})
+		report(os.Stdout, results)
+		os.Exit(1)
+	}
+	results = append(results, CheckResult{Name: "config", Detail: configPath(os.Args[1:])})
+
+	preflight := &Preflight{
+		Options: opts,
+		Region:  aws.USEast,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	creds, err := NewCredentials(opts)
+	if err == nil {
+		preflight.Auth, preflight.Region = creds.Auth(), creds.Region
+	}
+	results = append(results, preflight.Run(err)...)
+	if !report(os.Stdout, results) {
+		os.Exit(1)
+	}
+}
+
+// Run performs each check in turn, skipping those that depend on one that
+// failed; authErr is the result of loading credentials
+func (p *Preflight) Run(authErr error) []CheckResult {
+	results := []CheckResult{
+		{Name: "rules", Err: p.Options.Validate()},
+		{Name: "credentials", Err: authErr},
+	}
+	if p.Options.Bucket == "" {
+		results = append(results, CheckResult{Name: "bucket", Err: fmt.Errorf("no bucket configured")})
+	} else if authErr == nil {
+		results = append(results, p.bucket()...)
+	}
+
+	for _, name := range []string{"bucket", "region", "prefix", "index"} {
+		if !hasResult(results, name) {
+			results = append(results, CheckResult{Name: name, Skipped: true})
+		}
+	}
+	return results
+}
+
+func hasResult(results []CheckResult, name string) bool {
+	for _, result := range results {
+		if result.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigProblem is one invalid option and how to fix it
+type ConfigProblem struct {
+	Field   string
+	Problem string
+	Fix     string
+}
+
+// ConfigError lists every problem with the configuration, so all can be
+// fixed at once
+type ConfigError []ConfigProblem
+
+// Error lists the problems one per line
+func (e ConfigError) Error() string {
+	s := "invalid configuration"
+	for _, p := range e {
+		s += fmt.Sprintf("\n  %s: %s (%s)", p.Field, p.Problem, p.Fix)
+	}
+	return s
+}
+
+// Validate checks each option that has a syntax of its own, and options
+// that only work together, returning a ConfigError naming each problem
+func (o *Options) Validate() error {
+	problems := ConfigError{}
+	problem := func(field string, err error, fix string) {
+		problems = append(problems, ConfigProblem{Field: field, Problem: err.Error(), Fix: fix})
+	}
+
+	if _, err := ParseTTLRules(o.CacheTTL); err != nil {
+		problem("cache-ttl", err, "use pattern=duration e.g. text/html=30s, *.png=1h, or /assets/=forever")
+	}
+	if _, err := ParseLevel(o.LogLevel); err != nil {
+		problem("log-level", err, "use debug, info, warn, or error")
+	}
+	if _, err := ParseSampling(o.LogSample); err != nil {
+		problem("log-sample", err, "use level=fraction e.g. debug=0.01")
+	}
+	if o.LogFormat != "" && o.LogFormat != "text" && o.LogFormat != "json" {
+		problem("log-format", fmt.Errorf("unknown log format, %s", o.LogFormat), "use text or json")
+	}
+	if _, err := NewAccessLog(ioutil.Discard, o.AccessLog); err != nil {
+		problem("access-log", err, "use none, common, or combined")
+	}
+	if o.CacheAdmit != "always" && o.CacheAdmit != "tinylfu" {
+		problem("cache-admit", fmt.Errorf("unknown cache admission policy, %s", o.CacheAdmit), "use always or tinylfu")
+	}
+	if o.AlertFormat != "json" && o.AlertFormat != "slack" {
+		problem("alert-format", fmt.Errorf("unknown alert format, %s", o.AlertFormat), "use json or slack")
+	}
+	if _, err := strconv.ParseUint(o.SocketMode, 8, 32); err != nil {
+		problem("socket-mode", fmt.Errorf("invalid socket mode, %s", o.SocketMode), "use an octal mode e.g. 0660")
+	}
+	if _, ok := aws.Regions[o.S3Region]; !ok && o.S3Region != "" {
+		problem("s3-region", fmt.Errorf("unknown s3 region, %s", o.S3Region), "use a region e.g. us-west-2")
+	}
+	if (o.S3AccessKey == "") != (o.S3SecretKey == "") {
+		problem("s3-access-key", fmt.Errorf("s3-access-key and s3-secret-key must be set together"), "set both, or neither to use the AWS_ environment variables")
+	}
+	if _, err := ParseTrustedProxies(o.TrustedProxies); err != nil {
+		problem("trusted-proxies", err, "use cidrs or addresses e.g. 10.0.0.0/8")
+	}
+
+	tls := false
+	for _, value := range o.Listen {
+		spec, err := ParseListenSpec(value)
+		if err == nil && !strings.HasPrefix(spec.Addr, "unix:") {
+			_, _, err = tcpAddr(spec.Addr)
+		}
+		if err != nil {
+			problem("listen", err, "use host:port, [::1]:port, tls://host:port, or unix:/path, optionally prefixed admin=")
+		}
+		tls = tls || spec.TLS
+	}
+	switch {
+	case (o.TLSCert == "") != (o.TLSKey == ""):
+		problem("tls-cert", fmt.Errorf("tls-cert and tls-key must be set together"), "set both to a pem certificate and its private key")
+	case o.TLSCert != "":
+		if _, err := tlsKeyPair(o.TLSCert, o.TLSKey); err != nil {
+			problem("tls-cert", err, "check the files are a pem certificate and its matching private key")
+		}
+	case tls:
+		problem("listen", fmt.Errorf("tls:// listeners require a certificate"), "set tls-cert and tls-key")
+	}
+
+	if (o.Username == "") != (o.Password == "") {
+		problem("username", fmt.Errorf("username and password must be set together"), "set both, or neither to serve without auth")
+	}
+	if o.Pprof && o.AdminToken == "" {
+		problem("pprof", fmt.Errorf("pprof requires an admin-token"), "set admin-token, or drop pprof")
+	}
+	if o.CacheSize <= 0 {
+		for field, set := range map[string]bool{
+			"cache-dir":          o.CacheDir != "",
+			"invalidation-queue": o.Queue != "",
+			"warm":               len(o.Warm) > 0 || o.WarmManifest != "",
+			"cache-ttl":          len(o.CacheTTL) > 0,
+		} {
+			if set {
+				problem(field, fmt.Errorf("%s has no effect without a cache", field), "set cache-size to the cache size in MB")
+			}
+		}
+	}
+	for field, prefix := range map[string]string{"prefix": o.Prefix, "blue-prefix": o.BluePrefix, "green-prefix": o.GreenPrefix, "canary-prefix": o.CanaryPrefix} {
+		if err := prefixProblem(prefix); err != nil {
+			problem(field, err, "use {subdomain}, {env:NAME} with NAME set, {date:layout}, or {header:Name}")
+		} else if requestScoped(prefix) && (o.Releases || len(o.Warm) > 0 || o.WarmManifest != "") {
+			problem(field, fmt.Errorf("releases and warm don't support prefix variables"), "drop releases and warm, or the variables")
+		}
+	}
+	if o.BlueGreen() {
+		switch {
+		case o.BluePrefix == "" || o.GreenPrefix == "":
+			problem("blue-prefix", fmt.Errorf("blue-prefix and green-prefix must be set together"), "set both, or neither")
+		case o.Prefix != "":
+			problem("prefix", fmt.Errorf("prefix is replaced by blue-prefix and green-prefix"), "drop prefix")
+		case o.Releases:
+			problem("releases", fmt.Errorf("releases and blue/green prefixes both switch what's served"), "use one of releases or blue-prefix and green-prefix")
+		}
+	}
+	if o.CanaryPercent < 0 || o.CanaryPercent > 100 {
+		problem("canary-percent", fmt.Errorf("canary-percent must be 0-100"), "use a percent e.g. 10")
+	} else if o.CanaryPercent > 0 && o.CanaryPrefix == "" {
+		problem("canary-percent", fmt.Errorf("canary-percent has no effect without a canary-prefix"), "set canary-prefix to the prefix of the new build")
+	}
+	if o.CanarySticky != "" && o.CanarySticky != "cookie" && o.CanarySticky != "ip" {
+		problem("canary-sticky", fmt.Errorf("unknown canary stickiness, %s", o.CanarySticky), "use cookie or ip")
+	}
+	if o.CanaryPrefix != "" && o.Releases {
+		problem("canary-prefix", fmt.Errorf("releases don't support a canary prefix"), "drop releases, or the canary prefix")
+	}
+	if _, err := LoadMimeTypes(o.MimeTypesFile, o.MimeTypes); err != nil {
+		problem("mime-type", err, "use .ext=type, and a mime-types file listing a type then its extensions per line")
+	}
+	if _, err := LoadListingTemplate(o.AutoIndexTemplate); err != nil {
+		problem("autoindex-template", err, "check the template's path and syntax")
+	}
+	if _, err := LoadMarkdownLayout(o.MarkdownLayout); err != nil {
+		problem("markdown-layout", err, "check the layout's path and syntax")
+	}
+	if _, err := NewTemplates(o.Templates, o.TemplateVars); err != nil {
+		problem("template-var", err, "use name=value, with {env:NAME} set")
+	}
+	if _, err := LoadSnippets(o.InjectHead, o.InjectBody); err != nil {
+		problem("inject-head", err, "check the files named by inject-head and inject-body")
+	}
+	if o.Images {
+		if o.ImageMaxSize <= 0 {
+			problem("image-max-size", fmt.Errorf("image-max-size must be positive"), "use a size in pixels e.g. 2048")
+		}
+		if o.ImageQuality <= 0 || o.ImageQuality > 100 {
+			problem("image-quality", fmt.Errorf("image-quality must be 1-100"), "use a quality e.g. 80")
+		}
+	}
+	if _, err := NewMinifier(o.Minify, o.MinifyCacheSize); err != nil {
+		problem("minify", err, "use html, css, or js")
+	}
+	if _, err := ParseFeeds(o.Feeds); err != nil {
+		problem("feed", err, "use /path/ or /path/=title")
+	} else if len(o.Feeds) > 0 && requestScoped(o.Prefix) {
+		problem("feed", fmt.Errorf("feeds are built once for the site, so can't use a prefix that varies by request"), "drop the feeds, or the prefix variables")
+	}
+	if _, err := NewFallback(o.FallbackOrigin, o.S3Timeout); err != nil {
+		problem("fallback-origin", err, "use an http or https url e.g. https://old.example.com")
+	}
+	for _, value := range o.Forms {
+		if _, err := ParseForm(value); err != nil {
+			problem("form", err, "use path=ses:address, path=sns:topic-arn, or path=sqs:queue-url, then ;required=, ;email=, ;redirect=, ;subject=, ;from=, ;honeypot=, ;min-time=, ;rate=, or ;captcha= options")
+		}
+	}
+	for _, value := range o.Functions {
+		if _, err := ParseFunction(value); err != nil {
+			problem("function", err, "use path=name e.g. /api/subscribe=subscribe:live, then an optional ;methods=POST,PUT")
+		}
+	}
+	for _, p := range o.Zips {
+		if !strings.HasPrefix(p, "/") {
+			problem("zip", fmt.Errorf("invalid zip path, %s", p), "use a directory e.g. /artifacts/, or / for any")
+		}
+	}
+	if o.ZipMaxSize < 0 {
+		problem("zip-max-size", fmt.Errorf("zip-max-size must not be negative"), "use a size in MB, or 0 to allow any")
+	}
+	if _, err := ParseUpstreams(o.Upstreams, o.UpstreamTimeout); err != nil {
+		problem("upstream", err, "use prefix=url e.g. /api/=http://10.0.0.5:8080")
+	}
+	if _, err := NewAliases(o.Aliases, o.AliasObject); err != nil {
+		problem("alias", err, "use \"from to\" or \"from to status\", to a path or url")
+	}
+	if _, err := ParseRewrites(o.Rewrites); err != nil {
+		problem("rewrite", err, "use \"pattern replacement\", with a replacement path e.g. /posts/$1, then optionally last, redirect or redirect=301, and conditions e.g. cookie:beta=1 or header:User-Agent~(?i)mobile")
+	}
+	if _, err := NewLocales(o.Locales, o.LocaleCookie, o.LocaleMode); err != nil {
+		problem("locale", err, "use locales e.g. en or pt-BR, and a locale-mode of redirect or rewrite")
+	}
+	if o.Search && requestScoped(o.Prefix) {
+		problem("search", fmt.Errorf("the search index is built once for the site, so can't use a prefix that varies by request"), "drop search, or the prefix variables")
+	}
+	if o.Sitemap {
+		if requestScoped(o.Prefix) {
+			problem("sitemap", fmt.Errorf("sitemaps are built once for the site, so can't use a prefix that varies by request"), "drop the sitemap, or the prefix variables")
+		}
+		if o.SitemapURL != "" {
+			if u, err := url.Parse(o.SitemapURL); err != nil || u.Scheme == "" || u.Host == "" {
+				problem("sitemap-url", fmt.Errorf("invalid sitemap url, %s", o.SitemapURL), "use a scheme and host e.g. https://www.example.com")
+			}
+		}
+	}
+	if o.DefaultType != "" {
+		if _, _, err := mime.ParseMediaType(o.DefaultType); err != nil {
+			problem("default-type", fmt.Errorf("invalid default type, %s", o.DefaultType), "use a content type e.g. application/octet-stream")
+		}
+	}
+	if variants, err := ParseVariants(o.VariantCookie, o.Variants); err != nil {
+		problem("variant", err, "use name=prefix or name:weight=prefix")
+	} else {
+		for _, v := range variants.List {
+			if err := prefixProblem(v.Prefix); err != nil {
+				problem("variant", err, "use {subdomain}, {env:NAME} with NAME set, {date:layout}, or {header:Name}")
+			}
+		}
+		if len(variants.List) > 0 && (o.BlueGreen() || o.CanaryPrefix != "" || o.Releases) {
+			problem("variant", fmt.Errorf("variants replace the prefix, so can't be combined with blue/green, canary, or release prefixes"), "drop the variants, or the other prefixes")
+		}
+	}
+	if o.Slot != "" && o.Slot != SlotBlue && o.Slot != SlotGreen {
+		problem("live-slot", fmt.Errorf("unknown slot, %s", o.Slot), "use blue or green")
+	}
+	if o.Releases && o.ReleasePoll <= 0 {
+		problem("release-poll", fmt.Errorf("release-poll must be positive"), "use a duration e.g. 10s")
+	}
+	if len(o.StatsDTags) > 0 && !o.DogStatsD {
+		problem("statsd-tag", fmt.Errorf("statsd tags require dogstatsd"), "set dogstatsd, or drop the tags")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Field < problems[j].Field })
+	return problems
+}
+
+// bucket checks the bucket exists in the region served from, then that the
+// prefix and index file can be read
+func (p *Preflight) bucket() []CheckResult {
+	opts := p.Options
+	region, err := p.bucketRegion()
+	if err != nil {
+		return []CheckResult{{Name: "bucket", Err: err}}
+	}
+	results := []CheckResult{{Name: "bucket", Detail: opts.Bucket}}
+
+	if region != "" && region != p.Region.Name {
+		return append(results, CheckResult{Name: "region", Err: fmt.Errorf("bucket is in %s but s3site reads from %s; set s3-region", region, p.Region.Name)})
+	}
+	results = append(results, CheckResult{Name: "region", Detail: p.Region.Name})
+
+	api := s3.New(p.Auth, p.Region)
+	api.HTTPClient = func() *http.Client { return p.Client }
+	bucket := api.Bucket(opts.Bucket)
+
+	prefix := opts.keyPrefix("/")
+	list, err := bucket.List(prefix, "", "", 1)
+	switch {
+	case err != nil:
+		results = append(results, CheckResult{Name: "prefix", Err: err})
+	case len(list.Contents) == 0:
+		results = append(results, CheckResult{Name: "prefix", Err: fmt.Errorf("no objects under %s/%s", opts.Bucket, prefix)})
+	default:
+		results = append(results, CheckResult{Name: "prefix", Detail: opts.Bucket + "/" + prefix})
+	}
+
+	key := opts.Key("/")
+	resp, err := bucket.Head(key)
+	if err != nil {
+		if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+			err = fmt.Errorf("%s not found", key)
+		}
+		return append(results, CheckResult{Name: "index", Err: err})
+	}
+	resp.Body.Close()
+	return append(results, CheckResult{Name: "index", Detail: key})
+}
+
+// bucketRegion returns the region s3 reports for the bucket; s3 includes it
+// even when the request was sent to the wrong region
+func (p *Preflight) bucketRegion() (string, error) {
+	req, err := http.NewRequest("HEAD", strings.TrimRight(p.Region.S3Endpoint, "/")+"/"+p.Options.Bucket, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", hexSHA256(nil))
+	SignV4(req, p.Auth, p.Region.Name, "s3", nil, time.Now())
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	region := resp.Header.Get("X-Amz-Bucket-Region")
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return region, nil
+	case http.StatusMovedPermanently, http.StatusBadRequest:
+		if region != "" {
+			return region, nil
+		}
+	case http.StatusNotFound:
+		return "", fmt.Errorf("bucket %s does not exist", p.Options.Bucket)
+	case http.StatusForbidden:
+		return "", fmt.Errorf("access to bucket %s denied", p.Options.Bucket)
+	}
+	return "", fmt.Errorf("unable to reach bucket %s, %s", p.Options.Bucket, resp.Status)
+}
+
+// report writes a line per check and returns true if none failed
+func report(w io.Writer, results []CheckResult) bool {
+	passed := true
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, result := range results {
+		status, detail := "PASS", result.Detail
+		switch {
+		case result.Skipped:
+			status = "SKIP"
+		case result.Err != nil:
+			status, detail = "FAIL", result.Err.Error()
+			passed = false
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", status, result.Name, detail)
+	}
+	tw.Flush()
+
+	if passed {
+		fmt.Fprintln(w, "all checks passed")
+	} else {
+		fmt.Fprintln(w, "some checks failed")
+	}
+	return passed
+}
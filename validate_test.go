@@ -0,0 +1,147 @@
+package s3site
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+)
+
+func fakeS3(region string, keys ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Amz-Bucket-Region", region)
+		switch {
+		case req.Method == "HEAD" && req.URL.Path == "/bucket":
+			if region != "us-east-1" {
+				w.WriteHeader(http.StatusMovedPermanently)
+			}
+		case req.Method == "GET" && req.URL.Path == "/bucket/":
+			w.Write([]byte(`<ListBucketResult><Name>bucket</Name>`))
+			for _, key := range keys {
+				if strings.HasPrefix(key, req.URL.Query().Get("prefix")) {
+					w.Write([]byte(`<Contents><Key>` + key + `</Key></Contents>`))
+				}
+			}
+			w.Write([]byte(`</ListBucketResult>`))
+		case req.Method == "HEAD":
+			for _, key := range keys {
+				if req.URL.Path == "/bucket/"+key {
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func preflight(server *httptest.Server, opts *Options) *Preflight {
+	return &Preflight{
+		Options: opts,
+		Auth:    aws.Auth{AccessKey: "key", SecretKey: "secret"},
+		Region:  aws.Region{Name: "us-east-1", S3Endpoint: server.URL},
+		Client:  http.DefaultClient,
+	}
+}
+
+func statuses(results []CheckResult) string {
+	buf := &bytes.Buffer{}
+	report(buf, results)
+	return buf.String()
+}
+
+func validOptions() *Options {
+	return &Options{Bucket: "bucket", Prefix: "site", IndexFile: "index.html", LogLevel: "info", AccessLog: "none", CacheAdmit: "always", AlertFormat: "json", SocketMode: "0660"}
+}
+
+func TestPreflightPasses(t *testing.T) {
+	server := fakeS3("us-east-1", "site/index.html")
+	defer server.Close()
+
+	results := preflight(server, validOptions()).Run(nil)
+	buf := &bytes.Buffer{}
+	if !report(buf, results) {
+		t.Errorf("expected all checks to pass\n%s", buf)
+	}
+	if len(results) != 6 {
+		t.Errorf("expected 6 checks, got %d", len(results))
+	}
+}
+
+func TestPreflightFailures(t *testing.T) {
+	server := fakeS3("us-east-1", "site/about.html")
+	defer server.Close()
+
+	opts := validOptions()
+	opts.CacheTTL = []string{"*.png=soon"}
+	out := statuses(preflight(server, opts).Run(nil))
+	for _, expected := range []string{"FAIL  rules", "PASS  prefix", "FAIL  index        site/index.html not found", "some checks failed"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in report\n%s", expected, out)
+		}
+	}
+
+	opts = validOptions()
+	opts.Prefix = "missing"
+	out = statuses(preflight(server, opts).Run(nil))
+	if !strings.Contains(out, "FAIL  prefix") {
+		t.Errorf("expected an empty prefix to fail\n%s", out)
+	}
+}
+
+func TestPreflightWrongRegion(t *testing.T) {
+	server := fakeS3("eu-west-1")
+	defer server.Close()
+
+	out := statuses(preflight(server, validOptions()).Run(nil))
+	for _, expected := range []string{"PASS  bucket", "FAIL  region", "eu-west-1", "SKIP  prefix", "SKIP  index"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in report\n%s", expected, out)
+		}
+	}
+}
+
+func TestPreflightWithoutCredentials(t *testing.T) {
+	server := fakeS3("us-east-1", "site/index.html")
+	defer server.Close()
+
+	out := statuses(preflight(server, validOptions()).Run(errors.New("no credentials")))
+	for _, expected := range []string{"FAIL  credentials", "SKIP  bucket", "SKIP  index"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in report\n%s", expected, out)
+		}
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	if err := validOptions().Validate(); err != nil {
+		t.Errorf("expected valid options, got %v", err)
+	}
+
+	opts := validOptions()
+	opts.Username = "admin"
+	opts.CacheTTL = []string{"*.png=1h"}
+	opts.Listen = []string{"tls://:443", "::1:80"}
+	opts.TLSKey = "key.pem"
+	opts.LogLevel = "loud"
+
+	err, ok := opts.Validate().(ConfigError)
+	if !ok {
+		t.Fatalf("expected a ConfigError, got %v", err)
+	}
+	fields := []string{}
+	for _, problem := range err {
+		if problem.Fix == "" {
+			t.Errorf("expected a fix for %s", problem.Field)
+		}
+		fields = append(fields, problem.Field)
+	}
+	if got := strings.Join(fields, ","); got != "cache-ttl,listen,log-level,tls-cert,username" {
+		t.Errorf("unexpected problems, %s\n%v", got, err)
+	}
+}
@@ -0,0 +1,130 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// VariantHeader names the variant served to the visitor; it's reported
+	// in the access log
+	VariantHeader = "X-Variant"
+
+	variantCookieAge = 365 * 24 * 60 * 60
+)
+
+// Variant is one arm of an experiment, served from its own prefix
+type Variant struct {
+	Name   string
+	Prefix string
+	Weight int
+}
+
+// Variants assigns each visitor a variant, kept in a cookie so the visitor
+// sees the same one on every visit
+type Variants struct {
+	Cookie string
+	List   []Variant
+}
+
+// ParseVariants parses variants of the form name=prefix, or
+// name:weight=prefix to give the variant a larger share of visitors
+func ParseVariants(cookie string, values []string) (*Variants, error) {
+	variants := &Variants{Cookie: cookie}
+	seen := map[string]bool{}
+	for _, value := range values {
+		index := strings.Index(value, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid variant, %s; expected name=prefix", value)
+		}
+
+		v := Variant{Name: value[:index], Prefix: value[index+1:], Weight: 1}
+		if i := strings.Index(v.Name, ":"); i >= 0 {
+			weight, err := strconv.Atoi(v.Name[i+1:])
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid variant weight, %s; expected a positive integer", value)
+			}
+			v.Name, v.Weight = v.Name[:i], weight
+		}
+		if !validLabel(v.Name) {
+			return nil, fmt.Errorf("invalid variant name, %s; use lowercase letters, digits, and -", v.Name)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("duplicate variant, %s", v.Name)
+		}
+		seen[v.Name] = true
+		variants.List = append(variants.List, v)
+	}
+	return variants, nil
+}
+
+func (v *Variants) find(name string) *Variant {
+	for i := range v.List {
+		if v.List[i].Name == name {
+			return &v.List[i]
+		}
+	}
+	return nil
+}
+
+// Assign returns the visitor's variant, drawing one by weight and setting
+// the cookie for visitors without one; it returns nil when there are no
+// variants
+func (v *Variants) Assign(w http.ResponseWriter, req *http.Request) *Variant {
+	if v == nil || len(v.List) == 0 {
+		return nil
+	}
+	if c, err := req.Cookie(v.Cookie); err == nil {
+		if variant := v.find(c.Value); variant != nil {
+			return variant
+		}
+	}
+
+	total := 0
+	for _, variant := range v.List {
+		total += variant.Weight
+	}
+	draw := rand.Intn(total)
+	variant := &v.List[len(v.List)-1]
+	for i := range v.List {
+		if draw < v.List[i].Weight {
+			variant = &v.List[i]
+			break
+		}
+		draw -= v.List[i].Weight
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     v.Cookie,
+		Value:    variant.Name,
+		Path:     "/",
+		MaxAge:   variantCookieAge,
+		HttpOnly: true,
+	})
+	return variant
+}
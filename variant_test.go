@@ -0,0 +1,83 @@
+package s3site
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseVariants(t *testing.T) {
+	variants, err := ParseVariants("exp", []string{"control=site/a/", "new:3=site/b/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants.List) != 2 || variants.List[1] != (Variant{Name: "new", Prefix: "site/b/", Weight: 3}) {
+		t.Errorf("unexpected variants, %v", variants.List)
+	}
+
+	for _, value := range []string{"site/a/", "=site/a/", "a:0=site/a/", "a:x=site/a/", "A=site/a/"} {
+		if _, err := ParseVariants("exp", []string{value}); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+	if _, err := ParseVariants("exp", []string{"a=one/", "a=two/"}); err == nil {
+		t.Error("expected duplicate variants to be rejected")
+	}
+}
+
+func TestVariantsAssign(t *testing.T) {
+	variants, _ := ParseVariants("exp", []string{"control=site/a/", "new=site/b/"})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "exp", Value: "new"})
+	w := httptest.NewRecorder()
+	if variant := variants.Assign(w, req); variant == nil || variant.Name != "new" {
+		t.Errorf("expected the cookie's variant, got %v", variant)
+	}
+	if w.Header().Get("Set-Cookie") != "" {
+		t.Error("expected the existing assignment to be kept")
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "exp", Value: "retired"})
+		w := httptest.NewRecorder()
+		variant := variants.Assign(w, req)
+		if cookie := w.Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, "exp="+variant.Name+";") {
+			t.Fatalf("expected the assignment to be kept in the cookie, got %s", cookie)
+		}
+		seen[variant.Name] = true
+	}
+	if !seen["control"] || !seen["new"] {
+		t.Errorf("expected visitors to be assigned both variants, got %v", seen)
+	}
+
+	var none *Variants
+	if variant := none.Assign(httptest.NewRecorder(), req); variant != nil {
+		t.Errorf("expected no variant, got %v", variant)
+	}
+}
+
+func TestVariantReporting(t *testing.T) {
+	buf := &bytes.Buffer{}
+	accessLog, _ := NewAccessLog(buf, "common")
+	handler := accessLog.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(VariantHeader, "new")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !strings.HasSuffix(buf.String(), " variant=new\n") {
+		t.Errorf("expected the variant in the access log, got %s", buf.String())
+	}
+
+	metrics := NewMetrics()
+	metrics.ObserveVariant("new")
+	metrics.ObserveVariant("new")
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `s3site_variant_requests_total{variant="new"} 2`) {
+		t.Errorf("expected variant metrics, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,92 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set at link time e.g.
+//
+//	go build -ldflags "-X github.com/savaki/s3site.Version=1.4.0 -X github.com/savaki/s3site.Commit=$(git rev-parse HEAD) -X github.com/savaki/s3site.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/s3site
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Build describes the running binary
+type Build struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// CurrentBuild returns the build metadata, falling back to the revision the
+// go toolchain stamps when the linker flags weren't set
+func CurrentBuild() Build {
+	build := Build{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && build.Commit == "":
+				build.Commit = setting.Value
+			case setting.Key == "vcs.time" && build.BuildDate == "":
+				build.BuildDate = setting.Value
+			}
+		}
+	}
+	return build
+}
+
+func (b Build) String() string {
+	s := "s3site " + b.Version
+	if b.Commit != "" {
+		s += " commit " + b.Commit
+	}
+	if b.BuildDate != "" {
+		s += " built " + b.BuildDate
+	}
+	return s + " " + b.GoVersion
+}
+
+// VersionHeader identifies the version serving each response, in Server and
+// X-S3Site-Version, for auditing a fleet
+func VersionHeader(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server", "s3site/"+Version)
+		w.Header().Set("X-S3Site-Version", Version)
+		h.ServeHTTP(w, req)
+	})
+}
+
+// writeBuildInfo writes the build as a prometheus info metric
+func writeBuildInfo(w http.ResponseWriter, b Build) {
+	fmt.Fprintln(w, "# HELP s3site_build_info Version of the running binary.")
+	fmt.Fprintln(w, "# TYPE s3site_build_info gauge")
+	fmt.Fprintf(w, "s3site_build_info{version=%q,commit=%q,build_date=%q,go_version=%q} 1\n", b.Version, b.Commit, b.BuildDate, b.GoVersion)
+}
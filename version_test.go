@@ -0,0 +1,34 @@
+package s3site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCurrentBuild(t *testing.T) {
+	defer func(version, commit, date string) {
+		Version, Commit, BuildDate = version, commit, date
+	}(Version, Commit, BuildDate)
+	Version, Commit, BuildDate = "1.4.0", "abc123", "2015-06-01T00:00:00Z"
+
+	build := CurrentBuild()
+	if s := build.String(); !strings.HasPrefix(s, "s3site 1.4.0 commit abc123 built 2015-06-01T00:00:00Z go") {
+		t.Errorf("unexpected build, %s", s)
+	}
+
+	w := httptest.NewRecorder()
+	NewMetrics().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `s3site_build_info{version="1.4.0",commit="abc123",build_date="2015-06-01T00:00:00Z",go_version="go`) {
+		t.Errorf("expected build info in the metrics, got %s", w.Body.String())
+	}
+}
+
+func TestVersionHeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	VersionHeader(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("X-S3Site-Version") != Version || w.Header().Get("Server") != "s3site/"+Version {
+		t.Errorf("unexpected headers, %v", w.Header())
+	}
+}
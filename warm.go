@@ -0,0 +1,113 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	warmConcurrency = 8
+	maxManifest     = 4 << 20
+)
+
+// Warm prefetches the objects serving paths into the cache, keyed as
+// requests for them are
+func Warm(opts *Options, keys *KeyPolicy, origin *Origin, paths []string) {
+	started := time.Now()
+	queue := make(chan string)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < warmConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				entry, _, err := origin.Get(context.Background(), key, "")
+				if err != nil {
+					logger.Warn("unable to warm", Fields{"bucket": opts.Bucket, "key": key, "error": err})
+					continue
+				}
+				if entry.Stream != nil {
+					entry.Stream.Close()
+				}
+			}
+		}()
+	}
+
+	for _, path := range paths {
+		queue <- opts.Key(keys.Path(path))
+	}
+	close(queue)
+	wg.Wait()
+
+	logger.Info("warmed cache", Fields{"paths": len(paths), "duration": time.Since(started).Seconds()})
+}
+
+// WarmPaths returns the configured paths to warm along with any listed in
+// the manifest object; one path per line, blank lines and #comments ignored
+func WarmPaths(opts *Options, origin *Origin) ([]string, error) {
+	paths := append([]string{}, opts.Warm...)
+	if opts.WarmManifest == "" {
+		return paths, nil
+	}
+
+	ctx, span := StartSpan(context.Background(), "s3.GetObject", SpanClient)
+	span.SetAttribute("s3.bucket", origin.Bucket.Name)
+	span.SetAttribute("s3.key", opts.WarmManifest)
+	defer span.Finish()
+
+	resp, err := getObject(ctx, origin.Client, origin.Credentials.Sign(origin.Bucket), opts.WarmManifest, nil)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifest))
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	return append(paths, parseManifest(data)...), nil
+}
+
+func parseManifest(data []byte) []string {
+	paths := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths
+}
@@ -0,0 +1,49 @@
+package s3site
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	paths := parseManifest([]byte("# hot pages\n/\n\n  /docs/  \n/about.html\n"))
+
+	expected := []string{"/", "/docs/", "/about.html"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	origin, done := newTestOrigin(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/bucket/site/warm.txt":
+			w.Write([]byte("/Docs/../About.html\n/docs/\n"))
+		case "/bucket/site/about.html", "/bucket/site/docs/index.html":
+			w.Write([]byte("page"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer done()
+
+	opts := &Options{Prefix: "site", IndexFile: "index.html", Warm: []string{"/missing.html"}, WarmManifest: "site/warm.txt"}
+	paths, err := WarmPaths(opts, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"/missing.html", "/Docs/../About.html", "/docs/"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+
+	Warm(opts, &KeyPolicy{Lowercase: true}, origin, paths)
+	for _, key := range []string{"site/about.html", "site/docs/index.html"} {
+		if _, ok := origin.Cache.Get(key); !ok {
+			t.Errorf("expected %s to be warmed under the key requests use", key)
+		}
+	}
+	if snapshot := origin.Cache.Snapshot(); snapshot.Entries != 2 {
+		t.Errorf("expected 2 entries, got %d", snapshot.Entries)
+	}
+}
@@ -0,0 +1,175 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+const xrayHeader = "X-Amzn-Trace-Id"
+
+// XRayExporter sends spans to the x-ray daemon over udp; server spans
+// become segments and all others subsegments
+type XRayExporter struct {
+	Addr    string
+	Service string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewXRayExporter(addr, service string) *XRayExporter {
+	return &XRayExporter{Addr: addr, Service: service}
+}
+
+// xrayTraceID formats id as 1-<epoch seconds>-<96 random bits>
+func xrayTraceID(id [16]byte) string {
+	return fmt.Sprintf("1-%x-%x", id[:4], id[4:])
+}
+
+// parseXRayHeader extracts the trace and parent ids from an
+// X-Amzn-Trace-Id header e.g. Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1
+func parseXRayHeader(header string) (traceID [16]byte, parentID [8]byte, ok bool) {
+	var root, parent string
+	for _, field := range strings.Split(header, ";") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "Root":
+			root = parts[1]
+		case "Parent":
+			parent = parts[1]
+		}
+	}
+
+	segments := strings.Split(root, "-")
+	if len(segments) != 3 || segments[0] != "1" {
+		return traceID, parentID, false
+	}
+	id, err := hex.DecodeString(segments[1] + segments[2])
+	if err != nil || len(id) != 16 {
+		return traceID, parentID, false
+	}
+	copy(traceID[:], id)
+
+	if p, err := hex.DecodeString(parent); err == nil && len(p) == 8 {
+		copy(parentID[:], p)
+	}
+	return traceID, parentID, true
+}
+
+func epochSeconds(span *Span) (float64, float64) {
+	return float64(span.Start.UnixNano()) / 1e9, float64(span.End.UnixNano()) / 1e9
+}
+
+// document renders span as an x-ray segment or subsegment
+func (e *XRayExporter) document(span *Span) map[string]interface{} {
+	start, end := epochSeconds(span)
+	doc := map[string]interface{}{
+		"id":         hex.EncodeToString(span.SpanID[:]),
+		"trace_id":   xrayTraceID(span.TraceID),
+		"name":       span.Name,
+		"start_time": start,
+		"end_time":   end,
+	}
+	if span.ParentID != [8]byte{} {
+		doc["parent_id"] = hex.EncodeToString(span.ParentID[:])
+	}
+	if len(span.Attributes) > 0 {
+		doc["metadata"] = map[string]interface{}{"default": span.Attributes}
+	}
+	if span.Err != nil {
+		doc["fault"] = true
+		doc["cause"] = map[string]interface{}{
+			"exceptions": []map[string]interface{}{{"message": span.Err.Error()}},
+		}
+	}
+
+	status, _ := span.Attributes["http.status_code"].(int)
+	switch {
+	case status >= 500:
+		doc["fault"] = true
+	case status >= 400:
+		doc["error"] = true
+	}
+
+	switch {
+	case span.Kind == SpanServer:
+		doc["name"] = e.Service
+		doc["http"] = map[string]interface{}{
+			"request": map[string]interface{}{
+				"method":    span.Attributes["http.method"],
+				"url":       span.Attributes["http.target"],
+				"client_ip": span.Attributes["http.client_ip"],
+			},
+			"response": map[string]interface{}{"status": status},
+		}
+
+	case strings.HasPrefix(span.Name, "s3."):
+		doc["type"] = "subsegment"
+		doc["name"] = "S3"
+		doc["namespace"] = "aws"
+		doc["aws"] = map[string]interface{}{
+			"operation":   strings.TrimPrefix(span.Name, "s3."),
+			"bucket_name": span.Attributes["s3.bucket"],
+			"key":         span.Attributes["s3.key"],
+		}
+
+	default:
+		doc["type"] = "subsegment"
+	}
+	return doc
+}
+
+// Export sends one datagram per span
+func (e *XRayExporter) Export(spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn == nil {
+		conn, err := net.Dial("udp", e.Addr)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+
+	for _, span := range spans {
+		data, err := json.Marshal(e.document(span))
+		if err != nil {
+			return err
+		}
+		packet := append([]byte("{\"format\": \"json\", \"version\": 1}\n"), data...)
+		if _, err := e.conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
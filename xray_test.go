@@ -0,0 +1,109 @@
+package s3site
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseXRayHeader(t *testing.T) {
+	traceID, parentID, ok := parseXRayHeader("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	if !ok {
+		t.Fatal("expected header to parse")
+	}
+	if id := xrayTraceID(traceID); id != "1-5759e988-bd862e3fe1be46a994272793" {
+		t.Errorf("expected trace id to round trip, got %s", id)
+	}
+	if parentID != [8]byte{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8} {
+		t.Errorf("unexpected parent, %x", parentID)
+	}
+
+	for _, header := range []string{"", "Root=2-abc-def", "Root=1-zz-yy", "Sampled=1"} {
+		if _, _, ok := parseXRayHeader(header); ok {
+			t.Errorf("expected %q not to parse", header)
+		}
+	}
+}
+
+func TestTraceJoinsXRayTrace(t *testing.T) {
+	tracer = NewTracer()
+	defer func() { tracer = nil }()
+
+	var child *Span
+	h := Trace(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, child = StartSpan(req.Context(), "s3.GetObject", SpanClient)
+		child.Finish()
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(xrayHeader, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if xrayTraceID(child.TraceID) != "1-5759e988-bd862e3fe1be46a994272793" {
+		t.Errorf("expected spans to join the upstream trace")
+	}
+	if header := w.Header().Get(xrayHeader); header != "Root=1-5759e988-bd862e3fe1be46a994272793" {
+		t.Errorf("expected trace id in response, got %s", header)
+	}
+	if server := tracer.pending[1]; server.ParentID != [8]byte{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8} {
+		t.Errorf("expected server span to be a child of the upstream segment")
+	}
+}
+
+func TestXRayExport(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tracer = NewTracer()
+	defer func() { tracer = nil }()
+
+	ctx, server := StartSpan(context.Background(), "GET /", SpanServer)
+	server.SetAttribute("http.method", "GET")
+	server.SetAttribute("http.status_code", 503)
+	_, s3 := StartSpan(ctx, "s3.GetObject", SpanClient)
+	s3.SetAttribute("s3.bucket", "example")
+	s3.SetError(errors.New("timeout"))
+	s3.End, server.End = time.Now(), time.Now()
+
+	exporter := NewXRayExporter(conn.LocalAddr().String(), "s3site")
+	if err := exporter.Export([]*Span{s3, server}); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() map[string]interface{} {
+		buf := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.SplitN(string(buf[:n]), "\n", 2)
+		if parts[0] != `{"format": "json", "version": 1}` {
+			t.Errorf("unexpected header, %s", parts[0])
+		}
+		doc := map[string]interface{}{}
+		json.Unmarshal([]byte(parts[1]), &doc)
+		return doc
+	}
+
+	subsegment, segment := read(), read()
+	if subsegment["type"] != "subsegment" || subsegment["namespace"] != "aws" || subsegment["name"] != "S3" || subsegment["fault"] != true {
+		t.Errorf("unexpected subsegment, %v", subsegment)
+	}
+	if subsegment["parent_id"] != segment["id"] || subsegment["trace_id"] != segment["trace_id"] {
+		t.Errorf("expected subsegment to reference the segment")
+	}
+	if segment["name"] != "s3site" || segment["fault"] != true || segment["type"] != nil {
+		t.Errorf("unexpected segment, %v", segment)
+	}
+}
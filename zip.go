@@ -0,0 +1,209 @@
+// The MIT License (MIT)
+
+// Copyright (c) 2015 Matt Ho
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package s3site
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/goamz/s3"
+)
+
+// maxZipEntries bounds the objects a single archive holds
+const maxZipEntries = 10000
+
+// storedExtensions are already compressed, so they're stored in archives
+// rather than deflated again
+var storedExtensions = map[string]bool{
+	".7z": true, ".avif": true, ".br": true, ".bz2": true, ".gif": true, ".gz": true,
+	".jar": true, ".jpeg": true, ".jpg": true, ".mp3": true, ".mp4": true, ".png": true,
+	".tgz": true, ".webm": true, ".webp": true, ".whl": true, ".woff": true, ".woff2": true,
+	".xz": true, ".zip": true, ".zst": true,
+}
+
+// zipEntry is an object included in an archive
+type zipEntry struct {
+	Key      string
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// zipLimitError is returned for directories too large to archive
+type zipLimitError string
+
+func (e zipLimitError) Error() string {
+	return "more than " + string(e)
+}
+
+// Zips serves archives of the objects beneath directories e.g.
+// /docs/?download=zip
+type Zips struct {
+	// Paths are the directories, and those beneath them, that may be
+	// downloaded; / allows any
+	Paths []string
+	// MaxSize bounds the bytes an archive holds; 0 allows any
+	MaxSize int64
+}
+
+// NewZips returns the zips of paths, limited to maxMB each, or nil when
+// there are none
+func NewZips(paths []string, maxMB int) *Zips {
+	if len(paths) == 0 {
+		return nil
+	}
+	z := &Zips{MaxSize: int64(maxMB) << 20}
+	for _, p := range paths {
+		if !strings.HasSuffix(p, "/") {
+			p += "/"
+		}
+		z.Paths = append(z.Paths, p)
+	}
+	return z
+}
+
+// Matches reports whether req asks for an archive of the directory at
+// urlPath, and the directory may be downloaded
+func (z *Zips) Matches(req *http.Request, urlPath string) bool {
+	if z == nil || (req.Method != "GET" && req.Method != "HEAD") || !strings.HasSuffix(urlPath, "/") || req.URL.Query().Get("download") != "zip" {
+		return false
+	}
+	for _, p := range z.Paths {
+		if strings.HasPrefix(urlPath, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// entries lists the objects beneath prefix, failing once there are more
+// than an archive may hold
+func (z *Zips) entries(bucket *s3.Bucket, prefix string) ([]zipEntry, error) {
+	var entries []zipEntry
+	var total int64
+	marker := ""
+	for {
+		list, err := bucket.List(prefix, "", marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range list.Contents {
+			name := strings.TrimPrefix(key.Key, prefix)
+			if name == "" || strings.HasSuffix(name, "/") {
+				// directory marker objects
+				continue
+			}
+			total += key.Size
+			if len(entries) >= maxZipEntries {
+				return nil, zipLimitError(fmt.Sprintf("%d objects", maxZipEntries))
+			}
+			if z.MaxSize > 0 && total > z.MaxSize {
+				return nil, zipLimitError(fmt.Sprintf("%dMB", z.MaxSize>>20))
+			}
+			modified, _ := time.Parse(time.RFC3339, key.LastModified)
+			entries = append(entries, zipEntry{Key: key.Key, Name: name, Size: key.Size, Modified: modified})
+		}
+		if !list.IsTruncated || len(list.Contents) == 0 {
+			break
+		}
+		marker = list.NextMarker
+		if marker == "" {
+			marker = list.Contents[len(list.Contents)-1].Key
+		}
+	}
+	return entries, nil
+}
+
+// zipName returns the file name of the archive of urlPath e.g. docs.zip
+func zipName(urlPath, site string) string {
+	name := path.Base(urlPath)
+	if name == "/" || name == "." {
+		name = site
+	}
+	return name + ".zip"
+}
+
+// Serve streams an archive of the objects beneath prefix, the directory at
+// urlPath, fetching each in turn so memory stays bounded however large the
+// archive.  It returns false, having written nothing, when the directory
+// is empty.  Archives are built as they're sent, so a failure part way
+// aborts the response rather than sending a truncated archive.
+func (z *Zips) Serve(w http.ResponseWriter, req *http.Request, bucket *s3.Bucket, prefix, urlPath string, fetch func(key string) (*http.Response, error)) bool {
+	entries, err := z.entries(bucket, prefix)
+	if limit, ok := err.(zipLimitError); ok {
+		http.Error(w, fmt.Sprintf("%s is too large to download, holding %v", urlPath, limit), http.StatusRequestEntityTooLarge)
+		return true
+	} else if err != nil {
+		logger.Warn("unable to list archive", Fields{"request_id": requestID(req), "bucket": bucket.Name, "path": urlPath, "error": err})
+		http.Error(w, "unable to list directory", http.StatusBadGateway)
+		return true
+	}
+	if len(entries) == 0 {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", zipName(urlPath, bucket.Name)))
+	w.Header().Set("Cache-Control", "no-store")
+	if req.Method == "HEAD" {
+		return true
+	}
+
+	started := time.Now()
+	archive := zip.NewWriter(w)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name, Method: zip.Deflate, Modified: entry.Modified}
+		if storedExtensions[strings.ToLower(path.Ext(entry.Name))] {
+			header.Method = zip.Store
+		}
+		if err := z.add(archive, header, entry.Key, fetch); err != nil {
+			logger.Warn("unable to archive object", Fields{"request_id": requestID(req), "bucket": bucket.Name, "key": entry.Key, "error": err})
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	logger.Info("served archive", Fields{"request_id": requestID(req), "path": urlPath, "objects": len(entries), "duration": time.Since(started).Seconds()})
+	return true
+}
+
+// add copies the object at key into archive
+func (z *Zips) add(archive *zip.Writer, header *zip.FileHeader, key string, fetch func(key string) (*http.Response, error)) error {
+	resp, err := fetch(key)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dst, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = copyPooled(dst, resp.Body)
+	return err
+}
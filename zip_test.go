@@ -0,0 +1,98 @@
+package s3site
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/s3"
+)
+
+func TestZipsMatches(t *testing.T) {
+	zips := NewZips([]string{"/artifacts"}, 0)
+	testCases := map[string]bool{
+		"/artifacts/?download=zip":        true,
+		"/artifacts/v1/?download=zip":     true,
+		"/artifacts/v1/":                  false,
+		"/artifacts/v1/?download=tar":     false,
+		"/artifacts/app.tgz?download=zip": false,
+		"/docs/?download=zip":             false,
+	}
+	for target, expected := range testCases {
+		req := httptest.NewRequest("GET", target, nil)
+		if matched := zips.Matches(req, req.URL.Path); matched != expected {
+			t.Errorf("%s: expected %v; got %v", target, expected, matched)
+		}
+	}
+
+	var none *Zips
+	if none.Matches(httptest.NewRequest("GET", "/artifacts/?download=zip", nil), "/artifacts/") {
+		t.Error("expected nil zips to match nothing")
+	}
+}
+
+func TestZipsServe(t *testing.T) {
+	mem := &memS3{objects: map[string][]byte{
+		"site/artifacts/v1/":             nil,
+		"site/artifacts/v1/app.js":       bytes.Repeat([]byte("console.log(1);\n"), 100),
+		"site/artifacts/v1/img/logo.png": []byte("png"),
+		"site/artifacts/v2/app.js":       []byte("v2"),
+	}}
+	server := httptest.NewServer(mem)
+	defer server.Close()
+	bucket := s3.New(aws.Auth{AccessKey: "key", SecretKey: "secret"}, aws.Region{Name: "us-east-1", S3Endpoint: server.URL}).Bucket("bucket")
+	fetch := func(key string) (*http.Response, error) {
+		return getObject(context.Background(), http.DefaultClient, bucket, key, nil)
+	}
+
+	zips := NewZips([]string{"/"}, 1)
+	req := httptest.NewRequest("GET", "/artifacts/v1/?download=zip", nil)
+	w := httptest.NewRecorder()
+	if !zips.Serve(w, req, bucket, "site/artifacts/v1/", "/artifacts/v1/", fetch) {
+		t.Fatal("expected an archive")
+	}
+	if w.Header().Get("Content-Type") != "application/zip" || w.Header().Get("Content-Disposition") != `attachment; filename="v1.zip"` {
+		t.Errorf("unexpected headers, %v", w.Header())
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.File) != 2 || archive.File[0].Name != "app.js" || archive.File[1].Name != "img/logo.png" {
+		t.Fatalf("unexpected files, %v", archive.File)
+	}
+	if archive.File[0].Method != zip.Deflate || archive.File[1].Method != zip.Store {
+		t.Errorf("expected js deflated and png stored")
+	}
+	r, _ := archive.File[0].Open()
+	data, _ := ioutil.ReadAll(r)
+	if !bytes.Equal(data, mem.objects["site/artifacts/v1/app.js"]) {
+		t.Errorf("unexpected content, %q", data)
+	}
+
+	w = httptest.NewRecorder()
+	if zips.Serve(w, req, bucket, "site/empty/", "/empty/", fetch) {
+		t.Error("expected empty directories to be left to the usual handling")
+	}
+
+	mem.objects["site/artifacts/v2/big.bin"] = make([]byte, 2<<20)
+	w = httptest.NewRecorder()
+	if !zips.Serve(w, req, bucket, "site/artifacts/v2/", "/artifacts/v2/", fetch) || w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %v; got %v", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
+
+func TestZipName(t *testing.T) {
+	if name := zipName("/artifacts/v1/", "www.example.com"); name != "v1.zip" {
+		t.Errorf("unexpected name, %s", name)
+	}
+	if name := zipName("/", "www.example.com"); name != "www.example.com.zip" {
+		t.Errorf("unexpected name, %s", name)
+	}
+}